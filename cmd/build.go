@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	api "github.com/nanovms/ops/lepton"
 	"github.com/spf13/cobra"
 )
 
@@ -24,8 +25,69 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 
 	c.Program = args[0]
 	c.TargetRoot = targetRoot
+	layers, _ := cmd.Flags().GetStringArray("target-root-layer")
+	c.TargetRootLayers = append(c.TargetRootLayers, layers...)
+	libraryPaths, _ := cmd.Flags().GetStringArray("library-path")
+	c.LibraryPaths = append(c.LibraryPaths, libraryPaths...)
+	excludes, _ := cmd.Flags().GetStringArray("exclude")
+	c.Exclude = append(c.Exclude, excludes...)
+	if strict, _ := cmd.Flags().GetBool("target-root-strict"); strict {
+		c.TargetRootStrict = true
+	}
+	if materialize, _ := cmd.Flags().GetBool("materialize-symlinks"); materialize {
+		c.MaterializeSymlinks = true
+	}
+	if resolve, _ := cmd.Flags().GetBool("resolve-symlinks"); resolve {
+		c.ResolveSymlinks = true
+	}
+	if policy, _ := cmd.Flags().GetString("symlink-policy"); policy != "" {
+		c.SymlinkPolicy = api.SymlinkPolicy(policy)
+	}
+	if dedup, _ := cmd.Flags().GetBool("dedup"); dedup {
+		c.Deduplicate = true
+	}
+	dataVolumes, _ := cmd.Flags().GetStringArray("data-volume")
+	c.DataVolumes = append(c.DataVolumes, dataVolumes...)
+	programs, _ := cmd.Flags().GetStringArray("program")
+	if err := api.AddProgramFlags(programs, c); err != nil {
+		exitWithError(err.Error())
+	}
+	setups, _ := cmd.Flags().GetStringArray("setup")
+	if err := api.AddSetupFlags(setups, c); err != nil {
+		exitWithError(err.Error())
+	}
+	if onExit, _ := cmd.Flags().GetString("on-exit"); onExit != "" {
+		c.Exit.OnExit = api.ExitAction(onExit)
+	}
+	if onCrash, _ := cmd.Flags().GetString("on-crash"); onCrash != "" {
+		c.Exit.OnCrash = api.ExitAction(onCrash)
+	}
+	if propagate, _ := cmd.Flags().GetBool("propagate-exit-status"); propagate {
+		c.Exit.PropagateStatus = true
+	}
+	if exec, _ := cmd.Flags().GetString("exec"); exec != "" {
+		c.RunConfig.Exec = exec
+	}
+	if manifestName, _ := cmd.Flags().GetString("manifest-name"); manifestName != "" {
+		c.ManifestName = manifestName
+	}
+	if reportName, _ := cmd.Flags().GetString("report-name"); reportName != "" {
+		c.ReportName = reportName
+	}
+	if profileDir, _ := cmd.Flags().GetString("profile-dir"); profileDir != "" {
+		c.ProfileDir = profileDir
+	}
+	if label, _ := cmd.Flags().GetString("label"); label != "" {
+		c.Label = label
+	}
+	if mtime, _ := cmd.Flags().GetString("mtime"); mtime != "" {
+		c.ModTime = mtime
+	}
 	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
 
+	failOnWarnings, _ := cmd.Flags().GetStringArray("fail-on-warning")
+	c.FailOnWarnings = append(c.FailOnWarnings, failOnWarnings...)
+
 	if len(cmdenvs) > 0 {
 		if len(c.Env) == 0 {
 			c.Env = make(map[string]string)
@@ -36,6 +98,10 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 			c.Env[ez[0]] = ez[1]
 		}
 	}
+	passEnv, _ := cmd.Flags().GetStringArray("pass-env")
+	c.EnvPassthrough = append(c.EnvPassthrough, passEnv...)
+
+	applyConfigOverrides(cmd, c)
 
 	setDefaultImageName(cmd, c)
 
@@ -46,7 +112,7 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 
 	prepareImages(c)
 	if _, err := p.BuildImage(ctx); err != nil {
-		fmt.Println(err)
+		fmt.Println(api.DescribeError(err))
 		os.Exit(1)
 	}
 	fmt.Printf("Bootable image file:%s\n", c.RunConfig.Imagename)
@@ -56,9 +122,14 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 func BuildCommand() *cobra.Command {
 	var config string
 	var targetRoot string
+	var targetRootStrict bool
+	var materializeSymlinks bool
+	var resolveSymlinks bool
 	var targetCloud string
 	var imageName string
 	var envs []string
+	var overrides []string
+	var failOnWarnings []string
 
 	var cmdBuild = &cobra.Command{
 		Use:   "build [ELF file]",
@@ -68,9 +139,32 @@ func BuildCommand() *cobra.Command {
 	}
 
 	cmdBuild.PersistentFlags().StringArrayVarP(&envs, "envs", "e", nil, "env arguments")
+	cmdBuild.PersistentFlags().StringArray("pass-env", nil, "pass the value of a host environment variable to the image")
 	cmdBuild.PersistentFlags().StringVarP(&config, "config", "c", "", "ops config file")
-	cmdBuild.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root")
+	cmdBuild.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root directory, or docker image like docker://ubuntu:20.04")
+	cmdBuild.PersistentFlags().StringArray("target-root-layer", nil, "directory, or docker image, laid over the target root, later layers win")
+	cmdBuild.PersistentFlags().StringArray("library-path", nil, "directory searched for the shared libraries of the program, like LD_LIBRARY_PATH")
+	cmdBuild.PersistentFlags().StringArray("exclude", nil, "leave files matching a pattern like *.log, node_modules/** or re:<regexp> out of added directories")
+	cmdBuild.PersistentFlags().BoolVar(&targetRootStrict, "target-root-strict", false, "never take files or libraries missing from the target root from the host")
+	cmdBuild.PersistentFlags().BoolVar(&materializeSymlinks, "materialize-symlinks", false, "add the files symlinks out of added directories point to instead of the symlinks")
+	cmdBuild.PersistentFlags().BoolVar(&resolveSymlinks, "resolve-symlinks", false, "copy the content of every symlink, the image has no symlinks")
+	cmdBuild.PersistentFlags().String("symlink-policy", "", "what is added for symlinks [preserve, dereference, skip]")
+	cmdBuild.PersistentFlags().Bool("dedup", false, "store duplicate files once, as symlinks to the first of them")
+	cmdBuild.PersistentFlags().StringArray("data-volume", nil, "move an image directory like /var to a writable volume mounted at it")
+	cmdBuild.PersistentFlags().String("on-exit", "", "what the instance does when the program exits [halt, reboot]")
+	cmdBuild.PersistentFlags().String("on-crash", "", "what the instance does when the program crashes, --on-exit by default [halt, reboot]")
+	cmdBuild.PersistentFlags().Bool("propagate-exit-status", false, "report the exit status of the program to the hypervisor, ops run exits with it")
+	cmdBuild.PersistentFlags().StringArray("setup", nil, "run a program with its arguments before the program, like \"./migrate up\"")
+	cmdBuild.PersistentFlags().StringArray("program", nil, "add a program selectable at boot, name=path")
+	cmdBuild.PersistentFlags().String("exec", "", "name of the program of --program the image runs by default")
+	cmdBuild.PersistentFlags().StringP("manifest-name", "m", "", "save manifest to file")
+	cmdBuild.PersistentFlags().String("report-name", "", "save the build report to file as json")
+	cmdBuild.PersistentFlags().String("profile-dir", "", "write pprof cpu and heap profiles of the build to directory")
+	cmdBuild.PersistentFlags().String("label", "", "label of the root filesystem of the image")
+	cmdBuild.PersistentFlags().String("mtime", "", "modification time of every file of the image, RFC 3339 or seconds since the epoch")
 	cmdBuild.PersistentFlags().StringVarP(&targetCloud, "target-cloud", "t", "onprem", "cloud platform[gcp, onprem]")
 	cmdBuild.PersistentFlags().StringVarP(&imageName, "imagename", "i", "", "image name")
+	cmdBuild.PersistentFlags().StringArrayVar(&overrides, "set", nil, "override config field, e.g. env.PORT=8080")
+	cmdBuild.PersistentFlags().StringArrayVar(&failOnWarnings, "fail-on-warning", nil, "fail the build on warnings of a category[overwritten-file, broken-symlink, special-file, external-symlink, case-collision, all]")
 	return cmdBuild
 }
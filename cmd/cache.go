@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// CacheCommand provides commands to inspect and evict the caches of the ops
+// home
+func CacheCommand() *cobra.Command {
+	var cmdCache = &cobra.Command{
+		Use:   "cache",
+		Short: "manage the releases, packages, target roots and images cached in the ops home",
+		Run:   cacheListCommandHandler,
+	}
+
+	cmdCache.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "list cache entries, least recently used first",
+		Run:   cacheListCommandHandler,
+	})
+
+	var cmdPrune = &cobra.Command{
+		Use:   "prune",
+		Short: "evict least recently used cache entries that aren't pinned",
+		Run:   cachePruneCommandHandler,
+	}
+	cmdPrune.Flags().String("max-size", "", "size all the caches are evicted down to, like 10G")
+	cmdPrune.Flags().StringArray("limit", nil, "size a cache is evicted down to, like packages=2G")
+	cmdPrune.Flags().Duration("older-than", 0, "evict entries not used for that long, like 720h")
+	cmdPrune.Flags().StringSlice("kind", nil, "caches to prune [releases, packages, sysroots, images], all but images by default")
+	cmdPrune.Flags().Bool("dry-run", false, "only print the entries that would be evicted")
+	cmdCache.AddCommand(cmdPrune)
+
+	cmdCache.AddCommand(&cobra.Command{
+		Use:   "pin <path>",
+		Short: "keep a cache entry from being evicted",
+		Args:  cobra.ExactArgs(1),
+		Run:   cachePinCommandHandler,
+	})
+	cmdCache.AddCommand(&cobra.Command{
+		Use:   "unpin <path>",
+		Short: "let a pinned cache entry be evicted again",
+		Args:  cobra.ExactArgs(1),
+		Run:   cachePinCommandHandler,
+	})
+	return cmdCache
+}
+
+func cacheListCommandHandler(cmd *cobra.Command, args []string) {
+	entries, err := api.NewCacheManager().Entries()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	printCacheEntries(entries)
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	fmt.Printf("%d entries, %s\n", len(entries), api.Bytes2Human(total))
+}
+
+func cachePruneCommandHandler(cmd *cobra.Command, args []string) {
+	opts := api.PruneOptions{Limits: map[api.CacheKind]int64{}}
+	opts.OlderThan, _ = cmd.Flags().GetDuration("older-than")
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+
+	if maxSize, _ := cmd.Flags().GetString("max-size"); maxSize != "" {
+		var err error
+		if _, opts.MaxSize, err = api.ParseCacheLimit(maxSize); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+	limits, _ := cmd.Flags().GetStringArray("limit")
+	for _, limit := range limits {
+		kind, size, err := api.ParseCacheLimit(limit)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if kind == "" {
+			exitWithError(fmt.Sprintf("limit %q has no cache, expected <cache>=<size>", limit))
+		}
+		opts.Limits[kind] = size
+	}
+	kinds, _ := cmd.Flags().GetStringSlice("kind")
+	for _, kind := range kinds {
+		opts.Kinds = append(opts.Kinds, api.CacheKind(kind))
+	}
+
+	if opts.OlderThan == 0 && opts.MaxSize == 0 && len(opts.Limits) == 0 {
+		exitWithError("Please specify what to evict with --max-size, --limit or --older-than")
+	}
+
+	report, err := api.PruneCache(opts)
+	if report != nil && len(report.Evicted) > 0 {
+		printCacheEntries(report.Evicted)
+	}
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if opts.DryRun {
+		fmt.Printf("would evict %d entries, %s\n", len(report.Evicted), api.Bytes2Human(report.Freed))
+	} else {
+		fmt.Printf("evicted %d entries, %s freed\n", len(report.Evicted), api.Bytes2Human(report.Freed))
+	}
+}
+
+func cachePinCommandHandler(cmd *cobra.Command, args []string) {
+	pin := cmd.Name() == "pin"
+	if err := api.NewCacheManager().Pin(args[0], pin); err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+func printCacheEntries(entries []api.CacheEntry) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Cache", "Path", "Size", "Last Used", "Pinned"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	for _, e := range entries {
+		pinned := ""
+		if e.Pinned {
+			pinned = "yes"
+		}
+		table.Append([]string{
+			string(e.Kind),
+			e.Path,
+			api.Bytes2Human(e.Size),
+			api.Time2Human(e.LastUsed),
+			pinned,
+		})
+	}
+	table.Render()
+}
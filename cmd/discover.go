@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/spf13/cobra"
+)
+
+// DiscoverCommand records the files a program opens on the host and prints
+// the manifest of an image with exactly those files
+func DiscoverCommand() *cobra.Command {
+	var pid int
+	var duration time.Duration
+	var configOutput string
+	var policy bool
+
+	var cmdDiscover = &cobra.Command{
+		Use:   "discover [ELF file] [args]",
+		Short: "Run a program on the host with strace and print a manifest of the files it opens",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   discoverCommandHandler,
+	}
+
+	cmdDiscover.PersistentFlags().IntVarP(&pid, "pid", "p", 0, "attach to the running process of the program instead of running it")
+	cmdDiscover.PersistentFlags().DurationVarP(&duration, "duration", "d", 0, "stop recording after a duration like 30s, instead of when the program exits")
+	cmdDiscover.PersistentFlags().StringVarP(&configOutput, "config-output", "o", "", "write an ops config with the files to this file")
+	cmdDiscover.PersistentFlags().BoolVar(&policy, "policy", false, "restrict the program to the files and network access it used")
+	return cmdDiscover
+}
+
+func discoverCommandHandler(cmd *cobra.Command, args []string) {
+	pid, _ := cmd.Flags().GetInt("pid")
+	duration, _ := cmd.Flags().GetDuration("duration")
+	configOutput, _ := cmd.Flags().GetString("config-output")
+	policy, _ := cmd.Flags().GetBool("policy")
+
+	d := &api.Discovery{
+		Program:  args[0],
+		Args:     args[1:],
+		PID:      pid,
+		Duration: duration,
+	}
+
+	result, err := d.Run()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	m, err := api.DiscoverManifest(args[0], result.Files)
+	if err != nil {
+		exitWithError(api.DescribeError(err))
+	}
+	config := map[string]interface{}{"Files": result.Files}
+	if policy {
+		m.SetPolicy(result.Policy())
+		config["Policy"] = result.Policy()
+	}
+	fmt.Println(m.String())
+
+	if configOutput != "" {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if err := ioutil.WriteFile(configOutput, append(data, '\n'), 0644); err != nil {
+			exitWithError(err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "%d files written to %s\n", len(result.Files), configOutput)
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/spf13/cobra"
+)
+
+// ErrorsCommand provides a command to look up error codes
+func ErrorsCommand() *cobra.Command {
+	var cmdErrors = &cobra.Command{
+		Use:   "errors [code]",
+		Short: "list error codes or explain one",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   errorsCommandHandler,
+	}
+	return cmdErrors
+}
+
+func errorsCommandHandler(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		for _, info := range api.ErrorCodes() {
+			fmt.Printf("%s  %s\n", info.Code, info.Summary)
+		}
+		return
+	}
+
+	info, ok := api.LookupErrorCode(api.ErrorCode(strings.ToUpper(args[0])))
+	if !ok {
+		exitWithError(fmt.Sprintf("unknown error code %s", args[0]))
+	}
+	fmt.Printf("%s: %s\n\n%s\n", info.Code, info.Summary, info.Remediation)
+}
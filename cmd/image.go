@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	api "github.com/nanovms/ops/lepton"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +20,7 @@ func ImageCommands() *cobra.Command {
 	var cmdImage = &cobra.Command{
 		Use:       "image",
 		Short:     "manage nanos images",
-		ValidArgs: []string{"create", "list", "delete", "resize", "sync"},
+		ValidArgs: []string{"create", "list", "delete", "resize", "sync", "verify", "publish", "push", "pull"},
 		Args:      cobra.OnlyValidArgs,
 	}
 	cmdImage.PersistentFlags().StringVarP(&config, "config", "c", "", "ops config file")
@@ -29,6 +31,10 @@ func ImageCommands() *cobra.Command {
 	cmdImage.AddCommand(imageDeleteCommand())
 	cmdImage.AddCommand(imageResizeCommand())
 	cmdImage.AddCommand(imageSyncCommand())
+	cmdImage.AddCommand(imageVerifyCommand())
+	cmdImage.AddCommand(imagePublishCommand())
+	cmdImage.AddCommand(imagePushCommand())
+	cmdImage.AddCommand(imagePullCommand())
 	return cmdImage
 }
 
@@ -49,6 +55,8 @@ func imageCreateCommand() *cobra.Command {
 	cmdImageCreate.PersistentFlags().StringVarP(&pkg, "package", "p", "", "ops package name")
 	cmdImageCreate.PersistentFlags().StringArrayVarP(&args, "args", "a", nil, "command line arguments")
 	cmdImageCreate.PersistentFlags().StringArrayVar(&mounts, "mounts", nil, "mount <volume_id:mount_path>")
+	cmdImageCreate.PersistentFlags().StringArray("tmpfs", nil, "mount a tmpfs </mount_path>[:<size>], like /tmp:64M")
+	cmdImageCreate.PersistentFlags().Bool("grow-root", false, "grow the root filesystem to the size of the volume on first boot")
 	cmdImageCreate.PersistentFlags().BoolVarP(&nightly, "nightly", "n", false, "nightly build")
 
 	cmdImageCreate.PersistentFlags().StringVarP(&imageName, "imagename", "i", "", "image name")
@@ -89,7 +97,8 @@ func imageCreateCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError("Please select on of the cloud platform in config. [onprem, aws, gcp, do, vsphere, vultr]")
 	}
 
-	if len(c.CloudConfig.BucketName) == 0 && c.CloudConfig.Platform != "onprem" && c.CloudConfig.Platform != "hyper-v" {
+	directImport := c.CloudConfig.Platform == "aws" && c.CloudConfig.ImportMethod == api.AWSImportEBS
+	if len(c.CloudConfig.BucketName) == 0 && c.CloudConfig.Platform != "onprem" && c.CloudConfig.Platform != "hyper-v" && !directImport {
 		exitWithError("Please specify a cloud bucket in config")
 	}
 
@@ -104,11 +113,26 @@ func imageCreateCommandHandler(cmd *cobra.Command, args []string) {
 	}
 	c.BuildDir = bd
 
+	tmpfs, _ := cmd.Flags().GetStringArray("tmpfs")
+	if err := api.AddTmpfsMounts(tmpfs, c); err != nil {
+		exitWithError(err.Error())
+	}
+
+	if growRoot, _ := cmd.Flags().GetBool("grow-root"); growRoot {
+		c.GrowRootFS = true
+	}
+
 	p, ctx, err := getProviderAndContext(c, provider)
 	if err != nil {
 		exitWithError(err.Error())
 	}
 
+	// catch a wrong flavor before the image is uploaded
+	err = api.CheckInstanceType(p, ctx, c.CloudConfig.Zone, c.CloudConfig.Flavor)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	var keypath string
 	if len(pkg) > 0 {
 		c.Args = append(c.Args, cmdargs...)
@@ -144,14 +168,20 @@ func imageCreateCommandHandler(cmd *cobra.Command, args []string) {
 		setDefaultImageName(cmd, c)
 		keypath, err = p.BuildImage(ctx)
 		if err != nil {
-			exitWithError(err.Error())
+			exitWithError(api.DescribeError(err))
 		}
 	}
 
+	start := time.Now()
 	err = p.CreateImage(ctx, keypath)
 	if err != nil {
 		exitWithError(err.Error())
 	}
+	api.RecordTelemetry(api.TelemetryEvent{
+		Event:      "image_create",
+		Provider:   provider,
+		DurationMs: time.Since(start).Nanoseconds() / 1e6,
+	})
 
 	fmt.Printf("%s image '%s' created...\n", provider, c.CloudConfig.ImageName)
 }
@@ -371,3 +401,221 @@ func imageSyncCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 }
+
+func imageVerifyCommand() *cobra.Command {
+	var cmdImageVerify = &cobra.Command{
+		Use:   "verify <image_name> <image_file>",
+		Short: "verify a registered image has the content of a local image file",
+		Run:   imageVerifyCommandHandler,
+		Args:  cobra.MinimumNArgs(2),
+	}
+	return cmdImageVerify
+}
+
+func imageVerifyCommandHandler(cmd *cobra.Command, args []string) {
+	config, _ := cmd.Flags().GetString("config")
+	c := unWarpConfig(strings.TrimSpace(config))
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+
+	zone, _ := cmd.Flags().GetString("zone")
+	if zone != "" {
+		c.CloudConfig.Zone = zone
+	}
+
+	provider, _ := cmd.Flags().GetString("target-cloud")
+	p, err := getCloudProvider(provider, &c.CloudConfig)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	ctx := api.NewContext(c)
+
+	v, err := api.VerifyImage(ctx, p, args[0], args[1])
+	if v != nil {
+		fmt.Printf("%s compared by %s\n", v.Image, v.Method)
+		for i, mismatch := range v.Mismatches {
+			if i == 10 {
+				fmt.Printf("... and %d more\n", len(v.Mismatches)-i)
+				break
+			}
+			fmt.Println(mismatch)
+		}
+	}
+	if err != nil {
+		exitWithError(api.DescribeError(err))
+	}
+	fmt.Printf("image %s matches %s\n", args[0], args[1])
+}
+
+func imagePublishCommand() *cobra.Command {
+	var cmdImagePublish = &cobra.Command{
+		Use:   "publish <image_file>",
+		Short: "create a built image on several providers and zones concurrently",
+		Run:   imagePublishCommandHandler,
+		Args:  cobra.MinimumNArgs(1),
+	}
+	cmdImagePublish.PersistentFlags().StringArray("to", nil, "provider and optional zone to publish to, <provider>[:<zone>], like aws:us-west-2")
+	cmdImagePublish.PersistentFlags().IntP("jobs", "j", 0, "targets published to at the same time, defaults to all of them")
+	cmdImagePublish.PersistentFlags().StringP("imagename", "i", "", "name of the published images, defaults to the image file name")
+	return cmdImagePublish
+}
+
+func imagePublishCommandHandler(cmd *cobra.Command, args []string) {
+	config, _ := cmd.Flags().GetString("config")
+	c := unWarpConfig(strings.TrimSpace(config))
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+
+	zone, _ := cmd.Flags().GetString("zone")
+	if zone != "" {
+		c.CloudConfig.Zone = zone
+	}
+
+	imageName, _ := cmd.Flags().GetString("imagename")
+	if imageName == "" {
+		imageName = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	}
+	c.CloudConfig.ImageName = imageName
+
+	to, _ := cmd.Flags().GetStringArray("to")
+	if len(to) == 0 {
+		exitWithError("Please specify the providers to publish to with --to")
+	}
+	publisher := &api.Publisher{
+		Config:      c,
+		ImagePath:   args[0],
+		NewProvider: getCloudProvider,
+	}
+	publisher.Concurrency, _ = cmd.Flags().GetInt("jobs")
+	for _, s := range to {
+		target, err := api.ParsePublishTarget(s)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		publisher.Targets = append(publisher.Targets, target)
+	}
+
+	report, err := publisher.Publish()
+	if report != nil {
+		printPublishReport(report)
+	}
+	if err != nil {
+		exitWithError(api.DescribeError(err))
+	}
+}
+
+func printPublishReport(report *api.PublishReport) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Target", "Image", "Duration", "Error"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	for _, result := range report.Results {
+		errs := ""
+		if result.Err != nil {
+			errs = result.Err.Error()
+		}
+		table.Append([]string{
+			result.Target.Name(),
+			report.ImageName,
+			result.Duration.Round(1e6).String(),
+			errs,
+		})
+	}
+
+	table.Render()
+	fmt.Printf("Published %s to %d of %d targets in %s\n", report.ImageName, len(report.Results)-len(report.Failed()), len(report.Results), report.Duration.Round(1e6))
+}
+
+func imagePushCommand() *cobra.Command {
+	var cmdImagePush = &cobra.Command{
+		Use:   "push <image_file> <name>[:<tag>]",
+		Short: "store a built image, with its manifest and build report, in a registry",
+		Run:   imagePushCommandHandler,
+		Args:  cobra.MinimumNArgs(2),
+	}
+	cmdImagePush.PersistentFlags().String("registry", os.Getenv("OPS_REGISTRY"), "registry directory, s3://bucket/prefix, gs://bucket/prefix or oci://host/prefix, defaults to OPS_REGISTRY")
+	cmdImagePush.PersistentFlags().String("manifest", "", "manifest file the image was built from, written with --manifest-name, to pull the image by its hash")
+	cmdImagePush.PersistentFlags().String("report", "", "build report of the image, written with --report-name")
+	return cmdImagePush
+}
+
+func imagePushCommandHandler(cmd *cobra.Command, args []string) {
+	registry, ref := imageRegistryAndRef(cmd, args[1])
+
+	files := api.RegistryFiles{Image: args[0]}
+	files.Manifest, _ = cmd.Flags().GetString("manifest")
+	files.Report, _ = cmd.Flags().GetString("report")
+
+	image, err := registry.Push(ref, files)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("pushed %s:%s sha256:%s\n", image.Name, image.Tag, image.Digest)
+	if image.ManifestHash != "" {
+		fmt.Printf("pull it by manifest with %s@sha256:%s\n", image.Name, image.ManifestHash)
+	}
+}
+
+func imagePullCommand() *cobra.Command {
+	var cmdImagePull = &cobra.Command{
+		Use:   "pull <name>[:<tag>|@sha256:<manifest_hash>]",
+		Short: "get an image from a registry",
+		Run:   imagePullCommandHandler,
+		Args:  cobra.MinimumNArgs(1),
+	}
+	cmdImagePull.PersistentFlags().String("registry", os.Getenv("OPS_REGISTRY"), "registry directory, s3://bucket/prefix, gs://bucket/prefix or oci://host/prefix, defaults to OPS_REGISTRY")
+	cmdImagePull.PersistentFlags().StringP("output", "o", "", "image file to write, defaults to the image name in ~/.ops/images")
+	return cmdImagePull
+}
+
+func imagePullCommandHandler(cmd *cobra.Command, args []string) {
+	registry, ref := imageRegistryAndRef(cmd, args[0])
+
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		output = path.Join(api.GetOpsHome(), "images", path.Base(ref.Name)+".img")
+	}
+
+	image, err := registry.Pull(ref, output)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("pulled %s sha256:%s to %s\n", ref, image.Digest, output)
+	if image.ManifestHash != "" {
+		fmt.Printf("manifest written to %s.manifest\n", output)
+	}
+	if image.ReportDigest != "" {
+		fmt.Printf("build report written to %s.report.json\n", output)
+	}
+}
+
+// imageRegistryAndRef returns the registry of the registry flag and the
+// parsed image reference s
+func imageRegistryAndRef(cmd *cobra.Command, s string) (api.Registry, api.ImageRef) {
+	config, _ := cmd.Flags().GetString("config")
+	c := unWarpConfig(strings.TrimSpace(config))
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+
+	zone, _ := cmd.Flags().GetString("zone")
+	if zone != "" {
+		c.CloudConfig.Zone = zone
+	}
+
+	location, _ := cmd.Flags().GetString("registry")
+	if location == "" {
+		exitWithError("Please specify a registry with --registry or OPS_REGISTRY")
+	}
+	registry, err := api.NewRegistry(location, c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	ref, err := api.ParseImageRef(s)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	return registry, ref
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	api "github.com/nanovms/ops/lepton"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -35,6 +36,8 @@ func InstanceCommands() *cobra.Command {
 	cmdInstance.AddCommand(instanceDeleteCommand())
 	cmdInstance.AddCommand(instanceStopCommand())
 	cmdInstance.AddCommand(instanceStartCommand())
+	cmdInstance.AddCommand(instanceSuspendCommand())
+	cmdInstance.AddCommand(instanceScreenshotCommand())
 	cmdInstance.AddCommand(instanceLogsCommand())
 
 	return cmdInstance
@@ -43,7 +46,7 @@ func InstanceCommands() *cobra.Command {
 // Create Instance
 
 func instanceCreateCommand() *cobra.Command {
-	var imageName, config, flavor, domainname string
+	var imageName, config, flavor, domainname, restore string
 
 	var cmdInstanceCreate = &cobra.Command{
 		Use:   "create <instance_name>",
@@ -55,6 +58,9 @@ func instanceCreateCommand() *cobra.Command {
 	cmdInstanceCreate.PersistentFlags().StringVarP(&imageName, "imagename", "i", "", "image name [required]")
 	cmdInstanceCreate.PersistentFlags().StringVarP(&flavor, "flavor", "f", "", "flavor name for cloud provider")
 	cmdInstanceCreate.PersistentFlags().StringVarP(&domainname, "domainname", "d", "", "domain name for instance")
+	cmdInstanceCreate.PersistentFlags().StringVar(&restore, "restore", "", "resume a local instance from a file saved by instance suspend")
+	cmdInstanceCreate.PersistentFlags().String("exec", "", "name of the program of the image to run, passed as exec=<name> userdata")
+	cmdInstanceCreate.PersistentFlags().Int("vnc-port", 0, "serve the console of a local instance with vnc on a localhost port, from 5900")
 
 	cmdInstanceCreate.MarkPersistentFlagRequired("imagename")
 	return cmdInstanceCreate
@@ -78,6 +84,8 @@ func instanceCreateCommandHandler(cmd *cobra.Command, args []string) {
 	flavor, _ := cmd.Flags().GetString("flavor")
 	imagename, _ := cmd.Flags().GetString("imagename")
 	domainname, _ := cmd.Flags().GetString("domainname")
+	restore, _ := cmd.Flags().GetString("restore")
+	exec, _ := cmd.Flags().GetString("exec")
 
 	if projectID != "" {
 		c.CloudConfig.ProjectID = projectID
@@ -99,6 +107,21 @@ func instanceCreateCommandHandler(cmd *cobra.Command, args []string) {
 		c.RunConfig.DomainName = domainname
 	}
 
+	if restore != "" {
+		c.RunConfig.RestoreState = restore
+	}
+
+	if exec != "" {
+		c.RunConfig.Exec = exec
+	}
+
+	if vncPort, _ := cmd.Flags().GetInt("vnc-port"); vncPort != 0 {
+		if vncPort < api.VNCBasePort {
+			exitWithError(fmt.Sprintf("vnc port %d is lower than %d", vncPort, api.VNCBasePort))
+		}
+		c.RunConfig.VNCPort = vncPort
+	}
+
 	if len(args) > 0 {
 		c.RunConfig.InstanceName = args[0]
 	} else if c.RunConfig.InstanceName == "" {
@@ -131,15 +154,30 @@ func instanceCreateCommandHandler(cmd *cobra.Command, args []string) {
 
 	initDefaultRunConfigs(c, ports)
 
+	if c.RunConfig.Exec != "" && !isExecUserDataProvider(provider) {
+		exitWithError(fmt.Sprintf("selecting the program with --exec isn't supported on %s, build the image with --exec instead", provider))
+	}
+
 	p, ctx, err := getProviderAndContext(c, provider)
 	if err != nil {
 		exitForCmd(cmd, err.Error())
 	}
 
+	err = api.CheckInstanceType(p, ctx, c.CloudConfig.Zone, c.CloudConfig.Flavor)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	start := time.Now()
 	err = p.CreateInstance(ctx)
 	if err != nil {
 		exitWithError(err.Error())
 	}
+	api.RecordTelemetry(api.TelemetryEvent{
+		Event:      "instance_create",
+		Provider:   provider,
+		DurationMs: time.Since(start).Nanoseconds() / 1e6,
+	})
 }
 
 // List Instances
@@ -150,6 +188,7 @@ func instanceListCommand() *cobra.Command {
 		Short: "list instance on provider",
 		Run:   instanceListCommandHandler,
 	}
+	addInstanceFilterFlags(cmdInstanceList)
 	return cmdInstanceList
 }
 
@@ -175,6 +214,16 @@ func instanceListCommandHandler(cmd *cobra.Command, args []string) {
 		exitForCmd(cmd, err.Error())
 	}
 
+	filter, filtered := instanceFilterFromFlags(cmd)
+	if filtered {
+		instances, err := api.GetInstancesWithFilter(p, ctx, filter)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		printInstances(instances)
+		return
+	}
+
 	err = p.ListInstances(ctx)
 	if err != nil {
 		exitWithError(err.Error())
@@ -186,10 +235,10 @@ func instanceListCommandHandler(cmd *cobra.Command, args []string) {
 func instanceDeleteCommand() *cobra.Command {
 	var cmdInstanceDelete = &cobra.Command{
 		Use:   "delete <instance_name>",
-		Short: "delete instance on provider",
+		Short: "delete instance on provider, or every instance matching the filter flags",
 		Run:   instanceDeleteCommandHandler,
-		Args:  cobra.MinimumNArgs(1),
 	}
+	addInstanceFilterFlags(cmdInstanceDelete)
 	return cmdInstanceDelete
 }
 
@@ -210,6 +259,21 @@ func instanceDeleteCommandHandler(cmd *cobra.Command, args []string) {
 		exitForCmd(cmd, err.Error())
 	}
 
+	if filter, filtered := instanceFilterFromFlags(cmd); filtered {
+		deleted, err := api.DeleteInstances(p, ctx, filter)
+		for _, instance := range deleted {
+			fmt.Printf("deleted %s\n", instance.Name)
+		}
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		return
+	}
+
+	if len(args) == 0 {
+		exitForCmd(cmd, "instance name or filter is required")
+	}
+
 	err = p.DeleteInstance(ctx, args[0])
 	if err != nil {
 		exitWithError(err.Error())
@@ -256,10 +320,10 @@ func instanceStartCommandHandler(cmd *cobra.Command, args []string) {
 func instanceStopCommand() *cobra.Command {
 	var cmdInstanceStop = &cobra.Command{
 		Use:   "stop <instance_name>",
-		Short: "stop instance on provider",
+		Short: "stop instance on provider, or every instance matching the filter flags",
 		Run:   instanceStopCommandHandler,
-		Args:  cobra.MinimumNArgs(1),
 	}
+	addInstanceFilterFlags(cmdInstanceStop)
 	return cmdInstanceStop
 }
 
@@ -280,12 +344,95 @@ func instanceStopCommandHandler(cmd *cobra.Command, args []string) {
 		exitForCmd(cmd, err.Error())
 	}
 
+	if filter, filtered := instanceFilterFromFlags(cmd); filtered {
+		stopped, err := api.StopInstances(p, ctx, filter)
+		for _, instance := range stopped {
+			fmt.Printf("stopped %s\n", instance.Name)
+		}
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		return
+	}
+
+	if len(args) == 0 {
+		exitForCmd(cmd, "instance name or filter is required")
+	}
+
 	err = p.StopInstance(ctx, args[0])
 	if err != nil {
 		exitWithError(err.Error())
 	}
 }
 
+// Suspend Instance
+
+func instanceSuspendCommand() *cobra.Command {
+	var cmdInstanceSuspend = &cobra.Command{
+		Use:   "suspend <instance_name> <file>",
+		Short: "save the state of a local instance to file and stop it, resume it with instance create --restore",
+		Run:   instanceSuspendCommandHandler,
+		Args:  cobra.MinimumNArgs(2),
+	}
+	return cmdInstanceSuspend
+}
+
+func instanceSuspendCommandHandler(cmd *cobra.Command, args []string) {
+	provider, _ := cmd.Flags().GetString("target-cloud")
+
+	c := api.NewConfig()
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+
+	p, ctx, err := getProviderAndContext(c, provider)
+	if err != nil {
+		exitForCmd(cmd, err.Error())
+	}
+
+	suspender, ok := p.(api.InstanceSuspender)
+	if !ok {
+		exitWithError(fmt.Sprintf("suspending instances is not supported on %s", provider))
+	}
+
+	err = suspender.SuspendInstance(ctx, args[0], args[1])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+// Screenshot Instance
+
+func instanceScreenshotCommand() *cobra.Command {
+	var cmdInstanceScreenshot = &cobra.Command{
+		Use:   "screenshot <instance_name> <file>",
+		Short: "save the display of a local instance to a png or ppm file, the instance needs a display or vnc port",
+		Run:   instanceScreenshotCommandHandler,
+		Args:  cobra.MinimumNArgs(2),
+	}
+	return cmdInstanceScreenshot
+}
+
+func instanceScreenshotCommandHandler(cmd *cobra.Command, args []string) {
+	provider, _ := cmd.Flags().GetString("target-cloud")
+
+	c := api.NewConfig()
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+
+	p, ctx, err := getProviderAndContext(c, provider)
+	if err != nil {
+		exitForCmd(cmd, err.Error())
+	}
+
+	screenshotter, ok := p.(api.InstanceScreenshotter)
+	if !ok {
+		exitWithError(fmt.Sprintf("screenshots of instances are not supported on %s", provider))
+	}
+
+	err = screenshotter.ScreenshotInstance(ctx, args[0], args[1])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+}
+
 // Instance logs
 
 func instanceLogsCommand() *cobra.Command {
@@ -327,3 +474,82 @@ func instanceLogsCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 }
+
+// Instance filters
+
+func addInstanceFilterFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringArray("tag", nil, "only instances with this tag, key=value or key")
+	cmd.PersistentFlags().String("image", "", "only instances created from this image")
+	cmd.PersistentFlags().String("older-than", "", "only instances older than a time notation, e.g. 2d, 3w, 1m or 2y")
+}
+
+// instanceFilterFromFlags returns the filter set on cmd and whether any is set
+func instanceFilterFromFlags(cmd *cobra.Command) (api.InstanceFilter, bool) {
+	var filter api.InstanceFilter
+
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	for _, tag := range tags {
+		if filter.Tags == nil {
+			filter.Tags = map[string]string{}
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			filter.Tags[kv[0]] = kv[1]
+		} else {
+			filter.Tags[kv[0]] = ""
+		}
+	}
+
+	filter.Image, _ = cmd.Flags().GetString("image")
+
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	if olderThan != "" {
+		now := time.Now()
+		olderThanDate, err := SubtractTimeNotation(now, olderThan)
+		if err != nil {
+			exitWithError(fmt.Errorf("failed getting date from older-than flag: %s", err).Error())
+		}
+		filter.OlderThan = now.Sub(olderThanDate)
+	}
+
+	return filter, len(filter.Tags) > 0 || filter.Image != "" || filter.OlderThan > 0
+}
+
+func printInstances(instances []api.CloudInstance) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Id", "Status", "Created", "Image", "Private Ips", "Public Ips"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	for _, instance := range instances {
+		table.Append([]string{
+			instance.Name,
+			instance.ID,
+			instance.Status,
+			instance.Created,
+			instance.Image,
+			strings.Join(instance.PrivateIps, ","),
+			strings.Join(instance.PublicIps, ","),
+		})
+	}
+
+	table.Render()
+}
+
+// isExecUserDataProvider returns whether instances of provider select the
+// program of their image from the exec userdata
+func isExecUserDataProvider(provider string) bool {
+	for _, p := range api.ExecUserDataProviders {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -65,6 +65,7 @@ func mergeConfigs(pkgConfig *api.Config, usrConfig *api.Config) *api.Config {
 	pkgConfig.Args = append(pkgConfig.Args, usrConfig.Args...)
 	pkgConfig.Dirs = append(pkgConfig.Dirs, usrConfig.Dirs...)
 	pkgConfig.Files = append(pkgConfig.Files, usrConfig.Files...)
+	pkgConfig.Exclude = append(pkgConfig.Exclude, usrConfig.Exclude...)
 
 	if pkgConfig.MapDirs == nil {
 		pkgConfig.MapDirs = make(map[string]string)
@@ -89,6 +90,9 @@ func mergeConfigs(pkgConfig *api.Config, usrConfig *api.Config) *api.Config {
 	pkgConfig.Boot = usrConfig.Boot
 	pkgConfig.Mkfs = usrConfig.Mkfs
 	pkgConfig.TargetRoot = usrConfig.TargetRoot
+	pkgConfig.TargetRootLayers = usrConfig.TargetRootLayers
+	pkgConfig.Label = usrConfig.Label
+	pkgConfig.LibraryPaths = usrConfig.LibraryPaths
 	pkgConfig.Force = usrConfig.Force
 	pkgConfig.NightlyBuild = usrConfig.NightlyBuild
 	pkgConfig.NameServer = usrConfig.NameServer
@@ -257,6 +261,11 @@ func loadCommandHandler(cmd *cobra.Command, args []string) {
 	}
 	c.BuildDir = bd
 
+	tmpfs, _ := cmd.Flags().GetStringArray("tmpfs")
+	if err := api.AddTmpfsMounts(tmpfs, c); err != nil {
+		log.Fatal(err)
+	}
+
 	if !skipbuild {
 		if err = buildFromPackage(expackage, c); err != nil {
 			panic(err)
@@ -328,6 +337,7 @@ func LoadCommand() *cobra.Command {
 	cmdLoadPackage.PersistentFlags().BoolVarP(&skipbuild, "skipbuild", "s", false, "skip building package image")
 	cmdLoadPackage.PersistentFlags().BoolVarP(&local, "local", "l", false, "load local package")
 	cmdLoadPackage.PersistentFlags().StringArrayVar(&mounts, "mounts", nil, "<volume_id/label>:/<mount_path>")
+	cmdLoadPackage.PersistentFlags().StringArray("tmpfs", nil, "mount a tmpfs </mount_path>[:<size>], like /tmp:64M")
 	cmdLoadPackage.PersistentFlags().BoolVar(&syscallSummary, "syscall-summary", false, "print syscall summary on exit")
 
 	return cmdLoadPackage
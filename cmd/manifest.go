@@ -33,6 +33,9 @@ func printManifestHandler(cmd *cobra.Command, args []string) {
 	c.NightlyBuild = nightly
 	prepareImages(c)
 	c.TargetRoot = targetRoot
+	if err := api.ResolveTargetRoot(c); err != nil {
+		exitWithError(err.Error())
+	}
 	m, err := api.BuildManifest(c)
 	if err != nil {
 		fmt.Println(err)
@@ -53,7 +56,7 @@ func ManifestCommand() *cobra.Command {
 		Run:   printManifestHandler,
 	}
 	cmdPrintConfig.PersistentFlags().StringVarP(&config, "config", "c", "", "ops config file")
-	cmdPrintConfig.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root")
+	cmdPrintConfig.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root directory, or docker image like docker://ubuntu:20.04")
 	cmdPrintConfig.PersistentFlags().BoolVarP(&nightly, "nightly", "n", false, "nightly build")
 	return cmdPrintConfig
 }
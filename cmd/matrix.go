@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// MatrixCommand builds images of every combination of architectures,
+// profiles and providers
+func MatrixCommand() *cobra.Command {
+	var config string
+	var targetRoot string
+	var targets []string
+	var archs []string
+	var profiles string
+	var jobs int
+	var overrides []string
+
+	var cmdMatrix = &cobra.Command{
+		Use:   "matrix [ELF file]",
+		Short: "Build images for several architectures, profiles and providers concurrently",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   matrixCommandHandler,
+	}
+
+	cmdMatrix.PersistentFlags().StringVarP(&config, "config", "c", "", "ops config file")
+	cmdMatrix.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root directory, or docker image like docker://ubuntu:20.04")
+	cmdMatrix.PersistentFlags().StringSliceVarP(&targets, "target-cloud", "t", []string{"onprem"}, "cloud platforms whose image format is built")
+	cmdMatrix.PersistentFlags().StringSliceVarP(&archs, "arch", "a", []string{"amd64"}, "guest architectures")
+	cmdMatrix.PersistentFlags().StringVar(&profiles, "profiles", "", "json file of profile names to lists of config overrides")
+	cmdMatrix.PersistentFlags().IntVarP(&jobs, "jobs", "j", 0, "images built at the same time, defaults to the number of CPUs")
+	cmdMatrix.PersistentFlags().StringArrayVar(&overrides, "set", nil, "override config field, e.g. env.PORT=8080")
+	return cmdMatrix
+}
+
+func matrixCommandHandler(cmd *cobra.Command, args []string) {
+	config, _ := cmd.Flags().GetString("config")
+	targetRoot, _ := cmd.Flags().GetString("target-root")
+	targets, _ := cmd.Flags().GetStringSlice("target-cloud")
+	archs, _ := cmd.Flags().GetStringSlice("arch")
+	profilesFile, _ := cmd.Flags().GetString("profiles")
+	jobs, _ := cmd.Flags().GetInt("jobs")
+
+	c := unWarpConfig(strings.TrimSpace(config))
+	c.Program = args[0]
+	c.TargetRoot = targetRoot
+	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
+	applyConfigOverrides(cmd, c)
+	setDefaultImageName(cmd, c)
+
+	matrix := &api.BuildMatrix{
+		Base:        c,
+		Archs:       archs,
+		Providers:   targets,
+		Concurrency: jobs,
+		NewProvider: getCloudProvider,
+	}
+
+	if profilesFile != "" {
+		data, err := ioutil.ReadFile(profilesFile)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if err := json.Unmarshal(data, &matrix.Profiles); err != nil {
+			exitWithError(fmt.Sprintf("profiles %s: %v", profilesFile, err))
+		}
+	}
+
+	// kernel and boot images and the target root are fetched once for every
+	// variation
+	prepareImages(c)
+	if err := api.ResolveTargetRoot(c); err != nil {
+		exitWithError(err.Error())
+	}
+
+	report, err := matrix.Build()
+	if report != nil {
+		printMatrixReport(report)
+	}
+	if err != nil {
+		exitWithError(api.DescribeError(err))
+	}
+}
+
+func printMatrixReport(report *api.MatrixReport) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Variation", "Image", "Duration", "Error"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	for _, result := range report.Results {
+		errs := ""
+		if result.Err != nil {
+			errs = result.Err.Error()
+		}
+		table.Append([]string{
+			result.Variation.Name(),
+			result.ImagePath,
+			result.Duration.Round(1e6).String(),
+			errs,
+		})
+	}
+
+	table.Render()
+	fmt.Printf("Built %d of %d images in %s\n", len(report.Results)-len(report.Failed()), len(report.Results), report.Duration.Round(1e6))
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/spf13/cobra"
+)
+
+// PlanCommand prints what deploying an image to a provider changes, without
+// changing anything
+func PlanCommand() *cobra.Command {
+	var cmdPlan = &cobra.Command{
+		Use:   "plan <image_file>",
+		Short: "print the images, instances and dns records a deploy of an image creates, replaces and deletes",
+		Args:  cobra.ExactArgs(1),
+		Run:   planCommandHandler,
+	}
+	cmdPlan.Flags().StringP("config", "c", "", "ops config file")
+	cmdPlan.Flags().StringP("target-cloud", "t", "onprem", "cloud platform [gcp, aws, onprem, vultr, vsphere, azure, openstack, hyper-v]")
+	cmdPlan.Flags().StringP("zone", "z", os.Getenv("GOOGLE_CLOUD_ZONE"), "zone name for GCP or set env GOOGLE_CLOUD_ZONE")
+	cmdPlan.Flags().StringP("projectid", "g", os.Getenv("GOOGLE_CLOUD_PROJECT"), "project-id for GCP or set env GOOGLE_CLOUD_PROJECT")
+	cmdPlan.Flags().StringP("imagename", "i", "", "name of the uploaded image, the image file name by default")
+	cmdPlan.Flags().StringArray("instance", nil, "name of an instance running the image")
+	cmdPlan.Flags().StringSlice("region", nil, "region the image is replicated to")
+	cmdPlan.Flags().Bool("prune", false, "delete the instances of the image that aren't listed")
+	cmdPlan.Flags().StringP("domainname", "d", "", "domain name pointed at the first instance")
+	cmdPlan.Flags().Bool("json", false, "print the plan as json")
+	return cmdPlan
+}
+
+func planCommandHandler(cmd *cobra.Command, args []string) {
+	provider, _ := cmd.Flags().GetString("target-cloud")
+	config, _ := cmd.Flags().GetString("config")
+	config = strings.TrimSpace(config)
+	zone, _ := cmd.Flags().GetString("zone")
+	projectID, _ := cmd.Flags().GetString("projectid")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	var c *api.Config
+	if config != "" {
+		c = unWarpConfig(config)
+	} else {
+		c = api.NewConfig()
+	}
+	c.CloudConfig.Platform = provider
+	if zone != "" {
+		c.CloudConfig.Zone = zone
+	}
+	if projectID != "" {
+		c.CloudConfig.ProjectID = projectID
+	}
+
+	spec := api.DeploySpec{ImagePath: args[0]}
+	spec.ImageName, _ = cmd.Flags().GetString("imagename")
+	if spec.ImageName == "" {
+		spec.ImageName = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	}
+	spec.Instances, _ = cmd.Flags().GetStringArray("instance")
+	spec.Regions, _ = cmd.Flags().GetStringSlice("region")
+	if len(spec.Regions) == 0 {
+		spec.Regions = c.CloudConfig.GalleryRegions
+	}
+	spec.Prune, _ = cmd.Flags().GetBool("prune")
+	spec.DomainName, _ = cmd.Flags().GetString("domainname")
+	if spec.DomainName == "" {
+		spec.DomainName = c.RunConfig.DomainName
+	}
+
+	p, ctx, err := getProviderAndContext(c, provider)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	plan, err := api.PlanDeploy(ctx, p, spec)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if asJSON {
+		out, err := plan.JSON()
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Print(plan.String())
+}
@@ -16,7 +16,9 @@ func GetRootCommand() *cobra.Command {
 	rootCmd.AddCommand(RunCommand())
 	rootCmd.AddCommand(NetCommands())
 	rootCmd.AddCommand(BuildCommand())
+	rootCmd.AddCommand(MatrixCommand())
 	rootCmd.AddCommand(ManifestCommand())
+	rootCmd.AddCommand(DiscoverCommand())
 	rootCmd.AddCommand(VersionCommand())
 	rootCmd.AddCommand(ProfileCommand())
 	rootCmd.AddCommand(UpdateCommand())
@@ -25,6 +27,11 @@ func GetRootCommand() *cobra.Command {
 	rootCmd.AddCommand(InstanceCommands())
 	rootCmd.AddCommand(ImageCommands())
 	rootCmd.AddCommand(VolumeCommands())
+	rootCmd.AddCommand(ValidateCommand())
+	rootCmd.AddCommand(TelemetryCommand())
+	rootCmd.AddCommand(ErrorsCommand())
+	rootCmd.AddCommand(CacheCommand())
+	rootCmd.AddCommand(PlanCommand())
 
 	return rootCmd
 }
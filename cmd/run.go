@@ -8,6 +8,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-errors/errors"
 
@@ -149,6 +150,10 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
+	serialLog, _ := cmd.Flags().GetString("serial-log")
+	serialLogSize, _ := cmd.Flags().GetInt("serial-log-size")
+	serialLogBackups, _ := cmd.Flags().GetInt("serial-log-backups")
+
 	c := unWarpConfig(config)
 	AppendGlobalCmdFlagsToConfig(cmd.Flags(), c)
 
@@ -177,6 +182,10 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 			c.Env[ez[0]] = ez[1]
 		}
 	}
+	passEnv, _ := cmd.Flags().GetStringArray("pass-env")
+	c.EnvPassthrough = append(c.EnvPassthrough, passEnv...)
+
+	applyConfigOverrides(cmd, c)
 
 	c.Debugflags = []string{}
 
@@ -209,11 +218,40 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 
 	c.RunConfig.GdbPort = gdbport
 
+	if serialLog != "" {
+		c.RunConfig.SerialLog = serialLog
+	}
+	if serialLogSize > 0 {
+		c.RunConfig.SerialLogSize = serialLogSize
+	}
+	if serialLogBackups > 0 {
+		c.RunConfig.SerialLogBackups = serialLogBackups
+	}
+
+	if display, _ := cmd.Flags().GetString("display"); display != "" {
+		c.RunConfig.Display = display
+	}
+	if vncPort, _ := cmd.Flags().GetInt("vnc-port"); vncPort != 0 {
+		c.RunConfig.VNCPort = vncPort
+	}
+	if c.RunConfig.VNCPort != 0 && c.RunConfig.VNCPort < api.VNCBasePort {
+		exitWithError(fmt.Sprintf("vnc port %d is lower than %d", c.RunConfig.VNCPort, api.VNCBasePort))
+	}
+
 	if smp > 0 {
 		c.RunConfig.CPUs = smp
 	}
 
 	c.TargetRoot = targetRoot
+	layers, _ := cmd.Flags().GetStringArray("target-root-layer")
+	c.TargetRootLayers = append(c.TargetRootLayers, layers...)
+	libraryPaths, _ := cmd.Flags().GetStringArray("library-path")
+	c.LibraryPaths = append(c.LibraryPaths, libraryPaths...)
+	excludes, _ := cmd.Flags().GetStringArray("exclude")
+	c.Exclude = append(c.Exclude, excludes...)
+	if strict, _ := cmd.Flags().GetBool("target-root-strict"); strict {
+		c.TargetRootStrict = true
+	}
 
 	c.RunConfig.TapName = tapDeviceName
 	c.RunConfig.Verbose = verbose
@@ -222,6 +260,12 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 	c.NightlyBuild = nightly
 	c.Force = force
 	c.ManifestName = manifestName
+	if reportName, _ := cmd.Flags().GetString("report-name"); reportName != "" {
+		c.ReportName = reportName
+	}
+	if profileDir, _ := cmd.Flags().GetString("profile-dir"); profileDir != "" {
+		c.ProfileDir = profileDir
+	}
 
 	if ipaddr != "" && isIPAddressValid(ipaddr) {
 		c.RunConfig.IPAddr = ipaddr
@@ -256,13 +300,46 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 	}
 	c.BuildDir = bd
 
+	tmpfs, _ := cmd.Flags().GetStringArray("tmpfs")
+	if err := api.AddTmpfsMounts(tmpfs, c); err != nil {
+		log.Fatal(err)
+	}
+
+	dataVolumes, _ := cmd.Flags().GetStringArray("data-volume")
+	c.DataVolumes = append(c.DataVolumes, dataVolumes...)
+
+	programs, _ := cmd.Flags().GetStringArray("program")
+	if err := api.AddProgramFlags(programs, c); err != nil {
+		exitWithError(err.Error())
+	}
+	setups, _ := cmd.Flags().GetStringArray("setup")
+	if err := api.AddSetupFlags(setups, c); err != nil {
+		exitWithError(err.Error())
+	}
+	if onExit, _ := cmd.Flags().GetString("on-exit"); onExit != "" {
+		c.Exit.OnExit = api.ExitAction(onExit)
+	}
+	if onCrash, _ := cmd.Flags().GetString("on-crash"); onCrash != "" {
+		c.Exit.OnCrash = api.ExitAction(onCrash)
+	}
+	if propagate, _ := cmd.Flags().GetBool("propagate-exit-status"); propagate {
+		c.Exit.PropagateStatus = true
+	}
+	if exec, _ := cmd.Flags().GetString("exec"); exec != "" {
+		c.RunConfig.Exec = exec
+	}
+
 	if !skipbuild {
 		err = buildImages(c)
 		if err != nil {
-			panic(err)
+			exitWithError(api.DescribeError(err))
 		}
 	}
 
+	if err := api.AddDataVolumeMounts(c); err != nil {
+		exitWithError(api.DescribeError(err))
+	}
+
 	portsFlag, err := cmd.Flags().GetStringArray("port")
 	if err != nil {
 		panic(err)
@@ -293,7 +370,20 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 	}
 
 	initDefaultRunConfigs(c, ports)
-	hypervisor.Start(&c.RunConfig)
+
+	if debug {
+		fmt.Print(api.GdbInstructions(c))
+	}
+
+	c.RunConfig.PropagateExitStatus = c.Exit.PropagateStatus
+	err = hypervisor.Start(&c.RunConfig)
+
+	report := hypervisor.Report()
+	api.RecordTelemetry(api.TelemetryEvent{
+		Event:       "run",
+		DurationMs:  time.Since(report.StartedAt).Nanoseconds() / 1e6,
+		Accelerator: report.Accelerator,
+	})
 
 	if tapDeviceName != "" {
 		err := network.TurnOffNetworkInterfaces(networkService, tapDeviceName, bridged, bridgeName)
@@ -301,6 +391,10 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 			panic(err)
 		}
 	}
+
+	if exitErr, ok := err.(*api.GuestExitError); ok {
+		os.Exit(exitErr.Status)
+	}
 }
 
 // RunCommand provides support for running binary with nanos
@@ -320,6 +414,7 @@ func RunCommand() *cobra.Command {
 	var tap string
 	var mounts []string
 	var syscallSummary bool
+	var overrides []string
 
 	var skipbuild bool
 	var manifestName string
@@ -343,8 +438,13 @@ func RunCommand() *cobra.Command {
 	cmdRun.PersistentFlags().StringArrayVarP(&noTrace, "no-trace", "", nil, "do not trace syscall")
 	cmdRun.PersistentFlags().StringArrayVarP(&args, "args", "a", nil, "command line arguments")
 	cmdRun.PersistentFlags().StringArrayVarP(&envs, "envs", "e", nil, "env arguments")
+	cmdRun.PersistentFlags().StringArray("pass-env", nil, "pass the value of a host environment variable to the image")
 	cmdRun.PersistentFlags().StringVarP(&config, "config", "c", "", "ops config file")
-	cmdRun.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root")
+	cmdRun.PersistentFlags().StringVarP(&targetRoot, "target-root", "r", "", "target root directory, or docker image like docker://ubuntu:20.04")
+	cmdRun.PersistentFlags().StringArray("target-root-layer", nil, "directory, or docker image, laid over the target root, later layers win")
+	cmdRun.PersistentFlags().StringArray("library-path", nil, "directory searched for the shared libraries of the program, like LD_LIBRARY_PATH")
+	cmdRun.PersistentFlags().StringArray("exclude", nil, "leave files matching a pattern like *.log, node_modules/** or re:<regexp> out of added directories")
+	cmdRun.PersistentFlags().Bool("target-root-strict", false, "never take files or libraries missing from the target root from the host")
 	cmdRun.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose")
 	cmdRun.PersistentFlags().BoolVarP(&bridged, "bridged", "b", false, "bridge networking")
 	cmdRun.PersistentFlags().StringVarP(&tap, "tapname", "t", "", "tap device name")
@@ -354,10 +454,26 @@ func RunCommand() *cobra.Command {
 	cmdRun.PersistentFlags().BoolVarP(&skipbuild, "skipbuild", "s", false, "skip building image")
 	cmdRun.PersistentFlags().StringVarP(&imageName, "imagename", "i", "", "image name")
 	cmdRun.PersistentFlags().StringVarP(&manifestName, "manifest-name", "m", "", "save manifest to file")
+	cmdRun.PersistentFlags().String("report-name", "", "save the build report to file as json")
+	cmdRun.PersistentFlags().String("profile-dir", "", "write pprof cpu and heap profiles of the build to directory")
 	cmdRun.PersistentFlags().BoolVar(&accel, "accel", true, "use cpu virtualization extension")
 	cmdRun.PersistentFlags().IntVarP(&smp, "smp", "", 1, "number of threads to use")
 	cmdRun.PersistentFlags().StringArrayVar(&mounts, "mounts", nil, "<volume_id/label>:/<mount_path>")
+	cmdRun.PersistentFlags().StringArray("tmpfs", nil, "mount a tmpfs </mount_path>[:<size>], like /tmp:64M")
+	cmdRun.PersistentFlags().String("on-exit", "", "what the instance does when the program exits [halt, reboot]")
+	cmdRun.PersistentFlags().String("on-crash", "", "what the instance does when the program crashes, --on-exit by default [halt, reboot]")
+	cmdRun.PersistentFlags().Bool("propagate-exit-status", false, "report the exit status of the program to the hypervisor, ops run exits with it")
+	cmdRun.PersistentFlags().StringArray("setup", nil, "run a program with its arguments before the program, like \"./migrate up\"")
+	cmdRun.PersistentFlags().StringArray("program", nil, "add a program selectable at boot, name=path")
+	cmdRun.PersistentFlags().String("exec", "", "name of the program of --program to run")
+	cmdRun.PersistentFlags().String("display", "", "qemu display showing the console of the instance [gtk, sdl, curses]")
+	cmdRun.PersistentFlags().Int("vnc-port", 0, "serve the console of the instance with vnc on a localhost port, from 5900")
+	cmdRun.PersistentFlags().StringArray("data-volume", nil, "move an image directory like /var to a writable volume mounted at it")
 	cmdRun.PersistentFlags().BoolVar(&syscallSummary, "syscall-summary", false, "print syscall summary on exit")
+	cmdRun.PersistentFlags().StringArrayVar(&overrides, "set", nil, "override config field, e.g. env.PORT=8080")
+	cmdRun.PersistentFlags().String("serial-log", "", "also write serial output with timestamps to this file")
+	cmdRun.PersistentFlags().Int("serial-log-size", 0, "size in MB the serial log is rotated at (default 10)")
+	cmdRun.PersistentFlags().Int("serial-log-backups", 0, "number of rotated serial logs to keep (default 5)")
 
 	return cmdRun
 }
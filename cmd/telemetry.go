@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/spf13/cobra"
+)
+
+// TelemetryCommand provides commands to opt in to and inspect telemetry
+func TelemetryCommand() *cobra.Command {
+	var cmdTelemetry = &cobra.Command{
+		Use:   "telemetry",
+		Short: "manage anonymous usage telemetry, off unless enabled",
+		Run:   telemetryStatusCommandHandler,
+	}
+
+	var cmdEnable = &cobra.Command{
+		Use:   "enable",
+		Short: "record anonymous build and run metrics to a local spool",
+		Run:   telemetryEnableCommandHandler,
+	}
+	cmdEnable.Flags().String("endpoint", "", "url spooled metrics are uploaded to with telemetry upload")
+
+	cmdTelemetry.AddCommand(cmdEnable)
+	cmdTelemetry.AddCommand(&cobra.Command{
+		Use:   "disable",
+		Short: "stop recording telemetry and delete the spool",
+		Run:   telemetryDisableCommandHandler,
+	})
+	cmdTelemetry.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "print the spooled metrics",
+		Run:   telemetryShowCommandHandler,
+	})
+	cmdTelemetry.AddCommand(&cobra.Command{
+		Use:   "upload",
+		Short: "upload the spooled metrics to the configured endpoint",
+		Run:   telemetryUploadCommandHandler,
+	})
+	return cmdTelemetry
+}
+
+func telemetryStatusCommandHandler(cmd *cobra.Command, args []string) {
+	settings, err := api.LoadTelemetrySettings()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	events, err := api.SpooledTelemetry()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if !settings.Enabled {
+		fmt.Println("telemetry is disabled")
+	} else if settings.Endpoint == "" {
+		fmt.Println("telemetry is enabled, metrics are only kept locally")
+	} else {
+		fmt.Printf("telemetry is enabled, metrics are uploaded to %s\n", settings.Endpoint)
+	}
+	fmt.Printf("%d events spooled in %s\n", len(events), api.TelemetrySpoolFile())
+}
+
+func telemetryEnableCommandHandler(cmd *cobra.Command, args []string) {
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+
+	err := api.SaveTelemetrySettings(api.TelemetrySettings{Enabled: true, Endpoint: endpoint})
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	telemetryStatusCommandHandler(cmd, args)
+}
+
+func telemetryDisableCommandHandler(cmd *cobra.Command, args []string) {
+	if err := api.SaveTelemetrySettings(api.TelemetrySettings{}); err != nil {
+		exitWithError(err.Error())
+	}
+	if err := api.ClearTelemetry(); err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Println("telemetry is disabled")
+}
+
+func telemetryShowCommandHandler(cmd *cobra.Command, args []string) {
+	events, err := api.SpooledTelemetry()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	for _, event := range events {
+		data, _ := json.Marshal(event)
+		fmt.Println(string(data))
+	}
+}
+
+func telemetryUploadCommandHandler(cmd *cobra.Command, args []string) {
+	n, err := api.UploadTelemetry()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("uploaded %d events\n", n)
+}
@@ -1,9 +1,7 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
@@ -35,62 +33,51 @@ func exitForCmd(cmd *cobra.Command, errs string) {
 
 // unWarpConfig parses lepton config file from file
 func unWarpConfig(file string) *api.Config {
-	var c api.Config
 	if file != "" {
-		c = *api.NewConfig()
-		data, err := ioutil.ReadFile(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error reading config: %v\n", err)
-			os.Exit(1)
-		}
-		err = json.Unmarshal(data, &c)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error config: %v\n", err)
-			os.Exit(1)
-		}
-		return &c
+		return readConfig(file)
+	}
+	return unWarpDefaultConfig()
+}
+
+// readConfig returns the config of the config file at file, and exits when
+// it can't be read
+func readConfig(file string) *api.Config {
+	c, err := api.ReadConfig(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error config: %v\n", err)
+		os.Exit(1)
 	}
-	c = *unWarpDefaultConfig()
-	return &c
+	return c
 }
 
 // unWarpDefaultConfig gets default config file from env
 func unWarpDefaultConfig() *api.Config {
-	c := *api.NewConfig()
 	conf := os.Getenv("OPS_DEFAULT_CONFIG")
 	if conf != "" {
-		data, err := ioutil.ReadFile(conf)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error reading config: %v\n", err)
-			os.Exit(1)
-		}
-		err = json.Unmarshal(data, &c)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error config: %v\n", err)
-			os.Exit(1)
-		}
-		return &c
+		return readConfig(conf)
 	}
 	usr, err := user.Current()
 	if err != nil {
-		return &c
+		return api.NewConfig()
 	}
 	conf = usr.HomeDir + "/.opsrc"
 	_, err = os.Stat(conf)
 	if err != nil {
-		return &c
+		return api.NewConfig()
 	}
-	data, err := ioutil.ReadFile(conf)
+	return readConfig(conf)
+}
+
+// applyConfigOverrides applies the --set overrides on top of config
+func applyConfigOverrides(cmd *cobra.Command, c *api.Config) {
+	overrides, err := cmd.Flags().GetStringArray("set")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading config: %v\n", err)
-		os.Exit(1)
+		return
 	}
-	err = json.Unmarshal(data, &c)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error config: %v\n", err)
-		os.Exit(1)
+
+	if err := api.ApplyConfigOverrides(c, overrides...); err != nil {
+		exitWithError(err.Error())
 	}
-	return &c
 }
 
 // setDefaultImageName set default name for an image
@@ -176,6 +163,8 @@ func fixupConfigImages(c *api.Config, version string) {
 		c.Mkfs = path.Join(api.GetOpsHome(), version, "mkfs")
 	}
 
+	api.MarkCacheUsed(path.Join(api.GetOpsHome(), version))
+
 	if c.NameServer == "" {
 		// google dns server
 		c.NameServer = "8.8.8.8"
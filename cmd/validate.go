@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/spf13/cobra"
+)
+
+func validateConfigHandler(cmd *cobra.Command, args []string) {
+	schema, _ := cmd.Flags().GetBool("schema")
+	if schema {
+		out, err := json.MarshalIndent(api.ConfigSchema(), "", "  ")
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if len(args) == 0 {
+		exitForCmd(cmd, "config file is required")
+	}
+
+	failed := false
+	for _, file := range args {
+		issues, err := api.ValidateConfig(file)
+		if err != nil {
+			exitWithError(fmt.Sprintf("%s: %v", file, err))
+		}
+		for _, issue := range issues {
+			if issue.Line == 0 {
+				fmt.Printf("%s: %s\n", file, issue)
+			} else {
+				fmt.Printf("%s:%s\n", file, issue)
+			}
+			if issue.Kind != api.ConfigDeprecatedKey {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// ValidateCommand validates config files without building
+func ValidateCommand() *cobra.Command {
+	var schema bool
+	var cmdValidate = &cobra.Command{
+		Use:   "validate [config file]",
+		Short: "Validate config files",
+		Run:   validateConfigHandler,
+	}
+	cmdValidate.PersistentFlags().BoolVar(&schema, "schema", false, "print the config JSON schema")
+	return cmdValidate
+}
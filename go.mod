@@ -35,6 +35,8 @@ require (
 	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4
+	golang.org/x/text v0.3.2
 	google.golang.org/api v0.7.0
 	gopkg.in/ini.v1 v1.55.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ebs"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -20,30 +19,6 @@ type AWS struct {
 	ec2           *ec2.EC2
 }
 
-func loadAWSCreds() (err error) {
-	foundValidCredentials := false
-
-	fileCreds := credentials.NewSharedCredentials("", "")
-
-	_, err = fileCreds.Get()
-	if err == nil {
-		foundValidCredentials = true
-	}
-
-	envCreds := credentials.NewEnvCredentials()
-
-	_, err = envCreds.Get()
-	if err == nil {
-		foundValidCredentials = true
-	}
-
-	if foundValidCredentials {
-		err = nil
-	}
-
-	return
-}
-
 // Initialize AWS related things
 func (p *AWS) Initialize(config *ProviderConfig) error {
 	p.Storage = &S3{}
@@ -52,7 +27,7 @@ func (p *AWS) Initialize(config *ProviderConfig) error {
 		return fmt.Errorf("Zone missing")
 	}
 
-	err := loadAWSCreds()
+	_, err := ResolveCredentials("aws", config.Profile)
 	if err != nil {
 		return err
 	}
@@ -60,6 +35,8 @@ func (p *AWS) Initialize(config *ProviderConfig) error {
 	session, err := session.NewSession(
 		&aws.Config{
 			Region: aws.String(config.Zone),
+			// the sdk backs off on throttling by itself
+			MaxRetries: aws.Int(RetryPolicyFromConfig(config).Retries),
 		},
 	)
 	if err != nil {
@@ -0,0 +1,333 @@
+package lepton
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ebs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ebsWriters is the number of blocks uploaded concurrently
+const ebsWriters = 16
+
+// ebsBlock is a block of the image to be written to a snapshot
+type ebsBlock struct {
+	index int64
+	data  []byte
+}
+
+// writeSnapshot writes the image directly to a new snapshot using the EBS
+// direct APIs, skipping blocks that are all zeroes
+func (p *AWS) writeSnapshot(ctx *Context, imagePath string) (*string, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c := ctx.config
+	var tags []*ebs.Tag
+	for _, tag := range c.RunConfig.Tags {
+		tags = append(tags, &ebs.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+
+	state, err := loadUploadState("ebs-"+c.CloudConfig.ImageName, imagePath, 0)
+	if err != nil {
+		return nil, err
+	}
+	if state.UploadID != "" && !p.snapshotPending(state.UploadID) {
+		state.UploadID = ""
+		state.Parts = map[int64]string{}
+	}
+
+	if state.UploadID == "" {
+		ctx.logger.Info("Writing snapshot with EBS direct APIs")
+		snapshot, err := p.volumeService.StartSnapshot(&ebs.StartSnapshotInput{
+			Description: aws.String(fmt.Sprintf("nanos image %s", c.CloudConfig.ImageName)),
+			VolumeSize:  aws.Int64(ebsVolumeSize(fi.Size())),
+			Tags:        tags,
+		})
+		if err != nil {
+			return nil, err
+		}
+		state.UploadID = aws.StringValue(snapshot.SnapshotId)
+		state.PartSize = aws.Int64Value(snapshot.BlockSize)
+	} else {
+		ctx.logger.Info("Resuming snapshot %s, %d blocks already written", state.UploadID, len(state.Parts))
+	}
+	snapshotID := aws.String(state.UploadID)
+
+	written, err := p.writeSnapshotBlocks(snapshotID, state.PartSize, f, state, RetryPolicyFromConfig(&c.CloudConfig))
+	if err != nil {
+		state.save()
+		return nil, err
+	}
+
+	_, err = p.volumeService.CompleteSnapshot(&ebs.CompleteSnapshotInput{
+		SnapshotId:         snapshotID,
+		ChangedBlocksCount: aws.Int64(written),
+	})
+	if err != nil {
+		state.save()
+		return nil, err
+	}
+	state.remove()
+
+	ctx.logger.Info("Waiting for snapshot to complete")
+	err = p.ec2.WaitUntilSnapshotCompleted(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{snapshotID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotID, nil
+}
+
+// snapshotPending reports whether blocks can still be written to the
+// snapshot, it can't once it completed, failed or was deleted
+func (p *AWS) snapshotPending(snapshotID string) bool {
+	out, err := p.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String(snapshotID)},
+	})
+	if err != nil || len(out.Snapshots) == 0 {
+		return false
+	}
+	return aws.StringValue(out.Snapshots[0].State) == ec2.SnapshotStatePending
+}
+
+// writeSnapshotBlocks uploads the non-empty blocks of r, but those state
+// records as already written, and returns how many the snapshot has
+func (p *AWS) writeSnapshotBlocks(snapshotID *string, blockSize int64, r io.Reader, state *uploadState, retry RetryPolicy) (int64, error) {
+	blocks := make(chan ebsBlock)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var writeErr error
+	for i := 0; i < ebsWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range blocks {
+				sum, err := p.putSnapshotBlock(snapshotID, block, retry)
+				if err != nil {
+					once.Do(func() {
+						writeErr = err
+						close(done)
+					})
+					return
+				}
+				state.done(block.index, sum)
+			}
+		}()
+	}
+
+	var written int64
+	var readErr error
+
+read:
+	for index := int64(0); ; index++ {
+		data, err := readEBSBlock(r, blockSize)
+		if err != nil {
+			readErr = err
+			break
+		}
+		if data == nil {
+			break
+		}
+		if isZeroBlock(data) {
+			continue
+		}
+		if _, ok := state.sent(index); ok {
+			written++
+			continue
+		}
+
+		select {
+		case blocks <- ebsBlock{index: index, data: data}:
+			written++
+		case <-done:
+			break read
+		}
+	}
+	close(blocks)
+	wg.Wait()
+
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	if readErr != nil {
+		return 0, readErr
+	}
+
+	return written, nil
+}
+
+// putSnapshotBlock writes the block with its sha256 for ebs to check and
+// returns the base64 checksum
+func (p *AWS) putSnapshotBlock(snapshotID *string, block ebsBlock, retry RetryPolicy) (string, error) {
+	sum := sha256.Sum256(block.data)
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+
+	// the session retries requests, but a dropped connection mid block
+	// surfaces here and only this block needs to be sent again
+	err := retry.Do(func() error {
+		_, err := p.volumeService.PutSnapshotBlock(&ebs.PutSnapshotBlockInput{
+			SnapshotId:        snapshotID,
+			BlockIndex:        aws.Int64(block.index),
+			BlockData:         bytes.NewReader(block.data),
+			DataLength:        aws.Int64(int64(len(block.data))),
+			Checksum:          aws.String(checksum),
+			ChecksumAlgorithm: aws.String(ebs.ChecksumAlgorithmChecksumAlgorithmSha256),
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("writing block %d: %v", block.index, err)
+	}
+	return checksum, nil
+}
+
+// readEBSBlock reads the next block of r, zero padding the last block. It
+// returns nil at the end of r.
+func readEBSBlock(r io.Reader, blockSize int64) ([]byte, error) {
+	data := make([]byte, blockSize)
+	_, err := io.ReadFull(r, data)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func isZeroBlock(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ebsVolumeSize returns the size in GiB of the smallest volume holding size bytes
+func ebsVolumeSize(size int64) int64 {
+	const gib = 1 << 30
+	n := (size + gib - 1) / gib
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// VerifyImage compares the snapshot of the AMI imagename to the image file at
+// imagePath, using the checksums of the snapshot blocks the EBS direct APIs
+// return
+func (p *AWS) VerifyImage(ctx *Context, imagename string, imagePath string) (*ImageVerification, error) {
+	result, err := p.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("name"), Values: aws.StringSlice([]string{imagename})}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Images) == 0 || len(result.Images[0].BlockDeviceMappings) == 0 || result.Images[0].BlockDeviceMappings[0].Ebs == nil {
+		return nil, fmt.Errorf("image %s not found", imagename)
+	}
+	snapshotID := result.Images[0].BlockDeviceMappings[0].Ebs.SnapshotId
+
+	var blockSize int64
+	var blocks []*ebs.Block
+	err = p.volumeService.ListSnapshotBlocksPages(&ebs.ListSnapshotBlocksInput{SnapshotId: snapshotID},
+		func(page *ebs.ListSnapshotBlocksOutput, lastPage bool) bool {
+			blockSize = aws.Int64Value(page.BlockSize)
+			blocks = append(blocks, page.Blocks...)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	sums, err := p.snapshotBlockChecksums(snapshotID, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v := &ImageVerification{
+		Image:  fmt.Sprintf("%s (%s)", aws.StringValue(result.Images[0].ImageId), aws.StringValue(snapshotID)),
+		Method: "sha256 of the snapshot blocks",
+	}
+	v.Mismatches, err = compareBlocks(f, blockSize, sums)
+	return v, err
+}
+
+// snapshotBlockChecksums returns the base64 sha256 of the blocks of a
+// snapshot by block index
+func (p *AWS) snapshotBlockChecksums(snapshotID *string, blocks []*ebs.Block) (map[int64]string, error) {
+	work := make(chan *ebs.Block)
+	sums := map[int64]string{}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var once sync.Once
+	var readErr error
+	for i := 0; i < ebsWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range work {
+				out, err := p.volumeService.GetSnapshotBlock(&ebs.GetSnapshotBlockInput{
+					SnapshotId: snapshotID,
+					BlockIndex: block.BlockIndex,
+					BlockToken: block.BlockToken,
+				})
+				if err != nil {
+					once.Do(func() { readErr = fmt.Errorf("reading block %d: %v", aws.Int64Value(block.BlockIndex), err) })
+					continue
+				}
+				// only the checksum is compared
+				out.BlockData.Close()
+
+				if aws.StringValue(out.ChecksumAlgorithm) != ebs.ChecksumAlgorithmChecksumAlgorithmSha256 {
+					once.Do(func() {
+						readErr = fmt.Errorf("block %d has a checksum of unknown algorithm %s", aws.Int64Value(block.BlockIndex), aws.StringValue(out.ChecksumAlgorithm))
+					})
+					continue
+				}
+				mu.Lock()
+				sums[aws.Int64Value(block.BlockIndex)] = aws.StringValue(out.Checksum)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, block := range blocks {
+		work <- block
+	}
+	close(work)
+	wg.Wait()
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return sums, nil
+}
@@ -0,0 +1,46 @@
+package lepton
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEBSVolumeSize(t *testing.T) {
+	for size, want := range map[int64]int64{
+		0:                1,
+		1:                1,
+		1 << 30:          1,
+		1<<30 + 1:        2,
+		5*(1<<30) - 4096: 5,
+	} {
+		if got := ebsVolumeSize(size); got != want {
+			t.Errorf("ebsVolumeSize(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestReadEBSBlock(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})
+
+	var blocks [][]byte
+	for {
+		block, err := readEBSBlock(r, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	if !bytes.Equal(blocks[1], []byte{5, 6, 0, 0}) {
+		t.Errorf("last block should be zero padded, got %v", blocks[1])
+	}
+	if isZeroBlock(blocks[1]) || !isZeroBlock(make([]byte, 4)) {
+		t.Error("isZeroBlock misreported a block")
+	}
+}
@@ -39,50 +39,34 @@ func (p *AWS) BuildImageWithPackage(ctx *Context, pkgpath string) (string, error
 	return p.CustomizeImage(ctx)
 }
 
+const (
+	// AWSImportSnapshot imports images from the bucket with the vmimport service
+	AWSImportSnapshot = "snapshot"
+	// AWSImportEBS writes images directly to a snapshot with the EBS direct APIs
+	AWSImportEBS = "ebs"
+)
+
 // CreateImage - Creates image on AWS using nanos images
 // TODO : re-use and cache DefaultClient and instances.
 func (p *AWS) CreateImage(ctx *Context, imagePath string) error {
 	// this is a really convulted setup
-	// 1) upload the image
+	// 1) upload the image (or write it straight to ebs)
 	// 2) create a snapshot
 	// 3) create an image
 
-	err := p.Storage.CopyToBucket(ctx.config, imagePath)
-	if err != nil {
-		return err
-	}
-
 	c := ctx.config
-
-	bucket := c.CloudConfig.BucketName
 	key := c.CloudConfig.ImageName
 
-	input := &ec2.ImportSnapshotInput{
-		Description: aws.String("NanoVMs test"),
-		DiskContainer: &ec2.SnapshotDiskContainer{
-			Description: aws.String("NanoVMs test"),
-			Format:      aws.String("raw"),
-			UserBucket: &ec2.UserBucket{
-				S3Bucket: aws.String(bucket),
-				S3Key:    aws.String(key),
-			},
-		},
+	var snapshotID *string
+	var err error
+	switch c.CloudConfig.ImportMethod {
+	case "", AWSImportSnapshot:
+		snapshotID, err = p.importSnapshot(ctx, imagePath)
+	case AWSImportEBS:
+		snapshotID, err = p.writeSnapshot(ctx, imagePath)
+	default:
+		err = fmt.Errorf("unknown import method %q, expected %s or %s", c.CloudConfig.ImportMethod, AWSImportSnapshot, AWSImportEBS)
 	}
-
-	ctx.logger.Info("Importing snapshot from s3 image file")
-	res, err := p.ec2.ImportSnapshot(input)
-	if err != nil {
-		return err
-	}
-
-	snapshotID, err := p.waitSnapshotToBeReady(c, res.ImportTaskId)
-	if err != nil {
-		return err
-	}
-
-	// delete the tmp s3 image
-	ctx.logger.Info("Deleting s3 image file")
-	err = p.Storage.DeleteFromBucket(c, key)
 	if err != nil {
 		return err
 	}
@@ -99,7 +83,7 @@ func (p *AWS) CreateImage(ctx *Context, imagePath string) error {
 		return err
 	}
 
-	t := time.Now().UnixNano()
+	t := ctx.config.sources().Now().UnixNano()
 	s := strconv.FormatInt(t, 10)
 
 	amiName := key + s
@@ -142,6 +126,51 @@ func (p *AWS) CreateImage(ctx *Context, imagePath string) error {
 	return nil
 }
 
+// importSnapshot uploads the image to the bucket and imports it as a snapshot
+func (p *AWS) importSnapshot(ctx *Context, imagePath string) (*string, error) {
+	err := p.Storage.CopyToBucket(ctx.config, imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := ctx.config
+
+	bucket := c.CloudConfig.BucketName
+	key := c.CloudConfig.ImageName
+
+	input := &ec2.ImportSnapshotInput{
+		Description: aws.String("NanoVMs test"),
+		DiskContainer: &ec2.SnapshotDiskContainer{
+			Description: aws.String("NanoVMs test"),
+			Format:      aws.String("raw"),
+			UserBucket: &ec2.UserBucket{
+				S3Bucket: aws.String(bucket),
+				S3Key:    aws.String(key),
+			},
+		},
+	}
+
+	ctx.logger.Info("Importing snapshot from s3 image file")
+	res, err := p.ec2.ImportSnapshot(input)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotID, err := p.waitSnapshotToBeReady(c, res.ImportTaskId)
+	if err != nil {
+		return nil, err
+	}
+
+	// delete the tmp s3 image
+	ctx.logger.Info("Deleting s3 image file")
+	err = p.Storage.DeleteFromBucket(c, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotID, nil
+}
+
 var (
 	// NitroInstanceTypes are the AWS virtualized types built on the Nitro system.
 	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-types.html#ec2-nitro-instances
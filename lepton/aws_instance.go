@@ -17,10 +17,12 @@ import (
 
 func formalizeAWSInstance(instance *ec2.Instance) *CloudInstance {
 	instanceName := "unknown"
+	tags := map[string]string{}
 	for x := 0; x < len(instance.Tags); x++ {
 		if aws.StringValue(instance.Tags[x].Key) == "Name" {
 			instanceName = aws.StringValue(instance.Tags[x].Value)
 		}
+		tags[aws.StringValue(instance.Tags[x].Key)] = aws.StringValue(instance.Tags[x].Value)
 	}
 
 	var privateIps, publicIps []string
@@ -37,12 +39,27 @@ func formalizeAWSInstance(instance *ec2.Instance) *CloudInstance {
 		Name:       instanceName,
 		Status:     aws.StringValue(instance.State.Name),
 		Created:    aws.TimeValue(instance.LaunchTime).String(),
+		CreatedAt:  aws.TimeValue(instance.LaunchTime),
 		PublicIps:  publicIps,
 		PrivateIps: privateIps,
+		Image:      aws.StringValue(instance.ImageId),
+		Tags:       tags,
 	}
 }
 
 func getAWSInstances(region string, filter []*ec2.Filter) []CloudInstance {
+	cinstances, err := awsInstanceIterator(region, filter).All()
+	if err != nil {
+		fmt.Println(err)
+		exitWithError("failed getting instances")
+	}
+
+	return cinstances
+}
+
+// awsInstanceIterator lists the ops instances of region matching filter a
+// page at a time
+func awsInstanceIterator(region string, filter []*ec2.Filter) *InstanceIterator {
 	svc, err := session.NewSession(&aws.Config{
 		Region: aws.String(region)},
 	)
@@ -51,27 +68,40 @@ func getAWSInstances(region string, filter []*ec2.Filter) []CloudInstance {
 	filter = append(filter, &ec2.Filter{Name: aws.String("tag:CreatedBy"), Values: aws.StringSlice([]string{"ops"})})
 
 	request := ec2.DescribeInstancesInput{
-		Filters: filter,
-	}
-	result, err := compute.DescribeInstances(&request)
-	if err != nil {
-		fmt.Println(err)
-		exitWithError("failed getting instances")
+		Filters:    filter,
+		MaxResults: aws.Int64(1000),
 	}
 
-	var cinstances []CloudInstance
+	return NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+		if err != nil {
+			return nil, false, err
+		}
 
-	for _, reservation := range result.Reservations {
+		result, err := compute.DescribeInstances(&request)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var cinstances []CloudInstance
+
+		for _, reservation := range result.Reservations {
 
-		for i := 0; i < len(reservation.Instances); i++ {
-			instance := reservation.Instances[i]
+			for i := 0; i < len(reservation.Instances); i++ {
+				instance := reservation.Instances[i]
+
+				cinstances = append(cinstances, *formalizeAWSInstance(instance))
+			}
 
-			cinstances = append(cinstances, *formalizeAWSInstance(instance))
 		}
 
-	}
+		request.NextToken = result.NextToken
+		return cinstances, aws.StringValue(result.NextToken) != "", nil
+	})
+}
 
-	return cinstances
+// InstanceIterator lists instances on AWS a page at a time
+func (p *AWS) InstanceIterator(ctx *Context) *InstanceIterator {
+	return awsInstanceIterator(ctx.config.CloudConfig.Zone, nil)
 }
 
 // StartInstance stops instance from AWS by ami name
@@ -246,7 +276,7 @@ func (p *AWS) CreateInstance(ctx *Context) error {
 	tags, tagInstanceName := buildAwsTags(ctx.config.RunConfig.Tags, ctx.config.RunConfig.InstanceName)
 
 	// Specify the details of the instance that you want to create.
-	runResult, err := svc.RunInstances(&ec2.RunInstancesInput{
+	input := &ec2.RunInstancesInput{
 		ImageId:      aws.String(ami),
 		InstanceType: aws.String(ctx.config.CloudConfig.Flavor),
 		MinCount:     aws.Int64(1),
@@ -259,7 +289,11 @@ func (p *AWS) CreateInstance(ctx *Context) error {
 			{ResourceType: aws.String("instance"), Tags: tags},
 			{ResourceType: aws.String("volume"), Tags: tags},
 		},
-	})
+	}
+	if userData := execUserDataBase64(ctx.config); userData != "" {
+		input.UserData = aws.String(userData)
+	}
+	runResult, err := svc.RunInstances(input)
 
 	if err != nil {
 		fmt.Println("Could not create instance", err)
@@ -0,0 +1,86 @@
+package lepton
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// GetRegions returns the regions enabled for the account
+func (p *AWS) GetRegions(ctx *Context) ([]string, error) {
+	out, err := p.ec2.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := []string{}
+	for _, region := range out.Regions {
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	return regions, nil
+}
+
+// GetZones returns the availability zones of the configured region
+func (p *AWS) GetZones(ctx *Context) ([]Zone, error) {
+	out, err := p.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []Zone{}
+	for _, zone := range out.AvailabilityZones {
+		zones = append(zones, Zone{
+			Name:   aws.StringValue(zone.ZoneName),
+			Region: aws.StringValue(zone.RegionName),
+		})
+	}
+	return zones, nil
+}
+
+// GetInstanceTypes returns the instance types offered in zone, which can be
+// either the configured region or one of its availability zones
+func (p *AWS) GetInstanceTypes(ctx *Context, zone string) ([]InstanceType, error) {
+	locationType := ec2.LocationTypeAvailabilityZone
+	if zone == aws.StringValue(p.session.Config.Region) {
+		locationType = ec2.LocationTypeRegion
+	}
+
+	offered := map[string]bool{}
+	err := p.ec2.DescribeInstanceTypeOfferingsPages(&ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: aws.String(locationType),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("location"), Values: []*string{aws.String(zone)}},
+		},
+	}, func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+		for _, offering := range page.InstanceTypeOfferings {
+			offered[aws.StringValue(offering.InstanceType)] = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	types := []InstanceType{}
+	err = p.ec2.DescribeInstanceTypesPages(&ec2.DescribeInstanceTypesInput{}, func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+		for _, info := range page.InstanceTypes {
+			name := aws.StringValue(info.InstanceType)
+			if !offered[name] {
+				continue
+			}
+			t := InstanceType{Name: name}
+			if info.VCpuInfo != nil {
+				t.VCPUs = int(aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+			}
+			if info.MemoryInfo != nil {
+				t.MemoryMB = int(aws.Int64Value(info.MemoryInfo.SizeInMiB))
+			}
+			types = append(types, t)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return types, nil
+}
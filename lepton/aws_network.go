@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -152,7 +151,7 @@ func (p AWS) buildFirewallRule(protocol string, port string) *ec2.IpPermission {
 
 // CreateSG - Create security group
 func (p *AWS) CreateSG(ctx *Context, svc *ec2.EC2, imgName string, vpcID string) (string, error) {
-	t := time.Now().UnixNano()
+	t := ctx.config.sources().Now().UnixNano()
 	s := strconv.FormatInt(t, 10)
 
 	sgName := imgName + s
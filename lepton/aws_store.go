@@ -1,7 +1,11 @@
 package lepton
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"math"
 	"os"
 
@@ -9,7 +13,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // S3 provides AWS storage related operations
@@ -31,30 +34,123 @@ func (s *S3) CopyToBucket(config *Config, archPath string) error {
 	defer file.Close()
 
 	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(zone)},
+		Region:     aws.String(zone),
+		MaxRetries: aws.Int(RetryPolicyFromConfig(&config.CloudConfig).Retries)},
 	)
 	if err != nil {
 		return err
 	}
 
-	fileStats, _ := file.Stat()
-	fmt.Println("Uploading image with", fmt.Sprintf("%fMB", float64(fileStats.Size())/math.Pow(10, 6)))
+	fileStats, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := fileStats.Size()
+	fmt.Println("Uploading image with", fmt.Sprintf("%fMB", float64(size)/math.Pow(10, 6)))
 
-	uploader := s3manager.NewUploader(sess)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(config.CloudConfig.ImageName),
-		Body:   file,
-	})
+	state, err := loadUploadState("s3-"+bucket+"-"+config.CloudConfig.ImageName, archPath, s3PartSize(size))
+	if err != nil {
+		return err
+	}
+
+	err = s.uploadParts(s3.New(sess), bucket, config.CloudConfig.ImageName, file, size, state, RetryPolicyFromConfig(&config.CloudConfig))
 	if err != nil {
+		state.save()
 		return err
 	}
+	state.remove()
 
 	fmt.Printf("Successfully uploaded %q to %q\n", config.CloudConfig.ImageName, bucket)
 
 	return nil
 }
 
+// s3MaxParts is the most parts a multipart upload may have
+const s3MaxParts = 10000
+
+// s3PartSize returns the size of the parts a file of size is uploaded in,
+// 64MiB unless it has more than s3MaxParts of them
+func s3PartSize(size int64) int64 {
+	partSize := int64(64 * 1024 * 1024)
+	if n := (size + s3MaxParts - 1) / s3MaxParts; n > partSize {
+		partSize = n
+	}
+	return partSize
+}
+
+// uploadParts uploads file to key of bucket with a multipart upload, each
+// part sent with its md5 for s3 to check and retried on its own. Parts sent
+// by a previous attempt recorded in state are skipped.
+func (s *S3) uploadParts(svc *s3.S3, bucket, key string, file *os.File, size int64, state *uploadState, retry RetryPolicy) error {
+	if state.UploadID == "" {
+		out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		state.UploadID = aws.StringValue(out.UploadId)
+	} else {
+		fmt.Printf("Resuming upload of %s, %d parts already uploaded\n", key, len(state.Parts))
+	}
+
+	var uploaded int64
+	var parts []*s3.CompletedPart
+	for offset, index := int64(0), int64(0); offset < size; offset, index = offset+state.PartSize, index+1 {
+		length := state.PartSize
+		if size-offset < length {
+			length = size - offset
+		}
+
+		etag, ok := state.sent(index)
+		if !ok {
+			data := make([]byte, length)
+			if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+				return &UploadError{Uploaded: uploaded, Total: size, Err: err}
+			}
+			sum := md5.Sum(data)
+
+			err := retry.Do(func() error {
+				out, err := svc.UploadPart(&s3.UploadPartInput{
+					Bucket:     aws.String(bucket),
+					Key:        aws.String(key),
+					UploadId:   aws.String(state.UploadID),
+					PartNumber: aws.Int64(index + 1),
+					Body:       bytes.NewReader(data),
+					ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+				})
+				if err != nil {
+					return err
+				}
+				etag = aws.StringValue(out.ETag)
+				return nil
+			})
+			if err != nil {
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+					// the upload expired or was aborted, the next attempt
+					// starts over
+					state.UploadID = ""
+					state.Parts = map[int64]string{}
+				}
+				return &UploadError{Uploaded: uploaded, Total: size, Err: err}
+			}
+			state.done(index, etag)
+		}
+
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(index + 1)})
+		uploaded += length
+	}
+
+	_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
 // DeleteFromBucket deletes key from config's bucket
 func (s *S3) DeleteFromBucket(config *Config, key string) error {
 	bucket := config.CloudConfig.BucketName
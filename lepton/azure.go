@@ -160,50 +160,18 @@ func (a *Azure) BuildImage(ctx *Context) (string, error) {
 func (a *Azure) Initialize(config *ProviderConfig) error {
 	a.Storage = &AzureStorage{}
 
-	subID := os.Getenv("AZURE_SUBSCRIPTION_ID")
-	if subID != "" {
-		a.subID = subID
-	} else {
-		return fmt.Errorf("Set AZURE_SUBSCRIPTION_ID")
-	}
-
-	locationDefault := os.Getenv("AZURE_LOCATION_DEFAULT")
-	if locationDefault != "" {
-		a.locationDefault = locationDefault
-	}
-
-	clientID := os.Getenv("AZURE_CLIENT_ID")
-	if clientID != "" {
-		a.clientID = strings.TrimSpace(clientID)
-	} else {
-		return fmt.Errorf("Set AZURE_CLIENT_ID")
-	}
-
-	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
-	if clientSecret != "" {
-		a.clientSecret = strings.TrimSpace(clientSecret)
-	} else {
-		return fmt.Errorf("Set AZURE_CLIENT_SECRET")
-	}
-
-	tenantID := os.Getenv("AZURE_TENANT_ID")
-	if tenantID != "" {
-		a.tenantID = strings.TrimSpace(tenantID)
-	} else {
-		return fmt.Errorf("Set AZURE_TENANT_ID")
-	}
-
-	groupName := os.Getenv("AZURE_BASE_GROUP_NAME")
-	if groupName != "" {
-		a.groupName = strings.TrimSpace(groupName)
-	} else {
-		return fmt.Errorf("Set AZURE_BASE_GROUP_NAME")
+	creds, err := ResolveCredentials("azure", config.Profile)
+	if err != nil {
+		return err
 	}
 
-	storageAccount := os.Getenv("AZURE_STORAGE_ACCOUNT")
-	if storageAccount != "" {
-		a.storageAccount = strings.TrimSpace(storageAccount)
-	}
+	a.subID = creds["AZURE_SUBSCRIPTION_ID"]
+	a.locationDefault = creds["AZURE_LOCATION_DEFAULT"]
+	a.clientID = strings.TrimSpace(creds["AZURE_CLIENT_ID"])
+	a.clientSecret = strings.TrimSpace(creds["AZURE_CLIENT_SECRET"])
+	a.tenantID = strings.TrimSpace(creds["AZURE_TENANT_ID"])
+	a.groupName = strings.TrimSpace(creds["AZURE_BASE_GROUP_NAME"])
+	a.storageAccount = strings.TrimSpace(creds["AZURE_STORAGE_ACCOUNT"])
 
 	authorizer, err := auth.NewAuthorizerFromEnvironment()
 	if err != nil {
@@ -0,0 +1,163 @@
+package lepton
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func (a *Azure) getGalleriesClient() *compute.GalleriesClient {
+	client := compute.NewGalleriesClient(a.subID)
+	client.Authorizer = *a.authorizer
+	client.AddToUserAgent(userAgent)
+	return &client
+}
+
+func (a *Azure) getGalleryImagesClient() *compute.GalleryImagesClient {
+	client := compute.NewGalleryImagesClient(a.subID)
+	client.Authorizer = *a.authorizer
+	client.AddToUserAgent(userAgent)
+	return &client
+}
+
+func (a *Azure) getGalleryImageVersionsClient() *compute.GalleryImageVersionsClient {
+	client := compute.NewGalleryImageVersionsClient(a.subID)
+	client.Authorizer = *a.authorizer
+	client.AddToUserAgent(userAgent)
+	return &client
+}
+
+// galleryImageVersion returns the version an image built at t is published
+// as, versions must be of the form major.minor.patch with 32 bit integers
+func galleryImageVersion(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%d.%d.%d", t.Year(), int(t.Month())*100+t.Day(), t.Hour()*10000+t.Minute()*100+t.Second())
+}
+
+// galleryTargetRegions returns the regions an image version is replicated
+// to, starting with the image region
+func galleryTargetRegions(location string, regions []string) []compute.TargetRegion {
+	targets := []compute.TargetRegion{{Name: to.StringPtr(location), RegionalReplicaCount: to.Int32Ptr(1)}}
+	seen := map[string]bool{location: true}
+	for _, region := range regions {
+		if seen[region] {
+			continue
+		}
+		seen[region] = true
+		targets = append(targets, compute.TargetRegion{Name: to.StringPtr(region), RegionalReplicaCount: to.Int32Ptr(1)})
+	}
+	return targets
+}
+
+// publishToGallery publishes the managed image imageID as a new version in the
+// configured Shared Image Gallery, replicating it to the gallery regions so
+// the vhd is uploaded only once
+func (a *Azure) publishToGallery(ctx *Context, imageID string) error {
+	c := ctx.config
+	location := a.getLocation(c)
+	gallery := c.CloudConfig.Gallery
+	definition := c.CloudConfig.GalleryImage
+	if definition == "" {
+		definition = c.CloudConfig.ImageName
+	}
+
+	if err := a.ensureGallery(gallery, location); err != nil {
+		return err
+	}
+	if err := a.ensureGalleryImage(gallery, definition, location); err != nil {
+		return err
+	}
+
+	version := galleryImageVersion(ctx.config.sources().Now())
+	targets := galleryTargetRegions(location, c.CloudConfig.GalleryRegions)
+	ctx.logger.Info("Publishing image version %s of %s to gallery %s", version, definition, gallery)
+
+	versionsClient := a.getGalleryImageVersionsClient()
+	future, err := versionsClient.CreateOrUpdate(context.TODO(), a.groupName, gallery, definition, version, compute.GalleryImageVersion{
+		Location: to.StringPtr(location),
+		Tags:     getAzureDefaultTags(),
+		GalleryImageVersionProperties: &compute.GalleryImageVersionProperties{
+			PublishingProfile: &compute.GalleryImageVersionPublishingProfile{
+				TargetRegions: &targets,
+			},
+			StorageProfile: &compute.GalleryImageVersionStorageProfile{
+				Source: &compute.GalleryArtifactVersionSource{
+					ID: to.StringPtr(imageID),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = future.WaitForCompletionRef(context.TODO(), versionsClient.Client)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Image version %s published to gallery %s\n", version, gallery)
+	return nil
+}
+
+func (a *Azure) ensureGallery(gallery, location string) error {
+	client := a.getGalleriesClient()
+
+	_, err := client.Get(context.TODO(), a.groupName, gallery)
+	if err == nil {
+		return nil
+	}
+	if !isAzureNotFound(err) {
+		return err
+	}
+
+	future, err := client.CreateOrUpdate(context.TODO(), a.groupName, gallery, compute.Gallery{
+		Location: to.StringPtr(location),
+		Tags:     getAzureDefaultTags(),
+	})
+	if err != nil {
+		return err
+	}
+	return future.WaitForCompletionRef(context.TODO(), client.Client)
+}
+
+func (a *Azure) ensureGalleryImage(gallery, definition, location string) error {
+	client := a.getGalleryImagesClient()
+
+	_, err := client.Get(context.TODO(), a.groupName, gallery, definition)
+	if err == nil {
+		return nil
+	}
+	if !isAzureNotFound(err) {
+		return err
+	}
+
+	future, err := client.CreateOrUpdate(context.TODO(), a.groupName, gallery, definition, compute.GalleryImage{
+		Location: to.StringPtr(location),
+		Tags:     getAzureDefaultTags(),
+		GalleryImageProperties: &compute.GalleryImageProperties{
+			OsType:           compute.Linux,
+			OsState:          compute.Generalized,
+			HyperVGeneration: compute.HyperVGenerationV1,
+			Identifier: &compute.GalleryImageIdentifier{
+				Publisher: to.StringPtr("nanovms"),
+				Offer:     to.StringPtr("nanos"),
+				Sku:       to.StringPtr(definition),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return future.WaitForCompletionRef(context.TODO(), client.Client)
+}
+
+func isAzureNotFound(err error) bool {
+	derr, ok := err.(autorest.DetailedError)
+	return ok && derr.StatusCode == http.StatusNotFound
+}
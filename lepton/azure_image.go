@@ -90,41 +90,66 @@ func (a *Azure) CreateImage(ctx *Context, imagePath string) error {
 		},
 	}
 
-	_, err = imagesClient.CreateOrUpdate(context.TODO(), a.groupName, imgName, imageParams)
+	future, err := imagesClient.CreateOrUpdate(context.TODO(), a.groupName, imgName, imageParams)
 	if err != nil {
 		fmt.Println(err)
-	} else {
+		return nil
+	}
+
+	if c.CloudConfig.Gallery == "" {
 		fmt.Println("Image created")
+		return nil
 	}
 
-	return nil
+	err = future.WaitForCompletionRef(context.TODO(), imagesClient.Client)
+	if err != nil {
+		return err
+	}
+	image, err := future.Result(*imagesClient)
+	if err != nil {
+		return err
+	}
+
+	return a.publishToGallery(ctx, *image.ID)
 }
 
 // GetImages return all images for azure
 func (a *Azure) GetImages(ctx *Context) ([]CloudImage, error) {
-	var cimages []CloudImage
+	return a.ImageIterator(ctx).All()
+}
 
+// ImageIterator lists images on Azure a page at a time
+func (a *Azure) ImageIterator(ctx *Context) *ImageIterator {
 	imagesClient := a.getImagesClient()
 
-	images, err := imagesClient.List(context.TODO())
-	if err != nil {
-		return nil, err
-	}
+	var images *compute.ImageListResultPage
+	return NewImageIterator(func() ([]CloudImage, bool, error) {
+		if images == nil {
+			page, err := imagesClient.List(context.TODO())
+			if err != nil {
+				return nil, false, err
+			}
+			images = &page
+		} else if err := images.NextWithContext(context.TODO()); err != nil {
+			return nil, false, err
+		}
 
-	imgs := images.Values()
+		var cimages []CloudImage
+		imgs := images.Values()
 
-	for _, image := range imgs {
-		if hasAzureOpsTags(image.Tags) {
-			cImage := CloudImage{
-				Name:   *image.Name,
-				Status: *(*image.ImageProperties).ProvisioningState,
-			}
+		for _, image := range imgs {
+			if hasAzureOpsTags(image.Tags) {
+				cImage := CloudImage{
+					Name:   *image.Name,
+					Status: *(*image.ImageProperties).ProvisioningState,
+				}
 
-			cimages = append(cimages, cImage)
+				cimages = append(cimages, cImage)
+			}
 		}
-	}
 
-	return cimages, nil
+		return cimages, to.String(images.Response().NextLink) != "", nil
+	})
 }
 
 // ListImages lists images on azure
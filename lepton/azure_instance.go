@@ -144,6 +144,11 @@ func (a *Azure) CreateInstance(ctx *Context) error {
 		flavor = compute.VirtualMachineSizeTypesStandardA1V2
 	}
 
+	var customData *string
+	if userData := execUserDataBase64(ctx.config); userData != "" {
+		customData = to.StringPtr(userData)
+	}
+
 	future, err := vmClient.CreateOrUpdate(
 		nctx,
 		a.groupName,
@@ -170,6 +175,7 @@ func (a *Azure) CreateInstance(ctx *Context) error {
 					ComputerName:  to.StringPtr(vmName),
 					AdminUsername: to.StringPtr(username),
 					AdminPassword: to.StringPtr(password),
+					CustomData:    customData,
 					LinuxConfiguration: &compute.LinuxConfiguration{
 						SSH: &compute.SSHConfiguration{
 							PublicKeys: &[]compute.SSHPublicKey{
@@ -240,35 +246,53 @@ func (a *Azure) GetInstanceByID(ctx *Context, id string) (vm *CloudInstance, err
 }
 
 // GetInstances return all instances on Azure
-func (a *Azure) GetInstances(ctx *Context) (cinstances []CloudInstance, err error) {
+func (a *Azure) GetInstances(ctx *Context) ([]CloudInstance, error) {
+	return a.InstanceIterator(ctx).All()
+}
+
+// InstanceIterator lists instances on Azure a page at a time
+func (a *Azure) InstanceIterator(ctx *Context) *InstanceIterator {
 	vmClient := a.getVMClient()
 	nicClient := a.getNicClient()
 	ipClient := a.getIPClient()
 
-	vmlist, err := vmClient.List(context.TODO(), a.groupName)
-	if err != nil {
-		return
-	}
-
-	instances := vmlist.Values()
-
-	for _, instance := range instances {
-		if hasAzureOpsTags(instance.Tags) {
-			cinstance, err := a.convertToCloudInstance(&instance, nicClient, ipClient)
+	var vmlist *compute.VirtualMachineListResultPage
+	return NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+		if vmlist == nil {
+			page, err := vmClient.List(context.TODO(), a.groupName)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
+			vmlist = &page
+		} else if err := vmlist.NextWithContext(context.TODO()); err != nil {
+			return nil, false, err
+		}
+
+		var cinstances []CloudInstance
+		instances := vmlist.Values()
+
+		for _, instance := range instances {
+			if hasAzureOpsTags(instance.Tags) {
+				cinstance, err := a.convertToCloudInstance(&instance, nicClient, ipClient)
+				if err != nil {
+					return nil, false, err
+				}
 
-			cinstances = append(cinstances, *cinstance)
+				cinstances = append(cinstances, *cinstance)
+			}
 		}
-	}
 
-	return
+		return cinstances, to.String(vmlist.Response().NextLink) != "", nil
+	})
 }
 
 func (a *Azure) convertToCloudInstance(instance *compute.VirtualMachine, nicClient *network.InterfacesClient, ipClient *network.PublicIPAddressesClient) (*CloudInstance, error) {
 	cinstance := CloudInstance{
 		Name: *instance.Name,
+		Tags: map[string]string{},
+	}
+	for key, value := range instance.Tags {
+		cinstance.Tags[key] = to.String(value)
 	}
 	privateIP := ""
 	publicIP := ""
@@ -3,6 +3,8 @@ package lepton
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -70,19 +72,7 @@ func (az *AzureStorage) virtualSize(archPath string) uint32 {
 }
 
 func (az *AzureStorage) resizeImage(basePath string, newPath string, resizeSz uint32) {
-	in, err := os.Open(basePath)
-	if err != nil {
-		fmt.Println(err)
-	}
-	defer in.Close()
-
-	out, err := os.Create(newPath)
-	if err != nil {
-		fmt.Println(err)
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, in)
+	err := copyFile(newPath, basePath)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -172,22 +162,58 @@ func (az *AzureStorage) CopyToBucket(config *Config, imgPath string) error {
 		q++
 	}
 
-	_, err = blobURL.Create(ctx, length, 0, azblob.BlobHTTPHeaders{},
-		azblob.Metadata{}, azblob.BlobAccessConditions{})
+	// the vhd is converted again on every attempt, the image it is converted
+	// from tells whether a previous upload can be resumed
+	state, err := loadUploadState("azure-"+containerName+"-"+config.CloudConfig.ImageName, imgPath, int64(max))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
+	if !state.resuming() {
+		_, err = blobURL.Create(ctx, length, 0, azblob.BlobHTTPHeaders{},
+			azblob.Metadata{}, azblob.BlobAccessConditions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		fmt.Printf("Resuming upload of %s, %d of %d pages already uploaded\n", config.CloudConfig.ImageName, len(state.Parts), q)
+	}
+
+	// pages are retried one by one, so a dropped connection doesn't restart
+	// the whole upload, and sent with their md5 for the service to check
+	retry := RetryPolicyFromConfig(&config.CloudConfig)
+	var uploaded int64
 	for i := 0; i < q; i++ {
+		offset := int64(i * max)
+		if _, ok := state.sent(int64(i)); ok {
+			if length-offset < int64(max) {
+				uploaded += length - offset
+			} else {
+				uploaded += int64(max)
+			}
+			continue
+		}
+
 		page := make([]byte, max)
-		n, err := file.Read(page)
+		n, err := file.ReadAt(page, offset)
+		if err != nil && err != io.EOF {
+			state.save()
+			return &UploadError{Uploaded: uploaded, Total: length, Err: err}
+		}
 
-		_, err = blobURL.UploadPages(ctx, int64(i*max), bytes.NewReader(page[:n]), azblob.PageBlobAccessConditions{}, nil)
+		sum := md5.Sum(page[:n])
+		err = retry.Do(func() error {
+			_, err := blobURL.UploadPages(ctx, offset, bytes.NewReader(page[:n]), azblob.PageBlobAccessConditions{}, sum[:])
+			return err
+		})
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			state.save()
+			return &UploadError{Uploaded: uploaded, Total: length, Err: err}
 		}
+		state.done(int64(i), base64.StdEncoding.EncodeToString(sum[:]))
+		uploaded += int64(n)
 	}
+	state.remove()
 
 	return nil
 }
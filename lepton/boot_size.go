@@ -0,0 +1,84 @@
+package lepton
+
+import (
+	"fmt"
+)
+
+// defaultBootFSSize is the size of the boot filesystem of the images of the
+// nanos releases, which holds the kernel and the klibs. The klog region the
+// kernel saves its log to on crashes follows it in the image, there is no
+// room for a larger one.
+const defaultBootFSSize = 12 * MiByte
+
+// BootFSStats summarizes the size of the boot filesystem of an image
+type BootFSStats struct {
+	// Files are the kernel, the klibs and the other files of the boot
+	// filesystem
+	Files int
+	// AllocatedBytes is the space the files take, their sizes rounded up
+	// to sectors
+	AllocatedBytes int64
+	// Limit is the size of the boot filesystem
+	Limit int64
+}
+
+func (s BootFSStats) String() string {
+	return fmt.Sprintf("%d boot files taking %s of %s", s.Files, Bytes2Human(s.AllocatedBytes), Bytes2Human(s.Limit))
+}
+
+// SetBootFSSize sets the size the boot filesystem must fit in, for kernels
+// built with another one than the releases. Zero restores the size of the
+// releases.
+func (m *Manifest) SetBootFSSize(size int64) {
+	m.bootFSSize = size
+}
+
+// BootFSStats returns the size of the files of the boot filesystem, the
+// klibs found included
+func (m *Manifest) BootFSStats() (BootFSStats, error) {
+	stats := BootFSStats{Limit: m.bootFSSize}
+	if stats.Limit == 0 {
+		stats.Limit = defaultBootFSSize
+	}
+
+	add := func(hostpath string) error {
+		fi, err := m.files.stat(hostpath)
+		if err != nil {
+			return err
+		}
+		stats.Files++
+		stats.AllocatedBytes += (fi.Size() + fsSectorSize - 1) / fsSectorSize * fsSectorSize
+		return nil
+	}
+	err := m.boot.Walk(func(vmpath string, node ManifestNode) error {
+		if file, ok := node.(*FileNode); ok {
+			return add(file.HostPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	for _, klib := range m.klibs {
+		// missing klibs are reported when the manifest is written
+		if hostpath, ok := m.klibHostPath(klib); ok {
+			if err := add(hostpath); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// checkBootFSSize returns the size of the boot filesystem, with an error
+// when its files don't fit in it
+func (m *Manifest) checkBootFSSize() (BootFSStats, error) {
+	stats, err := m.BootFSStats()
+	if err != nil {
+		return stats, err
+	}
+	if stats.AllocatedBytes > stats.Limit {
+		return stats, WithCode(ErrMkfsBootFSSizeExceeded, fmt.Errorf("the kernel and klibs take %s, more than the %s of the boot filesystem", Bytes2Human(stats.AllocatedBytes), Bytes2Human(stats.Limit)))
+	}
+	return stats, nil
+}
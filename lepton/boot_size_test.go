@@ -0,0 +1,51 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBootFSSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	klibs := filepath.Join(dir, "klibs")
+	if err := os.Mkdir(klibs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, size := range map[string]int{"kernel.img": 3000, "klibs/ntp": 100, "config": 512} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	m.AddKernel(filepath.Join(dir, "kernel.img"))
+	m.AddKlibDir(klibs)
+	if err := m.AddKlibs([]string{"ntp", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddBootFile("/config", filepath.Join(dir, "config")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.checkBootFSSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BootFSStats{Files: 3, AllocatedBytes: 3072 + 512 + 512, Limit: defaultBootFSSize}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	m.SetBootFSSize(4 * KiByte)
+	_, err = m.checkBootFSSize()
+	if code, _ := ErrorCodeOf(err); code != ErrMkfsBootFSSizeExceeded {
+		t.Errorf("expected %s, got %v", ErrMkfsBootFSSizeExceeded, err)
+	}
+}
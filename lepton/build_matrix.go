@@ -0,0 +1,219 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildVariation is one combination of a build matrix
+type BuildVariation struct {
+	Arch     string
+	Profile  string
+	Provider string
+}
+
+// Name returns a name of v usable in image names
+func (v BuildVariation) Name() string {
+	parts := []string{v.Provider, v.Arch}
+	if v.Profile != "" {
+		parts = append(parts, v.Profile)
+	}
+	return strings.Join(parts, "-")
+}
+
+// BuildMatrix builds an image for every combination of architecture,
+// profile and provider from one base config
+type BuildMatrix struct {
+	// Base is the config every variation starts from
+	Base *Config
+
+	// Archs are the guest architectures, amd64 when empty
+	Archs []string
+
+	// Profiles are named sets of config overrides, like those of
+	// ApplyConfigOverrides, applied on top of Base. The base config is built
+	// as is when there are no profiles.
+	Profiles map[string][]string
+
+	// Providers are the providers whose image format is built, onprem when
+	// empty
+	Providers []string
+
+	// Concurrency is the number of images built at the same time, the
+	// number of CPUs when zero
+	Concurrency int
+
+	// NewProvider returns the initialized provider of a name
+	NewProvider func(name string, c *ProviderConfig) (Provider, error)
+}
+
+// MatrixResult is the outcome of building one variation
+type MatrixResult struct {
+	Variation BuildVariation
+
+	// ImagePath is the path of the built image, or of the archive the
+	// provider uploads
+	ImagePath string
+
+	Duration time.Duration
+	Err      error
+}
+
+// MatrixReport is the combined outcome of a build matrix
+type MatrixReport struct {
+	Results  []MatrixResult
+	Duration time.Duration
+}
+
+// Failed returns the results of the variations that failed to build
+func (r *MatrixReport) Failed() []MatrixResult {
+	failed := []MatrixResult{}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Variations returns the combinations the matrix builds, sorted by name
+func (bm *BuildMatrix) Variations() []BuildVariation {
+	archs := bm.Archs
+	if len(archs) == 0 {
+		archs = []string{"amd64"}
+	}
+	providers := bm.Providers
+	if len(providers) == 0 {
+		providers = []string{"onprem"}
+	}
+	profiles := []string{}
+	for name := range bm.Profiles {
+		profiles = append(profiles, name)
+	}
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+
+	variations := []BuildVariation{}
+	for _, provider := range providers {
+		for _, arch := range archs {
+			for _, profile := range profiles {
+				variations = append(variations, BuildVariation{Arch: arch, Profile: profile, Provider: provider})
+			}
+		}
+	}
+	sort.Slice(variations, func(i, j int) bool { return variations[i].Name() < variations[j].Name() })
+	return variations
+}
+
+// Build builds every variation and returns their results in the order of
+// Variations, the error lists the variations that failed
+func (bm *BuildMatrix) Build() (*MatrixReport, error) {
+	if bm.Base == nil || bm.NewProvider == nil {
+		return nil, fmt.Errorf("build matrix needs a base config and a provider factory")
+	}
+
+	concurrency := bm.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	started := time.Now()
+	variations := bm.Variations()
+	report := &MatrixReport{Results: make([]MatrixResult, len(variations))}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, v := range variations {
+		wg.Add(1)
+		go func(i int, v BuildVariation) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			path, err := bm.buildVariation(v)
+			report.Results[i] = MatrixResult{Variation: v, ImagePath: path, Duration: time.Since(start), Err: err}
+		}(i, v)
+	}
+	wg.Wait()
+	report.Duration = time.Since(started)
+
+	failed := []string{}
+	for _, result := range report.Failed() {
+		failed = append(failed, fmt.Sprintf("%s: %v", result.Variation.Name(), result.Err))
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("failed to build %d of %d variations: %s", len(failed), len(variations), strings.Join(failed, "; "))
+	}
+	return report, nil
+}
+
+func (bm *BuildMatrix) buildVariation(v BuildVariation) (string, error) {
+	if v.Arch != "amd64" {
+		return "", fmt.Errorf("unsupported architecture %q", v.Arch)
+	}
+
+	c, err := copyConfig(bm.Base)
+	if err != nil {
+		return "", err
+	}
+	if err := ApplyConfigOverrides(c, bm.Profiles[v.Profile]...); err != nil {
+		return "", err
+	}
+
+	// every variation writes its own image from its own build directory
+	buildDir, err := ioutil.TempDir("", v.Name())
+	if err != nil {
+		return "", err
+	}
+	c.BuildDir = buildDir
+	defer os.RemoveAll(buildDir)
+
+	imagename := c.RunConfig.Imagename
+	if imagename == "" {
+		imagename = GenerateImageName(c.Program)
+	}
+	c.RunConfig.Imagename = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(imagename, filepath.Ext(imagename)), v.Name(), filepath.Ext(imagename))
+	if c.CloudConfig.ImageName != "" {
+		c.CloudConfig.ImageName = c.CloudConfig.ImageName + "-" + v.Name()
+	}
+
+	p, err := bm.NewProvider(v.Provider, &c.CloudConfig)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := p.BuildImage(NewContext(c))
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = c.RunConfig.Imagename
+	}
+	return path, nil
+}
+
+// copyConfig returns a deep copy of c, variations change maps and slices
+// concurrently
+func copyConfig(c *Config) (*Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied Config
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	copied.BuildHooks = c.BuildHooks
+	return &copied, nil
+}
@@ -0,0 +1,83 @@
+package lepton
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type fakeBuildProvider struct {
+	Provider
+	mu     *sync.Mutex
+	built  map[string]*Config
+	broken bool
+}
+
+func (p *fakeBuildProvider) BuildImage(ctx *Context) (string, error) {
+	if p.broken {
+		return "", fmt.Errorf("broken provider")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.built[ctx.config.RunConfig.Imagename] = ctx.config
+	return "", nil
+}
+
+func TestBuildMatrix(t *testing.T) {
+	base := NewConfig()
+	base.Program = "/bin/app"
+	base.RunConfig.Imagename = "/images/app.img"
+	base.Env = map[string]string{"MODE": "base"}
+
+	var mu sync.Mutex
+	built := map[string]*Config{}
+	matrix := &BuildMatrix{
+		Base:      base,
+		Profiles:  map[string][]string{"debug": {"env.MODE=debug"}, "release": {"env.MODE=release"}},
+		Providers: []string{"onprem", "gcp"},
+		NewProvider: func(name string, c *ProviderConfig) (Provider, error) {
+			return &fakeBuildProvider{mu: &mu, built: built, broken: name == "gcp"}, nil
+		},
+	}
+
+	t.Run("should list every combination", func(t *testing.T) {
+		names := []string{}
+		for _, v := range matrix.Variations() {
+			names = append(names, v.Name())
+		}
+		want := []string{"gcp-amd64-debug", "gcp-amd64-release", "onprem-amd64-debug", "onprem-amd64-release"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("got %v want %v", names, want)
+		}
+	})
+
+	t.Run("should build variations from copies of the base config", func(t *testing.T) {
+		report, err := matrix.Build()
+		if err == nil || len(report.Failed()) != 2 {
+			t.Fatalf("expected the gcp variations to fail, got %v", err)
+		}
+
+		images := []string{}
+		for image, c := range built {
+			images = append(images, image)
+			if c.Env["MODE"] == "base" {
+				t.Errorf("profile not applied to %s", image)
+			}
+		}
+		sort.Strings(images)
+		want := []string{"/images/app-onprem-amd64-debug.img", "/images/app-onprem-amd64-release.img"}
+		if !reflect.DeepEqual(images, want) {
+			t.Errorf("got images %v want %v", images, want)
+		}
+		if base.Env["MODE"] != "base" {
+			t.Errorf("base config changed to %v", base.Env)
+		}
+		for _, result := range report.Results {
+			if result.Err == nil && result.ImagePath == "" {
+				t.Errorf("no image path for %s", result.Variation.Name())
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+)
+
+// buildProfiler writes pprof profiles of the steps of a build to a
+// directory, so slow builds can be reported with where the time and memory
+// went
+type buildProfiler struct {
+	dir string
+}
+
+// newBuildProfiler returns a profiler writing to dir, one with an empty dir
+// profiles nothing
+func newBuildProfiler(dir string) *buildProfiler {
+	return &buildProfiler{dir: dir}
+}
+
+// profile runs fn with the CPU profiled to <step>.cpu.pprof of the profile
+// directory, then writes the heap profile, with the allocations of the
+// build so far, to <step>.heap.pprof. The error of fn is returned before
+// those of the profiles.
+func (p *buildProfiler) profile(step string, fn func() error) error {
+	if p.dir == "" {
+		return fn()
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+
+	cpu, err := os.Create(filepath.Join(p.dir, step+".cpu.pprof"))
+	if err != nil {
+		return err
+	}
+	defer cpu.Close()
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		return fmt.Errorf("profiling %s: %v", step, err)
+	}
+	err = fn()
+	pprof.StopCPUProfile()
+	if err != nil {
+		return err
+	}
+	if err := cpu.Close(); err != nil {
+		return err
+	}
+
+	heap, err := os.Create(filepath.Join(p.dir, step+".heap.pprof"))
+	if err != nil {
+		return err
+	}
+	defer heap.Close()
+	// the in use figures are those of the live objects after the step
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(heap); err != nil {
+		return fmt.Errorf("profiling %s: %v", step, err)
+	}
+	return heap.Close()
+}
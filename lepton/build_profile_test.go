@@ -0,0 +1,41 @@
+package lepton
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildProfiler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newBuildProfiler(filepath.Join(dir, "build"))
+	ran := false
+	if err := p.profile("resolve", func() error { ran = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Error("expected the step to run")
+	}
+	for _, file := range []string{"resolve.cpu.pprof", "resolve.heap.pprof"} {
+		if fi, err := os.Stat(filepath.Join(dir, "build", file)); err != nil || fi.Size() == 0 {
+			t.Errorf("expected the profile %s, got %v", file, err)
+		}
+	}
+
+	failed := errors.New("mkfs failed")
+	if err := p.profile("mkfs", func() error { return failed }); err != failed {
+		t.Errorf("expected the error of the step, got %v", err)
+	}
+
+	// without a directory nothing is profiled
+	if err := newBuildProfiler("").profile("resolve", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package lepton
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// BuildReport describes an image build, it is filled in as the build
+// progresses and handed to the build hooks
+type BuildReport struct {
+	// Config is the configuration the image is built from
+	Config *Config
+
+	// Manifest is the resolved manifest, nil before PostResolve
+	Manifest *Manifest `json:"-"`
+
+	// Warnings are the non-fatal issues found resolving the manifest
+	Warnings []Warning
+
+	// ImagePath is the path of the image being written
+	ImagePath string
+
+	// StartedAt is the time the build started
+	StartedAt time.Time
+
+	// FinishedAt is the time mkfs finished writing the image
+	FinishedAt time.Time
+
+	// UUID is the filesystem UUID of the image, to mount it by and match
+	// it with deployments
+	UUID string
+
+	// Label is the filesystem label of the image, empty when it has none
+	Label string
+
+	// FileHashes are the sha256 of the files of the image by path, when
+	// Config.FileHashes is set
+	FileHashes map[string]string
+
+	// Deduplicated are the duplicate files replaced with symlinks, when
+	// Config.Deduplicate is set
+	Deduplicated DedupStats
+
+	// FileStats are the sizes of the files of the image and the space they
+	// take in it
+	FileStats FileStats
+
+	// BootFS is the size of the boot filesystem, the kernel and klibs
+	BootFS BootFSStats
+
+	// Provenance is where the files of the image were resolved from, the
+	// target root or the host, by path
+	Provenance map[string]FileProvenance
+}
+
+func newBuildReport(c *Config) *BuildReport {
+	return &BuildReport{
+		Config:    c,
+		ImagePath: c.RunConfig.Imagename,
+		StartedAt: c.sources().Now(),
+	}
+}
+
+// WriteFile writes the report to path as json
+func (r *BuildReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
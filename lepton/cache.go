@@ -0,0 +1,346 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheKind is a kind of download or build output ops keeps in its home
+type CacheKind string
+
+const (
+	// CacheReleases are the kernels, klibs and mkfs of nanos releases and
+	// nightly builds
+	CacheReleases CacheKind = "releases"
+	// CachePackages are the downloaded package archives
+	CachePackages CacheKind = "packages"
+	// CacheSysroots are the docker images extracted as target roots
+	CacheSysroots CacheKind = "sysroots"
+	// CacheImages are the built images
+	CacheImages CacheKind = "images"
+)
+
+// CacheKinds are every kind of cache
+var CacheKinds = []CacheKind{CacheReleases, CachePackages, CacheSysroots, CacheImages}
+
+// cachePinsFile is the file of the ops home the pinned entries are kept in
+const cachePinsFile = "cache-pins.json"
+
+var releaseDirRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+
+// CacheEntry is a release, package, target root or image of the ops home
+// evicted as a whole
+type CacheEntry struct {
+	Kind CacheKind
+	Path string
+	Size int64
+
+	// LastUsed is when ops last used the entry, see MarkCacheUsed
+	LastUsed time.Time
+
+	// Pinned entries are never evicted, they are in use or were pinned with
+	// CacheManager.Pin
+	Pinned bool
+}
+
+// PruneOptions select the cache entries PruneCache evicts, least recently
+// used first. Pinned entries are kept.
+type PruneOptions struct {
+	// OlderThan evicts the entries not used for that long, when set
+	OlderThan time.Duration
+
+	// MaxSize is the size the pruned caches together are evicted down to,
+	// when set
+	MaxSize int64
+
+	// Limits are the sizes the caches of a kind are evicted down to
+	Limits map[CacheKind]int64
+
+	// Kinds are the kinds of cache pruned, every kind but images when
+	// empty. The kinds of Limits are pruned too.
+	Kinds []CacheKind
+
+	// DryRun only reports what would be evicted
+	DryRun bool
+}
+
+// PruneReport lists the entries evicted by PruneCache
+type PruneReport struct {
+	Evicted []CacheEntry
+	Freed   int64
+}
+
+// ParseCacheLimit parses a size like 10G, or the size of a kind of cache
+// like packages=2G, the kind is empty for the former
+func ParseCacheLimit(s string) (CacheKind, int64, error) {
+	var kind CacheKind
+	if i := strings.Index(s, "="); i >= 0 {
+		kind = CacheKind(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+		known := false
+		for _, k := range CacheKinds {
+			known = known || k == kind
+		}
+		if !known {
+			return kind, 0, fmt.Errorf("unknown cache %q, expected one of %v", kind, CacheKinds)
+		}
+	}
+	size, err := parseBytes(strings.TrimSpace(s))
+	if err != nil {
+		return kind, 0, fmt.Errorf("invalid cache size %q: %v", s, err)
+	}
+	return kind, size, nil
+}
+
+// CacheManager lists and evicts the caches of an ops home
+type CacheManager struct {
+	// Dir is the ops home
+	Dir string
+}
+
+// NewCacheManager returns the manager of the ops home
+func NewCacheManager() *CacheManager {
+	return &CacheManager{Dir: GetOpsHome()}
+}
+
+// PruneCache evicts the entries of the caches of the ops home selected by
+// opts
+func PruneCache(opts PruneOptions) (*PruneReport, error) {
+	return NewCacheManager().Prune(opts)
+}
+
+// MarkCacheUsed records that the cache entry at path was just used, for
+// least recently used eviction
+func MarkCacheUsed(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Entries returns the entries of the caches of kinds, every kind when
+// empty, least recently used first
+func (cm *CacheManager) Entries(kinds ...CacheKind) ([]CacheEntry, error) {
+	if len(kinds) == 0 {
+		kinds = CacheKinds
+	}
+	pins, err := cm.pins()
+	if err != nil {
+		return nil, err
+	}
+	inUse := cm.inUse()
+
+	entries := []CacheEntry{}
+	for _, kind := range kinds {
+		paths, err := cm.paths(kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			fi, err := os.Lstat(p)
+			if err != nil {
+				continue
+			}
+			size, err := dirSize(p)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, CacheEntry{
+				Kind:     kind,
+				Path:     p,
+				Size:     size,
+				LastUsed: fi.ModTime(),
+				Pinned:   pins[p] || inUse[p],
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+	return entries, nil
+}
+
+// paths returns the paths of the entries of the cache of kind
+func (cm *CacheManager) paths(kind CacheKind) ([]string, error) {
+	var dir string
+	var match func(fi os.FileInfo) bool
+	switch kind {
+	case CacheReleases:
+		dir = cm.Dir
+		match = func(fi os.FileInfo) bool {
+			return fi.IsDir() && (releaseDirRegexp.MatchString(fi.Name()) || fi.Name() == "nightly")
+		}
+	case CachePackages:
+		dir = filepath.Join(cm.Dir, "packages")
+		match = func(fi os.FileInfo) bool { return fi.Name() != PackageManifestFileName }
+	case CacheSysroots:
+		dir = filepath.Join(cm.Dir, "sysroots")
+		match = func(fi os.FileInfo) bool { return fi.IsDir() && !strings.HasPrefix(fi.Name(), "extract") }
+	case CacheImages:
+		dir = filepath.Join(cm.Dir, "images")
+		match = func(fi os.FileInfo) bool { return !fi.IsDir() }
+	default:
+		return nil, fmt.Errorf("unknown cache %q, expected one of %v", kind, CacheKinds)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, fi := range infos {
+		if match(fi) {
+			paths = append(paths, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// inUse returns the entries ops uses, the current release and the images of
+// running instances
+func (cm *CacheManager) inUse() map[string]bool {
+	inUse := map[string]bool{}
+	if data, err := ioutil.ReadFile(filepath.Join(cm.Dir, "latest.txt")); err == nil {
+		inUse[filepath.Join(cm.Dir, strings.TrimSpace(string(data)))] = true
+	}
+
+	instances, _ := ioutil.ReadDir(filepath.Join(cm.Dir, "instances"))
+	for _, fi := range instances {
+		data, err := ioutil.ReadFile(filepath.Join(cm.Dir, "instances", fi.Name()))
+		if err != nil {
+			continue
+		}
+		var i instance
+		if json.Unmarshal(data, &i) == nil && i.Image != "" {
+			inUse[i.Image] = true
+			inUse[filepath.Join(cm.Dir, "images", filepath.Base(i.Image))] = true
+		}
+	}
+	return inUse
+}
+
+func (cm *CacheManager) pins() (map[string]bool, error) {
+	pins := map[string]bool{}
+	data, err := ioutil.ReadFile(filepath.Join(cm.Dir, cachePinsFile))
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return nil, fmt.Errorf("%s: %v", cachePinsFile, err)
+	}
+	for _, p := range paths {
+		pins[p] = true
+	}
+	return pins, nil
+}
+
+// Pin keeps the cache entry at path from being evicted, or lets it be
+// evicted again when pin is false
+func (cm *CacheManager) Pin(path string, pin bool) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	pins, err := cm.pins()
+	if err != nil {
+		return err
+	}
+	if pin {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		pins[path] = true
+	} else {
+		delete(pins, path)
+	}
+
+	paths := []string{}
+	for p := range pins {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	data, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cm.Dir, cachePinsFile), data, 0644)
+}
+
+// Prune evicts the entries selected by opts
+func (cm *CacheManager) Prune(opts PruneOptions) (*PruneReport, error) {
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = []CacheKind{CacheReleases, CachePackages, CacheSysroots}
+	}
+	// a limit on a kind prunes it
+	for kind := range opts.Limits {
+		selected := false
+		for _, k := range kinds {
+			selected = selected || k == kind
+		}
+		if !selected {
+			kinds = append(kinds, kind)
+		}
+	}
+	entries, err := cm.Entries(kinds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	sizes := map[CacheKind]int64{}
+	for _, e := range entries {
+		total += e.Size
+		sizes[e.Kind] += e.Size
+	}
+
+	// entries are least recently used first
+	report := &PruneReport{}
+	for _, e := range entries {
+		if e.Pinned {
+			continue
+		}
+
+		limit, limited := opts.Limits[e.Kind]
+		stale := opts.OlderThan > 0 && time.Since(e.LastUsed) > opts.OlderThan
+		if !stale && !(limited && sizes[e.Kind] > limit) && !(opts.MaxSize > 0 && total > opts.MaxSize) {
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := os.RemoveAll(e.Path); err != nil {
+				return report, err
+			}
+		}
+		report.Evicted = append(report.Evicted, e)
+		report.Freed += e.Size
+		total -= e.Size
+		sizes[e.Kind] -= e.Size
+	}
+	return report, nil
+}
+
+// dirSize returns the size of the files under path
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,123 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCacheEntry(t *testing.T, path string, size int, lastUsed time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ops-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	day := 24 * time.Hour
+	writeCacheEntry(t, filepath.Join(dir, "0.1.30", "kernel.img"), 300, now)
+	os.Chtimes(filepath.Join(dir, "0.1.30"), now.Add(-10*day), now.Add(-10*day))
+	writeCacheEntry(t, filepath.Join(dir, "0.1.31", "kernel.img"), 300, now)
+	os.Chtimes(filepath.Join(dir, "0.1.31"), now.Add(-20*day), now.Add(-20*day))
+	writeCacheEntry(t, filepath.Join(dir, "latest.txt"), 0, now)
+	ioutil.WriteFile(filepath.Join(dir, "latest.txt"), []byte("0.1.31\n"), 0644)
+	writeCacheEntry(t, filepath.Join(dir, "packages", "node_v14.tar.gz"), 100, now.Add(-3*day))
+	writeCacheEntry(t, filepath.Join(dir, "packages", "redis_5.tar.gz"), 100, now.Add(-1*day))
+	writeCacheEntry(t, filepath.Join(dir, "packages", PackageManifestFileName), 10, now.Add(-30*day))
+	writeCacheEntry(t, filepath.Join(dir, "images", "app.img"), 1000, now.Add(-30*day))
+
+	cm := &CacheManager{Dir: dir}
+
+	t.Run("should list entries least recently used first", func(t *testing.T) {
+		entries, err := cm.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, filepath.Base(e.Path))
+		}
+		want := []string{"app.img", "0.1.31", "0.1.30", "node_v14.tar.gz", "redis_5.tar.gz"}
+		if len(got) != len(want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		}
+		if !entries[1].Pinned || entries[1].Size != 300 {
+			t.Errorf("current release should be pinned: %+v", entries[1])
+		}
+	})
+
+	t.Run("should not evict on a dry run", func(t *testing.T) {
+		report, err := cm.Prune(PruneOptions{OlderThan: 5 * day, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Evicted) != 1 || report.Freed != 300 {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "0.1.30")); err != nil {
+			t.Error("dry run evicted an entry")
+		}
+	})
+
+	t.Run("should keep pinned entries", func(t *testing.T) {
+		if err := cm.Pin(filepath.Join(dir, "packages", "redis_5.tar.gz"), true); err != nil {
+			t.Fatal(err)
+		}
+		report, err := cm.Prune(PruneOptions{MaxSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Evicted) != 2 || report.Freed != 400 {
+			t.Errorf("unexpected report %+v", report)
+		}
+		for _, name := range []string{"0.1.31", "packages/redis_5.tar.gz", "images/app.img", "packages/" + PackageManifestFileName} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s evicted", name)
+			}
+		}
+	})
+
+	t.Run("should evict a kind down to its limit", func(t *testing.T) {
+		writeCacheEntry(t, filepath.Join(dir, "images", "old.img"), 1000, now.Add(-40*day))
+		report, err := cm.Prune(PruneOptions{Limits: map[CacheKind]int64{CacheImages: 1500}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Evicted) != 1 || filepath.Base(report.Evicted[0].Path) != "old.img" {
+			t.Errorf("unexpected report %+v", report)
+		}
+	})
+}
+
+func TestParseCacheLimit(t *testing.T) {
+	kind, size, err := ParseCacheLimit("packages=2G")
+	if err != nil || kind != CachePackages || size != 2000000000 {
+		t.Errorf("got %v %v %v", kind, size, err)
+	}
+	kind, size, err = ParseCacheLimit("512M")
+	if err != nil || kind != "" || size != 512000000 {
+		t.Errorf("got %v %v %v", kind, size, err)
+	}
+	if _, _, err := ParseCacheLimit("kernels=1G"); err == nil {
+		t.Error("expected an error for an unknown cache")
+	}
+}
@@ -19,7 +19,9 @@ type CloudInstance struct {
 	Name       string
 	Status     string
 	Created    string // TODO: prob. should be datetime w/helpers for human formatting
+	CreatedAt  time.Time
 	PrivateIps []string
 	PublicIps  []string
 	Image      string
+	Tags       map[string]string
 }
@@ -12,15 +12,34 @@ type Config struct {
 	// Boot
 	Boot string
 
+	// BootFSSize is the size the kernel and klibs must fit in, like 12M,
+	// the boot filesystem size of the nanos releases by default. Kernels
+	// built with another size set it.
+	BootFSSize string
+
 	// BuildDir
 	BuildDir string
 
+	// BuildHooks are run at each stage of the image build.
+	BuildHooks *BuildHooks `json:"-"`
+
 	// CloudConfig configures various attributes about the cloud provider.
 	CloudConfig ProviderConfig
 
+	// DataVolumes are image directories, like /var or /data, moved out of the
+	// image to writable local volumes mounted at them, so the image itself
+	// stays immutable. The volumes are created on the first build and kept
+	// by the next ones.
+	DataVolumes []string
+
 	// Debugflags
 	Debugflags []string
 
+	// Deduplicate stores the content of duplicate files, hard links or
+	// files of the same content, once. The duplicates are symlinks to the
+	// first of them in the image.
+	Deduplicate bool
+
 	// Dirs defines an array of directory locations to include into the image.
 	Dirs []string
 
@@ -28,26 +47,94 @@ type Config struct {
 	// runtime.
 	Env map[string]string
 
+	// EnvPassthrough are host environment variables whose values at build
+	// time are set in the environment of the image, the build fails when one
+	// is not set.
+	EnvPassthrough []string
+
+	// Exclude are patterns of the files and directories left out of Dirs
+	// and MapDirs: names like *.log, paths relative to the directories like
+	// node_modules/** where ** matches any number of directories, or
+	// regular expressions of relative paths prefixed with re:. The
+	// directories may also list the files to leave out in a .opsignore
+	// file, in the syntax of .gitignore.
+	Exclude []string
+
+	// Exit configures what the kernel does when the program exits or
+	// crashes. The kernel must read the reboot_on_crash tuple of an OnCrash
+	// differing from OnExit and the exit_status tuple of PropagateStatus,
+	// see KernelTuples.
+	Exit ExitPolicy
+
+	// FailOnWarnings fails the build when resolving the image files gives
+	// warnings of these categories: overwritten-file, broken-symlink,
+	// special-file, external-symlink, case-collision or all.
+	FailOnWarnings []string
+
+	// FileHashes records the sha256 of every file of the image in its
+	// manifest and in the build report.
+	FileHashes bool
+
 	// Files defines an array of file locations to include into the image.
 	Files []string
 
 	// Force
 	Force bool
 
+	// GrowRootFS marks the root filesystem as growable, so on the first boot
+	// on a volume larger than the image, like a cloud root volume, the kernel
+	// extends it to the whole volume instead of leaving the difference unused.
+	// The kernel must read the grow_root tuple, see KernelTuples.
+	GrowRootFS bool
+
 	// Kernel
 	Kernel string
 
+	// KernelTuples are manifest tuples the kernel reads besides those of
+	// the nanos releases, like the programs, setup, policy, uid and gid
+	// tuples of Programs, Setup, Policy and the owners of Manifest.SetOwner,
+	// or the tmpfs, grow_root, reboot_on_crash and exit_status tuples of
+	// Tmpfs, GrowRootFS and Exit. Builds using tuples the kernel doesn't read
+	// fail.
+	KernelTuples []string
+
+	// Label is the label written into the root filesystem of the image,
+	// ReadFilesystemLabel reads it back.
+	Label string
+
+	// LibraryPaths are directories of the image searched for the shared
+	// libraries of the programs before the system ones, like
+	// LD_LIBRARY_PATH, for programs shipping private libraries. They are
+	// prepended to the LD_LIBRARY_PATH of the image.
+	LibraryPaths []string
+
 	// ManifestName defines the name of the manifest file.
 	ManifestName string
 
+	// ManifestUUID derives the UUID of the filesystem from the hash of the
+	// manifest instead of generating it, images built from the same
+	// manifest have the same UUID.
+	ManifestUUID bool
+
 	// MapDirs specifies a map of local directories to add to into the image.
 	// These directory paths are then adjusted from local path specification
 	// to image path specification.
 	MapDirs map[string]string
 
+	// MaterializeSymlinks adds the files the symlinks of Dirs and MapDirs
+	// point to in place of the symlinks, when they point out of them.
+	MaterializeSymlinks bool
+
 	// Mkfs
 	Mkfs string
 
+	// ModTime is the modification time of every file, directory and symlink
+	// of the image, an RFC 3339 time or seconds since the epoch, for images
+	// that are the same byte for byte whenever they are built. It defaults
+	// to the SOURCE_DATE_EPOCH environment variable, the entries of the image
+	// have no modification time when neither is set.
+	ModTime string
+
 	// Mounts
 	Mounts map[string]string
 
@@ -61,6 +148,15 @@ type Config struct {
 	// NoTrace
 	NoTrace []string
 
+	// Policy is the least privilege policy the kernel enforces on the
+	// program.
+	Policy Policy
+
+	// ProfileDir is the directory the build writes pprof CPU and heap
+	// profiles of resolving the manifest and of mkfs to, for reports of
+	// slow builds.
+	ProfileDir string
+
 	// Program
 	Program string
 
@@ -68,20 +164,87 @@ type Config struct {
 	// attach/detach.
 	ProgramPath string
 
+	// Programs are more programs of the image by name, the kernel runs the
+	// one named by the exec boot argument or the exec=<name> line of the
+	// cloud userdata instead of Program. See RunConfig.Exec. The kernel
+	// must read the programs tuple, see KernelTuples.
+	Programs map[string]string
+
 	// RebootOnExit defines whether the image should automatically reboot
-	// if an error/failure occurs.
+	// if an error/failure occurs, like an Exit.OnExit of reboot.
 	RebootOnExit bool
 
+	// ReportName defines the name of the file the build report is written
+	// to as json.
+	ReportName string
+
+	// ResolveSymlinks copies the files and directories symlinks point to in
+	// place of the symlinks, the image has none.
+	ResolveSymlinks bool
+
 	// RunConfig
 	RunConfig RunConfig
 
-	// TargetRoot
+	// Setup are programs run to completion in order before Program, like a
+	// migration before a server. The image stops if one of them fails. The
+	// kernel must read the setup tuple, see KernelTuples.
+	Setup []SetupProgram
+
+	// Sources are the clock, randomness and filesystem UUIDs of builds and
+	// providers, the system ones when nil
+	Sources *Sources `json:"-"`
+
+	// SymlinkPolicy is what is added for the symlinks of added files and
+	// directories: preserve, the default, dereference like ResolveSymlinks,
+	// or skip. It takes precedence over ResolveSymlinks.
+	SymlinkPolicy SymlinkPolicy
+
+	// TargetRoot is the directory, or the docker image like
+	// docker://ubuntu:20.04, files and libraries are looked up in.
 	TargetRoot string
 
+	// TargetRootLayers are directories, or docker images, laid over
+	// TargetRoot like the layers of a container image, later layers win.
+	// Files named .wh.<name> delete name from the layers below.
+	TargetRootLayers []string
+
+	// TargetRootStrict confines the lookup of files and shared libraries to
+	// TargetRoot, files, libraries and symlink targets missing from it fail
+	// the build instead of being taken from the host.
+	TargetRootStrict bool
+
+	// Tmpfs mounts a tmpfs at each image path, of the size like 64M it maps
+	// to or without limit when empty, for writable scratch space like /tmp
+	// taking no room in the image. The kernel must read the tmpfs tuple, see
+	// KernelTuples.
+	Tmpfs map[string]string
+
 	// Version
 	Version string
 }
 
+// SetupProgram is a program run before the program of an image
+type SetupProgram struct {
+	// Program is the path of the program, looked up like Program
+	Program string
+
+	// Args are the arguments of the program, starting with its name like
+	// Args of the config. The program path when empty.
+	Args []string
+}
+
+// Policy restricts what the program of an image may access
+type Policy struct {
+	// AllowedPaths are the files and directories the program may access, the
+	// policy is only enforced when they are set. The program and its
+	// libraries are always allowed.
+	AllowedPaths []string
+
+	// Network allows the program to use the network when the policy is
+	// enforced.
+	Network bool
+}
+
 // ProviderConfig give provider details
 type ProviderConfig struct {
 	// BucketName specifies the bucket to store the ops built image artifacts.
@@ -90,9 +253,48 @@ type ProviderConfig struct {
 	// Flavor
 	Flavor string `cloud:"flavor"`
 
+	// Gallery publishes azure images as versions of an image definition in
+	// this Shared Image Gallery, created if it doesn't exist.
+	Gallery string `cloud:"gallery"`
+
+	// GalleryImage is the gallery image definition versions are published
+	// to, defaults to ImageName.
+	GalleryImage string `cloud:"galleryimage"`
+
+	// GalleryRegions are the regions published image versions are replicated
+	// to, in addition to the image region.
+	GalleryRegions []string `cloud:"galleryregions"`
+
+	// GuestOSFeatures enables guest OS features on gcp images, e.g.
+	// UEFI_COMPATIBLE or GVNIC.
+	GuestOSFeatures []string `cloud:"guestosfeatures"`
+
+	// ImageFamily adds gcp images to a family, so the latest image of the
+	// family can be used instead of a specific one.
+	ImageFamily string `cloud:"imagefamily"`
+
 	// ImageName
 	ImageName string `cloud:"imagename"`
 
+	// ImportMethod selects how images are imported on aws: "snapshot"
+	// (default) imports the image from the bucket, "ebs" writes the snapshot
+	// directly with the EBS direct APIs and needs neither a bucket nor the
+	// vmimport role.
+	ImportMethod string `cloud:"importmethod"`
+
+	// Profile is the named profile of the ops credentials file provider
+	// credentials are read from when they aren't set in the environment,
+	// defaults to OPS_PROFILE or "default".
+	Profile string `cloud:"profile"`
+
+	// Retries is how many times provider calls and upload chunks failing
+	// with transient errors, like throttling, are retried. Defaults to 5, a
+	// negative value disables retries. It applies to the AWS, GCP,
+	// DigitalOcean, Vultr and OpenStack calls and the Azure uploads, the
+	// other Azure calls use the retries of the Azure SDK and vSphere calls
+	// are not retried.
+	Retries int `cloud:"retries"`
+
 	// Platform defines the cloud provider to use with the ops CLI, currently
 	// supporting aws, azure, and gcp.
 	Platform string `cloud:"platform"`
@@ -101,6 +303,11 @@ type ProviderConfig struct {
 	// to gcp.
 	ProjectID string `cloud:"projectid"`
 
+	// StreamUpload compresses gcp images into the upload to the bucket as it
+	// goes, instead of writing the archive to upload next to the image
+	// first, which takes as much time and disk space again.
+	StreamUpload bool `cloud:"streamupload"`
+
 	// Zone is used to define the location of the host resource. Lists of these
 	// zones are dependent on selected Platform and can be found here:
 	// aws: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/using-regions-availability-zones.html
@@ -124,7 +331,7 @@ type RunConfig struct {
 	Accel bool
 
 	// BaseName of the image (FIXME).
-	BaseName string
+	BaseName string `deprecated:"set by ops from the image name"`
 
 	// Bridged parameter is set to true if bridged networking mode is
 	// in use. This also enables KVM acceleration.
@@ -133,18 +340,37 @@ type RunConfig struct {
 	// CPUs specifies the number of CPU cores to use
 	CPUs int
 
+	// CPUAffinity pins the vCPUs of local runs to host CPUs, vCPU n runs on
+	// CPUAffinity[n] modulo its length. Only supported on linux.
+	CPUAffinity []int
+
 	// Debug
 	Debug bool
 
+	// Display is the qemu display of local runs, like gtk, sdl or curses.
+	// Runs have no display by default, a display also adds a vga device
+	// the console of the guest is shown and screenshots are taken on.
+	Display string
+
 	// DomainName
 	DomainName string
 
+	// Exec is the name of the program of Programs to run. Images built with
+	// it run it by default, cloud instances are created with exec=<name> in
+	// their userdata.
+	Exec string
+
 	// Gateway
 	Gateway string
 
 	// GdbPort
 	GdbPort int
 
+	// HugePages backs guest memory of local runs with hugepages from the
+	// hugetlbfs mounted at /dev/hugepages, which must have enough free pages
+	// for Memory.
+	HugePages bool
+
 	// Imagename (FIXME)
 	Imagename string
 
@@ -157,17 +383,39 @@ type RunConfig struct {
 	// Klibs
 	Klibs []string
 
+	// KlibDirs are directories searched for Klibs before the klib
+	// directory of the release, in order, like one of locally built debug
+	// klibs.
+	KlibDirs []string
+
+	// KlibPaths are the host files of klibs by name, overriding those of
+	// the klib directories. The klibs are added to the image.
+	KlibPaths map[string]string
+
 	// Memory configures the amount of memory to allocate to qemu (default
 	// is 128 MiB). Optionally, a suffix of "M" or "G" can be used to
 	// signify a value in megabytes or gigabytes respectively.
 	Memory string
 
+	// MemoryBalloon adds a virtio-balloon device to local runs, so the host
+	// can reclaim memory the guest doesn't use.
+	MemoryBalloon bool
+
 	// Mounts
 	Mounts []string
 
 	// NetMask
 	NetMask string
 
+	// NetQueues is the number of virtio-net queue pairs of bridged local
+	// runs. Taps created by ops are single queue, with more queues create
+	// the tap with "ip tuntap add <tapname> mode tap multi_queue" first.
+	NetQueues int
+
+	// NUMANodes binds guest memory of local runs to host NUMA nodes, like
+	// "0" or "0-1".
+	NUMANodes string
+
 	// OnPrem is set to be true if the image is in a multi-instance/tenant
 	// on-premise environment.
 	OnPrem bool
@@ -175,9 +423,30 @@ type RunConfig struct {
 	// Ports specifies a list of port to expose.
 	Ports []string
 
+	// PropagateExitStatus tells local runs the kernel of the image reports
+	// the exit status of the program, set from ExitPolicy.PropagateStatus
+	PropagateExitStatus bool `json:"-"`
+
+	// RestoreState is a file holding guest state saved by suspending an
+	// instance, the instance resumes from it instead of booting. It must
+	// be run with the same image and options it was suspended with.
+	RestoreState string
+
 	// SecurityGroup
 	SecurityGroup string
 
+	// SerialLog is a file serial output of local runs is also written to,
+	// with timestamps
+	SerialLog string
+
+	// SerialLogSize is the size in MB the serial log is rotated at (default
+	// is 10).
+	SerialLogSize int
+
+	// SerialLogBackups is how many rotated serial logs are kept (default is
+	// 5).
+	SerialLogBackups int
+
 	// ShowDebug
 	ShowDebug bool
 
@@ -187,6 +456,12 @@ type RunConfig struct {
 	// ShowWarnings
 	ShowWarnings bool
 
+	// Sockets, Cores and Threads set the vCPU topology of local runs, CPUs
+	// defaults to their product
+	Sockets int
+	Cores   int
+	Threads int
+
 	// Subnet
 	Subnet string
 
@@ -202,9 +477,17 @@ type RunConfig struct {
 	// UDPPorts
 	UDPPorts []string
 
+	// VHost enables vhost-net for bridged local runs, moving packet
+	// processing into the host kernel. It needs access to /dev/vhost-net.
+	VHost bool
+
 	// Verbose enables logging for the runtime environment.
 	Verbose bool
 
+	// VNCPort serves the display of local runs with VNC on this localhost
+	// port, from 5900. Like Display it adds a vga device.
+	VNCPort int
+
 	// VolumeSizeInGb is an optional parameter only available for OpenStack.
 	VolumeSizeInGb int
 
@@ -212,6 +495,10 @@ type RunConfig struct {
 	VPC string
 }
 
+// VNCBasePort is the port of the first VNC display, RunConfig.VNCPort
+// can't be lower
+const VNCBasePort = 5900
+
 // RuntimeConfig constructs runtime config
 func RuntimeConfig(image string, ports []string, verbose bool) RunConfig {
 	return RunConfig{Imagename: image, Ports: ports, Verbose: verbose, Memory: "2G", Accel: true}
@@ -0,0 +1,50 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ReadConfig returns the config of the ops config file at path, with the
+// defaults of NewConfig for the fields it doesn't set. BuildManifest and
+// NewMkfsCommandFromConfig assemble the image it describes.
+//
+// Files ending in .yaml or .yml are read as YAML and, unlike JSON ones, are
+// rejected when they have unknown keys or values of the wrong type.
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isYAMLConfig(path) {
+		if data, err = readYAMLConfig(path, data); err != nil {
+			return nil, err
+		}
+	}
+	c := NewConfig()
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// readYAMLConfig validates the data of the YAML config at path and returns
+// it as JSON, the issues are reported with their line
+func readYAMLConfig(path string, data []byte) ([]byte, error) {
+	issues, err := validateYAMLConfigData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var errs []string
+	for _, issue := range issues {
+		if issue.Kind != ConfigDeprecatedKey {
+			errs = append(errs, fmt.Sprintf("%s:%s", path, issue))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return yamlToJSON(data)
+}
@@ -0,0 +1,41 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	data := `{"Args": ["-p", "8080"], "Env": {"PORT": "8080"}, "Dirs": ["static"], "BaseVolumeSz": "64M", "RunConfig": {"Klibs": ["ntp"]}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Args, []string{"-p", "8080"}) || c.Env["PORT"] != "8080" || c.BaseVolumeSz != "64M" {
+		t.Errorf("got %+v", c)
+	}
+	if !reflect.DeepEqual(c.RunConfig.Klibs, []string{"ntp"}) || !c.RunConfig.Accel {
+		t.Errorf("expected the klibs of the file and the defaults, got %+v", c.RunConfig)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"Args": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(path); err == nil {
+		t.Error("expected an error for malformed json")
+	}
+}
@@ -0,0 +1,188 @@
+package lepton
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fillConfig sets every exported field of v to a value derived from its path
+func fillConfig(v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				fillConfig(v.Field(i), path+"."+v.Type().Field(i).Name)
+			}
+		}
+	case reflect.String:
+		v.SetString("value" + path)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int:
+		v.SetInt(int64(len(path)))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		fillConfig(v.Index(0), path+"[0]")
+		fillConfig(v.Index(1), path+"[1]")
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(reflect.ValueOf("key"), reflect.ValueOf("value"+path))
+	}
+}
+
+func TestConfigExportImport(t *testing.T) {
+	var c Config
+	fillConfig(reflect.ValueOf(&c).Elem(), "")
+
+	exported, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should import the exported config unchanged", func(t *testing.T) {
+		var imported Config
+		if err := json.Unmarshal(exported, &imported); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, imported) {
+			t.Errorf("got %+v want %+v", imported, c)
+		}
+	})
+
+	t.Run("should validate the exported config", func(t *testing.T) {
+		issues, err := validateConfigData(exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, issue := range issues {
+			if issue.Kind != ConfigDeprecatedKey {
+				t.Errorf("unexpected issue %v", issue)
+			}
+		}
+	})
+}
+
+func TestConfigImportDifferential(t *testing.T) {
+	inputs := []string{
+		``,
+		`null`,
+		`{}`,
+		`[]`,
+		`"config"`,
+		`{"Args":null,"Env":null,"RunConfig":null}`,
+		`{"Args":["a"],"args":["b"]}`,
+		`{"RunConfig":{"CPUs":1e2}}`,
+		`{"RunConfig":{"CPUs":1.5}}`,
+		`{"RunConfig":{"CPUs":99999999999999999999}}`,
+		`{"RunConfig":{"CPUs":-1}}`,
+		`{"RunConfig":{"Tags":[{"Key":"k","Value":"v"},null]}}`,
+		`{"Env":{"":"","\u0000":"\ud800"}}`,
+		`{"Mounts":{"a":1}}`,
+		`{"CloudConfig":{"Retries":"3"}}`,
+		`{"BuildHooks":{"hooks":{}}}`,
+		`{}{}`,
+		`{"Args":[` + strings.Repeat(`[`, 1000),
+		`{"Unknown":` + strings.Repeat(`[`, 100) + strings.Repeat(`]`, 100) + `}`,
+		`{"Program":"a\"b","Files":["` + strings.Repeat("x", 4096) + `"]}`,
+	}
+
+	for _, input := range inputs {
+		data := []byte(input)
+		issues, verr := validateConfigData(data)
+
+		var c Config
+		if err := json.Unmarshal(data, &c); err != nil {
+			continue
+		}
+
+		if verr != nil {
+			t.Errorf("validator rejects %q the importer accepts: %v", input, verr)
+		}
+		for _, issue := range issues {
+			if issue.Kind == ConfigTypeMismatch {
+				t.Errorf("validator reports %v for %q the importer accepts", issue, input)
+			}
+		}
+
+		c.BuildHooks = nil
+		exported, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var imported Config
+		if err := json.Unmarshal(exported, &imported); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, imported) {
+			t.Errorf("%q changed on export: got %+v want %+v", input, imported, c)
+		}
+	}
+}
+
+func TestConfigOverrideDifferential(t *testing.T) {
+	sections := map[string]reflect.Type{
+		"":             reflect.TypeOf(Config{}),
+		"runconfig.":   reflect.TypeOf(RunConfig{}),
+		"cloudconfig.": reflect.TypeOf(ProviderConfig{}),
+	}
+
+	for prefix, typ := range sections {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+
+			value := map[reflect.Kind]string{
+				reflect.String: "override",
+				reflect.Bool:   "true",
+				reflect.Int:    "42",
+			}[field.Type.Kind()]
+			if value == "" {
+				continue
+			}
+
+			overridden := NewConfig()
+			if err := ApplyConfigOverrides(overridden, prefix+strings.ToLower(field.Name)+"="+value); err != nil {
+				t.Errorf("%s%s: %v", prefix, field.Name, err)
+				continue
+			}
+
+			want := NewConfig()
+			v := reflect.ValueOf(want).Elem()
+			if prefix != "" {
+				v = v.FieldByName(map[string]string{"runconfig.": "RunConfig", "cloudconfig.": "CloudConfig"}[prefix])
+			}
+			switch f := v.Field(i); f.Kind() {
+			case reflect.String:
+				f.SetString("override")
+			case reflect.Bool:
+				f.SetBool(true)
+			case reflect.Int:
+				f.SetInt(42)
+			}
+
+			if !reflect.DeepEqual(overridden, want) {
+				t.Errorf("%s%s: override differs from the programmatic value", prefix, field.Name)
+			}
+		}
+	}
+}
+
+func TestPackageManifestExportImport(t *testing.T) {
+	packages := map[string]Package{
+		"node_v14": {Runtime: "node", Version: "v14", Language: "javascript", Description: "node \"14\"", SHA256: "abc"},
+		"empty":    {},
+	}
+
+	exported, err := json.Marshal(packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported map[string]Package
+	if err := json.Unmarshal(exported, &imported); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(packages, imported) {
+		t.Errorf("got %+v want %+v", imported, packages)
+	}
+}
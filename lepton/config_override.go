@@ -0,0 +1,197 @@
+package lepton
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// configOverrideAliases maps short override keys to config field paths
+var configOverrideAliases = map[string]string{
+	"args":   "Args",
+	"env":    "Env",
+	"size":   "BaseVolumeSz",
+	"memory": "RunConfig.Memory",
+	"cpus":   "RunConfig.CPUs",
+	"zone":   "CloudConfig.Zone",
+}
+
+// ApplyConfigOverrides applies overrides like "env.PORT=8080", "args[0]=serve"
+// or "size=2g" on top of config. Keys are config field paths matched case
+// insensitively, map entries are addressed with a dot and slice elements with
+// an index, where an index equal to the slice length appends.
+func ApplyConfigOverrides(c *Config, overrides ...string) error {
+	for _, override := range overrides {
+		if err := applyConfigOverride(c, override); err != nil {
+			return fmt.Errorf("override %q: %v", override, err)
+		}
+	}
+	return nil
+}
+
+func applyConfigOverride(c *Config, override string) error {
+	eq := strings.Index(override, "=")
+	if eq <= 0 {
+		return fmt.Errorf("expected key=value")
+	}
+	key, value := strings.TrimSpace(override[:eq]), override[eq+1:]
+
+	segments := strings.Split(key, ".")
+	if alias, ok := configOverrideAliases[strings.ToLower(stripIndex(segments[0]))]; ok {
+		aliased := strings.Split(alias, ".")
+		aliased[len(aliased)-1] += segments[0][len(stripIndex(segments[0])):]
+		segments = append(aliased, segments[1:]...)
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	var path []string
+	for i, segment := range segments {
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("%s is not a section", strings.Join(path, "."))
+		}
+
+		name := stripIndex(segment)
+		sf, ok := v.Type().FieldByNameFunc(func(f string) bool { return strings.EqualFold(f, name) })
+		if !ok || sf.PkgPath != "" {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		path = append(path, sf.Name)
+		if sf.Tag.Get("json") == "-" {
+			return fmt.Errorf("%s can't be overridden", strings.Join(path, "."))
+		}
+		field := v.FieldByIndex(sf.Index)
+		last := i == len(segments)-1
+
+		switch field.Kind() {
+		case reflect.Map:
+			if last {
+				return fmt.Errorf("%s needs a key, e.g. %s.KEY=value", strings.Join(path, "."), strings.ToLower(strings.Join(path, ".")))
+			}
+			if err := setOverrideMapEntry(field, strings.Join(segments[i+1:], "."), value); err != nil {
+				return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+			}
+			return nil
+		case reflect.Slice:
+			if !last {
+				return fmt.Errorf("%s is not a section", strings.Join(path, "."))
+			}
+			if err := setOverrideSlice(field, segment[len(name):], value); err != nil {
+				return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+			}
+			return nil
+		}
+
+		if last {
+			if err := setOverrideValue(field, value); err != nil {
+				return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+			}
+			return nil
+		}
+		v = field
+	}
+
+	return nil
+}
+
+// stripIndex removes a trailing "[n]" from a key segment
+func stripIndex(segment string) string {
+	if i := strings.Index(segment, "["); i >= 0 {
+		return segment[:i]
+	}
+	return segment
+}
+
+// setOverrideMapEntry sets the entry of the map field at key, creating the
+// map when it is not set
+func setOverrideMapEntry(field reflect.Value, key string, value string) error {
+	if field.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("unsupported map field of type %s", field.Type())
+	}
+	elem := reflect.New(field.Type().Elem()).Elem()
+	if err := setOverrideValue(elem, value); err != nil {
+		return err
+	}
+	if field.IsNil() {
+		field.Set(reflect.MakeMap(field.Type()))
+	}
+	field.SetMapIndex(reflect.ValueOf(key).Convert(field.Type().Key()), elem)
+	return nil
+}
+
+// setOverrideSlice sets the element of field at index, like "[2]", or the
+// whole list from comma separated values when index is empty
+func setOverrideSlice(field reflect.Value, index string, value string) error {
+	if index == "" {
+		values := reflect.MakeSlice(field.Type(), 0, 0)
+		if value != "" {
+			for _, s := range strings.Split(value, ",") {
+				elem := reflect.New(field.Type().Elem()).Elem()
+				if err := setOverrideValue(elem, s); err != nil {
+					return err
+				}
+				values = reflect.Append(values, elem)
+			}
+		}
+		field.Set(values)
+		return nil
+	}
+
+	if !strings.HasPrefix(index, "[") || !strings.HasSuffix(index, "]") {
+		return fmt.Errorf("malformed index %q", index)
+	}
+	n, err := strconv.Atoi(index[1 : len(index)-1])
+	if err != nil || n < 0 {
+		return fmt.Errorf("malformed index %q", index)
+	}
+	if n > field.Len() {
+		return fmt.Errorf("index %d out of range, list has %d elements", n, field.Len())
+	}
+
+	elem := reflect.New(field.Type().Elem()).Elem()
+	if err := setOverrideValue(elem, value); err != nil {
+		return err
+	}
+	if n < field.Len() {
+		field.Index(n).Set(elem)
+	} else {
+		field.Set(reflect.Append(field, elem))
+	}
+	return nil
+}
+
+// setOverrideValue sets field, of a string, boolean or number type, to
+// value
+func setOverrideValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a positive number, got %q", value)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field of type %s", field.Type())
+	}
+	return nil
+}
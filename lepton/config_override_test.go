@@ -0,0 +1,93 @@
+package lepton
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigOverrides(t *testing.T) {
+	t.Run("should override fields on top of a loaded config", func(t *testing.T) {
+		c := NewConfig()
+		c.Args = []string{"app", "run"}
+		c.Env = map[string]string{"PORT": "80"}
+
+		err := ApplyConfigOverrides(c,
+			"env.PORT=8080",
+			"env.app.mode=prod",
+			"args[1]=serve",
+			"args[2]=--verbose",
+			"size=2g",
+			"runconfig.memory=1G",
+			"RunConfig.CPUs=4",
+			"runconfig.accel=false",
+			"cloudconfig.zone=us-west-1",
+			"files=a,b",
+			"runconfig.cpuaffinity=1,3",
+			"runconfig.cpuaffinity[2]=5",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := map[string]string{"PORT": "8080", "app.mode": "prod"}; !reflect.DeepEqual(c.Env, want) {
+			t.Errorf("got env %v want %v", c.Env, want)
+		}
+		if want := []string{"app", "serve", "--verbose"}; !reflect.DeepEqual(c.Args, want) {
+			t.Errorf("got args %v want %v", c.Args, want)
+		}
+		if want := []string{"a", "b"}; !reflect.DeepEqual(c.Files, want) {
+			t.Errorf("got files %v want %v", c.Files, want)
+		}
+		if want := []int{1, 3, 5}; !reflect.DeepEqual(c.RunConfig.CPUAffinity, want) {
+			t.Errorf("got cpu affinity %v want %v", c.RunConfig.CPUAffinity, want)
+		}
+		if c.BaseVolumeSz != "2g" || c.RunConfig.Memory != "1G" || c.RunConfig.CPUs != 4 ||
+			c.RunConfig.Accel || c.CloudConfig.Zone != "us-west-1" {
+			t.Errorf("unexpected config %+v", c)
+		}
+	})
+
+	t.Run("should create maps that are not set", func(t *testing.T) {
+		c := NewConfig()
+		if err := ApplyConfigOverrides(c, "env.PORT=8080"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Env["PORT"] != "8080" {
+			t.Errorf("got env %v", c.Env)
+		}
+	})
+
+	t.Run("should reject invalid overrides", func(t *testing.T) {
+		for _, override := range []string{
+			"noequals",
+			"=value",
+			"unknown=1",
+			"env=x",
+			"args[5]=x",
+			"args[x]=x",
+			"runconfig.cpus=many",
+			"runconfig.accel=maybe",
+			"program.name=x",
+			"runconfig.cpuaffinity=1,x",
+		} {
+			if err := ApplyConfigOverrides(NewConfig(), override); err == nil {
+				t.Errorf("expected error for %q", override)
+			}
+		}
+	})
+
+	t.Run("should name the fields that can't be overridden", func(t *testing.T) {
+		for override, field := range map[string]string{
+			"setup[0]=x":          "Setup",
+			"sources=x":           "Sources",
+			"runconfig.tags[0]=x": "RunConfig.Tags",
+			"runconfig.cpus=many": "RunConfig.CPUs",
+		} {
+			err := ApplyConfigOverrides(NewConfig(), override)
+			if err == nil || !strings.Contains(err.Error(), field) {
+				t.Errorf("expected error naming %s for %q, got %v", field, override, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package lepton
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ConfigIssueKind classifies problems found while validating a config
+type ConfigIssueKind string
+
+const (
+	// ConfigUnknownKey is reported for keys that don't map to a config field
+	ConfigUnknownKey ConfigIssueKind = "unknown-key"
+	// ConfigTypeMismatch is reported for values of the wrong type
+	ConfigTypeMismatch ConfigIssueKind = "type-mismatch"
+	// ConfigDeprecatedKey is reported for fields that should not be used anymore
+	ConfigDeprecatedKey ConfigIssueKind = "deprecated"
+)
+
+// ConfigIssue is a problem found in a config file
+type ConfigIssue struct {
+	Kind    ConfigIssueKind
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.Kind, i.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", i.Line, i.Column, i.Kind, i.Message)
+}
+
+// ValidateConfig checks the config file at path against the config format
+// and returns the issues found. An error is returned only if the file can't
+// be read or isn't valid JSON, or YAML for .yaml and .yml files.
+func ValidateConfig(path string) ([]ConfigIssue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isYAMLConfig(path) {
+		return validateYAMLConfigData(data)
+	}
+	return validateConfigData(data)
+}
+
+func validateConfigData(data []byte) ([]ConfigIssue, error) {
+	v := &configValidator{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+
+	if err := v.value(reflect.TypeOf(Config{}), ""); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line, col := v.position(int(serr.Offset))
+			return nil, fmt.Errorf("%d:%d: %v", line, col, err)
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, fmt.Errorf("unexpected end of config")
+		}
+		return nil, err
+	}
+
+	return v.issues, nil
+}
+
+type configValidator struct {
+	data   []byte
+	dec    *json.Decoder
+	issues []ConfigIssue
+}
+
+// position returns line and column of the token starting at or after offset
+func (v *configValidator) position(offset int) (int, int) {
+	for offset < len(v.data) && strings.IndexByte(" \t\r\n,:", v.data[offset]) >= 0 {
+		offset++
+	}
+	if offset > len(v.data) {
+		offset = len(v.data)
+	}
+
+	line := 1 + bytes.Count(v.data[:offset], []byte("\n"))
+	col := offset + 1
+	if nl := bytes.LastIndexByte(v.data[:offset], '\n'); nl >= 0 {
+		col = offset - nl
+	}
+	return line, col
+}
+
+func (v *configValidator) report(kind ConfigIssueKind, offset int, path, format string, a ...interface{}) {
+	line, col := v.position(offset)
+	v.issues = append(v.issues, ConfigIssue{
+		Kind:    kind,
+		Path:    path,
+		Line:    line,
+		Column:  col,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// value validates the next JSON value against type t
+func (v *configValidator) value(t reflect.Type, path string) error {
+	offset := int(v.dec.InputOffset())
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch {
+		case tok == '{' && t.Kind() == reflect.Struct:
+			return v.object(t, path)
+		case tok == '{' && t.Kind() == reflect.Map:
+			return v.mapObject(t, path)
+		case tok == '[' && t.Kind() == reflect.Slice:
+			for i := 0; v.dec.More(); i++ {
+				if err := v.value(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err := v.dec.Token()
+			return err
+		}
+		v.report(ConfigTypeMismatch, offset, path, "%s should be %s", path, schemaTypeName(t))
+		return v.skip(tok)
+	case nil:
+		return nil
+	case string:
+		if t.Kind() == reflect.String {
+			return nil
+		}
+	case bool:
+		if t.Kind() == reflect.Bool {
+			return nil
+		}
+	case float64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if tok != math.Trunc(tok) {
+				break
+			}
+			if !integerInRange(tok, t) {
+				v.report(ConfigTypeMismatch, offset, path, "%s is out of the range of %s", path, t.Kind())
+			}
+			return nil
+		case reflect.Float32, reflect.Float64:
+			return nil
+		}
+	}
+
+	v.report(ConfigTypeMismatch, offset, path, "%s should be %s", path, schemaTypeName(t))
+	return nil
+}
+
+// integerInRange tells whether the integer f fits in the integer type t
+func integerInRange(f float64, t reflect.Type) bool {
+	bits := t.Bits()
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f >= 0 && f < math.Ldexp(1, bits)
+	}
+	return f >= -math.Ldexp(1, bits-1) && f < math.Ldexp(1, bits-1)
+}
+
+func (v *configValidator) object(t reflect.Type, path string) error {
+	for v.dec.More() {
+		offset := int(v.dec.InputOffset())
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		field, ok := configField(t, key)
+		if !ok {
+			v.report(ConfigUnknownKey, offset, keyPath, "unknown key %s", keyPath)
+			if err := v.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if reason, ok := field.Tag.Lookup("deprecated"); ok {
+			v.report(ConfigDeprecatedKey, offset, keyPath, "%s is deprecated: %s", keyPath, reason)
+		}
+
+		if err := v.value(field.Type, keyPath); err != nil {
+			return err
+		}
+	}
+
+	_, err := v.dec.Token()
+	return err
+}
+
+func (v *configValidator) mapObject(t reflect.Type, path string) error {
+	for v.dec.More() {
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := v.value(t.Elem(), path+"."+tok.(string)); err != nil {
+			return err
+		}
+	}
+
+	_, err := v.dec.Token()
+	return err
+}
+
+// skipValue consumes the next JSON value
+func (v *configValidator) skipValue() error {
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); ok {
+		return v.skip(delim)
+	}
+	return nil
+}
+
+// skip consumes the rest of the object or array opened by delim
+func (v *configValidator) skip(delim json.Delim) error {
+	if delim != '{' && delim != '[' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := tok.(json.Delim); ok {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+	return nil
+}
+
+// configField finds the field of t a JSON key decodes into, using the same
+// case insensitive matching encoding/json does
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, skip := jsonFieldName(f)
+		if skip {
+			continue
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonFieldName returns the JSON key of f and whether it is not serialized
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", true
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, false
+	}
+	return f.Name, false
+}
+
+func schemaTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "a list"
+	}
+	return "an object"
+}
+
+// ConfigSchema returns the JSON schema of the config format
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "ops configuration"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	}
+
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, skip := jsonFieldName(f)
+		if skip {
+			continue
+		}
+		property := typeSchema(f.Type)
+		if reason, ok := f.Tag.Lookup("deprecated"); ok {
+			property["deprecated"] = true
+			property["description"] = reason
+		}
+		properties[name] = property
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,129 @@
+package lepton
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("should accept a valid config", func(t *testing.T) {
+		issues, err := ValidateConfig("../config.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(issues) != 0 {
+			t.Errorf("unexpected issues %v", issues)
+		}
+	})
+
+	t.Run("should report issues with their position", func(t *testing.T) {
+		data := []byte(`{
+  "Args": ["a", 1],
+  "Env": {"PORT": "80"},
+  "runconfig": {
+    "Memory": "2G",
+    "CPUs": "two",
+    "BaseName": "x"
+  },
+  "Bogus": {"nested": [1, 2]},
+  "Debugflags": ["trace"]
+}`)
+		issues, err := validateConfigData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []ConfigIssue{
+			{Kind: ConfigTypeMismatch, Path: "Args[1]", Line: 2, Column: 17},
+			{Kind: ConfigTypeMismatch, Path: "runconfig.CPUs", Line: 6, Column: 13},
+			{Kind: ConfigDeprecatedKey, Path: "runconfig.BaseName", Line: 7, Column: 5},
+			{Kind: ConfigUnknownKey, Path: "Bogus", Line: 9, Column: 3},
+		}
+		for i := range issues {
+			issues[i].Message = ""
+		}
+		if !reflect.DeepEqual(issues, want) {
+			t.Errorf("got %+v\nwant %+v", issues, want)
+		}
+	})
+
+	t.Run("should report integers out of the range of their field", func(t *testing.T) {
+		type sized struct {
+			Small  uint8
+			Count  uint
+			Offset int8
+			Size   int
+		}
+		data := []byte(`{"Small": 300, "Count": -1, "Offset": -128, "Size": 1e30}`)
+		v := &configValidator{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+		if err := v.value(reflect.TypeOf(sized{}), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var paths []string
+		for _, issue := range v.issues {
+			paths = append(paths, issue.Path)
+		}
+		if want := []string{"Small", "Count", "Size"}; !reflect.DeepEqual(paths, want) {
+			t.Errorf("got issues %v, want %v", v.issues, want)
+		}
+	})
+
+	t.Run("should fail on malformed JSON", func(t *testing.T) {
+		for _, data := range []string{`{"Args": [}`, `{"Args": `} {
+			if _, err := validateConfigData([]byte(data)); err == nil {
+				t.Errorf("expected error for %s", data)
+			}
+		}
+	})
+}
+
+func TestConfigSchemaIsUpToDate(t *testing.T) {
+	data, err := ioutil.ReadFile("../config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published interface{}
+	if err := json.Unmarshal(data, &published); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, _ := json.Marshal(ConfigSchema())
+	var current interface{}
+	json.Unmarshal(generated, &current)
+
+	if !reflect.DeepEqual(published, current) {
+		t.Error("config.schema.json is out of date, regenerate it with ops validate --schema")
+	}
+}
+
+func TestValidateSizedIntegers(t *testing.T) {
+	type sized struct {
+		A int8
+		B int16
+		C int32
+		D uint8
+		E uint16
+		F uint32
+	}
+	typ := reflect.TypeOf(sized{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if got := typeSchema(f.Type)["type"]; got != "integer" {
+			t.Errorf("%s: got schema type %v", f.Type, got)
+		}
+	}
+
+	data := []byte(`{"A": 1, "B": 2, "C": 3, "D": 4, "E": 5, "F": "six"}`)
+	v := &configValidator{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	if err := v.value(typ, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.issues) != 1 || v.issues[0].Message != "F should be an integer" {
+		t.Errorf("got issues %+v", v.issues)
+	}
+}
@@ -0,0 +1,146 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYAMLConfig tells whether the config file at path is YAML, by extension
+func isYAMLConfig(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML config to JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	// keys defined twice are rejected
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yamlNodeToJSON(&doc)
+}
+
+// yamlNodeToJSON converts the document node of a YAML config to JSON
+func yamlNodeToJSON(doc *yaml.Node) ([]byte, error) {
+	var v interface{}
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&v); err != nil {
+			return nil, err
+		}
+	}
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	v = jsonValue(v)
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("config should be a mapping")
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue returns the value yaml decoded as the value encoding/json would
+// decode, with string keys for the mappings
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = jsonValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = jsonValue(item)
+		}
+		return s
+	}
+	return v
+}
+
+// yamlPosition is where the value at a config path is in a YAML config,
+// key is nil for the items of lists
+type yamlPosition struct {
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+// yamlPositions records the positions of the values under node by their
+// paths, named like validateConfigData does
+func yamlPositions(node *yaml.Node, path string, positions map[string]yamlPosition) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			yamlPositions(n, path, positions)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			positions[keyPath] = yamlPosition{key: key, value: value}
+			yamlPositions(value, keyPath, positions)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			positions[itemPath] = yamlPosition{value: item}
+			yamlPositions(item, itemPath, positions)
+		}
+	}
+}
+
+// validateYAMLConfigData is validateConfigData for YAML configs. Issues are
+// located at the key, or the value for values of the wrong type, and sorted
+// by line like the ones of JSON configs.
+func validateYAMLConfigData(data []byte) ([]ConfigIssue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	jsonData, err := yamlNodeToJSON(&doc)
+	if err != nil {
+		return nil, err
+	}
+	issues, err := validateConfigData(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := map[string]yamlPosition{}
+	yamlPositions(&doc, "", positions)
+	for i := range issues {
+		pos := positions[issues[i].Path]
+		node := pos.value
+		if issues[i].Kind != ConfigTypeMismatch && pos.key != nil {
+			node = pos.key
+		}
+		issues[i].Line, issues[i].Column = 0, 0
+		if node != nil {
+			issues[i].Line, issues[i].Column = node.Line, node.Column
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	return issues, nil
+}
@@ -0,0 +1,108 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	data, err := yamlToJSON([]byte(`# build spec
+Args: [one, "two # not a comment", 'it''s']
+Env:
+  PORT: "8080"
+  EMPTY:
+RunConfig:
+  Memory: 2G # with a comment
+  CPUs: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Args":["one","two # not a comment","it's"],"Env":{"EMPTY":null,"PORT":"8080"},"RunConfig":{"CPUs":2,"Memory":"2G"}}`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+
+	for _, data := range []string{
+		"Args: [a, b",
+		"Args: a\nArgs: b",
+		"just a string",
+	} {
+		if _, err := yamlToJSON([]byte(data)); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestValidateYAMLConfig(t *testing.T) {
+	data := []byte(`Args:
+  - a
+  - 1
+Env: {PORT: "80"}
+runconfig:
+  Memory: 2G
+  CPUs: two
+  BaseName: x
+Bogus:
+  nested: [1, 2]
+`)
+	issues, err := validateYAMLConfigData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issues are at the key, or the value of the wrong type
+	want := []ConfigIssue{
+		{Kind: ConfigTypeMismatch, Path: "Args[1]", Line: 3, Column: 5},
+		{Kind: ConfigTypeMismatch, Path: "runconfig.CPUs", Line: 7, Column: 9},
+		{Kind: ConfigDeprecatedKey, Path: "runconfig.BaseName", Line: 8, Column: 3},
+		{Kind: ConfigUnknownKey, Path: "Bogus", Line: 9, Column: 1},
+	}
+	for i := range issues {
+		issues[i].Message = ""
+	}
+	if !reflect.DeepEqual(issues, want) {
+		t.Errorf("got %+v\nwant %+v", issues, want)
+	}
+}
+
+func TestReadYAMLConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ops-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(file, []byte(`Args: [one, two]
+Env:
+  PORT: "80"
+RunConfig:
+  Memory: 2G
+  Accel: false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ReadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Args, []string{"one", "two"}) || c.Env["PORT"] != "80" {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if c.RunConfig.Memory != "2G" || c.RunConfig.Accel {
+		t.Errorf("unexpected run config %+v", c.RunConfig)
+	}
+
+	bad := path.Join(dir, "bad.yml")
+	if err := ioutil.WriteFile(bad, []byte("Args: [one]\nArgz: [two]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(bad); err == nil || !strings.Contains(err.Error(), bad+":2:1: unknown-key") {
+		t.Errorf("expected an error for the unknown key at line 2, got %v", err)
+	}
+}
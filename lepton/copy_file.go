@@ -0,0 +1,44 @@
+package lepton
+
+import (
+	"io"
+	"os"
+)
+
+// zeroCopyThreshold is the size from which files are copied in the kernel,
+// where the platform can, instead of through buffers of ops
+const zeroCopyThreshold = 64 * MiByte
+
+// copyFile copies the file src to dst, large files without reading them in
+// userspace
+func copyFile(dst string, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	copied := int64(0)
+	if fi.Size() >= zeroCopyThreshold {
+		copied, err = copyFileRange(out, in, fi.Size())
+	}
+	if err == nil && copied < fi.Size() {
+		// the rest of the file, when the kernel can't copy it
+		_, err = io.Copy(out, in)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,28 @@
+package lepton
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileRange copies size bytes of in to out with copy_file_range, and
+// returns how many were copied before it isn't supported, like across
+// filesystems of old kernels
+func copyFileRange(out *os.File, in *os.File, size int64) (int64, error) {
+	var copied int64
+	for copied < size {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, int(size-copied), 0)
+		if err == unix.ENOSYS || err == unix.EXDEV || err == unix.EINVAL || err == unix.EOPNOTSUPP {
+			break
+		}
+		if err != nil {
+			return copied, err
+		}
+		if n == 0 {
+			break
+		}
+		copied += int64(n)
+	}
+	return copied, nil
+}
@@ -0,0 +1,63 @@
+package lepton
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<14)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should copy files", func(t *testing.T) {
+		dst := filepath.Join(dir, "dst")
+		if err := copyFile(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("got %d bytes, %v", len(got), err)
+		}
+		if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("got mode %v, %v", fi.Mode(), err)
+		}
+	})
+
+	t.Run("should copy ranges in the kernel or leave the rest to userspace", func(t *testing.T) {
+		in, err := os.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		out, err := os.Create(filepath.Join(dir, "range"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		copied, err := copyFileRange(out, in, int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadFile(out.Name())
+		if !bytes.Equal(got, data) {
+			t.Errorf("got %d bytes after %d copied in the kernel", len(got), copied)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// HoursPerMonth is the number of hours providers bill for an average month
+const HoursPerMonth = 730
+
+// PriceList maps provider, region and flavor to the on-demand hourly price
+// of an instance in USD. The region "*" applies to regions without their own
+// prices.
+type PriceList map[string]map[string]map[string]float64
+
+// DefaultPriceList is a snapshot of on-demand linux instance prices, it is
+// only meant to catch expensive mistakes and may lag behind the providers.
+var DefaultPriceList = PriceList{
+	"aws": {
+		"us-east-1": {
+			"t2.micro":  0.0116,
+			"t2.small":  0.023,
+			"t2.medium": 0.0464,
+			"t2.large":  0.0928,
+			"t3.micro":  0.0104,
+			"t3.small":  0.0208,
+			"t3.medium": 0.0416,
+			"t3.large":  0.0832,
+			"m5.large":  0.096,
+			"m5.xlarge": 0.192,
+			"c5.large":  0.085,
+			"c5.xlarge": 0.17,
+		},
+	},
+	"gcp": {
+		"us-central1": {
+			"f1-micro":      0.0076,
+			"g1-small":      0.0257,
+			"e2-micro":      0.008376,
+			"e2-small":      0.016751,
+			"e2-medium":     0.033503,
+			"e2-standard-2": 0.067006,
+			"n1-standard-1": 0.0475,
+			"n1-standard-2": 0.095,
+			"n1-standard-4": 0.19,
+		},
+	},
+	"azure": {
+		"eastus": {
+			"Standard_B1s":    0.0104,
+			"Standard_B1ms":   0.0207,
+			"Standard_B2s":    0.0416,
+			"Standard_D2s_v3": 0.096,
+		},
+	},
+	"do": {
+		"*": {
+			"s-1vcpu-1gb": 0.00744,
+			"s-1vcpu-2gb": 0.01488,
+			"s-2vcpu-2gb": 0.02232,
+			"s-2vcpu-4gb": 0.02976,
+		},
+	},
+}
+
+// LoadPriceList reads a price list from a JSON file, so estimates can use
+// current prices instead of the bundled snapshot
+func LoadPriceList(path string) (PriceList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prices PriceList
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("invalid price list %s: %v", path, err)
+	}
+	return prices, nil
+}
+
+// CostEstimate is the estimated cost of running instances
+type CostEstimate struct {
+	Provider string
+	Region   string
+	Flavor   string
+	Count    int
+
+	// HourlyPrice is the price of a single instance
+	HourlyPrice float64
+
+	// Hourly and Monthly are the cost of all instances
+	Hourly  float64
+	Monthly float64
+}
+
+// EstimateCost estimates the cost of running count instances of flavor in a
+// region using the bundled price list
+func EstimateCost(provider, region, flavor string, count int) (*CostEstimate, error) {
+	return DefaultPriceList.EstimateCost(provider, region, flavor, count)
+}
+
+// EstimateCost estimates the cost of running count instances of flavor in a
+// region. GCP zones and AWS availability zones are priced as their region.
+func (pl PriceList) EstimateCost(provider, region, flavor string, count int) (*CostEstimate, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("instance count must be positive, got %d", count)
+	}
+
+	regions, ok := pl[provider]
+	if !ok {
+		return nil, fmt.Errorf("no pricing for provider %s", provider)
+	}
+
+	var price float64
+	found := false
+	for _, r := range []string{region, priceRegion(provider, region), "*"} {
+		if price, found = regions[r][flavor]; found {
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no pricing for %s flavor %s in %s", provider, flavor, region)
+	}
+
+	hourly := price * float64(count)
+	return &CostEstimate{
+		Provider:    provider,
+		Region:      region,
+		Flavor:      flavor,
+		Count:       count,
+		HourlyPrice: price,
+		Hourly:      hourly,
+		Monthly:     hourly * HoursPerMonth,
+	}, nil
+}
+
+// priceRegion returns the region a zone is priced as
+func priceRegion(provider, zone string) string {
+	switch provider {
+	case "aws":
+		// us-east-1a
+		return strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+	case "gcp":
+		// us-central1-a
+		if i := strings.LastIndex(zone, "-"); i > 0 && strings.Count(zone, "-") == 2 {
+			return zone[:i]
+		}
+	}
+	return zone
+}
+
+func (e *CostEstimate) String() string {
+	return fmt.Sprintf("%d x %s in %s: $%.4f/hour, $%.2f/month", e.Count, e.Flavor, e.Region, e.Hourly, e.Monthly)
+}
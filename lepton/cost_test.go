@@ -0,0 +1,43 @@
+package lepton
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	t.Run("should price zones as their region", func(t *testing.T) {
+		for _, tt := range []struct{ provider, zone, flavor string }{
+			{"aws", "us-east-1a", "t2.micro"},
+			{"gcp", "us-central1-a", "g1-small"},
+			{"do", "nyc1", "s-1vcpu-1gb"},
+		} {
+			if _, err := EstimateCost(tt.provider, tt.zone, tt.flavor, 1); err != nil {
+				t.Errorf("%v: %v", tt, err)
+			}
+		}
+	})
+
+	t.Run("should multiply by count and hours", func(t *testing.T) {
+		pl := PriceList{"aws": {"us-east-1": {"m5.large": 0.1}}}
+		e, err := pl.EstimateCost("aws", "us-east-1", "m5.large", 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(e.Hourly-5) > 1e-9 || math.Abs(e.Monthly-5*HoursPerMonth) > 1e-6 {
+			t.Errorf("got %s", e)
+		}
+	})
+
+	t.Run("should fail on unknown prices", func(t *testing.T) {
+		if _, err := EstimateCost("aws", "us-east-1", "x1.huge", 1); err == nil {
+			t.Error("expected error for unknown flavor")
+		}
+		if _, err := EstimateCost("nope", "us-east-1", "t2.micro", 1); err == nil {
+			t.Error("expected error for unknown provider")
+		}
+		if _, err := EstimateCost("aws", "us-east-1", "t2.micro", 0); err == nil {
+			t.Error("expected error for no instances")
+		}
+	})
+}
@@ -0,0 +1,10 @@
+package lepton
+
+import "golang.org/x/sys/unix"
+
+// setThreadAffinity restricts the host thread tid to run on cpu
+func setThreadAffinity(tid int, cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(tid, &set)
+}
@@ -0,0 +1,7 @@
+package lepton
+
+import "errors"
+
+func setThreadAffinity(tid int, cpu int) error {
+	return errors.New("cpu affinity is only supported on linux")
+}
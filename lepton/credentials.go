@@ -0,0 +1,220 @@
+package lepton
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Credentials are the resolved credentials of a provider, keyed by the
+// environment variable each one is read from
+type Credentials map[string]string
+
+// credentialSpec lists the variables a provider reads its credentials from
+type credentialSpec struct {
+	required []string
+	optional []string
+	// files are well known files, relative to the home directory, whose path
+	// is used for a variable that isn't set otherwise
+	files map[string]string
+	// sdkFiles are well known files, relative to the home directory, the
+	// provider SDK reads the required credentials from by itself
+	sdkFiles []string
+}
+
+var credentialSpecs = map[string]credentialSpec{
+	"aws": {
+		required: []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"},
+		optional: []string{"AWS_SESSION_TOKEN", "AWS_PROFILE"},
+		sdkFiles: []string{".aws/credentials"},
+	},
+	"gcp": {
+		required: []string{"GOOGLE_APPLICATION_CREDENTIALS"},
+		files: map[string]string{
+			"GOOGLE_APPLICATION_CREDENTIALS": ".config/gcloud/application_default_credentials.json",
+		},
+	},
+	"azure": {
+		required: []string{"AZURE_SUBSCRIPTION_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID", "AZURE_BASE_GROUP_NAME"},
+		optional: []string{"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_ACCESS_KEY", "AZURE_LOCATION_DEFAULT"},
+	},
+	"do": {
+		required: []string{"TOKEN"},
+		optional: []string{"SPACES_KEY", "SPACES_SECRET"},
+	},
+	"vultr": {
+		required: []string{"TOKEN"},
+		optional: []string{"VULTR_ACCESS", "VULTR_SECRET"},
+	},
+	"vsphere": {
+		required: []string{"GOVC_URL"},
+		optional: []string{"GOVC_USERNAME", "GOVC_PASSWORD", "GOVC_DATACENTER", "GOVC_DATASTORE", "GOVC_NETWORK", "GOVC_RESOURCE_POOL"},
+	},
+	"openstack": {
+		required: []string{"OS_AUTH_URL"},
+		optional: []string{"OS_USERNAME", "OS_USERID", "OS_PASSWORD", "OS_TENANT_ID", "OS_TENANT_NAME", "OS_PROJECT_ID", "OS_PROJECT_NAME", "OS_DOMAIN_ID", "OS_DOMAIN_NAME", "OS_REGION_NAME"},
+	},
+}
+
+// CredentialsError is returned when credentials of a provider can't be found
+type CredentialsError struct {
+	Provider string
+	Missing  []string
+	Tried    []string
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("missing %s credentials %s, tried %s",
+		e.Provider, strings.Join(e.Missing, ", "), strings.Join(e.Tried, ", "))
+}
+
+// ErrorCode returns the code of missing credentials
+func (e *CredentialsError) ErrorCode() ErrorCode {
+	return ErrCredentialsMissing
+}
+
+// CredentialsFile returns the path of the ops credentials file holding named
+// profiles
+func CredentialsFile() string {
+	return filepath.Join(GetOpsHome(), "credentials")
+}
+
+// ResolveCredentials resolves the credentials of provider from, in order, the
+// environment, the named profile in the ops credentials file and well known
+// provider files. An empty profile uses OPS_PROFILE, or "default".
+//
+// Resolved credentials are exported to the environment, which is where the
+// provider SDKs read them from.
+func ResolveCredentials(provider, profile string) (Credentials, error) {
+	spec, ok := credentialSpecs[provider]
+	if !ok {
+		return Credentials{}, nil
+	}
+
+	if profile == "" {
+		profile = os.Getenv("OPS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	home, _ := HomeDir()
+	profiles, err := readCredentialsFile(CredentialsFile())
+	if err != nil {
+		return nil, err
+	}
+
+	creds, missing := resolveCredentials(spec, profiles[profile], home)
+
+	if len(missing) > 0 {
+		tried := []string{"environment", fmt.Sprintf("profile %q in %s", profile, CredentialsFile())}
+		for _, file := range spec.files {
+			tried = append(tried, filepath.Join(home, file))
+		}
+		for _, file := range spec.sdkFiles {
+			tried = append(tried, filepath.Join(home, file))
+		}
+		return nil, &CredentialsError{Provider: provider, Missing: missing, Tried: tried}
+	}
+
+	for key, value := range creds {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+
+	return creds, nil
+}
+
+// resolveCredentials looks up the variables of spec and returns the ones found
+// and the required ones that are missing
+func resolveCredentials(spec credentialSpec, profile map[string]string, home string) (Credentials, []string) {
+	creds := Credentials{}
+	lookup := func(key string) {
+		if value := os.Getenv(key); value != "" {
+			creds[key] = value
+		} else if value := profile[key]; value != "" {
+			creds[key] = value
+		} else if file, ok := spec.files[key]; ok && home != "" {
+			if _, err := os.Stat(filepath.Join(home, file)); err == nil {
+				creds[key] = filepath.Join(home, file)
+			}
+		}
+	}
+
+	for _, key := range spec.required {
+		lookup(key)
+	}
+	for _, key := range spec.optional {
+		lookup(key)
+	}
+
+	missing := []string{}
+	for _, key := range spec.required {
+		if creds[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 && home != "" {
+		for _, file := range spec.sdkFiles {
+			if _, err := os.Stat(filepath.Join(home, file)); err == nil {
+				return creds, nil
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	return creds, missing
+}
+
+// readCredentialsFile parses the named profiles of a credentials file:
+//
+//	[default]
+//	TOKEN = secret
+//
+//	[staging]
+//	AZURE_CLIENT_ID = id
+//
+// A missing file has no profiles.
+func readCredentialsFile(path string) (map[string]map[string]string, error) {
+	profiles := map[string]map[string]string{}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var profile map[string]string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if profiles[name] == nil {
+				profiles[name] = map[string]string{}
+			}
+			profile = profiles[name]
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || profile == nil {
+			return nil, fmt.Errorf("%s:%d: expected key = value in a [profile]", path, n)
+		}
+		profile[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return profiles, scanner.Err()
+}
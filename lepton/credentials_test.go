@@ -0,0 +1,105 @@
+package lepton
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(path, []byte(`
+# comment
+[default]
+TOKEN = abc
+
+[staging]
+AZURE_CLIENT_ID=id
+`), 0600)
+
+	profiles, err := readCredentialsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"default": {"TOKEN": "abc"},
+		"staging": {"AZURE_CLIENT_ID": "id"},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("got %v, want %v", profiles, want)
+	}
+
+	ioutil.WriteFile(path, []byte("TOKEN = abc\n"), 0600)
+	if _, err := readCredentialsFile(path); err == nil {
+		t.Error("expected error for key outside a profile")
+	}
+
+	if profiles, err := readCredentialsFile(filepath.Join(dir, "missing")); err != nil || len(profiles) != 0 {
+		t.Errorf("missing file should have no profiles, got %v %v", profiles, err)
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	spec := credentialSpec{
+		required: []string{"OPS_TEST_ID", "OPS_TEST_FILE"},
+		optional: []string{"OPS_TEST_REGION"},
+		files:    map[string]string{"OPS_TEST_FILE": ".test/creds.json"},
+	}
+	home, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	t.Run("should prefer the environment over the profile", func(t *testing.T) {
+		os.Setenv("OPS_TEST_ID", "env")
+		defer os.Unsetenv("OPS_TEST_ID")
+
+		creds, missing := resolveCredentials(spec, map[string]string{"OPS_TEST_ID": "profile", "OPS_TEST_FILE": "f"}, home)
+		if len(missing) != 0 || creds["OPS_TEST_ID"] != "env" || creds["OPS_TEST_FILE"] != "f" {
+			t.Errorf("got %v missing %v", creds, missing)
+		}
+	})
+
+	t.Run("should fall back to well known files", func(t *testing.T) {
+		os.MkdirAll(filepath.Join(home, ".test"), 0755)
+		ioutil.WriteFile(filepath.Join(home, ".test/creds.json"), []byte("{}"), 0600)
+		defer os.RemoveAll(filepath.Join(home, ".test"))
+
+		creds, missing := resolveCredentials(spec, map[string]string{"OPS_TEST_ID": "profile"}, home)
+		if len(missing) != 0 || creds["OPS_TEST_FILE"] != filepath.Join(home, ".test/creds.json") {
+			t.Errorf("got %v missing %v", creds, missing)
+		}
+	})
+
+	t.Run("should report what is missing", func(t *testing.T) {
+		_, missing := resolveCredentials(spec, nil, home)
+		if !reflect.DeepEqual(missing, []string{"OPS_TEST_FILE", "OPS_TEST_ID"}) {
+			t.Errorf("got missing %v", missing)
+		}
+	})
+
+	t.Run("should explain what was tried", func(t *testing.T) {
+		os.Setenv("OPS_PROFILE", "ops-test-missing-profile")
+		defer os.Unsetenv("OPS_PROFILE")
+		for _, key := range credentialSpecs["azure"].required {
+			defer os.Setenv(key, os.Getenv(key))
+			os.Unsetenv(key)
+		}
+
+		_, err := ResolveCredentials("azure", "")
+		var credsErr *CredentialsError
+		if !errors.As(err, &credsErr) || len(credsErr.Missing) == 0 || len(credsErr.Tried) < 2 {
+			t.Errorf("got %v", err)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SplitDataVolumes moves the directories vmpaths, like /var or /data, out of
+// the image to the manifests of volumes, by directory. The directories are
+// left empty in the image to mount the volumes at.
+func (m *Manifest) SplitDataVolumes(vmpaths []string) (map[string]*Manifest, error) {
+	dirs := []string{}
+	for _, vmpath := range vmpaths {
+		parts, err := vmPathParts(vmpath)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("the root of the image can't be a data volume")
+		}
+		dirs = append(dirs, "/"+strings.Join(parts, "/"))
+	}
+	sort.Strings(dirs)
+	for i := 1; i < len(dirs); i++ {
+		if dirs[i] == dirs[i-1] || strings.HasPrefix(dirs[i], dirs[i-1]+"/") {
+			return nil, fmt.Errorf("data volumes %s and %s overlap", dirs[i-1], dirs[i])
+		}
+	}
+
+	volumes := map[string]*Manifest{}
+	for _, dir := range dirs {
+		if err := m.MkdirAll(dir); err != nil {
+			return nil, err
+		}
+
+		node := m.root
+		parts := strings.Split(dir, "/")[1:]
+		for _, part := range parts[:len(parts)-1] {
+			node = node.Children[part].(*DirNode)
+		}
+		name := parts[len(parts)-1]
+
+		volume := NewManifest(m.targetRoot)
+		volume.root = node.Children[name].(*DirNode)
+		volume.environment = map[string]string{"USER": "root", "PWD": "/"}
+		volumes[dir] = volume
+		node.Children[name] = NewDirNode()
+	}
+	return volumes, nil
+}
+
+// findDataVolume returns the data volume name, if it was created
+func findDataVolume(name string) (*NanosVolume, error) {
+	vols, err := GetVolumes(LocalVolumeDir, map[string]string{"label": name})
+	if err != nil {
+		return nil, err
+	}
+	switch len(vols) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &vols[0], nil
+	default:
+		return nil, fmt.Errorf("ambiguous volume label: %s: multiple volumes found", name)
+	}
+}
+
+// AddDataVolumeMounts attaches the data volumes of the DataVolumes of config
+// to local runs of its image
+func AddDataVolumeMounts(config *Config) error {
+	for _, dir := range config.DataVolumes {
+		parts, err := vmPathParts(dir)
+		if err != nil {
+			return err
+		}
+		name := dataVolumeName(config.RunConfig.Imagename, "/"+strings.Join(parts, "/"))
+		vol, err := findDataVolume(name)
+		if err != nil {
+			return err
+		}
+		if vol == nil {
+			return WithCode(ErrVolumeNotFound, fmt.Errorf("data volume %s of %s not found, build the image first", name, dir))
+		}
+		config.RunConfig.Mounts = append(config.RunConfig.Mounts, vol.Path)
+	}
+	return nil
+}
+
+// dataVolumeName returns the name of the data volume of the image at dir
+func dataVolumeName(imagename string, dir string) string {
+	image := strings.TrimSuffix(filepath.Base(imagename), ".img")
+	name := strings.Replace(strings.Trim(dir, "/"), "/", "-", -1)
+	return strings.Replace(image+"-"+name, VolumeDelimiter, "-", -1)
+}
+
+// buildDataVolumes moves the DataVolumes of c out of the image m to local
+// volumes mounted at them. Existing volumes are kept, so the data written to
+// them outlives new builds of the image. AddDataVolumeMounts attaches them
+// to local runs.
+func buildDataVolumes(c *Config, m *Manifest) error {
+	if len(c.DataVolumes) == 0 {
+		return nil
+	}
+
+	volumes, err := m.SplitDataVolumes(c.DataVolumes)
+	if err != nil {
+		return err
+	}
+	dirs := make([]string, 0, len(volumes))
+	for dir := range volumes {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	if err := os.MkdirAll(LocalVolumeDir, 0755); err != nil {
+		return err
+	}
+
+	volConfig := *c
+	volConfig.BuildDir = LocalVolumeDir
+	volConfig.BaseVolumeSz = ""
+	for _, dir := range dirs {
+		name := dataVolumeName(c.RunConfig.Imagename, dir)
+
+		vol, err := findDataVolume(name)
+		if err != nil {
+			return err
+		}
+		if vol != nil {
+			fmt.Printf("keeping the existing data volume %s of %s\n", name, dir)
+		} else {
+			mnfPath := path.Join(LocalVolumeDir, name+".manifest")
+			if err := ioutil.WriteFile(mnfPath, []byte(volumes[dir].String()), 0644); err != nil {
+				return err
+			}
+			if _, err := createVolumeFromManifest(&volConfig, name, mnfPath, c.TargetRoot); err != nil {
+				return err
+			}
+		}
+		m.mounts[name] = dir
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package lepton
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitDataVolumes(t *testing.T) {
+	m := NewManifest("")
+	m.AddLibrary("/var/lib/app/state.db")
+	m.AddLibrary("/var/log/app.log")
+	m.AddLibrary("/usr/bin/app")
+
+	volumes, err := m.SplitDataVolumes([]string{"/var/lib/", "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"var": map[string]interface{}{
+			"lib": map[string]interface{}{},
+			"log": map[string]interface{}{"app.log": "/var/log/app.log"},
+		},
+		"usr":  map[string]interface{}{"bin": map[string]interface{}{"app": "/usr/bin/app"}},
+		"data": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(m.root.ToMap(), want) {
+		t.Errorf("got image %v, want %v", m.root.ToMap(), want)
+	}
+
+	if len(volumes) != 2 {
+		t.Fatalf("got volumes %v", volumes)
+	}
+	lib := volumes["/var/lib"].root.ToMap()
+	if want := map[string]interface{}{"app": map[string]interface{}{"state.db": "/var/lib/app/state.db"}}; !reflect.DeepEqual(lib, want) {
+		t.Errorf("got volume %v, want %v", lib, want)
+	}
+	if data := volumes["/data"]; len(data.root.Children) != 0 || !strings.Contains(data.String(), "USER:root") {
+		t.Errorf("expected an empty data volume, got %s", data.String())
+	}
+
+	for _, dirs := range [][]string{{"/var", "/var/log"}, {"/data", "data/"}, {"/"}, {"/../var"}} {
+		if _, err := NewManifest("").SplitDataVolumes(dirs); err == nil {
+			t.Errorf("%q: expected an error", dirs)
+		}
+	}
+}
+
+func TestDataVolumeName(t *testing.T) {
+	if got := dataVolumeName("/home/u/.ops/images/web.img", "/var/lib"); got != "web-var-lib" {
+		t.Errorf("got %s", got)
+	}
+}
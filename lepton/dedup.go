@@ -0,0 +1,139 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// DedupStats summarizes the files Deduplicate replaced with symlinks
+type DedupStats struct {
+	// Files are the files replaced with a symlink to a file of the same
+	// content
+	Files int
+	// Bytes is the space the replaced files took in the image, their sizes
+	// rounded up to sectors
+	Bytes int64
+}
+
+func (s DedupStats) String() string {
+	return fmt.Sprintf("%d duplicate files replaced with symlinks, saving %s", s.Files, Bytes2Human(s.Bytes))
+}
+
+// dedupFile is a file of the root fs considered by Deduplicate
+type dedupFile struct {
+	vmpath   string
+	hostpath string
+	info     os.FileInfo
+	sha256   string
+}
+
+// Deduplicate stores the content of the files of the root fs only once in
+// the image. Files that are hard links of, or have the same content as,
+// another file are replaced with a relative symlink to the first of them in
+// lexical order. The image filesystem has no hard links, so the duplicates
+// are symlinks in the image. Only the files of equal sizes are hashed, and
+// programs are kept as files.
+func (m *Manifest) Deduplicate() (DedupStats, error) {
+	var stats DedupStats
+	programs := map[string]bool{m.program: true}
+	for _, program := range m.programs {
+		programs[program] = true
+	}
+	for _, setup := range m.setup {
+		programs[setup.program] = true
+	}
+
+	bySize := map[int64][]*dedupFile{}
+	var sizes []int64
+	err := m.root.Walk(func(vmpath string, node ManifestNode) error {
+		file, ok := node.(*FileNode)
+		if !ok || programs[vmpath] {
+			return nil
+		}
+		hostpath, err := m.lookupFile(file.HostPath)
+		if err != nil {
+			return err
+		}
+		info, err := m.files.stat(hostpath)
+		if err != nil {
+			return err
+		}
+		// empty files take no space
+		if info.Size() == 0 {
+			return nil
+		}
+		if _, ok := bySize[info.Size()]; !ok {
+			sizes = append(sizes, info.Size())
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], &dedupFile{vmpath: vmpath, hostpath: hostpath, info: info})
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	for _, size := range sizes {
+		files := bySize[size]
+		if len(files) < 2 {
+			continue
+		}
+
+		var originals []*dedupFile
+		for _, file := range files {
+			original, err := m.dedupOriginal(originals, file)
+			if err != nil {
+				return stats, err
+			}
+			if original == nil {
+				originals = append(originals, file)
+				continue
+			}
+
+			target, err := filepath.Rel(path.Dir(file.vmpath), original.vmpath)
+			if err != nil {
+				return stats, err
+			}
+			node, name, err := m.fileParent(file.vmpath)
+			if err != nil {
+				return stats, err
+			}
+			node.Children[name] = &LinkNode{Target: filepath.ToSlash(target)}
+			stats.Files++
+			stats.Bytes += (size + fsSectorSize - 1) / fsSectorSize * fsSectorSize
+		}
+	}
+	return stats, nil
+}
+
+// dedupOriginal returns the file of originals, of the size of file, that is
+// the same host file as file or has its content, nil when there is none
+func (m *Manifest) dedupOriginal(originals []*dedupFile, file *dedupFile) (*dedupFile, error) {
+	for _, original := range originals {
+		if os.SameFile(original.info, file.info) {
+			return original, nil
+		}
+	}
+	if len(originals) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	if file.sha256, err = m.hostFileSHA256(file.hostpath); err != nil {
+		return nil, err
+	}
+	for _, original := range originals {
+		if original.sha256 == "" {
+			if original.sha256, err = m.hostFileSHA256(original.hostpath); err != nil {
+				return nil, err
+			}
+		}
+		if original.sha256 == file.sha256 {
+			return original, nil
+		}
+	}
+	return nil, nil
+}
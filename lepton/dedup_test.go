@@ -0,0 +1,76 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeduplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"app/logo.png":        "logo",
+		"app/static/logo.png": "logo",
+		"app/other.png":       "ogol",
+		"app/empty":           "",
+		"lib/empty":           "",
+		"bin/server":          "server",
+		"app/server.copy":     "server",
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(dir, "app/other.png"), filepath.Join(dir, "app/static/other.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("")
+	if err := m.AddDirectoryTo("/srv", dir); err != nil {
+		t.Fatal(err)
+	}
+	// programs are kept as files
+	m.program = "/srv/bin/server"
+
+	stats, err := m.Deduplicate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (DedupStats{Files: 2, Bytes: 2 * 512}); stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	want := map[string]interface{}{
+		"app": map[string]interface{}{
+			"empty":       filepath.Join(dir, "app/empty"),
+			"logo.png":    filepath.Join(dir, "app/logo.png"),
+			"other.png":   filepath.Join(dir, "app/other.png"),
+			"server.copy": filepath.Join(dir, "app/server.copy"),
+			"static": map[string]interface{}{
+				"logo.png":  LinkNode{Target: "../logo.png"},
+				"other.png": LinkNode{Target: "../other.png"},
+			},
+		},
+		"bin": map[string]interface{}{
+			"server": filepath.Join(dir, "bin/server"),
+		},
+		"lib": map[string]interface{}{
+			"empty": filepath.Join(dir, "lib/empty"),
+		},
+	}
+	if got := m.root.Lookup("/srv").(*DirNode).ToMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
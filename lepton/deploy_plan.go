@@ -0,0 +1,190 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PlanAction is what a deploy does to a resource
+type PlanAction string
+
+const (
+	// PlanCreate creates a resource that doesn't exist
+	PlanCreate PlanAction = "create"
+	// PlanReplace deletes a resource and creates it again
+	PlanReplace PlanAction = "replace"
+	// PlanDelete deletes a resource
+	PlanDelete PlanAction = "delete"
+)
+
+var planActionSymbols = map[PlanAction]string{
+	PlanCreate:  "+",
+	PlanReplace: "-/+",
+	PlanDelete:  "-",
+}
+
+// DeploySpec is the state a deploy brings a provider to
+type DeploySpec struct {
+	// ImagePath is the local image uploaded as ImageName
+	ImagePath string
+	ImageName string
+
+	// Regions the image is replicated to, besides the zone of the provider
+	Regions []string
+
+	// Instances are the names of the instances running the image
+	Instances []string
+
+	// Prune deletes the instances of the image that aren't in Instances
+	Prune bool
+
+	// DomainName is pointed at the first instance, when set
+	DomainName string
+}
+
+// PlanChange is a change of a deploy to a resource of a provider
+type PlanChange struct {
+	Action PlanAction
+	// Resource is the kind of resource, image, instance or dns
+	Resource string
+	Name     string
+	Detail   string `json:",omitempty"`
+}
+
+// DeployPlan is what a deploy changes on a provider, computed from its read
+// operations only so it can be printed or asserted on before the deploy
+type DeployPlan struct {
+	Provider  string
+	Zone      string
+	Image     string
+	ImageSize int64
+	Regions   []string
+	Changes   []PlanChange
+}
+
+// PlanDeploy returns the plan of deploying spec to p. It lists the images
+// and instances of the provider and doesn't change anything.
+func PlanDeploy(ctx *Context, p Provider, spec DeploySpec) (*DeployPlan, error) {
+	if spec.ImageName == "" {
+		return nil, fmt.Errorf("a deploy plan needs an image name")
+	}
+	fi, err := os.Stat(spec.ImagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := ctx.Config()
+	plan := &DeployPlan{
+		Provider:  config.CloudConfig.Platform,
+		Zone:      config.CloudConfig.Zone,
+		Image:     spec.ImageName,
+		ImageSize: fi.Size(),
+	}
+	if plan.Zone != "" {
+		plan.Regions = append(plan.Regions, plan.Zone)
+	}
+	plan.Regions = append(plan.Regions, spec.Regions...)
+
+	images, err := p.GetImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	imageAction := PlanCreate
+	for _, image := range images {
+		if image.Name == spec.ImageName {
+			imageAction = PlanReplace
+			break
+		}
+	}
+	plan.add(imageAction, "image", spec.ImageName, fmt.Sprintf("%s from %s", Bytes2Human(plan.ImageSize), spec.ImagePath))
+
+	instances, err := p.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]CloudInstance{}
+	for _, instance := range instances {
+		existing[instance.Name] = instance
+	}
+	wanted := map[string]bool{}
+	for _, name := range spec.Instances {
+		wanted[name] = true
+		instance, ok := existing[name]
+		switch {
+		case !ok:
+			plan.add(PlanCreate, "instance", name, "")
+		case instance.Image != spec.ImageName:
+			plan.add(PlanReplace, "instance", name, fmt.Sprintf("image %s -> %s", instance.Image, spec.ImageName))
+		case imageAction == PlanReplace:
+			plan.add(PlanReplace, "instance", name, "image "+spec.ImageName+" is replaced")
+		}
+	}
+	if spec.Prune {
+		for _, instance := range instances {
+			if instance.Image == spec.ImageName && !wanted[instance.Name] {
+				plan.add(PlanDelete, "instance", instance.Name, "")
+			}
+		}
+	}
+
+	if spec.DomainName != "" && len(spec.Instances) > 0 {
+		plan.add(PlanCreate, "dns", spec.DomainName, "points at "+spec.Instances[0])
+	}
+	return plan, nil
+}
+
+func (p *DeployPlan) add(action PlanAction, resource, name, detail string) {
+	p.Changes = append(p.Changes, PlanChange{Action: action, Resource: resource, Name: name, Detail: detail})
+}
+
+// Count returns the number of changes of an action
+func (p *DeployPlan) Count(action PlanAction) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Change returns the change of a resource, nil when the deploy doesn't
+// change it
+func (p *DeployPlan) Change(resource, name string) *PlanChange {
+	for i := range p.Changes {
+		if p.Changes[i].Resource == resource && p.Changes[i].Name == name {
+			return &p.Changes[i]
+		}
+	}
+	return nil
+}
+
+// JSON returns the plan as indented json
+func (p *DeployPlan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// String returns the plan for people, a line per change and a summary
+func (p *DeployPlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Deploy of %s to %s", p.Image, p.Provider)
+	if len(p.Regions) > 0 {
+		regions := append([]string(nil), p.Regions...)
+		sort.Strings(regions)
+		fmt.Fprintf(&sb, " (%s)", strings.Join(regions, ", "))
+	}
+	sb.WriteString(":\n")
+	for _, c := range p.Changes {
+		fmt.Fprintf(&sb, "  %-3s %s %s", planActionSymbols[c.Action], c.Resource, c.Name)
+		if c.Detail != "" {
+			fmt.Fprintf(&sb, " (%s)", c.Detail)
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "Plan: %d to create, %d to replace, %d to delete.\n",
+		p.Count(PlanCreate), p.Count(PlanReplace), p.Count(PlanDelete))
+	return sb.String()
+}
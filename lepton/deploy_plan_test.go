@@ -0,0 +1,95 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readOnlyProvider only implements the read operations of Provider, a plan
+// calling anything else panics
+type readOnlyProvider struct {
+	Provider
+	images    []CloudImage
+	instances []CloudInstance
+}
+
+func (p *readOnlyProvider) GetImages(ctx *Context) ([]CloudImage, error) {
+	return p.images, nil
+}
+
+func (p *readOnlyProvider) GetInstances(ctx *Context) ([]CloudInstance, error) {
+	return p.instances, nil
+}
+
+func TestPlanDeploy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ops-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	imagePath := filepath.Join(dir, "web.img")
+	if err := ioutil.WriteFile(imagePath, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &readOnlyProvider{
+		images: []CloudImage{{Name: "web"}},
+		instances: []CloudInstance{
+			{Name: "web-1", Image: "web"},
+			{Name: "web-2", Image: "web-old"},
+			{Name: "web-3", Image: "web"},
+			{Name: "db-1", Image: "db"},
+		},
+	}
+	c := NewConfig()
+	c.CloudConfig.Platform = "gcp"
+	c.CloudConfig.Zone = "us-west1-b"
+	spec := DeploySpec{
+		ImagePath:  imagePath,
+		ImageName:  "web",
+		Instances:  []string{"web-1", "web-2", "web-4"},
+		Prune:      true,
+		DomainName: "web.example.com",
+	}
+	plan, err := PlanDeploy(NewContext(c), p, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]PlanAction{
+		"image/web":           PlanReplace,
+		"instance/web-1":      PlanReplace,
+		"instance/web-2":      PlanReplace,
+		"instance/web-4":      PlanCreate,
+		"instance/web-3":      PlanDelete,
+		"dns/web.example.com": PlanCreate,
+	}
+	if len(plan.Changes) != len(want) {
+		t.Errorf("got %d changes, want %d: %+v", len(plan.Changes), len(want), plan.Changes)
+	}
+	for key, action := range want {
+		parts := strings.SplitN(key, "/", 2)
+		if c := plan.Change(parts[0], parts[1]); c == nil || c.Action != action {
+			t.Errorf("%s: got %+v, want %s", key, c, action)
+		}
+	}
+	if plan.ImageSize != 2048 || plan.Change("instance", "db-1") != nil {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	if s := plan.String(); !strings.Contains(s, "Plan: 2 to create, 3 to replace, 1 to delete.") {
+		t.Errorf("unexpected plan:\n%s", s)
+	}
+
+	p.images = nil
+	spec.Prune = false
+	plan, _ = PlanDeploy(NewContext(c), p, spec)
+	if c := plan.Change("instance", "web-1"); c != nil {
+		t.Errorf("web-1 already runs the image, got %+v", c)
+	}
+	if plan.Change("image", "web").Action != PlanCreate {
+		t.Error("a missing image is created")
+	}
+}
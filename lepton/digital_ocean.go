@@ -2,18 +2,21 @@ package lepton
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 
 	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
 )
 
 // DigitalOcean provides access to the DigitalOcean API.
 type DigitalOcean struct {
 	Storage *Spaces
 	Client  *godo.Client
+	retry   RetryPolicy
 }
 
 // BuildImage to be upload on DO
@@ -54,7 +57,7 @@ func (do *DigitalOcean) createImage(key string, bucket string, region string) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	client := &http.Client{Transport: do.retry.Transport(nil)}
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -69,8 +72,14 @@ func (do *DigitalOcean) createImage(key string, bucket string, region string) {
 
 // Initialize DigialOcean related things
 func (do *DigitalOcean) Initialize(config *ProviderConfig) error {
-	doToken := os.Getenv("TOKEN")
-	do.Client = godo.NewFromToken(doToken)
+	creds, err := ResolveCredentials("do", config.Profile)
+	if err != nil {
+		return err
+	}
+	do.retry = RetryPolicyFromConfig(config)
+	client := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: creds["TOKEN"]}))
+	client.Transport = do.retry.Transport(client.Transport)
+	do.Client = godo.NewClient(client)
 	return nil
 }
 
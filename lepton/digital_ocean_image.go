@@ -38,19 +38,28 @@ func (do *DigitalOcean) CreateImage(ctx *Context, imagePath string) error {
 
 // GetImages return all images on DigitalOcean
 func (do *DigitalOcean) GetImages(ctx *Context) ([]CloudImage, error) {
-	opt := &godo.ListOptions{}
-	list, _, err := do.Client.Images.List(context.TODO(), opt)
-	if err != nil {
-		return nil, err
-	}
-	images := make([]CloudImage, len(list))
-	for i, doImage := range list {
-		images[i].ID = fmt.Sprintf("%d", doImage.ID)
-		images[i].Name = doImage.Name
-		images[i].Status = doImage.Status
-		images[i].Created, _ = time.Parse("2006-01-02T15:04:05Z", doImage.Created)
-	}
-	return images, nil
+	return do.ImageIterator(ctx).All()
+}
+
+// ImageIterator lists images a page at a time
+func (do *DigitalOcean) ImageIterator(ctx *Context) *ImageIterator {
+	opt := &godo.ListOptions{Page: 1, PerPage: 200}
+	return NewImageIterator(func() ([]CloudImage, bool, error) {
+		list, resp, err := do.Client.Images.List(context.TODO(), opt)
+		if err != nil {
+			return nil, false, err
+		}
+		images := make([]CloudImage, len(list))
+		for i, doImage := range list {
+			images[i].ID = fmt.Sprintf("%d", doImage.ID)
+			images[i].Name = doImage.Name
+			images[i].Status = doImage.Status
+			images[i].Created, _ = time.Parse("2006-01-02T15:04:05Z", doImage.Created)
+		}
+
+		opt.Page++
+		return images, resp.Links != nil && !resp.Links.IsLastPage(), nil
+	})
 }
 
 // ListImages lists images on Digital Ocean.
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/olekukonko/tablewriter"
@@ -24,27 +25,37 @@ func (do *DigitalOcean) GetInstanceByID(ctx *Context, id string) (*CloudInstance
 // GetInstances return all instances on DigitalOcean
 // TODO
 func (do *DigitalOcean) GetInstances(ctx *Context) ([]CloudInstance, error) {
-	opt := &godo.ListOptions{}
-	list, _, err := do.Client.Droplets.List(context.TODO(), opt)
-	if err != nil {
-		return nil, err
-	}
-	cinstances := make([]CloudInstance, len(list))
-	for i, droplet := range list {
-		privateIPV4, _ := droplet.PrivateIPv4()
-		publicIPV4, _ := droplet.PublicIPv4()
-		publicIPV6, _ := droplet.PublicIPv6()
-		cinstances[i] = CloudInstance{
-			ID:         fmt.Sprintf("%d", droplet.ID),
-			Name:       droplet.Name,
-			Status:     droplet.Status,
-			Created:    droplet.Created,
-			PrivateIps: []string{privateIPV4},
-			PublicIps:  []string{publicIPV4, publicIPV6},
+	return do.InstanceIterator(ctx).All()
+}
+
+// InstanceIterator lists droplets a page at a time
+func (do *DigitalOcean) InstanceIterator(ctx *Context) *InstanceIterator {
+	opt := &godo.ListOptions{Page: 1, PerPage: 200}
+	return NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+		list, resp, err := do.Client.Droplets.List(context.TODO(), opt)
+		if err != nil {
+			return nil, false, err
+		}
+		cinstances := make([]CloudInstance, len(list))
+		for i, droplet := range list {
+			privateIPV4, _ := droplet.PrivateIPv4()
+			publicIPV4, _ := droplet.PublicIPv4()
+			publicIPV6, _ := droplet.PublicIPv6()
+			createdAt, _ := time.Parse(time.RFC3339, droplet.Created)
+			cinstances[i] = CloudInstance{
+				ID:         fmt.Sprintf("%d", droplet.ID),
+				Name:       droplet.Name,
+				Status:     droplet.Status,
+				Created:    droplet.Created,
+				CreatedAt:  createdAt,
+				PrivateIps: []string{privateIPV4},
+				PublicIps:  []string{publicIPV4, publicIPV6},
+			}
 		}
-	}
 
-	return cinstances, nil
+		opt.Page++
+		return cinstances, resp.Links != nil && !resp.Links.IsLastPage(), nil
+	})
 }
 
 // ListInstances lists instances on DO
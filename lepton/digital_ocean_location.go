@@ -0,0 +1,62 @@
+package lepton
+
+import (
+	"context"
+
+	"github.com/digitalocean/godo"
+)
+
+// GetRegions returns the available regions
+func (do *DigitalOcean) GetRegions(ctx *Context) ([]string, error) {
+	list, _, err := do.Client.Regions.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := []string{}
+	for _, region := range list {
+		if region.Available {
+			regions = append(regions, region.Slug)
+		}
+	}
+	return regions, nil
+}
+
+// GetZones returns the available regions, DigitalOcean has no zones
+func (do *DigitalOcean) GetZones(ctx *Context) ([]Zone, error) {
+	regions, err := do.GetRegions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []Zone{}
+	for _, region := range regions {
+		zones = append(zones, Zone{Name: region, Region: region})
+	}
+	return zones, nil
+}
+
+// GetInstanceTypes returns the droplet sizes available in zone
+func (do *DigitalOcean) GetInstanceTypes(ctx *Context, zone string) ([]InstanceType, error) {
+	sizes, _, err := do.Client.Sizes.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return nil, err
+	}
+
+	types := []InstanceType{}
+	for _, size := range sizes {
+		inZone := false
+		for _, region := range size.Regions {
+			inZone = inZone || region == zone
+		}
+		if !size.Available || !inZone {
+			continue
+		}
+		types = append(types, InstanceType{
+			Name:     size.Slug,
+			VCPUs:    size.Vcpus,
+			MemoryMB: size.Memory,
+		})
+	}
+	return types, nil
+}
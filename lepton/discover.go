@@ -0,0 +1,272 @@
+package lepton
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoveredSyscalls are the syscalls whose path arguments are files the
+// program needs
+var discoveredSyscalls = map[string]bool{
+	"open":       true,
+	"openat":     true,
+	"openat2":    true,
+	"creat":      true,
+	"execve":     true,
+	"execveat":   true,
+	"stat":       true,
+	"lstat":      true,
+	"newfstatat": true,
+	"statx":      true,
+	"access":     true,
+	"faccessat":  true,
+	"faccessat2": true,
+	"readlink":   true,
+	"readlinkat": true,
+}
+
+// networkFamilies are the socket families of network connections
+var networkFamilies = []string{"AF_INET", "AF_INET6"}
+
+// discoverIgnoredDirs are host pseudo filesystems nanos provides itself
+var discoverIgnoredDirs = []string{"/proc/", "/sys/", "/dev/"}
+
+var straceCallRegex = regexp.MustCompile(`^(\w+)\((.*)\)\s+=\s+(-?\d+)`)
+
+// Discovery records the files a program opens while it runs on the host,
+// with strace, to find the files its image needs
+type Discovery struct {
+	// Program and Args are run when PID is not set
+	Program string
+	Args    []string
+	// PID is a running process to attach to instead
+	PID int
+	// Duration stops the recording after it, when set, instead of when the
+	// program exits or on interrupt
+	Duration time.Duration
+	// Dir is the working directory of the program, relative paths are
+	// relative to it
+	Dir string
+}
+
+// DiscoveryResult is what a Discovery observed of a program
+type DiscoveryResult struct {
+	// Files are the host files the program opened
+	Files []string
+	// Network is set when the program opened network sockets
+	Network bool
+}
+
+// Policy returns the least privilege policy allowing what was observed
+func (r *DiscoveryResult) Policy() Policy {
+	return Policy{AllowedPaths: r.Files, Network: r.Network}
+}
+
+// Run runs or attaches to the program and returns what it accessed
+func (d *Discovery) Run() (*DiscoveryResult, error) {
+	if _, err := exec.LookPath("strace"); err != nil {
+		return nil, fmt.Errorf("strace is needed to discover the files of a program: %v", err)
+	}
+
+	out, err := ioutil.TempFile("", "ops-discover")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	calls := []string{"socket"}
+	for call := range discoveredSyscalls {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+
+	args := []string{"-f", "-qq", "-s", "4096", "-e", "trace=" + strings.Join(calls, ","), "-o", out.Name()}
+	if d.PID != 0 {
+		args = append(args, "-p", strconv.Itoa(d.PID))
+	} else {
+		args = append(append(args, "--", d.Program), d.Args...)
+	}
+
+	cmd := exec.Command("strace", args...)
+	cmd.Dir = d.Dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	if d.Duration > 0 {
+		timer := time.AfterFunc(d.Duration, func() {
+			// strace detaches on interrupt, and kills the program it runs
+			cmd.Process.Signal(os.Interrupt)
+		})
+		defer timer.Stop()
+	}
+	// the exit status of the program is not the outcome of the discovery
+	cmd.Wait()
+
+	f, err := os.Open(out.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := d.Dir
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	paths, network := parseStrace(f, dir)
+	return &DiscoveryResult{Files: discoveredFiles(paths), Network: network}, nil
+}
+
+// parseStrace returns the paths of the successful file syscalls of the
+// strace -f output r, relative paths are made absolute from dir, and whether
+// network sockets were opened
+func parseStrace(r io.Reader, dir string) (paths []string, network bool) {
+	pending := map[string]string{}
+	paths = []string{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		pid := ""
+		if i := strings.IndexAny(line, " \t"); i > 0 {
+			if _, err := strconv.Atoi(line[:i]); err == nil {
+				pid, line = line[:i], strings.TrimSpace(line[i:])
+			}
+		}
+
+		// calls interrupted by other threads are split over two lines
+		if i := strings.Index(line, " <unfinished ...>"); i >= 0 {
+			pending[pid] = line[:i]
+			continue
+		}
+		if strings.HasPrefix(line, "<... ") {
+			i := strings.Index(line, " resumed>")
+			start, ok := pending[pid]
+			if i < 0 || !ok {
+				continue
+			}
+			delete(pending, pid)
+			line = start + line[i+len(" resumed>"):]
+		}
+
+		match := straceCallRegex.FindStringSubmatch(line)
+		if match == nil || strings.HasPrefix(match[3], "-") {
+			continue
+		}
+
+		args := match[2]
+		if match[1] == "socket" {
+			for _, family := range networkFamilies {
+				if strings.HasPrefix(args, family+",") {
+					network = true
+				}
+			}
+			continue
+		}
+		if !discoveredSyscalls[match[1]] {
+			continue
+		}
+
+		start := strings.IndexByte(args, '"')
+		if start < 0 {
+			continue
+		}
+		end := quotedStringEnd(args, start)
+		if end < 0 {
+			continue
+		}
+		path, err := strconv.Unquote(args[start : end+1])
+		if err != nil || path == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(path) {
+			// paths of the *at calls relative to another directory than the
+			// working one can't be resolved from the trace
+			if start > 0 && !strings.HasPrefix(args, "AT_FDCWD") {
+				continue
+			}
+			path = filepath.Join(dir, path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths, network
+}
+
+// quotedStringEnd returns the index of the quote closing the string starting
+// at start in s, or -1
+func quotedStringEnd(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// discoveredFiles returns the sorted regular files of paths, without
+// duplicates and without the host pseudo filesystems
+func discoveredFiles(paths []string) []string {
+	seen := map[string]bool{}
+	files := []string{}
+
+outer:
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		for _, dir := range discoverIgnoredDirs {
+			if strings.HasPrefix(path, dir) {
+				continue outer
+			}
+		}
+		if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// DiscoverManifest returns a manifest running program with exactly files,
+// as found by a Discovery
+func DiscoverManifest(program string, files []string) (*Manifest, error) {
+	m := NewManifest("")
+	if err := m.SetProgram(program); err != nil {
+		return nil, err
+	}
+
+	abs, _ := filepath.Abs(program)
+	for _, file := range files {
+		if file == abs {
+			continue
+		}
+		if err := m.AddFile(file, file); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
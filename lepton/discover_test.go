@@ -0,0 +1,65 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStrace(t *testing.T) {
+	trace := `1200  execve("/usr/bin/app", ["app"], 0x7ffd /* 20 vars */) = 0
+1200  access("/etc/ld.so.preload", R_OK) = -1 ENOENT (No such file or directory)
+1200  openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+1200  openat(AT_FDCWD, "/lib/x86_64-linux-gnu/libc.so.6", O_RDONLY|O_CLOEXEC) = 3
+1201  openat(AT_FDCWD, "config/app.yaml", O_RDONLY <unfinished ...>
+1200  openat(3, "relative.txt", O_RDONLY) = 4
+1200  socket(AF_UNIX, SOCK_STREAM|SOCK_CLOEXEC, 0) = 6
+1202  stat("data/file with \"quotes\"", {st_mode=S_IFREG|0644, st_size=3, ...}) = 0
+1201  <... openat resumed>) = 5
+1201  --- SIGCHLD {si_signo=SIGCHLD} ---
+1200  +++ exited with 0 +++
+`
+	want := []string{
+		"/usr/bin/app",
+		"/etc/ld.so.cache",
+		"/lib/x86_64-linux-gnu/libc.so.6",
+		`/srv/data/file with "quotes"`,
+		"/srv/config/app.yaml",
+	}
+	got, network := parseStrace(strings.NewReader(trace), "/srv")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if network {
+		t.Error("unix sockets are not network")
+	}
+
+	trace += "1203  socket(AF_INET6, SOCK_DGRAM, IPPROTO_IP) = 7\n"
+	if _, network := parseStrace(strings.NewReader(trace), "/srv"); !network {
+		t.Error("expected network")
+	}
+}
+
+func TestDiscoveredFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := filepath.Join(dir, "b")
+	a := filepath.Join(dir, "a")
+	for _, file := range []string{a, b} {
+		if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := discoveredFiles([]string{b, "/proc/self/maps", dir, a, filepath.Join(dir, "missing"), b})
+	if want := []string{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package lepton
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	goerrors "github.com/go-errors/errors"
+)
+
+// ErrorCode identifies a class of failure. Codes are stable, messages may
+// change but a code keeps its meaning so docs and tooling can link to it.
+type ErrorCode string
+
+// Error codes, never renumber or reuse them
+const (
+	ErrMkfsMissingHostFile    ErrorCode = "OPS-MKFS-001"
+	ErrMkfsFailed             ErrorCode = "OPS-MKFS-002"
+	ErrMkfsHookFailed         ErrorCode = "OPS-MKFS-003"
+	ErrMkfsWarnings           ErrorCode = "OPS-MKFS-004"
+	ErrMkfsSymlinkLoop        ErrorCode = "OPS-MKFS-005"
+	ErrMkfsSymlinkEscape      ErrorCode = "OPS-MKFS-006"
+	ErrMkfsKlibMismatch       ErrorCode = "OPS-MKFS-007"
+	ErrMkfsUnsupportedProgram ErrorCode = "OPS-MKFS-008"
+	ErrMkfsPathConflict       ErrorCode = "OPS-MKFS-009"
+	ErrMkfsExecSizeExceeded   ErrorCode = "OPS-MKFS-010"
+	ErrMkfsInvalidPath        ErrorCode = "OPS-MKFS-011"
+	ErrMkfsBootFSSizeExceeded ErrorCode = "OPS-MKFS-012"
+	ErrMkfsUnsupportedTuple   ErrorCode = "OPS-MKFS-013"
+
+	ErrImageInvalidName   ErrorCode = "OPS-IMG-001"
+	ErrImageInvalidLabels ErrorCode = "OPS-IMG-002"
+	ErrImageUploadFailed  ErrorCode = "OPS-IMG-003"
+	ErrImageSizeExceeded  ErrorCode = "OPS-IMG-004"
+	ErrImageMismatch      ErrorCode = "OPS-IMG-005"
+
+	ErrInstanceBulkFailed ErrorCode = "OPS-INST-001"
+
+	ErrVolumeNotFound ErrorCode = "OPS-VOL-001"
+
+	ErrCredentialsMissing ErrorCode = "OPS-CRED-001"
+)
+
+// ErrorInfo describes an error code
+type ErrorInfo struct {
+	Code        ErrorCode
+	Summary     string
+	Remediation string
+}
+
+var errorCatalog = map[ErrorCode]ErrorInfo{
+	ErrMkfsMissingHostFile: {
+		Summary:     "a file the image needs doesn't exist on the host",
+		Remediation: "check the Files, Dirs and MapDirs of the config, and TargetRoot when it is set",
+	},
+	ErrMkfsFailed: {
+		Summary:     "mkfs failed to write the image",
+		Remediation: "check the mkfs output above, the disk space of the build directory and that the nanos release is installed with ops update",
+	},
+	ErrMkfsHookFailed: {
+		Summary:     "a build hook aborted the build",
+		Remediation: "the hook error says why, fix its cause or remove the hook",
+	},
+	ErrMkfsWarnings: {
+		Summary:     "the build has warnings of a category listed in FailOnWarnings",
+		Remediation: "fix the files the warnings name, or remove their category from FailOnWarnings",
+	},
+	ErrMkfsSymlinkLoop: {
+		Summary:     "symlinks of the target root link to each other in a loop",
+		Remediation: "fix the symlinks the error names in the target root",
+	},
+	ErrMkfsSymlinkEscape: {
+		Summary:     "a symlink of the target root points to a file outside of it",
+		Remediation: "add the target to the target root, or unset TargetRootStrict to take it from the host",
+	},
+	ErrMkfsKlibMismatch: {
+		Summary:     "a klib isn't built for the kernel of the image",
+		Remediation: "use the kernel and klibs of the same release, run ops update to fetch them again",
+	},
+	ErrMkfsUnsupportedProgram: {
+		Summary:     "the program isn't a Linux ELF executable for the architecture of the image",
+		Remediation: "build the program for linux/amd64, for go with GOOS=linux GOARCH=amd64",
+	},
+	ErrMkfsPathConflict: {
+		Summary:     "a file of the image is at the path of a directory, or in place of one of its parents",
+		Remediation: "check the Files, Dirs and MapDirs of the config for a file and a directory at the same image path",
+	},
+	ErrMkfsExecSizeExceeded: {
+		Summary:     "the arguments and environment of a program are larger than a program can be started with",
+		Remediation: "move large values to files of the image and pass their paths instead",
+	},
+	ErrMkfsInvalidPath: {
+		Summary:     "an image path has characters the image filesystem can't store",
+		Remediation: "rename the file, image paths must be valid UTF-8 without control characters",
+	},
+	ErrMkfsBootFSSizeExceeded: {
+		Summary:     "the kernel and klibs don't fit in the boot filesystem",
+		Remediation: "remove klibs from the config, or set BootFSSize to the boot filesystem size of a custom kernel",
+	},
+	ErrMkfsUnsupportedTuple: {
+		Summary:     "the manifest has tuples the kernel of the image doesn't read",
+		Remediation: "remove Programs, Setup, Policy, file owners, Tmpfs, GrowRootFS, Exit.OnCrash or Exit.PropagateStatus from the image, or list the tuples in KernelTuples for a kernel that reads them",
+	},
+	ErrImageInvalidName: {
+		Summary:     "the provider rejects the image name or family",
+		Remediation: "use lowercase letters, digits and hyphens, starting with a letter",
+	},
+	ErrImageInvalidLabels: {
+		Summary:     "the provider rejects the image labels",
+		Remediation: "use lowercase letters, digits, - and _ in label keys and values",
+	},
+	ErrImageUploadFailed: {
+		Summary:     "uploading the image failed part way",
+		Remediation: "transient failures are retried, raise CloudConfig.Retries or retry the command",
+	},
+	ErrImageSizeExceeded: {
+		Summary:     "the image content doesn't fit the requested size",
+		Remediation: "request a size larger than the image, or remove files from it",
+	},
+	ErrImageMismatch: {
+		Summary:     "the registered image doesn't have the content of the local image",
+		Remediation: "compare with the image file the registered image was created from, or create the image again",
+	},
+	ErrInstanceBulkFailed: {
+		Summary:     "an operation failed on some of the selected instances",
+		Remediation: "the error lists each failed instance, retry with a narrower filter",
+	},
+	ErrVolumeNotFound: {
+		Summary:     "no volume has the given UUID or label",
+		Remediation: "list volumes with ops volume list",
+	},
+	ErrCredentialsMissing: {
+		Summary:     "provider credentials can't be found",
+		Remediation: "set the missing variables in the environment or in a profile of the ops credentials file",
+	},
+}
+
+// CodedError attaches an error code to an error
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of the error
+func (e *CodedError) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+// WithCode attaches code to err, a nil err stays nil
+func WithCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// ErrorCodeOf returns the code attached to err or to an error it wraps
+func ErrorCodeOf(err error) (ErrorCode, bool) {
+	for err != nil {
+		var coded interface{ ErrorCode() ErrorCode }
+		if errors.As(err, &coded) {
+			return coded.ErrorCode(), true
+		}
+
+		// errors wrapped for a stack trace don't unwrap
+		var stack *goerrors.Error
+		if !errors.As(err, &stack) || stack.Err == err {
+			break
+		}
+		err = stack.Err
+	}
+	return "", false
+}
+
+// LookupErrorCode returns the description of code
+func LookupErrorCode(code ErrorCode) (ErrorInfo, bool) {
+	info, ok := errorCatalog[code]
+	info.Code = code
+	return info, ok
+}
+
+// ErrorCodes returns the descriptions of every error code, sorted by code
+func ErrorCodes() []ErrorInfo {
+	infos := []ErrorInfo{}
+	for code := range errorCatalog {
+		info, _ := LookupErrorCode(code)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Code < infos[j].Code })
+	return infos
+}
+
+// DescribeError returns the message of err followed by its code and
+// remediation when it has one
+func DescribeError(err error) string {
+	code, ok := ErrorCodeOf(err)
+	if !ok {
+		return err.Error()
+	}
+	info, _ := LookupErrorCode(code)
+	return fmt.Sprintf("%v\n%s: %s", err, code, info.Remediation)
+}
@@ -0,0 +1,59 @@
+package lepton
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	goerrors "github.com/go-errors/errors"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	coded := WithCode(ErrMkfsMissingHostFile, fmt.Errorf("missing file"))
+
+	t.Run("should find the code through wrapped errors", func(t *testing.T) {
+		wrapped := []error{
+			coded,
+			fmt.Errorf("build failed: %w", coded),
+			goerrors.Wrap(coded, 1),
+			fmt.Errorf("build failed: %w", goerrors.Wrap(coded, 1)),
+		}
+		for _, err := range wrapped {
+			code, ok := ErrorCodeOf(err)
+			if !ok || code != ErrMkfsMissingHostFile {
+				t.Errorf("got %q, %v for %v", code, ok, err)
+			}
+		}
+	})
+
+	t.Run("should find the code of typed errors", func(t *testing.T) {
+		err := fmt.Errorf("upload: %w", &UploadError{Err: fmt.Errorf("timeout")})
+		if code, _ := ErrorCodeOf(err); code != ErrImageUploadFailed {
+			t.Errorf("got %q", code)
+		}
+	})
+
+	t.Run("should report errors without code", func(t *testing.T) {
+		if _, ok := ErrorCodeOf(goerrors.Wrap(fmt.Errorf("plain"), 1)); ok {
+			t.Error("expected no code")
+		}
+		if WithCode(ErrMkfsFailed, nil) != nil {
+			t.Error("expected nil error to stay nil")
+		}
+	})
+
+	t.Run("should describe the remediation", func(t *testing.T) {
+		description := DescribeError(coded)
+		if !strings.HasPrefix(description, "missing file\n") || !strings.Contains(description, string(ErrMkfsMissingHostFile)) {
+			t.Errorf("got %q", description)
+		}
+	})
+}
+
+func TestErrorCatalog(t *testing.T) {
+	for _, info := range ErrorCodes() {
+		if info.Summary == "" || info.Remediation == "" {
+			t.Errorf("%s is missing its summary or remediation", info.Code)
+		}
+	}
+}
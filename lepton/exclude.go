@@ -0,0 +1,168 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// excludePattern is a pattern of the files left out of the directories
+// added to an image
+type excludePattern struct {
+	parts    []string // glob of each path component, ** matches any number
+	anchored bool     // matched against the whole path instead of the name
+	dirOnly  bool     // only matches directories
+	negate   bool     // includes what the previous patterns exclude
+	re       *regexp.Regexp
+}
+
+// opsIgnoreFile is the file of added directories listing the files left out
+// of the image, in the syntax of .gitignore
+const opsIgnoreFile = ".opsignore"
+
+// parseExcludePatterns parses exclude patterns. Globs without a slash, like
+// *.log, match the names of files and directories anywhere in the walked
+// directory; globs with one, like node_modules/** or docs/*.md, match paths
+// relative to it where ** matches any number of directories. A trailing
+// slash only matches directories. Patterns prefixed with re: are regular
+// expressions matched against relative paths.
+func parseExcludePatterns(patterns []string) ([]excludePattern, error) {
+	excludes := make([]excludePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if expr := strings.TrimPrefix(pattern, "re:"); expr != pattern {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %s: %v", pattern, err)
+			}
+			excludes = append(excludes, excludePattern{re: re})
+			continue
+		}
+
+		p, err := parseExcludeGlob(filepath.ToSlash(pattern))
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, p)
+	}
+	return excludes, nil
+}
+
+// parseExcludeGlob parses a slash separated glob pattern
+func parseExcludeGlob(glob string) (excludePattern, error) {
+	p := excludePattern{dirOnly: strings.HasSuffix(glob, "/")}
+	trimmed := strings.Trim(glob, "/")
+	if trimmed == "" {
+		return p, fmt.Errorf("invalid exclude pattern %q", glob)
+	}
+	p.parts = strings.Split(trimmed, "/")
+	p.anchored = len(p.parts) > 1 || strings.HasPrefix(glob, "/")
+	for _, part := range p.parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return p, fmt.Errorf("invalid exclude pattern %s: %v", glob, err)
+		}
+	}
+	return p, nil
+}
+
+// readOpsIgnore returns the patterns of the .opsignore file of dir, none
+// when it has no such file
+func readOpsIgnore(dir string) ([]excludePattern, error) {
+	file := filepath.Join(dir, opsIgnoreFile)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ignores, err := parseOpsIgnore(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", file, err)
+	}
+	return ignores, nil
+}
+
+// parseOpsIgnore parses the lines of an .opsignore file like those of a
+// .gitignore: blank lines and lines starting with # are skipped, a leading !
+// includes the files previous lines exclude, and \# and \! start patterns
+// with those characters. Globs are those of parseExcludePatterns, without
+// regular expressions.
+func parseOpsIgnore(data string) ([]excludePattern, error) {
+	var ignores []excludePattern
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		// trailing spaces are dropped unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+
+		p, err := parseExcludeGlob(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", i+1, err)
+		}
+		p.negate = negate
+		ignores = append(ignores, p)
+	}
+	return ignores, nil
+}
+
+// excluded tells whether the file or directory at rel, a slash separated
+// path relative to the walked directory, is excluded by excludes. The last
+// pattern matching it decides, so negated patterns include files again.
+func excluded(excludes []excludePattern, rel string, isDir bool) bool {
+	exclude := false
+	for _, p := range excludes {
+		if p.matches(rel, isDir) {
+			exclude = !p.negate
+		}
+	}
+	return exclude
+}
+
+func (p excludePattern) matches(rel string, isDir bool) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		matched, _ := path.Match(p.parts[0], path.Base(rel))
+		return matched
+	}
+	return matchGlobParts(p.parts, strings.Split(rel, "/"))
+}
+
+// matchGlobParts matches the components of a path against the globs of
+// parts, where ** matches any number of components
+func matchGlobParts(parts []string, names []string) bool {
+	if len(parts) == 0 {
+		return len(names) == 0
+	}
+	if parts[0] == "**" {
+		if matchGlobParts(parts[1:], names) {
+			return true
+		}
+		return len(names) > 0 && matchGlobParts(parts, names[1:])
+	}
+	if len(names) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(parts[0], names[0]); !matched {
+		return false
+	}
+	return matchGlobParts(parts[1:], names[1:])
+}
@@ -0,0 +1,169 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "app.log", false, true},
+		{"*.log", "logs/app.log", false, true},
+		{"*.log", "app.log.1", false, false},
+		{".git", ".git", true, true},
+		{".git", "vendor/x/.git", true, true},
+		{"node_modules/**", "node_modules/a/index.js", false, true},
+		{"node_modules/**", "node_modules", true, true},
+		{"node_modules/**", "web/node_modules", true, false},
+		{"**/node_modules", "web/node_modules", true, true},
+		{"docs/*.md", "docs/README.md", false, true},
+		{"docs/*.md", "docs/api/README.md", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"cache/", "cache", true, true},
+		{"cache/", "cache", false, false},
+		{"re:\\.py[co]$", "lib/x.pyc", false, true},
+		{"re:^tmp/", "src/tmp/x", false, false},
+	}
+	for _, tt := range tests {
+		excludes, err := parseExcludePatterns([]string{tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := excluded(excludes, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%s matching %s: got %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"[a", "re:(", "/"} {
+		if _, err := parseExcludePatterns([]string{pattern}); err == nil {
+			t.Errorf("expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestAddDirectoryExcludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"main.js", "debug.log", ".git/HEAD", "node_modules/a/index.js", "lib/util.js", "lib/util.js.map"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.SetExcludes([]string{"*.log", ".git", "node_modules/**", "re:\\.map$"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDirectoryTo("/app", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"/app/main.js", "/app/lib/util.js"} {
+		if !m.FileExists(file) {
+			t.Errorf("expected %s in the image", file)
+		}
+	}
+	for _, file := range []string{"/app/debug.log", "/app/.git", "/app/node_modules", "/app/lib/util.js.map"} {
+		if m.root.Lookup(file) != nil {
+			t.Errorf("expected no %s in the image", file)
+		}
+	}
+}
+
+func TestParseOpsIgnore(t *testing.T) {
+	ignores, err := parseOpsIgnore(`# build artifacts
+*.o
+build/
+
+!keep.o
+\#notes
+/tmp
+trailing.txt   
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"main.o", false, true},
+		{"lib/util.o", false, true},
+		{"keep.o", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"#notes", false, true},
+		{"tmp", true, true},
+		{"src/tmp", true, false},
+		{"trailing.txt", false, true},
+		{"main.c", false, false},
+	}
+	for _, tt := range tests {
+		if got := excluded(ignores, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+
+	if _, err := parseOpsIgnore("ok\n[bad\n"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestAddDirectoryOpsIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".opsignore":        "*.log\n!important.log\ncache/\n",
+		"main.js":           "x",
+		"debug.log":         "x",
+		"important.log":     "x",
+		"cache/data":        "x",
+		"lib/cache/data.js": "x",
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.AddDirectoryTo("/app", dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"/app/main.js", "/app/important.log", "/app/.opsignore"} {
+		if !m.FileExists(file) {
+			t.Errorf("expected %s in the image", file)
+		}
+	}
+	for _, file := range []string{"/app/debug.log", "/app/cache", "/app/lib/cache"} {
+		if m.root.Lookup(file) != nil {
+			t.Errorf("expected no %s in the image", file)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package lepton
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The limits on the arguments and environment a program starts with, those
+// of Linux with its default 8M stack, which programs are written for. The
+// strings and their pointers are copied to the initial stack of the program.
+const (
+	// maxArgStrlen is the size of the longest argument or environment
+	// string, with its terminating zero
+	maxArgStrlen = 32 * 4096
+	// maxExecSize is the size of all the arguments and environment strings
+	// and of their pointers
+	maxExecSize = 2 * 1024 * 1024
+)
+
+// checkExecSize checks that the arguments and environment of the program
+// and of each setup program fit the limits programs start with
+func (m *Manifest) checkExecSize() error {
+	env := make([]string, 0, len(m.environment))
+	for name, value := range m.environment {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+
+	if err := checkExecArgs(m.program, m.args, env); err != nil {
+		return err
+	}
+	for _, setup := range m.setup {
+		if err := checkExecArgs(setup.program, setup.args, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExecArgs returns an error when program can't be started with args and
+// env, naming the argument or variable too long or the size of them all
+func checkExecArgs(program string, args []string, env []string) error {
+	size := 0
+	for i, arg := range args {
+		if len(arg)+1 > maxArgStrlen {
+			return WithCode(ErrMkfsExecSizeExceeded, fmt.Errorf("argument %d of %s is %d bytes, longer than the limit of %d", i, program, len(arg)+1, maxArgStrlen))
+		}
+		size += len(arg) + 1 + 8
+	}
+	for _, v := range env {
+		if len(v)+1 > maxArgStrlen {
+			name := strings.SplitN(v, "=", 2)[0]
+			return WithCode(ErrMkfsExecSizeExceeded, fmt.Errorf("environment variable %s of %s is %d bytes, longer than the limit of %d", name, program, len(v)+1, maxArgStrlen))
+		}
+		size += len(v) + 1 + 8
+	}
+	if size > maxExecSize {
+		return WithCode(ErrMkfsExecSizeExceeded, fmt.Errorf("the %d arguments and %d environment variables of %s take %d bytes, more than the limit of %d", len(args), len(env), program, size, maxExecSize))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package lepton
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckExecSize(t *testing.T) {
+	m := NewManifest("")
+	m.program = "/main"
+	m.AddArgument("main")
+	m.AddEnvironmentVariable("PORT", "8080")
+	if err := m.checkExecSize(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should reject a string longer than the limit", func(t *testing.T) {
+		m.AddEnvironmentVariable("CERT", strings.Repeat("x", maxArgStrlen))
+		err := m.checkExecSize()
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsExecSizeExceeded || !strings.Contains(err.Error(), "CERT") {
+			t.Errorf("got %v", err)
+		}
+		delete(m.environment, "CERT")
+	})
+
+	t.Run("should reject arguments larger than the limit together", func(t *testing.T) {
+		arg := strings.Repeat("x", maxArgStrlen/2)
+		for i := 0; i < 2*maxExecSize/maxArgStrlen+1; i++ {
+			m.AddArgument(arg)
+		}
+		if code, _ := ErrorCodeOf(m.checkExecSize()); code != ErrMkfsExecSizeExceeded {
+			t.Errorf("got %v", m.checkExecSize())
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package lepton
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// ExitAction is what the kernel does when the program stops
+type ExitAction string
+
+const (
+	// ExitHalt powers the instance off, the default
+	ExitHalt ExitAction = "halt"
+	// ExitReboot reboots the instance, running the program again
+	ExitReboot ExitAction = "reboot"
+)
+
+// ExitPolicy configures what the kernel does when the program exits or
+// crashes, like rebooting production instances on a panic while CI runs
+// stop with the exit status of the program
+type ExitPolicy struct {
+	// OnExit is the action when the program exits, halt when empty
+	OnExit ExitAction
+
+	// OnCrash is the action when the program faults or the kernel panics,
+	// OnExit when empty
+	OnCrash ExitAction
+
+	// PropagateStatus makes the kernel report the exit status of the
+	// program to the hypervisor, offset by guestExitSuccess, local runs
+	// return it as a GuestExitError and ops run exits with it. Local runs
+	// always stop on reboot.
+	PropagateStatus bool
+}
+
+// exitPolicy returns the exit policy of c, RebootOnExit is an OnExit of
+// reboot
+func exitPolicy(c *Config) ExitPolicy {
+	p := c.Exit
+	if c.RebootOnExit && p.OnExit == "" {
+		p.OnExit = ExitReboot
+	}
+	return p
+}
+
+// validate checks the actions of the policy
+func (p ExitPolicy) validate() error {
+	for _, a := range []ExitAction{p.OnExit, p.OnCrash} {
+		if a != "" && a != ExitHalt && a != ExitReboot {
+			return fmt.Errorf("invalid exit action %q, expected %s or %s", a, ExitHalt, ExitReboot)
+		}
+	}
+	if p.PropagateStatus && p.OnExit == ExitReboot {
+		return fmt.Errorf("the exit status can't be propagated when rebooting on exit")
+	}
+	return nil
+}
+
+// tuples returns the manifest options of the policy. reboot_on_exit also
+// applies to crashes, reboot_on_crash is only written for a crash action
+// differing from the exit action.
+func (p ExitPolicy) tuples() map[string]bool {
+	onExit := p.OnExit
+	if onExit == "" {
+		onExit = ExitHalt
+	}
+	onCrash := p.OnCrash
+	if onCrash == "" {
+		onCrash = onExit
+	}
+	tuples := map[string]bool{}
+	if onExit == ExitReboot {
+		tuples["reboot_on_exit"] = true
+	}
+	if onCrash != onExit {
+		tuples["reboot_on_crash"] = onCrash == ExitReboot
+	}
+	if p.PropagateStatus {
+		tuples["exit_status"] = true
+	}
+	return tuples
+}
+
+// SetExitPolicy sets what the kernel does when the program exits or crashes
+func (m *Manifest) SetExitPolicy(p ExitPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	for key, value := range p.tuples() {
+		if err := m.SetRootTuple(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guestExitSuccess is what a kernel propagating exit statuses writes to the
+// isa-debug-exit device of qemu for a program exiting with 0, statuses are
+// written offset by it. qemu exits with (value << 1) | 1, so 3 for a
+// success, while qemu exits with 1 on its own errors.
+const guestExitSuccess = 1
+
+// guestExitStatus returns the exit status the guest reported through the
+// isa-debug-exit device of qemu from the error of waiting for qemu, false
+// when qemu failed or was killed
+func guestExitStatus(err error) (int, bool) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	code := exitErr.ExitCode()
+	if code&1 == 0 || code>>1 < guestExitSuccess {
+		return 0, false
+	}
+	return code>>1 - guestExitSuccess, true
+}
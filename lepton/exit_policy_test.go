@@ -0,0 +1,70 @@
+package lepton
+
+import (
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExitPolicyTuples(t *testing.T) {
+	tests := []struct {
+		policy ExitPolicy
+		want   map[string]bool
+	}{
+		{ExitPolicy{}, map[string]bool{}},
+		{ExitPolicy{OnExit: ExitReboot}, map[string]bool{"reboot_on_exit": true}},
+		{ExitPolicy{OnExit: ExitHalt, OnCrash: ExitHalt}, map[string]bool{}},
+		{ExitPolicy{OnExit: ExitReboot, OnCrash: ExitHalt}, map[string]bool{"reboot_on_exit": true, "reboot_on_crash": false}},
+		{ExitPolicy{OnCrash: ExitReboot, PropagateStatus: true}, map[string]bool{"reboot_on_crash": true, "exit_status": true}},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.validate(); err != nil {
+			t.Errorf("%+v: %v", tt.policy, err)
+		}
+		if got := tt.policy.tuples(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+
+	for _, p := range []ExitPolicy{{OnExit: "restart"}, {OnExit: ExitReboot, PropagateStatus: true}} {
+		if err := p.validate(); err == nil {
+			t.Errorf("%+v should be invalid", p)
+		}
+	}
+}
+
+func TestSetExitPolicy(t *testing.T) {
+	m := NewManifest("")
+	m.AddDebugFlag("reboot_on_exit", 'f')
+	if err := m.SetExitPolicy(exitPolicy(&Config{RebootOnExit: true})); err != nil {
+		t.Fatal(err)
+	}
+	s := m.String()
+	if !strings.Contains(s, "reboot_on_exit:t\n") || strings.Contains(s, "reboot_on_exit:f") {
+		t.Errorf("unexpected manifest:\n%s", s)
+	}
+}
+
+func TestGuestExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	// qemu exits with 7 when the guest writes 3 to isa-debug-exit, for a
+	// status of 2
+	err := exec.Command("sh", "-c", "exit 7").Run()
+	if status, ok := guestExitStatus(err); !ok || status != 2 {
+		t.Errorf("got %d, %v, want 2", status, ok)
+	}
+	err = exec.Command("sh", "-c", "exit 3").Run()
+	if status, ok := guestExitStatus(err); !ok || status != 0 {
+		t.Errorf("got %d, %v, want 0", status, ok)
+	}
+	for _, code := range []string{"1", "2"} {
+		err = exec.Command("sh", "-c", "exit "+code).Run()
+		if _, ok := guestExitStatus(err); ok {
+			t.Errorf("exit code %s is a qemu error", code)
+		}
+	}
+}
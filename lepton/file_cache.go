@@ -0,0 +1,216 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// maxSymlinkHops is the number of symlinks followed resolving one path
+// before giving up, like MAXSYMLINKS of linux
+const maxSymlinkHops = 40
+
+// fileCacheWorkers is the number of files resolved at the same time by
+// resolveAll, it hides the latency of network filesystems
+const fileCacheWorkers = 16
+
+type statResult struct {
+	info os.FileInfo
+	err  error
+}
+
+type linkResult struct {
+	target string
+	err    error
+}
+
+// fileCache memoizes the lstat, stat and readlink results of one build, so
+// the files of a target root on a slow filesystem like NFS are resolved
+// once. A nil cache calls the filesystem every time.
+type fileCache struct {
+	mu     sync.Mutex
+	lstats map[string]statResult
+	stats  map[string]statResult
+	links  map[string]linkResult
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{
+		lstats: make(map[string]statResult),
+		stats:  make(map[string]statResult),
+		links:  make(map[string]linkResult),
+	}
+}
+
+// prime records the lstat result of path a directory walk already has
+func (fc *fileCache) prime(path string, info os.FileInfo) {
+	if fc == nil || info == nil {
+		return
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.lstats[path] = statResult{info: info}
+	if info.Mode()&os.ModeSymlink == 0 {
+		fc.stats[path] = statResult{info: info}
+	}
+}
+
+func (fc *fileCache) cachedStat(cache map[string]statResult, path string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	if fc == nil {
+		return stat(path)
+	}
+
+	fc.mu.Lock()
+	r, ok := cache[path]
+	fc.mu.Unlock()
+	if ok {
+		return r.info, r.err
+	}
+
+	info, err := stat(path)
+	fc.mu.Lock()
+	cache[path] = statResult{info: info, err: err}
+	fc.mu.Unlock()
+	return info, err
+}
+
+func (fc *fileCache) lstat(path string) (os.FileInfo, error) {
+	if fc == nil {
+		return os.Lstat(path)
+	}
+	return fc.cachedStat(fc.lstats, path, os.Lstat)
+}
+
+func (fc *fileCache) stat(path string) (os.FileInfo, error) {
+	if fc == nil {
+		return os.Stat(path)
+	}
+	return fc.cachedStat(fc.stats, path, os.Stat)
+}
+
+func (fc *fileCache) readlink(path string) (string, error) {
+	if fc == nil {
+		return os.Readlink(path)
+	}
+
+	fc.mu.Lock()
+	r, ok := fc.links[path]
+	fc.mu.Unlock()
+	if ok {
+		return r.target, r.err
+	}
+
+	target, err := os.Readlink(path)
+	fc.mu.Lock()
+	fc.links[path] = linkResult{target: target, err: err}
+	fc.mu.Unlock()
+	return target, err
+}
+
+// lookupFile returns the path of path in targetRoot, following the symlinks
+// of the target root, or the host path when it isn't in the target root.
+// When strict, relative symlinks are resolved within the target root too and
+// paths are never looked up on the host.
+func (fc *fileCache) lookupFile(targetRoot string, path string, strict bool) (string, error) {
+	p, err := fc.resolveFile(targetRoot, path, strict)
+	return p.Resolved, err
+}
+
+// resolveFile is lookupFile returning where path was found
+func (fc *fileCache) resolveFile(targetRoot string, path string, strict bool) (FileProvenance, error) {
+	p := FileProvenance{Resolved: path, Source: FileFromHost}
+	if targetRoot != "" {
+		var targetPath string
+		currentPath := path
+		visited := map[string]bool{}
+		for hops := 0; ; hops++ {
+			p.SymlinkHops = hops
+			targetPath = filepath.Join(targetRoot, currentPath)
+			if visited[targetPath] || hops > maxSymlinkHops {
+				return p, WithCode(ErrMkfsSymlinkLoop, fmt.Errorf("too many levels of symbolic links resolving %s in %s", path, targetRoot))
+			}
+			visited[targetPath] = true
+
+			fi, err := fc.lstat(targetPath)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return p, err
+				}
+				if strict && hops > 0 {
+					return p, WithCode(ErrMkfsSymlinkEscape, fmt.Errorf("%s links to %s, which is not in target root %s", path, currentPath, targetRoot))
+				}
+				if strict {
+					return p, &os.PathError{Op: "lookup", Path: targetPath, Err: os.ErrNotExist}
+				}
+				// lookup on host
+				p.SymlinkHops = 0
+				break
+			}
+
+			if fi.Mode()&os.ModeSymlink == 0 {
+				// not a symlink found in target root
+				p.Resolved = targetPath
+				p.Source = FileFromTargetRoot
+				return p, nil
+			}
+
+			link, err := fc.readlink(targetPath)
+			if err != nil {
+				return p, err
+			}
+
+			if link[0] != '/' {
+				if !strict {
+					// relative symlinks are ok
+					p.Resolved = targetPath
+					p.Source = FileFromTargetRoot
+					p.SymlinkHops++
+					break
+				}
+				// resolve like the guest does, ".." stops at the root
+				link = resolveLink(currentPath, link)
+			}
+
+			// absolute symlinks need to be resolved again
+			currentPath = link
+		}
+	}
+
+	_, err := fc.stat(p.Resolved)
+
+	return p, err
+}
+
+// resolveLink returns the absolute path the relative link of the file at
+// file points to
+func resolveLink(file string, link string) string {
+	return path.Join("/", path.Dir(filepath.ToSlash(file)), filepath.ToSlash(link))
+}
+
+// resolveAll looks up paths concurrently so the later lookups of each one
+// are answered from the cache
+func (fc *fileCache) resolveAll(targetRoot string, paths []string, strict bool) {
+	if fc == nil || len(paths) < 2 {
+		return
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < fileCacheWorkers && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				fc.lookupFile(targetRoot, path, strict)
+			}
+		}()
+	}
+	for _, path := range paths {
+		work <- path
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,160 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCacheLookup(t *testing.T) {
+	root, err := ioutil.TempDir("", "targetroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "lib"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(root, "lib", "libc.so.6"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/lib/libc.so.6", filepath.Join(root, "lib", "libc.so")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	fc := newFileCache()
+
+	t.Run("should resolve like the uncached lookup", func(t *testing.T) {
+		for _, path := range []string{"/lib/libc.so", "/lib/libc.so.6", "/lib/missing"} {
+			want, wantErr := lookupFile(root, path)
+			got, err := fc.lookupFile(root, path, false)
+			if got != want || (err == nil) != (wantErr == nil) {
+				t.Errorf("%s: got %s, %v want %s, %v", path, got, err, want, wantErr)
+			}
+		}
+	})
+
+	t.Run("should answer repeated lookups from the cache", func(t *testing.T) {
+		os.Remove(filepath.Join(root, "lib", "libc.so.6"))
+		if _, err := fc.lookupFile(root, "/lib/libc.so", false); err != nil {
+			t.Errorf("expected the cached result, got %v", err)
+		}
+		if _, err := lookupFile(root, "/lib/libc.so"); err == nil {
+			t.Error("expected the uncached lookup to see the removal")
+		}
+	})
+
+	t.Run("should resolve paths concurrently", func(t *testing.T) {
+		paths := []string{}
+		for i := 0; i < 100; i++ {
+			paths = append(paths, filepath.Join(root, "lib", "libc.so"))
+		}
+
+		fc := newFileCache()
+		fc.resolveAll("", paths, false)
+
+		if len(fc.stats) != 1 {
+			t.Errorf("got %d cached stats", len(fc.stats))
+		}
+	})
+}
+
+func TestLookupFileSymlinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "targetroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "lib"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "lib", "real.so"), nil, 0644)
+	links := map[string]string{
+		"lib/a.so":       "/lib/b.so",
+		"lib/b.so":       "/lib/a.so",
+		"lib/rel.so":     "../../../lib/real.so",
+		"lib/host.so":    "/nonexistent/host.so",
+		"lib/outside.so": "../../../../" + os.TempDir(),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+	}
+
+	t.Run("should detect symlink loops", func(t *testing.T) {
+		for _, strict := range []bool{false, true} {
+			_, err := newFileCache().lookupFile(root, "/lib/a.so", strict)
+			if code, _ := ErrorCodeOf(err); code != ErrMkfsSymlinkLoop {
+				t.Errorf("got %v", err)
+			}
+		}
+	})
+
+	t.Run("should resolve relative symlinks within the target root", func(t *testing.T) {
+		got, err := newFileCache().lookupFile(root, "/lib/rel.so", true)
+		if err != nil || got != filepath.Join(root, "lib", "real.so") {
+			t.Errorf("got %s, %v", got, err)
+		}
+	})
+
+	t.Run("should not look up strict paths on the host", func(t *testing.T) {
+		_, err := newFileCache().lookupFile(root, "/lib/host.so", true)
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsSymlinkEscape {
+			t.Errorf("got %v", err)
+		}
+
+		_, err = newFileCache().lookupFile(root, os.TempDir(), true)
+		if !os.IsNotExist(err) {
+			t.Errorf("got %v", err)
+		}
+
+		_, err = newFileCache().lookupFile(root, "/lib/outside.so", true)
+		if err == nil {
+			t.Error("expected the link out of the target root to fail")
+		}
+	})
+}
+
+func TestResolveFileProvenance(t *testing.T) {
+	root, err := ioutil.TempDir("", "targetroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "lib"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(root, "lib", "libc.so.6"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/lib/libc.so.6", filepath.Join(root, "lib", "libc.so")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	host, err := ioutil.TempFile("", "hostfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Close()
+	defer os.Remove(host.Name())
+
+	m := NewManifest(root)
+	m.AddLibrary("/lib/libc.so")
+	m.AddLibrary("/lib/libc.so.6")
+	if err := m.AddFile("/etc/hostfile", host.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := m.Provenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]FileProvenance{
+		"/lib/libc.so":   {Path: "/lib/libc.so", Resolved: filepath.Join(root, "lib", "libc.so.6"), Source: FileFromTargetRoot, SymlinkHops: 1},
+		"/lib/libc.so.6": {Path: "/lib/libc.so.6", Resolved: filepath.Join(root, "lib", "libc.so.6"), Source: FileFromTargetRoot},
+		"/etc/hostfile":  {Path: host.Name(), Resolved: host.Name(), Source: FileFromHost},
+	}
+	for vmpath, p := range want {
+		if provenance[vmpath] != p {
+			t.Errorf("%s: got %+v, want %+v", vmpath, provenance[vmpath], p)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package lepton
+
+// FileSource is where a file of the image is read from
+type FileSource string
+
+const (
+	// FileFromHost files are read from the host, the path of the manifest
+	// isn't in the target root or there is none
+	FileFromHost FileSource = "host"
+	// FileFromTargetRoot files are read from the target root
+	FileFromTargetRoot FileSource = "target-root"
+)
+
+// FileProvenance is where a file of the image was resolved from
+type FileProvenance struct {
+	// Path is the path of the file in the manifest
+	Path string
+
+	// Resolved is the file read, in the target root or on the host
+	Resolved string
+
+	Source FileSource
+
+	// SymlinkHops is the number of symlinks of the target root followed to
+	// the file
+	SymlinkHops int `json:",omitempty"`
+}
+
+// Provenance returns where every file of the image is resolved from, by
+// image path
+func (m *Manifest) Provenance() (map[string]FileProvenance, error) {
+	provenance := map[string]FileProvenance{}
+	err := m.root.Walk(func(vmpath string, node ManifestNode) error {
+		file, ok := node.(*FileNode)
+		if !ok {
+			return nil
+		}
+		p, err := m.resolveFile(file.HostPath)
+		if err != nil {
+			return err
+		}
+		p.Path = file.HostPath
+		provenance[vmpath] = p
+		return nil
+	})
+	return provenance, err
+}
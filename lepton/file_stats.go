@@ -0,0 +1,65 @@
+package lepton
+
+import (
+	"fmt"
+)
+
+const (
+	// fsSectorSize is the unit file extents are allocated in by mkfs
+	fsSectorSize = 512
+	// smallFileSize is the size under which files are counted as small
+	smallFileSize = 4 * KiByte
+)
+
+// FileStats summarizes the sizes of the files of an image. Every file takes
+// whole sectors in the image, the space lost to it adds up for images of
+// many small files like Python or Node ones. Coalescing small files into
+// shared extents needs support from mkfs.
+type FileStats struct {
+	Files      int
+	SmallFiles int
+	// Bytes is the size of the content of the files
+	Bytes int64
+	// AllocatedBytes estimates the space the files take in the image, their
+	// sizes rounded up to sectors
+	AllocatedBytes int64
+}
+
+// Overhead is the estimated space lost to the alignment of the files
+func (s FileStats) Overhead() int64 {
+	return s.AllocatedBytes - s.Bytes
+}
+
+func (s FileStats) String() string {
+	return fmt.Sprintf("%d files (%d small) of %s, taking about %s in the image",
+		s.Files, s.SmallFiles, Bytes2Human(s.Bytes), Bytes2Human(s.AllocatedBytes))
+}
+
+// FileStats returns the size statistics of the files of the image
+func (m *Manifest) FileStats() (FileStats, error) {
+	var stats FileStats
+	err := m.root.Walk(func(vmpath string, node ManifestNode) error {
+		file, ok := node.(*FileNode)
+		if !ok {
+			return nil
+		}
+		hostpath, err := m.lookupFile(file.HostPath)
+		if err != nil {
+			return err
+		}
+		fi, err := m.files.stat(hostpath)
+		if err != nil {
+			return err
+		}
+
+		size := fi.Size()
+		stats.Files++
+		if size < smallFileSize {
+			stats.SmallFiles++
+		}
+		stats.Bytes += size
+		stats.AllocatedBytes += (size + fsSectorSize - 1) / fsSectorSize * fsSectorSize
+		return nil
+	})
+	return stats, err
+}
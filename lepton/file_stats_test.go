@@ -0,0 +1,39 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManifest("")
+	for name, size := range map[string]int{"empty": 0, "small": 10, "sector": 512, "large": 5000} {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddFile("/data/"+name, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := m.FileStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FileStats{Files: 4, SmallFiles: 3, Bytes: 5522, AllocatedBytes: 512 + 512 + 5120}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+	if stats.Overhead() != 622 {
+		t.Errorf("got overhead %d", stats.Overhead())
+	}
+}
@@ -0,0 +1,102 @@
+package lepton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// tfsMagic starts the log header of a nanos filesystem
+	tfsMagic = "NVMTFS"
+
+	// tfsUUIDLen and tfsLabelLen are the sizes of the UUID and label
+	// fields of the log header
+	tfsUUIDLen  = 16
+	tfsLabelLen = 32
+)
+
+// ReadFilesystemLabel returns the label of the filesystem of the image or
+// volume at path, the root filesystem for images. Filesystems without a
+// label return an empty label.
+func ReadFilesystemLabel(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	offset, err := rootPartitionOffset(f)
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, fsSectorSize)
+	if _, err := f.ReadAt(header, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+	label, err := tfsHeaderLabel(header)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return label, nil
+}
+
+// rootPartitionOffset returns the offset of the last partition of the mbr
+// of an image, the root filesystem, or 0 for volumes that have no
+// partition table
+func rootPartitionOffset(r io.ReaderAt) (int64, error) {
+	mbr := make([]byte, fsSectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return 0, nil
+	}
+	var offset int64
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+16*i : 446+16*(i+1)]
+		if entry[4] == 0 {
+			continue
+		}
+		offset = int64(binary.LittleEndian.Uint32(entry[8:])) * fsSectorSize
+	}
+	return offset, nil
+}
+
+// tfsHeaderLabel returns the label of the log header of a nanos filesystem:
+// the magic, the version and the size of the log as varints, the UUID and
+// the label padded with zeros
+func tfsHeaderLabel(header []byte) (string, error) {
+	if !bytes.HasPrefix(header, []byte(tfsMagic)) {
+		return "", fmt.Errorf("no nanos filesystem found")
+	}
+	b := header[len(tfsMagic):]
+	for i := 0; i < 2; i++ {
+		n := varintLen(b)
+		if n == 0 {
+			return "", fmt.Errorf("truncated filesystem header")
+		}
+		b = b[n:]
+	}
+	if len(b) < tfsUUIDLen+tfsLabelLen {
+		return "", fmt.Errorf("truncated filesystem header")
+	}
+	label := b[tfsUUIDLen : tfsUUIDLen+tfsLabelLen]
+	if i := bytes.IndexByte(label, 0); i >= 0 {
+		label = label[:i]
+	}
+	return string(label), nil
+}
+
+// varintLen returns the length of the nanos varint b starts with, the bytes
+// but the last have their high bit set, or 0 when b is truncated
+func varintLen(b []byte) int {
+	for i, c := range b {
+		if c&0x80 == 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package lepton
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTFSHeader(label string) []byte {
+	header := append([]byte(tfsMagic), 0x04, 0x81, 0x00)
+	header = append(header, make([]byte, tfsUUIDLen)...)
+	padded := make([]byte, tfsLabelLen)
+	copy(padded, label)
+	return append(header, padded...)
+}
+
+func TestReadFilesystemLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fslabel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("should read the label of a volume", func(t *testing.T) {
+		path := filepath.Join(dir, "volume.raw")
+		if err := ioutil.WriteFile(path, testTFSHeader("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if label, err := ReadFilesystemLabel(path); err != nil || label != "data" {
+			t.Errorf("got %q, %v", label, err)
+		}
+	})
+
+	t.Run("should read the label of the root partition of an image", func(t *testing.T) {
+		image := make([]byte, 4*fsSectorSize)
+		image[510], image[511] = 0x55, 0xaa
+		for i, lba := range []uint32{1, 2} {
+			entry := image[446+16*i:]
+			entry[4] = 0x83
+			binary.LittleEndian.PutUint32(entry[8:], lba)
+		}
+		copy(image[fsSectorSize:], testTFSHeader("boot"))
+		copy(image[2*fsSectorSize:], testTFSHeader("root"))
+
+		path := filepath.Join(dir, "image.img")
+		if err := ioutil.WriteFile(path, image, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if label, err := ReadFilesystemLabel(path); err != nil || label != "root" {
+			t.Errorf("got %q, %v", label, err)
+		}
+	})
+
+	t.Run("should fail without a nanos filesystem", func(t *testing.T) {
+		path := filepath.Join(dir, "empty.raw")
+		if err := ioutil.WriteFile(path, make([]byte, fsSectorSize), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadFilesystemLabel(path); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
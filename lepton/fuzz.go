@@ -0,0 +1,91 @@
+// +build gofuzz
+
+package lepton
+
+// Fuzz targets for go-fuzz, the importers parse configs and package
+// manifests fetched from CI systems and registries. Build a target with
+//
+//	go-fuzz-build -func FuzzConfig github.com/nanovms/ops/lepton
+//	go-fuzz -bin lepton-fuzz.zip -workdir fuzz/config
+//
+// A target panics when an importer breaks one of its invariants.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// FuzzConfig checks that the validator agrees with the config importer and
+// that importing an exported config gives the same config
+func FuzzConfig(data []byte) int {
+	issues, verr := validateConfigData(data)
+
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0
+	}
+
+	if verr != nil {
+		panic(fmt.Sprintf("validator rejects a config the importer accepts: %v", verr))
+	}
+	for _, issue := range issues {
+		if issue.Kind == ConfigTypeMismatch {
+			panic(fmt.Sprintf("validator reports a type mismatch the importer accepts: %v", issue))
+		}
+	}
+
+	checkConfigRoundTrip(&c)
+	return 1
+}
+
+// FuzzConfigOverride checks that overrides never panic and leave a config
+// that survives export and import
+func FuzzConfigOverride(data []byte) int {
+	c := NewConfig()
+	if err := ApplyConfigOverrides(c, string(data)); err != nil {
+		return 0
+	}
+
+	checkConfigRoundTrip(c)
+	return 1
+}
+
+// FuzzPackageManifest checks that importing an exported package manifest
+// gives the same manifest
+func FuzzPackageManifest(data []byte) int {
+	var packages map[string]Package
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return 0
+	}
+
+	exported, err := json.Marshal(packages)
+	if err != nil {
+		panic(err)
+	}
+	var imported map[string]Package
+	if err := json.Unmarshal(exported, &imported); err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(packages, imported) {
+		panic(fmt.Sprintf("package manifest changed on export: %+v != %+v", packages, imported))
+	}
+	return 1
+}
+
+func checkConfigRoundTrip(c *Config) {
+	// build hooks are registered programmatically and never exported
+	c.BuildHooks = nil
+
+	exported, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	var imported Config
+	if err := json.Unmarshal(exported, &imported); err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(*c, imported) {
+		panic(fmt.Sprintf("config changed on export: %+v != %+v", *c, imported))
+	}
+}
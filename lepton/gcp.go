@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -18,6 +20,17 @@ var (
 	errGCloudZoneMissing      = func() error { return errors.New("zone is missing. Please set env variable GCLOUD_ZONE") }
 )
 
+// gcpClient returns the authorized client of the GCP APIs, which retries
+// transient failures with the retry policy of config
+func gcpClient(ctx context.Context, config *ProviderConfig) (*http.Client, error) {
+	client, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = RetryPolicyFromConfig(config).Transport(client.Transport)
+	return client, nil
+}
+
 // GCloudOperation status check
 type GCloudOperation struct {
 	service       *compute.Service
@@ -38,6 +51,27 @@ func buildGcpTags(tags []Tag) map[string]string {
 	return labels
 }
 
+var (
+	// gcpNameRegexp matches valid gcp resource names, like image families
+	gcpNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	// gcpLabelKeyRegexp and gcpLabelValueRegexp match valid gcp labels
+	gcpLabelKeyRegexp   = regexp.MustCompile(`^[a-z][-_a-z0-9]{0,62}$`)
+	gcpLabelValueRegexp = regexp.MustCompile(`^[-_a-z0-9]{0,63}$`)
+)
+
+// checkGcpLabels reports labels gcp would reject
+func checkGcpLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !gcpLabelKeyRegexp.MatchString(key) {
+			return WithCode(ErrImageInvalidLabels, fmt.Errorf("invalid label key %q, keys must be lowercase letters, digits, - or _ and start with a letter", key))
+		}
+		if !gcpLabelValueRegexp.MatchString(value) {
+			return WithCode(ErrImageInvalidLabels, fmt.Errorf("invalid label value %q for %s, values must be lowercase letters, digits, - or _", value, key))
+		}
+	}
+	return nil
+}
+
 func checkGCCredentialsProvided() error {
 	creds, ok := os.LookupEnv("GOOGLE_APPLICATION_CREDENTIALS")
 	if !ok {
@@ -151,11 +185,15 @@ func (p *GCloud) Initialize(config *ProviderConfig) error {
 		return fmt.Errorf("Zone missing")
 	}
 
+	if _, err := ResolveCredentials("gcp", config.Profile); err != nil {
+		return err
+	}
+
 	if err := checkGCCredentialsProvided(); err != nil {
 		return err
 	}
 
-	client, err := google.DefaultClient(context.Background(), compute.CloudPlatformScope)
+	client, err := gcpClient(context.Background(), config)
 	if err != nil {
 		return err
 	}
@@ -167,7 +205,7 @@ func (p *GCloud) Initialize(config *ProviderConfig) error {
 
 	p.Service = computeService
 
-	p.dnsService, err = p.getDNSService()
+	p.dnsService, err = p.getDNSService(client)
 	if err != nil {
 		return err
 	}
@@ -1,10 +1,9 @@
 package lepton
 
 import (
-	"context"
+	"net/http"
 	"strings"
 
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/dns/v1"
 )
 
@@ -80,17 +79,6 @@ func (p *GCloud) CreateZoneRecord(config *Config, zoneID string, record *DNSReco
 	return nil
 }
 
-func (p *GCloud) getDNSService() (*dns.Service, error) {
-	context := context.TODO()
-	_, err := google.FindDefaultCredentials(context)
-	if err != nil {
-		return nil, err
-	}
-
-	dnsService, err := dns.NewService(context)
-	if err != nil {
-		return nil, err
-	}
-
-	return dnsService, nil
+func (p *GCloud) getDNSService(client *http.Client) (*dns.Service, error) {
+	return dns.New(client)
 }
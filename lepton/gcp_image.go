@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/olekukonko/tablewriter"
@@ -22,15 +23,12 @@ func (p *GCloud) getArchiveName(ctx *Context) string {
 // CustomizeImage returns image path with adaptations needed by cloud provider
 func (p *GCloud) CustomizeImage(ctx *Context) (string, error) {
 	imagePath := ctx.config.RunConfig.Imagename
-	symlink := filepath.Join(filepath.Dir(imagePath), "disk.raw")
-
-	if _, err := os.Lstat(symlink); err == nil {
-		if err := os.Remove(symlink); err != nil {
-			return "", fmt.Errorf("failed to unlink: %+v", err)
-		}
+	if ctx.config.CloudConfig.StreamUpload {
+		// the archive is written to the bucket by CreateImage
+		return imagePath, nil
 	}
 
-	err := os.Link(imagePath, symlink)
+	symlink, err := linkDisk(imagePath)
 	if err != nil {
 		return "", err
 	}
@@ -45,6 +43,22 @@ func (p *GCloud) CustomizeImage(ctx *Context) (string, error) {
 	return archPath, nil
 }
 
+// linkDisk links imagePath to the disk.raw gcp expects in image archives
+func linkDisk(imagePath string) (string, error) {
+	symlink := filepath.Join(filepath.Dir(imagePath), "disk.raw")
+
+	if _, err := os.Lstat(symlink); err == nil {
+		if err := os.Remove(symlink); err != nil {
+			return "", fmt.Errorf("failed to unlink: %+v", err)
+		}
+	}
+
+	if err := os.Link(imagePath, symlink); err != nil {
+		return "", err
+	}
+	return symlink, nil
+}
+
 // BuildImage to be upload on GCP
 func (p *GCloud) BuildImage(ctx *Context) (string, error) {
 	c := ctx.config
@@ -69,12 +83,29 @@ func (p *GCloud) BuildImageWithPackage(ctx *Context, pkgpath string) (string, er
 // CreateImage - Creates image on GCP using nanos images
 // TODO : re-use and cache DefaultClient and instances.
 func (p *GCloud) CreateImage(ctx *Context, imagePath string) error {
-	err := p.Storage.CopyToBucket(ctx.config, imagePath)
-	if err != nil {
+	c := ctx.config
+
+	// fail before the upload, gcp only validates these on insert
+	labels := buildGcpTags(c.RunConfig.Tags)
+	if err := checkGcpLabels(labels); err != nil {
 		return err
 	}
+	if c.CloudConfig.ImageFamily != "" && !gcpNameRegexp.MatchString(c.CloudConfig.ImageFamily) {
+		return WithCode(ErrImageInvalidName, fmt.Errorf("invalid image family %q, it must match %s", c.CloudConfig.ImageFamily, gcpNameRegexp))
+	}
 
-	c := ctx.config
+	var err error
+	if c.CloudConfig.StreamUpload {
+		var symlink string
+		if symlink, err = linkDisk(imagePath); err == nil {
+			err = p.Storage.StreamToBucket(c, p.getArchiveName(ctx), []string{symlink})
+		}
+	} else {
+		err = p.Storage.CopyToBucket(c, imagePath)
+	}
+	if err != nil {
+		return err
+	}
 	context := context.TODO()
 
 	sourceURL := fmt.Sprintf(GCPStorageURL,
@@ -82,12 +113,19 @@ func (p *GCloud) CreateImage(ctx *Context, imagePath string) error {
 
 	rb := &compute.Image{
 		Name:   c.CloudConfig.ImageName,
-		Labels: buildGcpTags(ctx.config.RunConfig.Tags),
+		Family: c.CloudConfig.ImageFamily,
+		Labels: labels,
 		RawDisk: &compute.ImageRawDisk{
 			Source: sourceURL,
 		},
 	}
 
+	for _, feature := range c.CloudConfig.GuestOSFeatures {
+		rb.GuestOsFeatures = append(rb.GuestOsFeatures, &compute.GuestOsFeature{
+			Type: strings.ToUpper(feature),
+		})
+	}
+
 	op, err := p.Service.Images.Insert(c.CloudConfig.ProjectID, rb).Context(context).Do()
 	if err != nil {
 		return fmt.Errorf("error:%+v", err)
@@ -101,26 +139,68 @@ func (p *GCloud) CreateImage(ctx *Context, imagePath string) error {
 	return nil
 }
 
-// GetImages return all images on GCloud
-func (p *GCloud) GetImages(ctx *Context) ([]CloudImage, error) {
-	context := context.TODO()
-	creds, err := google.FindDefaultCredentials(context)
+// VerifyImage compares the image imagename to the image file at imagePath,
+// downloading the archive the image was created from
+func (p *GCloud) VerifyImage(ctx *Context, imagename string, imagePath string) (*ImageVerification, error) {
+	c := ctx.config
+	image, err := p.Service.Images.Get(c.CloudConfig.ProjectID, imagename).Context(context.TODO()).Do()
 	if err != nil {
 		return nil, err
 	}
-	client, err := google.DefaultClient(context, compute.CloudPlatformScope)
-	if err != nil {
-		return nil, err
+	if image.RawDisk == nil || image.RawDisk.Source == "" {
+		return nil, fmt.Errorf("image %s wasn't created from an archive that can be compared", imagename)
 	}
-	computeService, err := compute.New(client)
+
+	source := strings.TrimPrefix(image.RawDisk.Source, "https://storage.googleapis.com/")
+	parts := strings.SplitN(source, "/", 2)
+	if source == image.RawDisk.Source || len(parts) != 2 {
+		return nil, fmt.Errorf("image %s source %s is not in cloud storage", imagename, image.RawDisk.Source)
+	}
+
+	sum, err := p.Storage.diskSHA256(&c.CloudConfig, parts[0], parts[1])
 	if err != nil {
 		return nil, err
 	}
 
-	var images []CloudImage
+	v := &ImageVerification{Image: image.SelfLink, Method: "sha256 of the disk.raw of " + image.RawDisk.Source}
+	v.Mismatches, err = compareFileSHA256(imagePath, sum)
+	return v, err
+}
+
+// GetImages return all images on GCloud
+func (p *GCloud) GetImages(ctx *Context) ([]CloudImage, error) {
+	return p.ImageIterator(ctx).All()
+}
+
+// ImageIterator lists images on GCP a page at a time
+func (p *GCloud) ImageIterator(ctx *Context) *ImageIterator {
+	context := context.TODO()
+	var req *compute.ImagesListCall
+	pageToken := ""
+
+	return NewImageIterator(func() ([]CloudImage, bool, error) {
+		if req == nil {
+			creds, err := google.FindDefaultCredentials(context)
+			if err != nil {
+				return nil, false, err
+			}
+			client, err := gcpClient(context, &ctx.config.CloudConfig)
+			if err != nil {
+				return nil, false, err
+			}
+			computeService, err := compute.New(client)
+			if err != nil {
+				return nil, false, err
+			}
+			req = computeService.Images.List(creds.ProjectID)
+		}
+
+		page, err := req.PageToken(pageToken).Context(context).Do()
+		if err != nil {
+			return nil, false, err
+		}
 
-	req := computeService.Images.List(creds.ProjectID)
-	err = req.Pages(context, func(page *compute.ImageList) error {
+		var images []CloudImage
 		for _, image := range page.Items {
 			if val, ok := image.Labels["createdby"]; ok && val == "ops" {
 				imageCreatedAt, _ := time.Parse("2006-01-02T15:04:05-07:00", image.CreationTimestamp)
@@ -134,11 +214,10 @@ func (p *GCloud) GetImages(ctx *Context) ([]CloudImage, error) {
 				images = append(images, ci)
 			}
 		}
-		return nil
-	})
-
-	return images, err
 
+		pageToken = page.NextPageToken
+		return images, pageToken != "", nil
+	})
 }
 
 // ListImages lists images on Google Cloud
@@ -175,7 +254,7 @@ func (p *GCloud) DeleteImage(ctx *Context, imagename string) error {
 	if err != nil {
 		return err
 	}
-	client, err := google.DefaultClient(context, compute.CloudPlatformScope)
+	client, err := gcpClient(context, &ctx.config.CloudConfig)
 	if err != nil {
 		return err
 	}
@@ -206,7 +285,16 @@ func createArchive(archive string, files []string) error {
 	if err != nil {
 		return err
 	}
-	gzw := gzip.NewWriter(fd)
+	if err := writeArchive(fd, files); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// writeArchive writes the tar.gz archive of files to w
+func writeArchive(w io.Writer, files []string) error {
+	gzw := gzip.NewWriter(w)
 
 	tw := tar.NewWriter(gzw)
 
@@ -247,9 +335,6 @@ func createArchive(archive string, files []string) error {
 	if err := gzw.Close(); err != nil {
 		return err
 	}
-	if err := fd.Close(); err != nil {
-		return err
-	}
 	return nil
 }
 
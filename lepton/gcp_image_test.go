@@ -0,0 +1,35 @@
+package lepton
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	disk := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	file := filepath.Join(dir, "disk.raw")
+	if err := ioutil.WriteFile(file, disk, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := archiveDiskSHA256(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := readerSHA256(bytes.NewReader(disk)); sum != want {
+		t.Errorf("got %s, want %s", sum, want)
+	}
+}
@@ -35,7 +35,7 @@ func (p *GCloud) CreateInstance(ctx *Context) error {
 		c.CloudConfig.Flavor = "g1-small"
 	}
 
-	client, err := google.DefaultClient(context, compute.CloudPlatformScope)
+	client, err := gcpClient(context, &c.CloudConfig)
 	if err != nil {
 		return err
 	}
@@ -92,6 +92,9 @@ func (p *GCloud) CreateInstance(ctx *Context) error {
 			Items: []string{instanceName},
 		},
 	}
+	if userData := execUserData(c); userData != "" {
+		rb.Metadata.Items = append(rb.Metadata.Items, &compute.MetadataItems{Key: "user-data", Value: &userData})
+	}
 	op, err := computeService.Instances.Insert(c.CloudConfig.ProjectID, c.CloudConfig.Zone, rb).Context(context).Do()
 	if err != nil {
 		return err
@@ -191,25 +194,31 @@ func (p *GCloud) GetInstanceByID(ctx *Context, id string) (*CloudInstance, error
 
 // GetInstances return all instances on GCloud
 func (p *GCloud) GetInstances(ctx *Context) ([]CloudInstance, error) {
-	context := context.TODO()
-	var (
-		cinstances []CloudInstance
-		req        = p.Service.Instances.List(ctx.config.CloudConfig.ProjectID, ctx.config.CloudConfig.Zone)
-	)
+	return p.InstanceIterator(ctx).All()
+}
+
+// InstanceIterator lists instances on GCP a page at a time
+func (p *GCloud) InstanceIterator(ctx *Context) *InstanceIterator {
+	req := p.Service.Instances.List(ctx.config.CloudConfig.ProjectID, ctx.config.CloudConfig.Zone)
+	pageToken := ""
 
-	if err := req.Pages(context, func(page *compute.InstanceList) error {
+	return NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+		page, err := req.PageToken(pageToken).Context(context.TODO()).Do()
+		if err != nil {
+			return nil, false, err
+		}
+
+		var cinstances []CloudInstance
 		for _, instance := range page.Items {
 			if val, ok := instance.Labels["createdby"]; ok && val == "ops" {
 				cinstance := p.convertToCloudInstance(instance)
 				cinstances = append(cinstances, *cinstance)
 			}
 		}
-		return nil
-	}); err != nil {
-		return nil, err
-	}
 
-	return cinstances, nil
+		pageToken = page.NextPageToken
+		return cinstances, pageToken != "", nil
+	})
 }
 
 func (p *GCloud) convertToCloudInstance(instance *compute.Instance) *CloudInstance {
@@ -228,12 +237,16 @@ func (p *GCloud) convertToCloudInstance(instance *compute.Instance) *CloudInstan
 		}
 	}
 
+	createdAt, _ := time.Parse(time.RFC3339, instance.CreationTimestamp)
+
 	return &CloudInstance{
 		Name:       instance.Name,
 		Status:     instance.Status,
 		Created:    instance.CreationTimestamp,
+		CreatedAt:  createdAt,
 		PublicIps:  publicIps,
 		PrivateIps: privateIps,
+		Tags:       instance.Labels,
 	}
 }
 
@@ -0,0 +1,58 @@
+package lepton
+
+import (
+	"context"
+	"path"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// GetRegions returns the regions available to the project
+func (p *GCloud) GetRegions(ctx *Context) ([]string, error) {
+	regions := []string{}
+	err := p.Service.Regions.List(ctx.config.CloudConfig.ProjectID).Pages(context.TODO(), func(page *compute.RegionList) error {
+		for _, region := range page.Items {
+			regions = append(regions, region.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// GetZones returns the zones available to the project
+func (p *GCloud) GetZones(ctx *Context) ([]Zone, error) {
+	zones := []Zone{}
+	err := p.Service.Zones.List(ctx.config.CloudConfig.ProjectID).Pages(context.TODO(), func(page *compute.ZoneList) error {
+		for _, zone := range page.Items {
+			// region is a resource url
+			zones = append(zones, Zone{Name: zone.Name, Region: path.Base(zone.Region)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// GetInstanceTypes returns the machine types available in zone
+func (p *GCloud) GetInstanceTypes(ctx *Context, zone string) ([]InstanceType, error) {
+	types := []InstanceType{}
+	err := p.Service.MachineTypes.List(ctx.config.CloudConfig.ProjectID, zone).Pages(context.TODO(), func(page *compute.MachineTypeList) error {
+		for _, machineType := range page.Items {
+			types = append(types, InstanceType{
+				Name:     machineType.Name,
+				VCPUs:    int(machineType.GuestCpus),
+				MemoryMB: int(machineType.MemoryMb),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
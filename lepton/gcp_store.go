@@ -1,23 +1,76 @@
 package lepton
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 
 	storage "cloud.google.com/go/storage"
+	"google.golang.org/api/option"
 )
 
+// gcpStorageClient returns a cloud storage client retrying transient
+// failures with the retry policy of config. Uploads retry their chunks
+// themselves, their requests are sent once.
+func gcpStorageClient(ctx context.Context, config *ProviderConfig) (*storage.Client, error) {
+	client, err := gcpClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewClient(ctx, option.WithHTTPClient(client))
+}
+
 // GCPStorage provides GCP storage related operations
 type GCPStorage struct{}
 
 // CopyToBucket copies archive to bucket
 func (s *GCPStorage) CopyToBucket(config *Config, archPath string) error {
+	f, err := os.Open(archPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var total int64
+	if fi, err := f.Stat(); err == nil {
+		total = fi.Size()
+	}
 
+	// the crc32c of the archive is sent with it for gcs to check the object
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := h.Sum32()
+
+	return s.upload(config, filepath.Base(archPath), total, &sum, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+// StreamToBucket writes the tar.gz archive of files to the object of the
+// bucket as it is compressed, without a local copy of the archive
+func (s *GCPStorage) StreamToBucket(config *Config, object string, files []string) error {
+	return s.upload(config, object, 0, nil, func(w io.Writer) error {
+		return writeArchive(w, files)
+	})
+}
+
+// upload writes the object of the bucket of config with write, creating the
+// bucket if needed. total is the size of the object and crc32c its
+// checksum, when they are known.
+func (s *GCPStorage) upload(config *Config, object string, total int64, crc32c *uint32, write func(w io.Writer) error) error {
 	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+	client, err := gcpStorageClient(ctx, &config.CloudConfig)
 	if err != nil {
 		fmt.Println(err)
 		fmt.Println("Have you set GOOGLE_APPLICATION_CREDENTIALS?")
@@ -38,17 +91,73 @@ func (s *GCPStorage) CopyToBucket(config *Config, archPath string) error {
 		fmt.Println("bucket found:", config.CloudConfig.BucketName)
 	}
 
-	wr := bucket.Object(filepath.Base(archPath)).NewWriter(ctx)
-	f, err := os.Open(archPath)
-	if err != nil {
-		return err
+	wr := bucket.Object(object).NewWriter(ctx)
+	if crc32c != nil {
+		wr.CRC32C = *crc32c
+		wr.SendCRC32C = true
 	}
-	defer f.Close()
-	if _, err = io.Copy(wr, f); err != nil {
-		return err
+	// the writer does a resumable upload in chunks and retries chunks failing
+	// with transient errors, rather than restarting the upload
+	counter := &countingWriter{w: wr}
+	if err := write(counter); err != nil {
+		return &UploadError{Uploaded: counter.n, Total: total, Err: err}
 	}
 	if err = wr.Close(); err != nil {
-		return err
+		return &UploadError{Uploaded: counter.n, Total: total, Err: err}
 	}
 	return nil
 }
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// diskSHA256 downloads the image archive object of bucket and returns the
+// sha256 of the disk.raw it holds
+func (s *GCPStorage) diskSHA256(config *ProviderConfig, bucket string, object string) (string, error) {
+	ctx := context.Background()
+	client, err := gcpStorageClient(ctx, config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("reading gs://%s/%s: %v", bucket, object, err)
+	}
+	defer r.Close()
+
+	return archiveDiskSHA256(r)
+}
+
+// archiveDiskSHA256 returns the sha256 of the disk.raw of the image archive r
+func archiveDiskSHA256(r io.Reader) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("no disk.raw in the image archive")
+		}
+		if err != nil {
+			return "", err
+		}
+		if filepath.Base(hdr.Name) == "disk.raw" {
+			return readerSHA256(tr)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package lepton
+
+import "testing"
+
+func TestCheckGcpLabels(t *testing.T) {
+	valid := []map[string]string{
+		{"createdby": "ops"},
+		{"env": "", "team_a": "2021-q1"},
+	}
+	for _, labels := range valid {
+		if err := checkGcpLabels(labels); err != nil {
+			t.Errorf("expected %v to be valid, got %v", labels, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"Env": "prod"},
+		{"1env": "prod"},
+		{"env": "Prod"},
+		{"env": "a.b"},
+	}
+	for _, labels := range invalid {
+		if err := checkGcpLabels(labels); err == nil {
+			t.Errorf("expected %v to be invalid", labels)
+		}
+	}
+}
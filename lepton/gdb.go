@@ -0,0 +1,67 @@
+package lepton
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultGdbPort is the port of the gdb stub when RunConfig.GdbPort isn't set
+const DefaultGdbPort = 1234
+
+// kernelSymbolFiles are the files, next to the kernel image, that may hold
+// its symbols, in order of preference
+var kernelSymbolFiles = []string{"kernel.elf", "kernel.dbg"}
+
+// KernelSymbols returns the path of an ELF file with the symbols of the
+// kernel c boots, either a kernel.elf next to the kernel image or the image
+// itself
+func KernelSymbols(c *Config) (string, error) {
+	if c.Kernel == "" {
+		return "", fmt.Errorf("no kernel configured")
+	}
+
+	candidates := []string{}
+	for _, name := range kernelSymbolFiles {
+		candidates = append(candidates, filepath.Join(filepath.Dir(c.Kernel), name))
+	}
+	candidates = append(candidates, c.Kernel)
+
+	for _, candidate := range candidates {
+		if hasSymbols(candidate) {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no kernel symbols found, tried %s", strings.Join(candidates, ", "))
+}
+
+// hasSymbols reports whether path is an ELF file with a symbol table
+func hasSymbols(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	return f.Section(".symtab") != nil
+}
+
+// GdbInstructions returns how to attach gdb to a local run of c started with
+// RunConfig.Debug, loading the kernel symbols when they can be found
+func GdbInstructions(c *Config) string {
+	port := c.RunConfig.GdbPort
+	if port == 0 {
+		port = DefaultGdbPort
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Waiting for gdb connection. Connect to qemu with:\n\n  gdb %s \\\n", c.ProgramPath))
+	if symbols, err := KernelSymbols(c); err == nil {
+		sb.WriteString(fmt.Sprintf("    -ex \"add-symbol-file %s\" \\\n", symbols))
+	}
+	sb.WriteString(fmt.Sprintf("    -ex \"target remote localhost:%d\"\n\n", port))
+	sb.WriteString("See further instructions in https://nanovms.gitbook.io/ops/debugging\n")
+	return sb.String()
+}
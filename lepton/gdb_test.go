@@ -0,0 +1,93 @@
+package lepton
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestELF writes an ELF file with no code and, if symtab is set, an empty
+// symbol table
+func writeTestELF(t *testing.T, path string, symtab bool) {
+	shstrtab := []byte("\x00.shstrtab\x00.symtab\x00")
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: 64, Size: uint64(len(shstrtab))},
+	}
+	if symtab {
+		sections = append(sections, elf.Section64{Name: 11, Type: uint32(elf.SHT_SYMTAB), Entsize: 24})
+	}
+
+	shoff := 64 + uint64(len(shstrtab))
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shoff,
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  1,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(shstrtab)
+	binary.Write(&buf, binary.LittleEndian, sections)
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKernelSymbols(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kernel := filepath.Join(dir, "kernel.img")
+	c := &Config{Kernel: kernel}
+
+	t.Run("should fail without symbols", func(t *testing.T) {
+		writeTestELF(t, kernel, false)
+		if _, err := KernelSymbols(c); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("should use a kernel image with symbols", func(t *testing.T) {
+		writeTestELF(t, kernel, true)
+		if symbols, err := KernelSymbols(c); symbols != kernel {
+			t.Errorf("got %s, %v", symbols, err)
+		}
+	})
+
+	t.Run("should prefer kernel.elf", func(t *testing.T) {
+		elfPath := filepath.Join(dir, "kernel.elf")
+		writeTestELF(t, elfPath, true)
+		if symbols, err := KernelSymbols(c); symbols != elfPath {
+			t.Errorf("got %s, %v", symbols, err)
+		}
+	})
+}
+
+func TestGdbInstructions(t *testing.T) {
+	c := &Config{ProgramPath: "/src/main", Kernel: "/nonexistent/kernel.img"}
+	c.RunConfig.GdbPort = 4321
+
+	instructions := GdbInstructions(c)
+	if !strings.Contains(instructions, "gdb /src/main") || !strings.Contains(instructions, "target remote localhost:4321") {
+		t.Errorf("got %s", instructions)
+	}
+}
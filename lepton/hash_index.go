@@ -0,0 +1,127 @@
+package lepton
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// hashIndexFile is the file of the ops home the hash index is kept in
+const hashIndexFile = "hash-index.json"
+
+// hashChunkSize is the size of the reads hashing files, large files are
+// hashed a chunk at a time instead of being read whole
+const hashChunkSize = 4 << 20
+
+// hashIndexEntry is the sha256 of a host file at the size and modification
+// time it had when hashed
+type hashIndexEntry struct {
+	Size    int64
+	ModTime int64 // unix nanoseconds
+	SHA256  string
+}
+
+// hashIndex keeps the sha256 of the host files of images across builds, so
+// files that didn't change are not hashed again. Files are keyed by their
+// absolute path and considered unchanged when their size and modification
+// time are.
+type hashIndex struct {
+	path    string
+	entries map[string]hashIndexEntry
+	dirty   bool
+}
+
+// loadHashIndex reads the hash index at path. A missing or unreadable index
+// is an empty one, it only costs hashing the files again.
+func loadHashIndex(path string) *hashIndex {
+	ix := &hashIndex{path: path, entries: map[string]hashIndexEntry{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ix
+	}
+	if err := json.Unmarshal(data, &ix.entries); err != nil {
+		ix.entries = map[string]hashIndexEntry{}
+	}
+	return ix
+}
+
+// sha256 returns the hex sha256 of the content of the file at path, from the
+// index when the file didn't change since it was hashed
+func (ix *hashIndex) sha256(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if e, ok := ix.entries[path]; ok && e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano() {
+		return e.SHA256, nil
+	}
+
+	hash, err := chunkedSHA256(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	ix.entries[path] = hashIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), SHA256: hash}
+	ix.dirty = true
+	return hash, nil
+}
+
+// save writes the index when files were hashed, dropping the entries of
+// files that don't exist anymore
+func (ix *hashIndex) save() error {
+	if !ix.dirty {
+		return nil
+	}
+	for path := range ix.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(ix.entries, path)
+		}
+	}
+	data, err := json.Marshal(ix.entries)
+	if err != nil {
+		return err
+	}
+
+	// a build interrupted while saving leaves the previous index
+	tmp := ix.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ix.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
+
+// chunkedSHA256 returns the hex sha256 of the content of r read in chunks of
+// hashChunkSize
+func chunkedSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	buf := make([]byte, hashChunkSize)
+	for {
+		n, err := r.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
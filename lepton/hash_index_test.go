@@ -0,0 +1,102 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	index := filepath.Join(dir, hashIndexFile)
+	ix := loadHashIndex(index)
+	hash, err := ix.sha256(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != helloSHA256 {
+		t.Errorf("got %s, want %s", hash, helloSHA256)
+	}
+	if err := ix.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should not hash unchanged files again", func(t *testing.T) {
+		ix := loadHashIndex(index)
+		e := ix.entries[file]
+		e.SHA256 = "cached"
+		ix.entries[file] = e
+		if hash, err := ix.sha256(file); err != nil || hash != "cached" {
+			t.Errorf("got %s, %v, want the indexed hash", hash, err)
+		}
+		if ix.dirty {
+			t.Error("expected the index not to change")
+		}
+	})
+
+	t.Run("should hash changed files again", func(t *testing.T) {
+		ix := loadHashIndex(index)
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+		e := ix.entries[file]
+		e.SHA256 = "stale"
+		ix.entries[file] = e
+		if hash, err := ix.sha256(file); err != nil || hash != helloSHA256 {
+			t.Errorf("got %s, %v, want %s", hash, err, helloSHA256)
+		}
+	})
+
+	t.Run("should drop missing files", func(t *testing.T) {
+		ix := loadHashIndex(index)
+		ix.entries[filepath.Join(dir, "missing")] = hashIndexEntry{SHA256: "x"}
+		ix.dirty = true
+		if err := ix.save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := loadHashIndex(index).entries[filepath.Join(dir, "missing")]; ok {
+			t.Error("expected the missing file to be dropped")
+		}
+	})
+}
+
+func TestManifestUseHashIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index := filepath.Join(dir, hashIndexFile)
+	m := NewManifest("")
+	m.UseHashIndex(index)
+	if err := m.AddFile("/data", file); err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := m.HashFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := loadHashIndex(index).entries[file]; !ok || e.SHA256 != hashes["/data"] {
+		t.Errorf("expected %s in the index, got %+v", hashes["/data"], e)
+	}
+}
@@ -2,6 +2,7 @@ package lepton
 
 import (
 	"C"
+	"errors"
 	"fmt"
 	"os"
 	"unsafe"
@@ -41,6 +42,10 @@ func hvSupport() (bool, error) {
 	return resBuf != 0, nil
 }
 
+func kvmAvailable() error {
+	return errors.New("kvm is only available on linux")
+}
+
 func sysctl(mib []C.int, old *byte, oldlen *uintptr, new *byte, newlen uintptr) (err error) {
 	var _zero uintptr
 	var _p0 unsafe.Pointer
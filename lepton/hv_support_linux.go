@@ -3,6 +3,9 @@ package lepton
 import (
 	"io/ioutil"
 	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func hvSupport() (bool, error) {
@@ -35,3 +38,8 @@ func hvSupport() (bool, error) {
 
 	return false, nil
 }
+
+// kvmAvailable checks /dev/kvm exists and the user can use it
+func kvmAvailable() error {
+	return syscall.Access("/dev/kvm", unix.R_OK|unix.W_OK)
+}
@@ -1,5 +1,19 @@
 package lepton
 
+import (
+	"errors"
+	"syscall"
+)
+
+// hvSupport checks the Windows Hypervisor Platform, which whpx runs on, is
+// enabled
 func hvSupport() (bool, error) {
-	return false, nil
+	if err := syscall.NewLazyDLL("WinHvPlatform.dll").Load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func kvmAvailable() error {
+	return errors.New("kvm is only available on linux")
 }
@@ -28,6 +28,12 @@ type Hypervisor interface {
 	Start(rconfig *RunConfig) error
 	Command(rconfig *RunConfig) *exec.Cmd
 	Stop()
+	Reset() error
+	Pause() error
+	Resume() error
+	Suspend(file string) error
+	Screenshot(file string) error
+	Report() RunReport
 }
 
 // available hypervisors
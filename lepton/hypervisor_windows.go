@@ -26,7 +26,15 @@ type Hypervisor interface {
 	Start(rconfig *RunConfig) error
 	Command(rconfig *RunConfig) *exec.Cmd
 	Stop()
+	Reset() error
+	Pause() error
+	Resume() error
+	Suspend(file string) error
+	Screenshot(file string) error
+	Report() RunReport
 }
 
 // available hypervisors
-var hypervisors = map[string]func() Hypervisor{}
+var hypervisors = map[string]func() Hypervisor{
+	"qemu-system-x86_64": newQemu,
+}
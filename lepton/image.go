@@ -9,8 +9,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -21,32 +23,57 @@ var localImageDir = path.Join(GetOpsHome(), "images")
 // BuildImage builds a unikernel image for user
 // supplied ELF binary.
 func BuildImage(c Config) error {
-	m, err := BuildManifest(&c)
-	if err != nil {
-		return errors.Wrap(err, 1)
-	}
-
-	if err = buildImage(&c, m); err != nil {
-		return errors.Wrap(err, 1)
-	}
+	return buildImageWithHooks(&c, BuildManifest)
+}
 
-	return nil
+// BuildImageFromManifest builds a unikernel image from the manifest m, like
+// one read with ReadManifestJSON, instead of the program of c
+func BuildImageFromManifest(c Config, m *Manifest) error {
+	return buildImageWithHooks(&c, func(c *Config) (*Manifest, error) {
+		return m, nil
+	})
 }
 
 // rebuildImage rebuilds a unikernel image for user
 // supplied ELF binary after volume attach/detach
 func rebuildImage(c Config) error {
 	c.Program = c.ProgramPath
-	m, err := BuildManifest(&c)
+	return buildImageWithHooks(&c, BuildManifest)
+}
+
+// buildImageWithHooks resolves the manifest with resolve and writes the
+// image, running the configured build hooks around both steps.
+func buildImageWithHooks(c *Config, resolve func(c *Config) (*Manifest, error)) error {
+	report := newBuildReport(c)
+
+	if err := ResolveTargetRoot(c); err != nil {
+		return err
+	}
+
+	if err := c.BuildHooks.Run(PreResolve, report); err != nil {
+		return err
+	}
+
+	var m *Manifest
+	err := newBuildProfiler(c.ProfileDir).profile("resolve", func() (err error) {
+		m, err = resolve(c)
+		return err
+	})
 	if err != nil {
 		return errors.Wrap(err, 1)
 	}
+	report.Manifest = m
+	report.Warnings = m.Warnings()
 
-	if err = buildImage(&c, m); err != nil {
-		return errors.Wrap(err, 1)
+	if err := c.BuildHooks.Run(PostResolve, report); err != nil {
+		return err
 	}
 
-	return nil
+	if err := checkWarnings(report.Warnings, c.FailOnWarnings); err != nil {
+		return err
+	}
+
+	return buildImage(c, m, report)
 }
 
 func createFile(filepath string) (*os.File, error) {
@@ -72,7 +99,7 @@ func addDNSConfig(m *Manifest, c *Config) {
 	if err != nil {
 		panic(err)
 	}
-	err = m.AddFile("/etc/resolv.conf", resolv)
+	err = m.addHostFile("/etc/resolv.conf", resolv)
 	if err != nil {
 		panic(err)
 	}
@@ -87,7 +114,7 @@ func addHostName(m *Manifest, c *Config) {
 	if err != nil {
 		panic(err)
 	}
-	err = m.AddFile("/proc/sys/kernel/hostname", hostname)
+	err = m.addHostFile("/proc/sys/kernel/hostname", hostname)
 	if err != nil {
 		panic(err)
 	}
@@ -105,7 +132,7 @@ func addPasswd(m *Manifest, c *Config) {
 	if err != nil {
 		panic(err)
 	}
-	err = m.AddFile("/etc/passwd", passwd)
+	err = m.addHostFile("/etc/passwd", passwd)
 	if err != nil {
 		panic(err)
 	}
@@ -132,7 +159,7 @@ func addDefaultFiles(m *Manifest, c *Config) error {
 
 	localLibDNS := path.Join(commonPath, "libnss_dns.so.2")
 	if _, err := os.Stat(localLibDNS); !os.IsNotExist(err) {
-		err = m.AddFile(libDNS, localLibDNS)
+		err = m.addHostFile(libDNS, localLibDNS)
 		if err != nil {
 			return err
 		}
@@ -140,7 +167,7 @@ func addDefaultFiles(m *Manifest, c *Config) error {
 
 	localSslCert := path.Join(commonPath, "ca-certificates.crt")
 	if _, err := os.Stat(localSslCert); !os.IsNotExist(err) {
-		err = m.AddFile(sslCERT, localSslCert)
+		err = m.addHostFile(sslCERT, localSslCert)
 		if err != nil {
 			return err
 		}
@@ -149,29 +176,36 @@ func addDefaultFiles(m *Manifest, c *Config) error {
 	return nil
 }
 
-func addFilesFromPackage(packagepath string, m *Manifest) {
+// addFilesFromPackage adds the files of the sysroot of the package at
+// packagepath to the root of the image, and the other files of the package
+// under its name
+func addFilesFromPackage(packagepath string, m *Manifest) error {
 
 	rootPath := filepath.Join(packagepath, "sysroot")
 	packageName := filepath.Base(packagepath)
 
-	filepath.Walk(rootPath, func(hostpath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	// packages without a sysroot only have files of their own
+	if _, err := os.Stat(rootPath); err == nil {
+		err = filepath.Walk(rootPath, func(hostpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
 
-		if info.IsDir() {
-			return nil
-		}
+			if info.IsDir() {
+				return nil
+			}
 
-		filePath := strings.Split(hostpath, rootPath)
-		err = m.AddFile(filePath[1], hostpath)
+			filePath := strings.Split(hostpath, rootPath)
+			return m.AddFile(filePath[1], hostpath)
+		})
 		if err != nil {
 			return err
 		}
-		return nil
-	})
+	} else if !os.IsNotExist(err) {
+		return err
+	}
 
-	filepath.Walk(packagepath, func(hostpath string, info os.FileInfo, err error) error {
+	return filepath.Walk(packagepath, func(hostpath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -186,24 +220,39 @@ func addFilesFromPackage(packagepath string, m *Manifest) {
 
 		filePath := strings.Split(hostpath, packagepath)
 		vmpath := filepath.Join(string(os.PathSeparator), packageName, filePath[1])
-		err = m.AddFile(vmpath, hostpath)
-		if err != nil {
-			return err
-		}
-		return nil
+		return m.AddFile(vmpath, hostpath)
 	})
 }
 
 // BuildPackageManifest builds manifest using package
 func BuildPackageManifest(packagepath string, c *Config) (*Manifest, error) {
 	m := NewManifest(c.TargetRoot)
+	m.SetGeneratedDir(getImageTempDir(c))
+	m.SetStrictTargetRoot(c.TargetRootStrict)
+	m.SetMaterializeSymlinks(c.MaterializeSymlinks)
+	m.SetResolveSymlinks(c.ResolveSymlinks)
+	if c.SymlinkPolicy != "" {
+		if err := m.SetSymlinkPolicy(c.SymlinkPolicy); err != nil {
+			return nil, err
+		}
+	}
+	modTime, err := ParseModTime(c.ModTime)
+	if err != nil {
+		return nil, err
+	}
+	m.SetModTime(modTime)
+	if err := m.SetExcludes(c.Exclude); err != nil {
+		return nil, err
+	}
 
 	// Add files from package
-	addFilesFromPackage(packagepath, m)
+	if err := addFilesFromPackage(packagepath, m); err != nil {
+		return nil, err
+	}
 
 	m.nightly = c.NightlyBuild
 	m.program = c.Program
-	err := addFromConfig(m, c)
+	err = addFromConfig(m, c)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
@@ -221,11 +270,28 @@ func BuildPackageManifest(packagepath string, c *Config) (*Manifest, error) {
 
 func addFromConfig(m *Manifest, c *Config) error {
 	m.AddKernel(c.Kernel)
+	m.SetKernelTuples(c.KernelTuples)
 	addDNSConfig(m, c)
 	addHostName(m, c)
 	addPasswd(m, c)
-	m.AddKlibs(c.RunConfig.Klibs)
+	if err := m.AddKlibs(c.RunConfig.Klibs); err != nil {
+		return err
+	}
+	for _, dir := range c.RunConfig.KlibDirs {
+		m.AddKlibDir(dir)
+	}
+	klibNames := make([]string, 0, len(c.RunConfig.KlibPaths))
+	for name := range c.RunConfig.KlibPaths {
+		klibNames = append(klibNames, name)
+	}
+	sort.Strings(klibNames)
+	for _, name := range klibNames {
+		if err := m.SetKlibPath(name, c.RunConfig.KlibPaths[name]); err != nil {
+			return err
+		}
+	}
 
+	m.files.resolveAll(m.targetRoot, c.Files, m.strictTargetRoot)
 	for _, f := range c.Files {
 		err := m.AddFile(f, f)
 		if err != nil {
@@ -233,11 +299,8 @@ func addFromConfig(m *Manifest, c *Config) error {
 		}
 	}
 
-	for k, v := range c.MapDirs {
-		err := addMappedFiles(k, v, m)
-		if err != nil {
-			return err
-		}
+	if err := m.MapDirs(c.MapDirs); err != nil {
+		return err
 	}
 
 	for _, d := range c.Dirs {
@@ -251,8 +314,12 @@ func addFromConfig(m *Manifest, c *Config) error {
 		m.AddArgument(a)
 	}
 
-	if c.RebootOnExit {
-		m.AddDebugFlag("reboot_on_exit", 't')
+	if err := m.SetExitPolicy(exitPolicy(c)); err != nil {
+		return err
+	}
+
+	if c.GrowRootFS {
+		m.AddDebugFlag("grow_root", 't')
 	}
 
 	for _, dbg := range c.Debugflags {
@@ -270,34 +337,91 @@ func addFromConfig(m *Manifest, c *Config) error {
 	for k, v := range c.Env {
 		m.AddEnvironmentVariable(k, v)
 	}
+	if err := m.AddEnvPassthrough(c.EnvPassthrough...); err != nil {
+		return err
+	}
+	if len(c.LibraryPaths) > 0 {
+		ldLibraryPath := strings.Join(c.LibraryPaths, ":")
+		if val := m.environment["LD_LIBRARY_PATH"]; val != "" {
+			ldLibraryPath += ":" + val
+		}
+		m.AddEnvironmentVariable("LD_LIBRARY_PATH", ldLibraryPath)
+	}
 
 	for k, v := range c.Mounts {
 		m.AddMount(k, v)
 	}
 
+	tmpfs := make([]string, 0, len(c.Tmpfs))
+	for vmpath := range c.Tmpfs {
+		tmpfs = append(tmpfs, vmpath)
+	}
+	sort.Strings(tmpfs)
+	for _, vmpath := range tmpfs {
+		if err := m.AddTmpfs(vmpath, c.Tmpfs[vmpath]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // BuildManifest builds manifest using config
 func BuildManifest(c *Config) (*Manifest, error) {
 	m := NewManifest(c.TargetRoot)
+	m.SetGeneratedDir(getImageTempDir(c))
+	m.SetStrictTargetRoot(c.TargetRootStrict)
+	m.SetMaterializeSymlinks(c.MaterializeSymlinks)
+	m.SetResolveSymlinks(c.ResolveSymlinks)
+	if c.SymlinkPolicy != "" {
+		if err := m.SetSymlinkPolicy(c.SymlinkPolicy); err != nil {
+			return nil, err
+		}
+	}
+	modTime, err := ParseModTime(c.ModTime)
+	if err != nil {
+		return nil, err
+	}
+	m.SetModTime(modTime)
+	if err := m.SetExcludes(c.Exclude); err != nil {
+		return nil, err
+	}
 
 	addDefaultFiles(m, c)
 
-	err := addFromConfig(m, c)
+	err = addFromConfig(m, c)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
 
 	m.nightly = c.NightlyBuild
-	m.AddUserProgram(c.Program)
+	if err := m.SetProgram(c.Program); err != nil {
+		return nil, err
+	}
 
-	deps, err := getSharedLibs(c.TargetRoot, c.Program)
+	deps, err := cachedSharedLibs(c.TargetRoot, c.Program, c.TargetRootStrict, c.LibraryPaths)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
 	for _, libpath := range deps {
-		m.AddLibrary(libpath)
+		if err := m.AddLibrary(libpath); err != nil {
+			return nil, err
+		}
+	}
+	nss, err := addNSSModules(m, c, deps)
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+	deps = append(deps, nss...)
+
+	if err := addPrograms(m, c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Policy.AllowedPaths) > 0 {
+		policy := c.Policy
+		policy.AllowedPaths = append(append([]string{}, deps...), c.Policy.AllowedPaths...)
+		m.SetPolicy(policy)
 	}
 
 	if c.RunConfig.IPAddr != "" {
@@ -311,43 +435,193 @@ func BuildManifest(c *Config) (*Manifest, error) {
 	return m, nil
 }
 
-func addMappedFiles(src string, dest string, m *Manifest) error {
-	dir, pattern := filepath.Split(src)
-	err := filepath.Walk(dir, func(hostpath string, info os.FileInfo, err error) error {
-		if err != nil {
+// sharedLibsCache holds the libraries of the programs built so far, builds
+// of the same program, like those of a build matrix, resolve them once
+var sharedLibsCache = struct {
+	sync.Mutex
+	libs map[string][]string
+}{libs: map[string][]string{}}
+
+// AddSetupFlags adds the setup programs of flags like "./migrate up" to
+// Setup, the first word is the program and the rest its arguments
+func AddSetupFlags(setups []string, config *Config) error {
+	for _, setup := range setups {
+		fields := strings.Fields(setup)
+		if len(fields) == 0 {
+			return fmt.Errorf("setup program invalid: %q", setup)
+		}
+		args := append([]string{filepath.Base(fields[0])}, fields[1:]...)
+		config.Setup = append(config.Setup, SetupProgram{Program: fields[0], Args: args})
+	}
+	return nil
+}
+
+// AddProgramFlags adds the programs of flags like worker=./worker to
+// Programs
+func AddProgramFlags(programs []string, config *Config) error {
+	for _, program := range programs {
+		i := strings.Index(program, "=")
+		if i <= 0 || i == len(program)-1 {
+			return fmt.Errorf("program invalid: %s, expected name=path", program)
+		}
+		if config.Programs == nil {
+			config.Programs = make(map[string]string)
+		}
+		config.Programs[program[:i]] = program[i+1:]
+	}
+	return nil
+}
+
+// addPrograms adds the programs of c selectable at boot and its setup
+// programs with their shared libraries, and makes the program of
+// RunConfig.Exec the default one
+func addPrograms(m *Manifest, c *Config) error {
+	names := make([]string, 0, len(c.Programs))
+	for name := range c.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		program := c.Programs[name]
+		if err := m.AddProgram(name, program); err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
+		if err := addProgramLibs(m, c, program); err != nil {
+			return err
 		}
-		hostdir, filename := filepath.Split(hostpath)
-		matched, _ := filepath.Match(pattern, filename)
-		if matched {
-			reldir, err := filepath.Rel(dir, hostdir)
-			if err != nil {
-				return err
-			}
-			vmpath := filepath.Join(dest, reldir, filename)
-			err = m.AddFile(vmpath, hostpath)
-			if err != nil {
-				return err
-			}
+	}
+
+	for _, setup := range c.Setup {
+		if err := m.AddSetupProgram(setup.Program, setup.Args); err != nil {
+			return err
 		}
-		return nil
-	})
-	return err
+		if err := addProgramLibs(m, c, setup.Program); err != nil {
+			return err
+		}
+	}
+
+	if c.RunConfig.Exec != "" {
+		return m.SelectProgram(c.RunConfig.Exec)
+	}
+	return nil
+}
+
+// addProgramLibs adds the shared libraries of program
+func addProgramLibs(m *Manifest, c *Config, program string) error {
+	deps, err := cachedSharedLibs(c.TargetRoot, program, m.strictTargetRoot, c.LibraryPaths)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+	for _, libpath := range deps {
+		if err := m.AddLibrary(libpath); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func buildImage(c *Config, m *Manifest) error {
-	//  prepare manifest file
-	var elfmanifest string
-	elfmanifest = m.String()
-	if c.ManifestName != "" {
-		err := ioutil.WriteFile(c.ManifestName, []byte(elfmanifest), 0644)
+func cachedSharedLibs(targetRoot string, program string, strict bool, libDirs []string) ([]string, error) {
+	fi, err := os.Stat(program)
+	if err != nil {
+		return getSharedLibs(targetRoot, program, strict, libDirs)
+	}
+	abs, _ := filepath.Abs(program)
+	key := fmt.Sprintf("%s:%v:%s:%s:%d:%d", targetRoot, strict, strings.Join(libDirs, ":"), abs, fi.Size(), fi.ModTime().UnixNano())
+
+	sharedLibsCache.Lock()
+	libs, ok := sharedLibsCache.libs[key]
+	sharedLibsCache.Unlock()
+	if ok {
+		return append([]string(nil), libs...), nil
+	}
+
+	libs, err = getSharedLibs(targetRoot, program, strict, libDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedLibsCache.Lock()
+	sharedLibsCache.libs[key] = libs
+	sharedLibsCache.Unlock()
+	return append([]string(nil), libs...), nil
+}
+
+func buildImage(c *Config, m *Manifest, report *BuildReport) error {
+	// klibs of another kernel crash at boot, fail before writing the image
+	if err := m.checkKlibs(); err != nil {
+		return err
+	}
+	// kernels ignore the tuples they don't read, the image would boot
+	// without the features it was built with
+	if err := m.checkKernelTuples(); err != nil {
+		return err
+	}
+	// programs whose arguments don't fit their stack fail at boot
+	if err := m.checkExecSize(); err != nil {
+		return err
+	}
+	// a boot fs larger than its partition overwrites the klog region
+	if c.BootFSSize != "" {
+		size, err := parseBytes(c.BootFSSize)
 		if err != nil {
-			return errors.Wrap(err, 1)
+			return fmt.Errorf("invalid BootFSSize %q: %v", c.BootFSSize, err)
 		}
+		m.SetBootFSSize(size)
+	}
+	bootStats, err := m.checkBootFSSize()
+	report.BootFS = bootStats
+	if err != nil {
+		return err
+	}
+
+	if err := buildDataVolumes(c, m); err != nil {
+		return err
+	}
+
+	if c.Deduplicate {
+		dedup, err := m.Deduplicate()
+		if err != nil {
+			return err
+		}
+		report.Deduplicated = dedup
+	}
+
+	if c.FileHashes {
+		m.UseHashIndex(path.Join(GetOpsHome(), hashIndexFile))
+		hashes, err := m.HashFiles()
+		if err != nil {
+			return err
+		}
+		report.FileHashes = hashes
+	}
+
+	stats, err := m.FileStats()
+	if err != nil {
+		return err
+	}
+	report.FileStats = stats
+
+	provenance, err := m.Provenance()
+	if err != nil {
+		return err
+	}
+	report.Provenance = provenance
+
+	// the manifest is written to disk and streamed to mkfs from there, so
+	// its text is never whole in memory
+	manifestPath := c.ManifestName
+	if manifestPath == "" {
+		manifestPath = path.Join(getImageTempDir(c), "manifest")
+	}
+	if err := writeManifestFile(m, manifestPath); err != nil {
+		return errors.Wrap(err, 1)
+	}
+	elfmanifest, err := os.Open(manifestPath)
+	if err != nil {
+		return errors.Wrap(err, 1)
 	}
+	defer elfmanifest.Close()
 
 	// produce final image, boot + kernel + elf
 	fd, err := createFile(c.RunConfig.Imagename)
@@ -360,19 +634,24 @@ func buildImage(c *Config, m *Manifest) error {
 
 	defer cleanup(c)
 
-	mkfsCommand := NewMkfsCommand(c.Mkfs)
-
-	if c.TargetRoot != "" {
-		mkfsCommand.SetTargetRoot(c.TargetRoot)
+	mkfsCommand := NewMkfsCommandFromConfig(c)
+	if c.ManifestUUID {
+		uuid, err := readerHashUUID(elfmanifest)
+		if err != nil {
+			return errors.Wrap(err, 1)
+		}
+		if _, err := elfmanifest.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, 1)
+		}
+		mkfsCommand.SetUUID(uuid)
+	} else if err := mkfsCommand.SetUUIDSource(c.sources()); err != nil {
+		return err
 	}
 
-	if c.BaseVolumeSz != "" {
-		mkfsCommand.SetFileSystemSize(c.BaseVolumeSz)
+	if err := mkfsCommand.RunHooks(PreWrite, report); err != nil {
+		return err
 	}
 
-	mkfsCommand.SetBoot(c.Boot)
-	mkfsCommand.SetFileSystemPath(c.RunConfig.Imagename)
-
 	mkfsCommand.SetupCommand()
 	stdin, err := mkfsCommand.GetStdinPipe()
 	if err != nil {
@@ -381,18 +660,55 @@ func buildImage(c *Config, m *Manifest) error {
 
 	go func() {
 		defer stdin.Close()
-		io.WriteString(stdin, elfmanifest)
+		io.Copy(stdin, elfmanifest)
 	}()
 
-	err = mkfsCommand.Execute()
+	err = newBuildProfiler(c.ProfileDir).profile("mkfs", mkfsCommand.Execute)
+	// mkfs has copied the content of the generated files into the image
+	removeErr := m.RemoveGeneratedFiles()
 	if err != nil {
 		log.Println("mkfs:" + string(mkfsCommand.GetOutput()))
-		return errors.Wrap(err, 1)
+		return WithCode(ErrMkfsFailed, errors.Wrap(err, 1))
+	}
+	if removeErr != nil {
+		return errors.Wrap(removeErr, 1)
+	}
+	report.FinishedAt = c.sources().Now()
+	report.UUID = mkfsCommand.GetUUID()
+	report.Label = mkfsCommand.GetLabel()
+
+	if err := mkfsCommand.RunHooks(PostWrite, report); err != nil {
+		return err
 	}
 
+	if c.ReportName != "" {
+		if err := report.WriteFile(c.ReportName); err != nil {
+			return errors.Wrap(err, 1)
+		}
+	}
+
+	event := TelemetryEvent{Event: "build", DurationMs: report.FinishedAt.Sub(report.StartedAt).Nanoseconds() / 1e6}
+	if fi, err := os.Stat(c.RunConfig.Imagename); err == nil {
+		event.ImageSize = fi.Size()
+	}
+	RecordTelemetry(event)
+
 	return nil
 }
 
+// writeManifestFile writes the manifest of m to the file at path
+func writeManifestFile(m *Manifest, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func cleanup(c *Config) {
 	os.RemoveAll(c.BuildDir)
 }
@@ -544,41 +860,5 @@ func DownloadFile(filepath string, url string, timeout int, showProgress bool) e
 }
 
 func lookupFile(targetRoot string, path string) (string, error) {
-	if targetRoot != "" {
-		var targetPath string
-		currentPath := path
-		for {
-			targetPath = filepath.Join(targetRoot, currentPath)
-			fi, err := os.Lstat(targetPath)
-			if err != nil {
-				if !os.IsNotExist(err) {
-					return path, err
-				}
-				// lookup on host
-				break
-			}
-
-			if fi.Mode()&os.ModeSymlink == 0 {
-				// not a symlink found in target root
-				return targetPath, nil
-			}
-
-			currentPath, err = os.Readlink(targetPath)
-			if err != nil {
-				return path, err
-			}
-
-			if currentPath[0] != '/' {
-				// relative symlinks are ok
-				path = targetPath
-				break
-			}
-
-			// absolute symlinks need to be resolved again
-		}
-	}
-
-	_, err := os.Stat(path)
-
-	return path, err
+	return (*fileCache)(nil).lookupFile(targetRoot, path, false)
 }
@@ -0,0 +1,132 @@
+package lepton
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ImageVerifier is implemented by providers that can compare an image they
+// registered to a local image file
+type ImageVerifier interface {
+	VerifyImage(ctx *Context, imagename string, imagePath string) (*ImageVerification, error)
+}
+
+// ImageVerification is the outcome of the comparison of a registered image
+// to a local image file
+type ImageVerification struct {
+	Image string
+	// Method says how the registered image was read, like the checksums of
+	// its snapshot blocks or a download of its content
+	Method string
+	// Mismatches describe the differences found, the image matches when
+	// there are none
+	Mismatches []string
+}
+
+// Match returns whether the registered image has the content of the local one
+func (v *ImageVerification) Match() bool {
+	return len(v.Mismatches) == 0
+}
+
+// VerifyImage compares the image imagename registered with p to the local
+// image file at imagePath. It fails when p can't compare images or when the
+// images differ.
+func VerifyImage(ctx *Context, p Provider, imagename string, imagePath string) (*ImageVerification, error) {
+	verifier, ok := p.(ImageVerifier)
+	if !ok {
+		return nil, fmt.Errorf("the provider can't verify images")
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return nil, err
+	}
+
+	v, err := verifier.VerifyImage(ctx, imagename, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Match() {
+		return v, WithCode(ErrImageMismatch, fmt.Errorf("image %s doesn't match %s: %d differences found", imagename, imagePath, len(v.Mismatches)))
+	}
+	return v, nil
+}
+
+// readerSHA256 returns the hex sha256 of the content of r
+func readerSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// compareFileSHA256 returns the mismatch of the file at path and the sha256
+// of a registered copy, if any
+func compareFileSHA256(path string, remote string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	local, err := readerSHA256(f)
+	if err != nil {
+		return nil, err
+	}
+	if local != remote {
+		return []string{fmt.Sprintf("sha256 is %s, the local image has %s", remote, local)}, nil
+	}
+	return nil, nil
+}
+
+// compareBlocks compares the blocks of r to the base64 sha256 checksums of
+// the blocks of a snapshot, by block index. Snapshots may omit blocks of
+// zeroes.
+func compareBlocks(r io.Reader, blockSize int64, remote map[int64]string) ([]string, error) {
+	zeroSum := sha256.Sum256(make([]byte, blockSize))
+	zero := base64.StdEncoding.EncodeToString(zeroSum[:])
+
+	var mismatches []string
+	seen := map[int64]bool{}
+	for index := int64(0); ; index++ {
+		data, err := readEBSBlock(r, blockSize)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			break
+		}
+		seen[index] = true
+
+		sum, ok := remote[index]
+		if isZeroBlock(data) {
+			if ok && sum != zero {
+				mismatches = append(mismatches, fmt.Sprintf("block %d has data, it is empty in the local image", index))
+			}
+			continue
+		}
+
+		local := sha256.Sum256(data)
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("block %d is missing", index))
+		case sum != base64.StdEncoding.EncodeToString(local[:]):
+			mismatches = append(mismatches, fmt.Sprintf("block %d differs", index))
+		}
+	}
+
+	var extra []int64
+	for index, sum := range remote {
+		if !seen[index] && sum != zero {
+			extra = append(extra, index)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+	for _, index := range extra {
+		mismatches = append(mismatches, fmt.Sprintf("block %d is past the end of the local image", index))
+	}
+	return mismatches, nil
+}
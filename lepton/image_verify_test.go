@@ -0,0 +1,75 @@
+package lepton
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func blockChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestCompareBlocks(t *testing.T) {
+	const blockSize = 4
+	image := []byte("abcd\x00\x00\x00\x00efgh\x00\x00")
+	padded := []byte("ij\x00\x00")
+
+	remote := map[int64]string{
+		0: blockChecksum([]byte("abcd")),
+		1: blockChecksum(make([]byte, blockSize)),
+		2: blockChecksum([]byte("efgh")),
+	}
+	mismatches, err := compareBlocks(bytes.NewReader(image), blockSize, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("expected a match, got %v", mismatches)
+	}
+
+	remote[1] = blockChecksum([]byte("xxxx"))
+	remote[2] = blockChecksum([]byte("efgX"))
+	remote[5] = blockChecksum([]byte("tail"))
+	remote[6] = blockChecksum(make([]byte, blockSize))
+	mismatches, err = compareBlocks(bytes.NewReader(append(image, padded...)), blockSize, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"block 1 has data, it is empty in the local image",
+		"block 2 differs",
+		"block 3 is missing",
+		"block 5 is past the end of the local image",
+	}
+	if !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("got %q, want %q", mismatches, want)
+	}
+}
+
+func TestArchiveDiskSHA256(t *testing.T) {
+	disk := []byte("raw disk content")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"other", "disk.raw"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(disk))})
+		tw.Write(disk)
+	}
+	tw.Close()
+	gz.Close()
+
+	sum, err := archiveDiskSHA256(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := readerSHA256(bytes.NewReader(disk)); sum != want {
+		t.Errorf("got %s, want %s", sum, want)
+	}
+}
@@ -1,12 +1,27 @@
 package lepton
 
 import (
+	"encoding/base64"
 	"strings"
 )
 
 type instance struct {
 	Image string   `json:"image"`
 	Ports []string `json:"ports"`
+	QMP   string   `json:"qmp,omitempty"`
+	Accel string   `json:"accel,omitempty"`
+}
+
+// InstanceSuspender is implemented by providers that can save the state of a
+// running instance to a file and resume it later
+type InstanceSuspender interface {
+	SuspendInstance(ctx *Context, instancename string, file string) error
+}
+
+// InstanceScreenshotter is implemented by providers that can save the
+// display of a running instance to a file
+type InstanceScreenshotter interface {
+	ScreenshotInstance(ctx *Context, instancename string, file string) error
 }
 
 func (in *instance) portList() string {
@@ -17,3 +32,26 @@ func (in *instance) portList() string {
 
 	return strings.TrimRight(s, ", ")
 }
+
+// ExecUserDataProviders are the providers instances are created with the
+// exec=<name> userdata on, selecting the program of the image they run
+var ExecUserDataProviders = []string{"aws", "azure", "gcp"}
+
+// execUserData returns the cloud userdata selecting the program of
+// RunConfig.Exec, empty when it isn't set
+func execUserData(c *Config) string {
+	if c.RunConfig.Exec == "" {
+		return ""
+	}
+	return "exec=" + c.RunConfig.Exec + "\n"
+}
+
+// execUserDataBase64 returns execUserData base64 encoded, as providers take
+// userdata
+func execUserDataBase64(c *Config) string {
+	data := execUserData(c)
+	if data == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
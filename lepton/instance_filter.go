@@ -0,0 +1,132 @@
+package lepton
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InstanceFilter selects instances by tag, image and age, unset fields match
+// every instance
+type InstanceFilter struct {
+	// Tags must all be set on the instance with the same values, an empty
+	// value only requires the tag to be set
+	Tags map[string]string
+
+	// Image is the image the instance was created from
+	Image string
+
+	// OlderThan selects instances created longer ago than this, instances
+	// whose creation time is unknown are never selected
+	OlderThan time.Duration
+}
+
+// Match reports whether instance is selected by the filter at time now
+func (f InstanceFilter) Match(instance CloudInstance, now time.Time) bool {
+	for key, value := range f.Tags {
+		v, ok := instance.Tags[key]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+
+	if f.Image != "" && instance.Image != f.Image {
+		return false
+	}
+
+	if f.OlderThan > 0 {
+		if instance.CreatedAt.IsZero() || now.Sub(instance.CreatedAt) < f.OlderThan {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterInstances returns the instances selected by filter
+func FilterInstances(instances []CloudInstance, filter InstanceFilter) []CloudInstance {
+	now := time.Now()
+	selected := []CloudInstance{}
+	for _, instance := range instances {
+		if filter.Match(instance, now) {
+			selected = append(selected, instance)
+		}
+	}
+	return selected
+}
+
+// GetInstancesWithFilter returns the instances of provider selected by filter
+func GetInstancesWithFilter(p Provider, ctx *Context, filter InstanceFilter) ([]CloudInstance, error) {
+	now := time.Now()
+	selected := []CloudInstance{}
+
+	it := IterateInstances(p, ctx)
+	for {
+		instance, err := it.Next()
+		if err == ErrIteratorDone {
+			return selected, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filter.Match(*instance, now) {
+			selected = append(selected, *instance)
+		}
+	}
+}
+
+// BulkInstanceError is returned by bulk operations that failed on some of the
+// selected instances
+type BulkInstanceError struct {
+	Op     string
+	Errors map[string]error
+}
+
+func (e *BulkInstanceError) Error() string {
+	failed := []string{}
+	for name, err := range e.Errors {
+		failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+	}
+	return fmt.Sprintf("failed to %s %d instances: %s", e.Op, len(e.Errors), strings.Join(failed, "; "))
+}
+
+// ErrorCode returns the code of partially failed bulk operations
+func (e *BulkInstanceError) ErrorCode() ErrorCode {
+	return ErrInstanceBulkFailed
+}
+
+// StopInstances stops every instance of provider selected by filter and
+// returns the instances it stopped
+func StopInstances(p Provider, ctx *Context, filter InstanceFilter) ([]CloudInstance, error) {
+	return bulkInstanceOp(p, ctx, filter, "stop", p.StopInstance)
+}
+
+// DeleteInstances deletes every instance of provider selected by filter and
+// returns the instances it deleted
+func DeleteInstances(p Provider, ctx *Context, filter InstanceFilter) ([]CloudInstance, error) {
+	return bulkInstanceOp(p, ctx, filter, "delete", p.DeleteInstance)
+}
+
+// bulkInstanceOp runs op on every selected instance, carrying on when it fails
+// on some of them
+func bulkInstanceOp(p Provider, ctx *Context, filter InstanceFilter, name string, op func(ctx *Context, instancename string) error) ([]CloudInstance, error) {
+	instances, err := GetInstancesWithFilter(p, ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []CloudInstance{}
+	errs := map[string]error{}
+	for _, instance := range instances {
+		if err := op(ctx, instance.Name); err != nil {
+			errs[instance.Name] = err
+			continue
+		}
+		done = append(done, instance)
+	}
+
+	if len(errs) > 0 {
+		return done, &BulkInstanceError{Op: name, Errors: errs}
+	}
+	return done, nil
+}
@@ -0,0 +1,91 @@
+package lepton
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeInstanceProvider serves instances from memory, only the instance
+// methods used by the bulk operations are implemented
+type fakeInstanceProvider struct {
+	Provider
+	instances []CloudInstance
+	deleted   []string
+	fail      map[string]bool
+}
+
+func (p *fakeInstanceProvider) GetInstances(ctx *Context) ([]CloudInstance, error) {
+	return p.instances, nil
+}
+
+func (p *fakeInstanceProvider) DeleteInstance(ctx *Context, instancename string) error {
+	if p.fail[instancename] {
+		return errors.New("boom")
+	}
+	p.deleted = append(p.deleted, instancename)
+	return nil
+}
+
+func TestInstanceFilter(t *testing.T) {
+	now := time.Now()
+	instance := CloudInstance{
+		Name:      "web",
+		Image:     "web-image",
+		CreatedAt: now.Add(-72 * time.Hour),
+		Tags:      map[string]string{"branch": "x", "team": "a"},
+	}
+
+	tests := []struct {
+		filter InstanceFilter
+		want   bool
+	}{
+		{InstanceFilter{}, true},
+		{InstanceFilter{Tags: map[string]string{"branch": "x"}}, true},
+		{InstanceFilter{Tags: map[string]string{"branch": ""}}, true},
+		{InstanceFilter{Tags: map[string]string{"branch": "y"}}, false},
+		{InstanceFilter{Tags: map[string]string{"owner": ""}}, false},
+		{InstanceFilter{Image: "web-image"}, true},
+		{InstanceFilter{Image: "other"}, false},
+		{InstanceFilter{OlderThan: 48 * time.Hour}, true},
+		{InstanceFilter{OlderThan: 96 * time.Hour}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Match(instance, now); got != tt.want {
+			t.Errorf("%+v: got %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	if (InstanceFilter{OlderThan: time.Hour}).Match(CloudInstance{}, now) {
+		t.Error("instances with unknown creation time should not match an age filter")
+	}
+}
+
+func TestDeleteInstances(t *testing.T) {
+	old := time.Now().Add(-72 * time.Hour)
+	p := &fakeInstanceProvider{
+		instances: []CloudInstance{
+			{Name: "a", CreatedAt: old, Tags: map[string]string{"branch": "x"}},
+			{Name: "b", CreatedAt: time.Now(), Tags: map[string]string{"branch": "x"}},
+			{Name: "c", CreatedAt: old, Tags: map[string]string{"branch": "y"}},
+			{Name: "d", CreatedAt: old, Tags: map[string]string{"branch": "x"}},
+		},
+		fail: map[string]bool{"d": true},
+	}
+
+	filter := InstanceFilter{Tags: map[string]string{"branch": "x"}, OlderThan: 48 * time.Hour}
+	deleted, err := DeleteInstances(p, NewContext(NewConfig()), filter)
+
+	var bulkErr *BulkInstanceError
+	if !errors.As(err, &bulkErr) || len(bulkErr.Errors) != 1 || bulkErr.Errors["d"] == nil {
+		t.Fatalf("expected d to fail, got %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].Name != "a" {
+		t.Errorf("got deleted %v", deleted)
+	}
+	if !reflect.DeepEqual(p.deleted, []string{"a"}) {
+		t.Errorf("got provider deletes %v", p.deleted)
+	}
+}
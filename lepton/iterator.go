@@ -0,0 +1,134 @@
+package lepton
+
+import "errors"
+
+// ErrIteratorDone is returned by iterators when there are no more items
+var ErrIteratorDone = errors.New("no more items in iterator")
+
+// InstanceIterator lists instances a page at a time, so only one page is
+// held in memory
+type InstanceIterator struct {
+	next func() ([]CloudInstance, bool, error)
+	page []CloudInstance
+	more bool
+	err  error
+}
+
+// NewInstanceIterator returns an iterator over the pages returned by next,
+// which reports whether there are more pages after the one it returns
+func NewInstanceIterator(next func() ([]CloudInstance, bool, error)) *InstanceIterator {
+	return &InstanceIterator{next: next, more: true}
+}
+
+// Next returns the next instance, or ErrIteratorDone after the last one
+func (it *InstanceIterator) Next() (*CloudInstance, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if !it.more {
+			return nil, ErrIteratorDone
+		}
+		it.page, it.more, it.err = it.next()
+	}
+
+	instance := it.page[0]
+	it.page = it.page[1:]
+	return &instance, nil
+}
+
+// All returns the remaining instances
+func (it *InstanceIterator) All() ([]CloudInstance, error) {
+	instances := []CloudInstance{}
+	for {
+		instance, err := it.Next()
+		if err == ErrIteratorDone {
+			return instances, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, *instance)
+	}
+}
+
+// ImageIterator lists images a page at a time, so only one page is held in
+// memory
+type ImageIterator struct {
+	next func() ([]CloudImage, bool, error)
+	page []CloudImage
+	more bool
+	err  error
+}
+
+// NewImageIterator returns an iterator over the pages returned by next, which
+// reports whether there are more pages after the one it returns
+func NewImageIterator(next func() ([]CloudImage, bool, error)) *ImageIterator {
+	return &ImageIterator{next: next, more: true}
+}
+
+// Next returns the next image, or ErrIteratorDone after the last one
+func (it *ImageIterator) Next() (*CloudImage, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if !it.more {
+			return nil, ErrIteratorDone
+		}
+		it.page, it.more, it.err = it.next()
+	}
+
+	image := it.page[0]
+	it.page = it.page[1:]
+	return &image, nil
+}
+
+// All returns the remaining images
+func (it *ImageIterator) All() ([]CloudImage, error) {
+	images := []CloudImage{}
+	for {
+		image, err := it.Next()
+		if err == ErrIteratorDone {
+			return images, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+}
+
+// InstanceIterable is implemented by providers that list instances in pages
+type InstanceIterable interface {
+	InstanceIterator(ctx *Context) *InstanceIterator
+}
+
+// ImageIterable is implemented by providers that list images in pages
+type ImageIterable interface {
+	ImageIterator(ctx *Context) *ImageIterator
+}
+
+// IterateInstances returns an iterator over the instances of p, providers
+// that don't list instances in pages return them in a single page
+func IterateInstances(p Provider, ctx *Context) *InstanceIterator {
+	if iterable, ok := p.(InstanceIterable); ok {
+		return iterable.InstanceIterator(ctx)
+	}
+	return NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+		instances, err := p.GetInstances(ctx)
+		return instances, false, err
+	})
+}
+
+// IterateImages returns an iterator over the images of p, providers that
+// don't list images in pages return them in a single page
+func IterateImages(p Provider, ctx *Context) *ImageIterator {
+	if iterable, ok := p.(ImageIterable); ok {
+		return iterable.ImageIterator(ctx)
+	}
+	return NewImageIterator(func() ([]CloudImage, bool, error) {
+		images, err := p.GetImages(ctx)
+		return images, false, err
+	})
+}
@@ -0,0 +1,50 @@
+package lepton
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInstanceIterator(t *testing.T) {
+	t.Run("should walk every page, skipping empty ones", func(t *testing.T) {
+		pages := [][]CloudInstance{{{Name: "a"}, {Name: "b"}}, {}, {{Name: "c"}}}
+		fetched := 0
+		it := NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+			page := pages[fetched]
+			fetched++
+			return page, fetched < len(pages), nil
+		})
+
+		instances, err := it.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != 3 || instances[2].Name != "c" {
+			t.Errorf("got %v", instances)
+		}
+		if _, err := it.Next(); err != ErrIteratorDone {
+			t.Errorf("expected done, got %v", err)
+		}
+	})
+
+	t.Run("should stop on errors", func(t *testing.T) {
+		boom := errors.New("boom")
+		it := NewInstanceIterator(func() ([]CloudInstance, bool, error) {
+			return nil, true, boom
+		})
+		if _, err := it.All(); err != boom {
+			t.Errorf("got %v", err)
+		}
+		if _, err := it.Next(); err != boom {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("should fall back to GetInstances", func(t *testing.T) {
+		p := &fakeInstanceProvider{instances: []CloudInstance{{Name: "a"}}}
+		instances, err := IterateInstances(p, NewContext(NewConfig())).All()
+		if err != nil || len(instances) != 1 {
+			t.Errorf("got %v %v", instances, err)
+		}
+	})
+}
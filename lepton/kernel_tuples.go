@@ -0,0 +1,61 @@
+package lepton
+
+import (
+	"fmt"
+	"strings"
+)
+
+// releaseUnreadTuples are the tuples the manifest has for features no nanos
+// release reads yet. A kernel that doesn't read them boots the image
+// without the programs, setup programs, policy, file owners, tmpfs mounts,
+// root growth or exit policy it was built with, so builds using them fail
+// unless the kernel is declared to read them.
+var releaseUnreadTuples = []string{"programs", "setup", "policy", "uid", "gid", "tmpfs", "grow_root", "reboot_on_crash", "exit_status"}
+
+// SetKernelTuples sets the tuples the kernel of the image reads besides
+// those of the nanos releases, for custom kernels
+func (m *Manifest) SetKernelTuples(tuples []string) {
+	m.kernelTuples = tuples
+}
+
+// usesTuple tells whether the manifest writes the tuple named key
+func (m *Manifest) usesTuple(key string) bool {
+	switch key {
+	case "programs":
+		return len(m.programs) > 0
+	case "setup":
+		return len(m.setup) > 0
+	case "policy":
+		return m.policy != nil
+	case "uid", "gid":
+		return len(m.owners) > 0
+	case "tmpfs":
+		return len(m.tmpfs) > 0
+	case "grow_root":
+		_, ok := m.debugFlags[key]
+		return ok
+	case "reboot_on_crash", "exit_status":
+		_, ok := m.rootTuples[key]
+		return ok
+	}
+	return false
+}
+
+// checkKernelTuples fails when the manifest has tuples the kernel doesn't
+// read
+func (m *Manifest) checkKernelTuples() error {
+	read := make(map[string]bool, len(m.kernelTuples))
+	for _, key := range m.kernelTuples {
+		read[key] = true
+	}
+	var unread []string
+	for _, key := range releaseUnreadTuples {
+		if m.usesTuple(key) && !read[key] {
+			unread = append(unread, key)
+		}
+	}
+	if len(unread) > 0 {
+		return WithCode(ErrMkfsUnsupportedTuple, fmt.Errorf("the nanos releases don't read the %s tuples of the manifest, list them in KernelTuples for a kernel that does", strings.Join(unread, ", ")))
+	}
+	return nil
+}
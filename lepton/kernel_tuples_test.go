@@ -0,0 +1,82 @@
+package lepton
+
+import (
+	"testing"
+)
+
+func TestCheckKernelTuples(t *testing.T) {
+	m := NewManifest("")
+	if err := m.checkKernelTuples(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetPolicy(Policy{AllowedPaths: []string{"/etc"}})
+	if code, _ := ErrorCodeOf(m.checkKernelTuples()); code != ErrMkfsUnsupportedTuple {
+		t.Errorf("expected %s for the policy tuple", ErrMkfsUnsupportedTuple)
+	}
+
+	if err := m.AddProgram("worker", "../data/main"); err != nil {
+		t.Fatal(err)
+	}
+	m.SetKernelTuples([]string{"policy"})
+	if code, _ := ErrorCodeOf(m.checkKernelTuples()); code != ErrMkfsUnsupportedTuple {
+		t.Errorf("expected %s for the programs tuple", ErrMkfsUnsupportedTuple)
+	}
+
+	m.SetKernelTuples([]string{"programs", "policy"})
+	if err := m.checkKernelTuples(); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.AddSetupProgram("../data/main", nil); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := ErrorCodeOf(m.checkKernelTuples()); code != ErrMkfsUnsupportedTuple {
+		t.Errorf("expected %s for the setup tuple", ErrMkfsUnsupportedTuple)
+	}
+
+	m.SetKernelTuples([]string{"programs", "setup", "policy"})
+	if err := m.checkKernelTuples(); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.SetOwner("/data/main", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := ErrorCodeOf(m.checkKernelTuples()); code != ErrMkfsUnsupportedTuple {
+		t.Errorf("expected %s for the uid and gid tuples", ErrMkfsUnsupportedTuple)
+	}
+
+	m.SetKernelTuples([]string{"programs", "setup", "policy", "uid", "gid"})
+	if err := m.checkKernelTuples(); err != nil {
+		t.Error(err)
+	}
+
+	// tuples of features the release kernels don't implement
+	m = NewManifest("")
+	if err := m.SetExitPolicy(ExitPolicy{OnExit: ExitReboot}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.checkKernelTuples(); err != nil {
+		t.Errorf("reboot_on_exit is read by the releases: %v", err)
+	}
+	if err := m.AddTmpfs("/tmp", "64M"); err != nil {
+		t.Fatal(err)
+	}
+	m.AddDebugFlag("grow_root", 't')
+	if err := m.SetExitPolicy(ExitPolicy{OnCrash: ExitReboot, PropagateStatus: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"tmpfs", "grow_root", "reboot_on_crash", "exit_status"} {
+		if !m.usesTuple(key) {
+			t.Errorf("expected the %s tuple to be used", key)
+		}
+	}
+	if code, _ := ErrorCodeOf(m.checkKernelTuples()); code != ErrMkfsUnsupportedTuple {
+		t.Errorf("expected %s for the tmpfs, grow_root and exit tuples", ErrMkfsUnsupportedTuple)
+	}
+	m.SetKernelTuples([]string{"tmpfs", "grow_root", "reboot_on_crash", "exit_status"})
+	if err := m.checkKernelTuples(); err != nil {
+		t.Error(err)
+	}
+}
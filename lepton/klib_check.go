@@ -0,0 +1,168 @@
+package lepton
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// nanosVersionSection is the ELF section the kernel and klibs record
+	// the kernel version they are built for in
+	nanosVersionSection = ".nanos_version"
+
+	// releaseManifestFile lists the checksums of the files of a release, in
+	// the release directory
+	releaseManifestFile = "release.json"
+)
+
+// releaseManifest describes the files of a nanos release
+type releaseManifest struct {
+	Version string `json:"version"`
+	// Files maps paths in the release directory, like kernel.img or
+	// klibs/ntp, to their sha256
+	Files map[string]string `json:"files"`
+}
+
+func readReleaseManifest(dir string) (*releaseManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, releaseManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rm := &releaseManifest{}
+	if err := json.Unmarshal(data, rm); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, releaseManifestFile), err)
+	}
+	return rm, nil
+}
+
+// version returns the release version of the file at path, which is name in
+// the release, or "" when the release doesn't list it. It fails when the
+// release lists name with another checksum.
+func (rm *releaseManifest) version(name string, path string) (string, error) {
+	if rm == nil {
+		return "", nil
+	}
+	sum, ok := rm.Files[name]
+	if !ok {
+		return "", nil
+	}
+	if actual := sha256Of(path); actual != sum {
+		return "", fmt.Errorf("%s has sha256 %s, release %s lists %s for %s", path, actual, rm.Version, sum, name)
+	}
+	return rm.Version, nil
+}
+
+// elfNanosVersion returns the kernel version recorded in the ELF file at
+// path, or "" when it has none
+func elfNanosVersion(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	defer f.Close()
+
+	section := f.Section(nanosVersionSection)
+	if section == nil {
+		return "", nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return string(bytes.TrimRight(data, "\x00\n")), nil
+}
+
+// kernelVersion returns the version of the kernel at path, from its ELF
+// metadata, or from the release manifest when the kernel is the one of the
+// release. It returns "" when the version is unknown.
+func kernelVersion(path string, rm *releaseManifest) (string, error) {
+	version, err := elfNanosVersion(path)
+	if err != nil || version != "" {
+		return version, err
+	}
+
+	if rm == nil {
+		return "", nil
+	}
+	if sum, ok := rm.Files["kernel.img"]; ok && sha256Of(path) == sum {
+		return rm.Version, nil
+	}
+	return "", nil
+}
+
+// checkKlibs verifies that the klibs of the manifest are built for its
+// kernel
+func (m *Manifest) checkKlibs() error {
+	kernel, ok := m.boot.Children["kernel"].(*FileNode)
+	if !ok || kernel.HostPath == "" || len(m.klibs) == 0 {
+		return nil
+	}
+	klibs := make(map[string]string, len(m.klibs))
+	for _, klib := range m.klibs {
+		if klibPath, ok := m.klibHostPath(klib); ok {
+			klibs[klib] = klibPath
+		}
+	}
+	return checkKlibVersions(kernel.HostPath, getKlibsDir(m.nightly), klibs)
+}
+
+// checkKlibVersions verifies that klibs, host paths by klib name, are built
+// for kernel. Versions come from the ELF metadata of the files, or for the
+// klibs of the release directory klibsDir from the checksums of its release
+// manifest. Files whose version is unknown can't be verified and are
+// accepted.
+func checkKlibVersions(kernel string, klibsDir string, klibs map[string]string) error {
+	if _, err := os.Stat(kernel); err != nil {
+		return nil
+	}
+
+	rm, err := readReleaseManifest(filepath.Dir(klibsDir))
+	if err != nil {
+		return err
+	}
+
+	kversion, err := kernelVersion(kernel, rm)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(klibs))
+	for klib := range klibs {
+		names = append(names, klib)
+	}
+	sort.Strings(names)
+
+	for _, klib := range names {
+		klibPath := klibs[klib]
+		if _, err := os.Stat(klibPath); err != nil {
+			// missing klibs are reported when the manifest is written
+			continue
+		}
+
+		version, err := elfNanosVersion(klibPath)
+		if err != nil {
+			return err
+		}
+		// klibs built locally are not in the release manifest
+		if version == "" && filepath.Dir(klibPath) == filepath.Clean(klibsDir) {
+			if version, err = rm.version("klibs/"+klib, klibPath); err != nil {
+				return WithCode(ErrMkfsKlibMismatch, fmt.Errorf("klib %s is corrupt or from another release: %v", klib, err))
+			}
+		}
+
+		if version != "" && kversion != "" && version != kversion {
+			return WithCode(ErrMkfsKlibMismatch, fmt.Errorf("klib %s at %s is built for kernel %s, but kernel %s is %s", klib, klibPath, version, kernel, kversion))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package lepton
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeVersionedELF writes an ELF file recording version in its
+// .nanos_version section, or with no such section if version is empty
+func writeVersionedELF(t *testing.T, path string, version string) {
+	shstrtab := []byte("\x00.shstrtab\x00" + nanosVersionSection + "\x00")
+	data := []byte(version + "\x00")
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: 64, Size: uint64(len(shstrtab))},
+	}
+	if version != "" {
+		sections = append(sections, elf.Section64{Name: 11, Type: uint32(elf.SHT_PROGBITS), Off: 64 + uint64(len(shstrtab)), Size: uint64(len(data))})
+	} else {
+		data = nil
+	}
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     64 + uint64(len(shstrtab)+len(data)),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  1,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(shstrtab)
+	buf.Write(data)
+	binary.Write(&buf, binary.LittleEndian, sections)
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeReleaseManifest(t *testing.T, dir string, rm releaseManifest) {
+	data, err := json.Marshal(rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, releaseManifestFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckKlibVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	klibsDir := filepath.Join(dir, "klibs")
+	if err := os.Mkdir(klibsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kernel := filepath.Join(dir, "kernel.img")
+	ntp := filepath.Join(klibsDir, "ntp")
+
+	expectMismatch := func(t *testing.T, err error) {
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsKlibMismatch {
+			t.Errorf("expected %s, got %v", ErrMkfsKlibMismatch, err)
+		}
+	}
+
+	t.Run("should accept klibs of the kernel version", func(t *testing.T) {
+		writeVersionedELF(t, kernel, "0.1.30")
+		writeVersionedELF(t, ntp, "0.1.30")
+		if err := checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp, "missing": filepath.Join(klibsDir, "missing")}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("should reject klibs of another kernel version", func(t *testing.T) {
+		writeVersionedELF(t, ntp, "0.1.29")
+		expectMismatch(t, checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}))
+	})
+
+	t.Run("should accept klibs of unknown version", func(t *testing.T) {
+		writeVersionedELF(t, ntp, "")
+		if err := checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("should use the checksums of the release manifest", func(t *testing.T) {
+		writeVersionedELF(t, kernel, "")
+		writeReleaseManifest(t, dir, releaseManifest{
+			Version: "0.1.30",
+			Files: map[string]string{
+				"kernel.img": sha256Of(kernel),
+				"klibs/ntp":  sha256Of(ntp),
+			},
+		})
+		if err := checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}); err != nil {
+			t.Error(err)
+		}
+
+		writeReleaseManifest(t, dir, releaseManifest{
+			Version: "0.1.30",
+			Files: map[string]string{
+				"kernel.img": sha256Of(kernel),
+				"klibs/ntp":  "0000",
+			},
+		})
+		expectMismatch(t, checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}))
+	})
+
+	t.Run("should compare klibs of the release to the version of a custom kernel", func(t *testing.T) {
+		writeVersionedELF(t, ntp, "")
+		writeReleaseManifest(t, dir, releaseManifest{
+			Version: "0.1.30",
+			Files:   map[string]string{"klibs/ntp": sha256Of(ntp)},
+		})
+
+		writeVersionedELF(t, kernel, "0.1.31")
+		expectMismatch(t, checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}))
+
+		writeVersionedELF(t, kernel, "")
+		if err := checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": ntp}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("should not look up klibs out of the release in its manifest", func(t *testing.T) {
+		writeVersionedELF(t, kernel, "0.1.30")
+		writeReleaseManifest(t, dir, releaseManifest{
+			Version: "0.1.30",
+			Files:   map[string]string{"kernel.img": sha256Of(kernel), "klibs/ntp": "0000"},
+		})
+		debug := filepath.Join(dir, "ntp-debug")
+		writeVersionedELF(t, debug, "")
+		if err := checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": debug}); err != nil {
+			t.Error(err)
+		}
+
+		writeVersionedELF(t, debug, "0.1.29")
+		expectMismatch(t, checkKlibVersions(kernel, klibsDir, map[string]string{"ntp": debug}))
+	})
+}
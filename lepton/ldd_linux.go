@@ -45,9 +45,15 @@ func IsDynamicLinked(efd *elf.File) bool {
 	return true
 }
 
-// works only on linux, need to
-// replace looking up in dynamic section in ELF
-func getSharedLibs(targetRoot string, path string) ([]string, error) {
+// getSharedLibs returns the libraries the program at path needs, looking
+// in libDirs first like LD_LIBRARY_PATH. Programs of a target root are
+// resolved in userspace, they may not run on this host. When strict, the
+// libraries are only looked up in the target root.
+func getSharedLibs(targetRoot string, path string, strict bool, libDirs []string) ([]string, error) {
+	if targetRoot != "" {
+		return resolveSharedLibs(targetRoot, path, strict, libDirs)
+	}
+
 	var notExistLib []string
 	path, err := filepath.Abs(path)
 	if err != nil {
@@ -77,6 +83,13 @@ func getSharedLibs(targetRoot string, path string) ([]string, error) {
 	if err == nil && IsDynamicLinked(elfFile) {
 		env := os.Environ()
 		env = append(env, "LD_TRACE_LOADED_OBJECTS=1")
+		if len(libDirs) > 0 {
+			ldLibraryPath := strings.Join(libDirs, ":")
+			if val := os.Getenv("LD_LIBRARY_PATH"); val != "" {
+				ldLibraryPath += ":" + val
+			}
+			env = append(env, "LD_LIBRARY_PATH="+ldLibraryPath)
+		}
 		cmd := exec.Command(path)
 		cmd.Env = env
 		out, _ := cmd.StdoutPipe()
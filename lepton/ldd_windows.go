@@ -21,7 +21,7 @@ func GetElfFileInfo(path string) (*elf.File, error) {
 }
 
 // stub
-func getSharedLibs(targetRoot string, path string) ([]string, error) {
+func getSharedLibs(targetRoot string, path string, strict bool, libDirs []string) ([]string, error) {
 	var deps []string
 	return deps, nil
 }
@@ -0,0 +1,368 @@
+package lepton
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ldSoCachePath = "/etc/ld.so.cache"
+	ldSoConfPath  = "/etc/ld.so.conf"
+
+	ldSoCacheOldMagic = "ld.so-1.7.0"
+	ldSoCacheNewMagic = "glibc-ld.so.cache1.1"
+
+	// flags of the x86-64 glibc libraries in ld.so.cache
+	ldSoCacheTypeMask   = 0x00ff
+	ldSoCacheArchMask   = 0xff00
+	ldSoCacheELFLibc6   = 0x0003
+	ldSoCacheX8664Lib64 = 0x0300
+)
+
+// ldSoPlatform is the value of the $PLATFORM token of search paths
+const ldSoPlatform = "x86_64"
+
+// ldSoLibDirs are the values the $LIB token of search paths takes, it is
+// lib64 on rpm distros and the multiarch directory on debian ones, both are
+// tried in this order
+var ldSoLibDirs = []string{"lib64", "lib/x86_64-linux-gnu"}
+
+// defaultLibDirs are searched after ld.so.cache and the directories of
+// ld.so.conf
+var defaultLibDirs = []string{"/lib64", "/lib/x86_64-linux-gnu", "/lib", "/usr/lib64", "/usr/lib/x86_64-linux-gnu", "/usr/lib"}
+
+// libResolver finds the libraries a program needs the way the dynamic
+// loader of a root filesystem does, without running anything from it and
+// without chroot, so foreign roots resolve on any host.
+type libResolver struct {
+	targetRoot string
+	// strict resolves libraries in the target root only, see
+	// Config.TargetRootStrict
+	strict bool
+	// program is the file whose libraries are resolved, it may be on the
+	// host even when strict
+	program string
+	// libDirs are searched like LD_LIBRARY_PATH, before it
+	libDirs  []string
+	cache    map[string]string
+	confDirs []string
+}
+
+func newLibResolver(targetRoot string, strict bool) *libResolver {
+	r := &libResolver{targetRoot: targetRoot, strict: strict}
+
+	// a missing or unreadable cache falls back to the directories
+	if hostpath, err := r.lookupFile(ldSoCachePath); err == nil {
+		if data, err := ioutil.ReadFile(hostpath); err == nil {
+			r.cache, _ = parseLdSoCache(data)
+		}
+	}
+	r.confDirs = r.readLdSoConf(ldSoConfPath, map[string]bool{})
+	return r
+}
+
+// resolveSharedLibs returns the libraries program needs, and those they
+// need, as paths in targetRoot, starting with the dynamic loader. libDirs are
+// searched like LD_LIBRARY_PATH. When strict, libraries missing from
+// targetRoot are not looked up on the host.
+func resolveSharedLibs(targetRoot string, program string, strict bool, libDirs []string) ([]string, error) {
+	r := newLibResolver(targetRoot, strict)
+	r.libDirs = libDirs
+	r.program = program
+	libs := []string{}
+	if err := r.walk(program, map[string]bool{}, &libs); err != nil {
+		return nil, err
+	}
+	return libs, nil
+}
+
+func (r *libResolver) walk(file string, seen map[string]bool, libs *[]string) error {
+	hostpath, err := r.lookupFile(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	f, err := elf.Open(hostpath)
+	if err != nil {
+		if strings.Contains(err.Error(), "bad magic number") {
+			return fmt.Errorf("only ELF binaries are supported, is %s a Linux binary? run 'file %s' on it", file, hostpath)
+		}
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := r.add(string(bytes.TrimRight(interp, "\x00")), seen, libs); err != nil {
+			return err
+		}
+	}
+
+	needed, err := f.DynString(elf.DT_NEEDED)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if len(needed) == 0 {
+		return nil
+	}
+
+	dirs, err := r.searchDirs(f, path.Dir(filepath.ToSlash(file)))
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for _, name := range needed {
+		if name == "" {
+			continue
+		}
+		lib, err := r.findLib(name, dirs)
+		if err != nil {
+			return fmt.Errorf("%s needs %s: %v", file, name, err)
+		}
+		if err := r.add(lib, seen, libs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *libResolver) add(lib string, seen map[string]bool, libs *[]string) error {
+	if seen[lib] {
+		return nil
+	}
+	seen[lib] = true
+	*libs = append(*libs, lib)
+	return r.walk(lib, seen, libs)
+}
+
+// searchDirs returns the directories searched before ld.so.cache for the
+// libraries of f: DT_RPATH when there is no DT_RUNPATH, LD_LIBRARY_PATH and
+// then DT_RUNPATH, with their $ORIGIN, $PLATFORM and $LIB tokens expanded
+func (r *libResolver) searchDirs(f *elf.File, origin string) ([]string, error) {
+	ldLibraryPath := append([]string{}, r.libDirs...)
+	if val := strings.TrimSpace(os.Getenv("LD_LIBRARY_PATH")); val != "" {
+		ldLibraryPath = append(ldLibraryPath, strings.Split(val, ":")...)
+	}
+
+	runpath, err := f.DynString(elf.DT_RUNPATH)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	if len(runpath) == 0 {
+		rpath, err := f.DynString(elf.DT_RPATH)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range rpath {
+			dirs = append(dirs, strings.Split(d, ":")...)
+		}
+		dirs = append(dirs, ldLibraryPath...)
+	} else {
+		dirs = append(dirs, ldLibraryPath...)
+		for _, d := range runpath {
+			dirs = append(dirs, strings.Split(d, ":")...)
+		}
+	}
+
+	expanded := []string{}
+	for _, d := range dirs {
+		d = expandDynamicToken(d, "ORIGIN", origin)
+		d = expandDynamicToken(d, "PLATFORM", ldSoPlatform)
+		if !strings.Contains(d, "$LIB") && !strings.Contains(d, "${LIB}") {
+			expanded = append(expanded, d)
+			continue
+		}
+		for _, lib := range ldSoLibDirs {
+			expanded = append(expanded, expandDynamicToken(d, "LIB", lib))
+		}
+	}
+	return expanded, nil
+}
+
+// expandDynamicToken replaces the dynamic string token $name, or ${name},
+// of the search path dir by value
+func expandDynamicToken(dir, name, value string) string {
+	dir = strings.Replace(dir, "${"+name+"}", value, -1)
+	return strings.Replace(dir, "$"+name, value, -1)
+}
+
+// findLib returns the path of the library name, looking in dirs, then in
+// ld.so.cache, the directories of ld.so.conf and the default directories
+func (r *libResolver) findLib(name string, dirs []string) (string, error) {
+	if strings.Contains(name, "/") {
+		if _, err := r.lookupFile(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+
+	for _, dir := range dirs {
+		if lib, ok := r.exists(path.Join(dir, name)); ok {
+			return lib, nil
+		}
+	}
+	if lib, ok := r.cache[name]; ok {
+		if lib, ok := r.exists(lib); ok {
+			return lib, nil
+		}
+	}
+	for _, dir := range append(r.confDirs, defaultLibDirs...) {
+		if lib, ok := r.exists(path.Join(dir, name)); ok {
+			return lib, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// lookupFile returns the path of file in the target root
+func (r *libResolver) lookupFile(file string) (string, error) {
+	return (*fileCache)(nil).lookupFile(r.targetRoot, file, r.strict && file != r.program)
+}
+
+func (r *libResolver) exists(lib string) (string, bool) {
+	_, err := r.lookupFile(lib)
+	return lib, err == nil
+}
+
+// readLdSoConf returns the directories listed in conf and the files it
+// includes
+func (r *libResolver) readLdSoConf(conf string, visited map[string]bool) []string {
+	if visited[conf] {
+		return nil
+	}
+	visited[conf] = true
+
+	hostpath, err := r.lookupFile(conf)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(hostpath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	dirs := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ',' || c == ':'
+		})
+		if len(fields) == 0 || fields[0] == "hwcap" {
+			continue
+		}
+
+		if fields[0] != "include" {
+			dirs = append(dirs, fields...)
+			continue
+		}
+		for _, pattern := range fields[1:] {
+			if !path.IsAbs(pattern) {
+				pattern = path.Join(path.Dir(conf), pattern)
+			}
+			for _, include := range r.glob(pattern) {
+				dirs = append(dirs, r.readLdSoConf(include, visited)...)
+			}
+		}
+	}
+	return dirs
+}
+
+// glob returns the paths in the target root matching pattern
+func (r *libResolver) glob(pattern string) []string {
+	root := r.targetRoot
+	if root == "" {
+		root = "/"
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(root, filepath.FromSlash(pattern)))
+	paths := []string{}
+	for _, match := range matches {
+		rel, err := filepath.Rel(root, match)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		paths = append(paths, path.Join("/", filepath.ToSlash(rel)))
+	}
+	return paths
+}
+
+// parseLdSoCache returns the x86-64 libraries of an ld.so.cache by name. It
+// reads the new format, alone or after the entries of the old one.
+func parseLdSoCache(data []byte) (map[string]string, error) {
+	base := 0
+	if bytes.HasPrefix(data, []byte(ldSoCacheOldMagic)) {
+		if len(data) < 16 {
+			return nil, fmt.Errorf("truncated ld.so.cache")
+		}
+		nlibs := int(binary.LittleEndian.Uint32(data[12:]))
+		// the new format follows the old entries, aligned to 8 bytes
+		base = (16 + nlibs*12 + 7) &^ 7
+	}
+	if base < 0 || base > len(data) || !bytes.HasPrefix(data[base:], []byte(ldSoCacheNewMagic)) {
+		return nil, fmt.Errorf("unsupported ld.so.cache format")
+	}
+
+	header := data[base:]
+	if len(header) < 48 {
+		return nil, fmt.Errorf("truncated ld.so.cache")
+	}
+	nlibs := int(binary.LittleEndian.Uint32(header[20:]))
+	if nlibs < 0 || nlibs > (len(header)-48)/24 {
+		return nil, fmt.Errorf("truncated ld.so.cache")
+	}
+
+	str := func(offset uint32) (string, bool) {
+		if int(offset) >= len(header) {
+			return "", false
+		}
+		end := bytes.IndexByte(header[offset:], 0)
+		if end < 0 {
+			return "", false
+		}
+		return string(header[int(offset) : int(offset)+end]), true
+	}
+
+	libs := map[string]string{}
+	for i := 0; i < nlibs; i++ {
+		entry := header[48+i*24:]
+		flags := binary.LittleEndian.Uint32(entry)
+		if flags&ldSoCacheTypeMask != ldSoCacheELFLibc6 || flags&ldSoCacheArchMask != ldSoCacheX8664Lib64 {
+			continue
+		}
+
+		name, ok := str(binary.LittleEndian.Uint32(entry[4:]))
+		if !ok {
+			return nil, fmt.Errorf("invalid ld.so.cache entry %d", i)
+		}
+		value, ok := str(binary.LittleEndian.Uint32(entry[8:]))
+		if !ok {
+			return nil, fmt.Errorf("invalid ld.so.cache entry %d", i)
+		}
+		// entries are sorted by preference
+		if _, ok := libs[name]; !ok {
+			libs[name] = value
+		}
+	}
+	return libs, nil
+}
@@ -0,0 +1,327 @@
+package lepton
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeDynamicELF writes an ELF file loaded by interp, if set, that needs
+// the libraries needed and looks for them in runpath
+func writeDynamicELF(t *testing.T, path string, interp string, needed []string, runpath string) {
+	dynstr := []byte{0}
+	addString := func(s string) uint64 {
+		off := uint64(len(dynstr))
+		dynstr = append(dynstr, s...)
+		dynstr = append(dynstr, 0)
+		return off
+	}
+	var dynamic []elf.Dyn64
+	for _, lib := range needed {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: addString(lib)})
+	}
+	if runpath != "" {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_RUNPATH), Val: addString(runpath)})
+	}
+	dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+	var progs []elf.Prog64
+	var interpBytes []byte
+	interpOff := uint64(64)
+	if interp != "" {
+		interpOff += 56
+		interpBytes = append([]byte(interp), 0)
+		progs = append(progs, elf.Prog64{Type: uint32(elf.PT_INTERP), Off: interpOff, Filesz: uint64(len(interpBytes))})
+	}
+
+	shstrtab := []byte("\x00.shstrtab\x00.dynstr\x00.dynamic\x00")
+	shstrtabOff := interpOff + uint64(len(interpBytes))
+	dynstrOff := shstrtabOff + uint64(len(shstrtab))
+	dynamicOff := dynstrOff + uint64(len(dynstr))
+	shoff := dynamicOff + uint64(len(dynamic)*16)
+
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint64(len(shstrtab))},
+		{Name: 11, Type: uint32(elf.SHT_STRTAB), Off: dynstrOff, Size: uint64(len(dynstr))},
+		{Name: 19, Type: uint32(elf.SHT_DYNAMIC), Off: dynamicOff, Size: uint64(len(dynamic) * 16), Link: 2, Entsize: 16},
+	}
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shoff,
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  1,
+	}
+	if len(progs) > 0 {
+		header.Phoff = 64
+		header.Phentsize = 56
+		header.Phnum = uint16(len(progs))
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	binary.Write(&buf, binary.LittleEndian, progs)
+	buf.Write(interpBytes)
+	buf.Write(shstrtab)
+	buf.Write(dynstr)
+	binary.Write(&buf, binary.LittleEndian, dynamic)
+	binary.Write(&buf, binary.LittleEndian, sections)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type ldSoCacheEntry struct {
+	flags uint32
+	key   string
+	value string
+}
+
+// ldSoCache returns an ld.so.cache of entries in the new format, after the
+// entries of the old one if old is set
+func ldSoCache(old bool, entries []ldSoCacheEntry) []byte {
+	var prefix []byte
+	if old {
+		prefix = append([]byte(ldSoCacheOldMagic), 0)
+		prefix = append(prefix, make([]byte, 16-len(prefix))...)
+		binary.LittleEndian.PutUint32(prefix[12:], 1)
+		// one old entry, then padding to 8 bytes
+		prefix = append(prefix, make([]byte, 12+4)...)
+	}
+
+	strtabOff := 48 + len(entries)*24
+	var strtab []byte
+	addString := func(s string) uint32 {
+		off := uint32(strtabOff + len(strtab))
+		strtab = append(strtab, s...)
+		strtab = append(strtab, 0)
+		return off
+	}
+
+	header := make([]byte, 48)
+	copy(header, ldSoCacheNewMagic)
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(entries)))
+	for _, e := range entries {
+		entry := make([]byte, 24)
+		binary.LittleEndian.PutUint32(entry, e.flags)
+		binary.LittleEndian.PutUint32(entry[4:], addString(e.key))
+		binary.LittleEndian.PutUint32(entry[8:], addString(e.value))
+		header = append(header, entry...)
+	}
+	return append(append(prefix, header...), strtab...)
+}
+
+func writeRootFile(t *testing.T, root string, path string, content string) {
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseLdSoCache(t *testing.T) {
+	entries := []ldSoCacheEntry{
+		{flags: 0x0303, key: "libc.so.6", value: "/lib/x86_64-linux-gnu/libc.so.6"},
+		{flags: 0x0003, key: "libc.so.6", value: "/lib/i386-linux-gnu/libc.so.6"},
+		{flags: 0x0303, key: "libc.so.6", value: "/usr/lib/libc.so.6"},
+		{flags: 0x0a03, key: "libm.so.6", value: "/lib/aarch64-linux-gnu/libm.so.6"},
+	}
+	want := map[string]string{"libc.so.6": "/lib/x86_64-linux-gnu/libc.so.6"}
+
+	for _, old := range []bool{false, true} {
+		libs, err := parseLdSoCache(ldSoCache(old, entries))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(libs, want) {
+			t.Errorf("old format %v: got %v, want %v", old, libs, want)
+		}
+	}
+
+	data := ldSoCache(false, entries)
+	for _, bad := range [][]byte{nil, []byte("not a cache"), data[:60], data[:48+len(entries)*24+5]} {
+		if _, err := parseLdSoCache(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestReadLdSoConf(t *testing.T) {
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeRootFile(t, root, "/etc/ld.so.conf", "include /etc/ld.so.conf.d/*.conf\n/usr/local/lib\n")
+	writeRootFile(t, root, "/etc/ld.so.conf.d/a.conf", "# comment\n/opt/a/lib /opt/b/lib # trailing\nhwcap 0 nosegneg\n")
+	writeRootFile(t, root, "/etc/ld.so.conf.d/b.conf", "/opt/c/lib,/opt/d/lib:/opt/e/lib\ninclude ../ld.so.conf\n")
+	writeRootFile(t, root, "/etc/ld.so.conf.d/c.txt", "/opt/ignored\n")
+
+	r := newLibResolver(root, false)
+	want := []string{"/opt/a/lib", "/opt/b/lib", "/opt/c/lib", "/opt/d/lib", "/opt/e/lib", "/usr/local/lib"}
+	if !reflect.DeepEqual(r.confDirs, want) {
+		t.Errorf("got %v, want %v", r.confDirs, want)
+	}
+}
+
+func TestResolveSharedLibs(t *testing.T) {
+	if ldLibraryPath, ok := os.LookupEnv("LD_LIBRARY_PATH"); ok {
+		os.Unsetenv("LD_LIBRARY_PATH")
+		defer os.Setenv("LD_LIBRARY_PATH", ldLibraryPath)
+	}
+
+	dir, err := ioutil.TempDir("", "ldso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	loader := "/lib64/ld-linux-x86-64.so.2"
+	writeDynamicELF(t, filepath.Join(root, loader), "", nil, "")
+	writeRootFile(t, root, ldSoCachePath, string(ldSoCache(false, []ldSoCacheEntry{
+		{flags: 0x0303, key: "libfoo.so.1", value: "/cache/libfoo.so.1"},
+	})))
+	writeRootFile(t, root, ldSoConfPath, "/opt/lib\n")
+	writeDynamicELF(t, filepath.Join(root, "/cache/libfoo.so.1"), "", []string{"libbaz.so"}, "")
+	writeDynamicELF(t, filepath.Join(root, "/usr/lib/libbaz.so.1"), "", []string{"libfoo.so.1", "libc.so.6"}, "")
+	writeDynamicELF(t, filepath.Join(root, "/lib/x86_64-linux-gnu/libc.so.6"), "", nil, "")
+	// absolute links point into the target root, not to the host
+	if err := os.MkdirAll(filepath.Join(root, "/opt/lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/lib/libbaz.so.1", filepath.Join(root, "/opt/lib/libbaz.so")); err != nil {
+		t.Fatal(err)
+	}
+
+	program := filepath.Join(dir, "bin", "program")
+	writeDynamicELF(t, program, loader, []string{"libfoo.so.1", "libbar.so"}, "$ORIGIN/../lib")
+	writeDynamicELF(t, filepath.Join(dir, "lib", "libbar.so"), "", nil, "")
+
+	libs, err := resolveSharedLibs(root, program, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		loader,
+		"/cache/libfoo.so.1",
+		"/opt/lib/libbaz.so",
+		"/lib/x86_64-linux-gnu/libc.so.6",
+		filepath.Join(dir, "lib", "libbar.so"),
+	}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("got %v, want %v", libs, want)
+	}
+
+	writeDynamicELF(t, program, loader, []string{"libmissing.so"}, "")
+	if _, err := resolveSharedLibs(root, program, false, nil); err == nil {
+		t.Error("expected an error for a missing library")
+	}
+}
+
+func TestResolveSharedLibsStrict(t *testing.T) {
+	if ldLibraryPath, ok := os.LookupEnv("LD_LIBRARY_PATH"); ok {
+		os.Unsetenv("LD_LIBRARY_PATH")
+		defer os.Setenv("LD_LIBRARY_PATH", ldLibraryPath)
+	}
+
+	dir, err := ioutil.TempDir("", "ldso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	hostLibs := filepath.Join(dir, "host", "lib")
+
+	loader := "/lib64/ld-linux-x86-64.so.2"
+	writeDynamicELF(t, filepath.Join(root, loader), "", nil, "")
+	// the run path is only on the host, not in the target root
+	writeDynamicELF(t, filepath.Join(root, "/bin/program"), loader, []string{"libhost.so"}, hostLibs)
+	writeDynamicELF(t, filepath.Join(hostLibs, "libhost.so"), "", nil, "")
+
+	libs, err := resolveSharedLibs(root, "/bin/program", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{loader, filepath.Join(hostLibs, "libhost.so")}; !reflect.DeepEqual(libs, want) {
+		t.Errorf("got %v, want %v", libs, want)
+	}
+
+	if _, err := resolveSharedLibs(root, "/bin/program", true, nil); err == nil {
+		t.Error("expected an error for a library missing from the target root")
+	}
+}
+
+func TestResolveSharedLibsDynamicTokens(t *testing.T) {
+	if ldLibraryPath, ok := os.LookupEnv("LD_LIBRARY_PATH"); ok {
+		os.Unsetenv("LD_LIBRARY_PATH")
+		defer os.Setenv("LD_LIBRARY_PATH", ldLibraryPath)
+	}
+
+	dir, err := ioutil.TempDir("", "ldso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	writeDynamicELF(t, filepath.Join(root, "/opt/app/bin/program"), "", []string{"libapp.so", "libplat.so"}, "/opt/app/$LIB:/opt/app/${PLATFORM}")
+	writeDynamicELF(t, filepath.Join(root, "/opt/app/lib/x86_64-linux-gnu/libapp.so"), "", nil, "")
+	writeDynamicELF(t, filepath.Join(root, "/opt/app/x86_64/libplat.so"), "", nil, "")
+
+	libs, err := resolveSharedLibs(root, "/opt/app/bin/program", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/opt/app/lib/x86_64-linux-gnu/libapp.so", "/opt/app/x86_64/libplat.so"}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("got %v, want %v", libs, want)
+	}
+}
+
+func TestResolveSharedLibsLibraryPaths(t *testing.T) {
+	if ldLibraryPath, ok := os.LookupEnv("LD_LIBRARY_PATH"); ok {
+		os.Unsetenv("LD_LIBRARY_PATH")
+		defer os.Setenv("LD_LIBRARY_PATH", ldLibraryPath)
+	}
+
+	dir, err := ioutil.TempDir("", "ldso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	writeDynamicELF(t, filepath.Join(root, "/app/bin/program"), "", []string{"libprivate.so"}, "")
+	writeDynamicELF(t, filepath.Join(root, "/usr/lib/libprivate.so"), "", nil, "")
+	writeDynamicELF(t, filepath.Join(root, "/app/lib/libprivate.so"), "", nil, "")
+
+	libs, err := resolveSharedLibs(root, "/app/bin/program", true, []string{"/app/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/app/lib/libprivate.so"}; !reflect.DeepEqual(libs, want) {
+		t.Errorf("got %v, want %v", libs, want)
+	}
+}
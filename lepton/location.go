@@ -0,0 +1,45 @@
+package lepton
+
+import "fmt"
+
+// Zone is a location instances can be created in
+type Zone struct {
+	Name   string
+	Region string
+}
+
+// InstanceType describes a flavor instances can be created with
+type InstanceType struct {
+	Name     string
+	VCPUs    int
+	MemoryMB int
+}
+
+// LocationService is implemented by providers that can list where instances
+// can be created and with which flavors
+type LocationService interface {
+	GetRegions(ctx *Context) ([]string, error)
+	GetZones(ctx *Context) ([]Zone, error)
+	GetInstanceTypes(ctx *Context, zone string) ([]InstanceType, error)
+}
+
+// CheckInstanceType returns an error if flavor isn't available in zone. It is
+// a no-op for providers that don't implement LocationService.
+func CheckInstanceType(p Provider, ctx *Context, zone, flavor string) error {
+	ls, ok := p.(LocationService)
+	if !ok || flavor == "" {
+		return nil
+	}
+
+	types, err := ls.GetInstanceTypes(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range types {
+		if t.Name == flavor {
+			return nil
+		}
+	}
+	return fmt.Errorf("instance type %s is not available in %s", flavor, zone)
+}
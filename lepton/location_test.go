@@ -0,0 +1,40 @@
+package lepton
+
+import "testing"
+
+type fakeLocationProvider struct {
+	Provider
+	types map[string][]InstanceType
+}
+
+func (p *fakeLocationProvider) GetRegions(ctx *Context) ([]string, error) {
+	return []string{"r1"}, nil
+}
+
+func (p *fakeLocationProvider) GetZones(ctx *Context) ([]Zone, error) {
+	return []Zone{{Name: "r1-a", Region: "r1"}}, nil
+}
+
+func (p *fakeLocationProvider) GetInstanceTypes(ctx *Context, zone string) ([]InstanceType, error) {
+	return p.types[zone], nil
+}
+
+func TestCheckInstanceType(t *testing.T) {
+	ctx := NewContext(NewConfig())
+	p := &fakeLocationProvider{types: map[string][]InstanceType{
+		"r1-a": {{Name: "small", VCPUs: 1, MemoryMB: 1024}},
+	}}
+
+	if err := CheckInstanceType(p, ctx, "r1-a", "small"); err != nil {
+		t.Error(err)
+	}
+	if err := CheckInstanceType(p, ctx, "r1-b", "small"); err == nil {
+		t.Error("expected small to be unavailable in r1-b")
+	}
+	if err := CheckInstanceType(p, ctx, "r1-a", "huge"); err == nil {
+		t.Error("expected huge to be unavailable")
+	}
+	if err := CheckInstanceType(&fakeInstanceProvider{}, ctx, "r1-a", "huge"); err != nil {
+		t.Errorf("providers without a location service should not be checked, got %v", err)
+	}
+}
@@ -1,21 +1,24 @@
 package lepton
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 var localManifestDir = path.Join(GetOpsHome(), "manifests")
 
-// link refers to a link filetype
-type link struct {
-	path string
-}
-
 // ManifestNetworkConfig has network configuration to set static IP
 type ManifestNetworkConfig struct {
 	IP      string
@@ -25,30 +28,152 @@ type ManifestNetworkConfig struct {
 
 // Manifest represent the filesystem.
 type Manifest struct {
-	sb            strings.Builder
-	children      map[string]interface{} // root fs
-	boot          map[string]interface{} // boot fs
+	root          *DirNode // root fs
+	boot          *DirNode // boot fs
+	adding        *DirNode // fs files are being added to, root fs when nil
 	program       string
+	programs      map[string]string // image paths of the programs selectable at boot by name
+	setup         []setupProgram
 	args          []string
 	debugFlags    map[string]rune
 	noTrace       []string
 	environment   map[string]string
 	targetRoot    string
 	mounts        map[string]string
+	tmpfs         map[string]int64 // sizes of tmpfs mounts by path, 0 for no limit
+	rootTuples    map[string]interface{}
+	fileHashes    map[string]string // sha256 of files by host path
+	hashIndex     *hashIndex        // sha256 of host files from previous builds
+	owners        map[string]Owner  // owners of the entries of the root fs by image path
+	modTime       time.Time         // modification time of every entry, none when zero
+	bootTuples    map[string]interface{}
 	klibs         []string
+	klibDirs      []string          // directories searched for klibs before the one of the release
+	klibPaths     map[string]string // host paths of klibs by name, overriding the search
+	bootFSSize    int64             // size the boot fs must fit in, the one of releases when zero
 	nightly       bool
 	networkConfig *ManifestNetworkConfig
+	policy        *Policy
+	kernelTuples  []string // tuples the kernel reads besides those of the releases
+	warnings      []Warning
+	warningOutput io.Writer
+	files         *fileCache
+	// strictTargetRoot confines the lookup of files to targetRoot
+	strictTargetRoot bool
+	// hostFallback are the host paths looked up on the host when missing
+	// from a strict target root: the files ops generates or provides and
+	// the programs
+	hostFallback map[string]bool
+	// materializeSymlinks adds the files symlinks out of added directories
+	// point to instead of the symlinks
+	materializeSymlinks bool
+	// symlinks is what is added for symlinks
+	symlinks SymlinkPolicy
+	// walking are the real paths of the directories being added, to detect
+	// symlink loops when resolving symlinks
+	walking map[string]bool
+	// excludes are the patterns of the files left out of added directories
+	excludes []excludePattern
+	// filter is the filter of the directory AddDirectoryFunc is adding
+	filter func(hostpath string, info os.FileInfo) bool
+	// caseNames are the names of the entries of directories by their lower
+	// case form, to detect names differing only by case
+	caseNames map[*DirNode]map[string]string
+	// generatedDir is the host directory of the files of AddFileFromReader,
+	// a temporary one created by the manifest when generatedTemp is set
+	generatedDir  string
+	generatedTemp bool
+	// generated are the files written by AddFileFromReader
+	generated []string
 }
 
 // NewManifest init
 func NewManifest(targetRoot string) *Manifest {
 	return &Manifest{
-		boot:        make(map[string]interface{}),
-		children:    make(map[string]interface{}),
-		debugFlags:  make(map[string]rune),
-		environment: make(map[string]string),
-		targetRoot:  targetRoot,
-		mounts:      make(map[string]string),
+		boot:          NewDirNode(),
+		root:          NewDirNode(),
+		debugFlags:    make(map[string]rune),
+		environment:   make(map[string]string),
+		targetRoot:    targetRoot,
+		mounts:        make(map[string]string),
+		warningOutput: os.Stdout,
+		files:         newFileCache(),
+		symlinks:      SymlinkPreserve,
+	}
+}
+
+// SetStrictTargetRoot confines the lookup of files to the target root, files
+// and symlink targets missing from it are errors instead of being looked up
+// on the host
+func (m *Manifest) SetStrictTargetRoot(strict bool) {
+	m.strictTargetRoot = strict
+}
+
+// lookupFile returns the path of the file of the manifest at hostpath, in
+// the target root or on the host
+func (m *Manifest) lookupFile(hostpath string) (string, error) {
+	p, err := m.resolveFile(hostpath)
+	return p.Resolved, err
+}
+
+// resolveFile is lookupFile returning where hostpath was found
+func (m *Manifest) resolveFile(hostpath string) (FileProvenance, error) {
+	return m.files.resolveFile(m.targetRoot, hostpath, m.strictTargetRoot && !m.hostFallback[hostpath])
+}
+
+// addHostFile adds the file at hostpath, which ops generated or provides,
+// at vmpath. It is taken from the host when a strict target root doesn't
+// have it.
+func (m *Manifest) addHostFile(vmpath string, hostpath string) error {
+	if m.hostFallback == nil {
+		m.hostFallback = make(map[string]bool)
+	}
+	m.hostFallback[hostpath] = true
+	return m.AddFile(vmpath, hostpath)
+}
+
+// SetMaterializeSymlinks adds the files symlinks of added directories point
+// to in place of the symlinks, when they are out of the directory
+func (m *Manifest) SetMaterializeSymlinks(materialize bool) {
+	m.materializeSymlinks = materialize
+}
+
+// SetResolveSymlinks copies the content symlinks point to in place of every
+// symlink, so the image has none, like the SymlinkDereference policy
+func (m *Manifest) SetResolveSymlinks(resolve bool) {
+	m.symlinks = SymlinkPreserve
+	if resolve {
+		m.symlinks = SymlinkDereference
+	}
+}
+
+// SetExcludes leaves the files and directories matching patterns out of the
+// directories added, see parseExcludePatterns for the patterns
+func (m *Manifest) SetExcludes(patterns []string) error {
+	excludes, err := parseExcludePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	m.excludes = excludes
+	return nil
+}
+
+// Warnings returns the non-fatal issues found while adding files
+func (m *Manifest) Warnings() []Warning {
+	return m.warnings
+}
+
+// SetWarningOutput sets where warnings are logged as they are found, they
+// are only collected when w is nil
+func (m *Manifest) SetWarningOutput(w io.Writer) {
+	m.warningOutput = w
+}
+
+func (m *Manifest) warn(category WarningCategory, path string, format string, a ...interface{}) {
+	w := Warning{Category: category, Path: path, Message: fmt.Sprintf(format, a...)}
+	m.warnings = append(m.warnings, w)
+	if m.warningOutput != nil {
+		fmt.Fprintln(m.warningOutput, w)
 	}
 }
 
@@ -57,23 +182,126 @@ func (m *Manifest) AddNetworkConfig(networkConfig *ManifestNetworkConfig) {
 	m.networkConfig = networkConfig
 }
 
-// AddUserProgram adds user program
-func (m *Manifest) AddUserProgram(imgpath string) {
+// SetPolicy sets the access policy the kernel enforces on the program, the
+// program is always allowed
+func (m *Manifest) SetPolicy(policy Policy) {
+	m.policy = &policy
+}
+
+// AddUserProgram adds the user program at imgpath, like SetProgram
+func (m *Manifest) AddUserProgram(imgpath string) error {
+	return m.SetProgram(imgpath)
+}
+
+// SetProgram adds the user program at imgpath and makes it the program the
+// image runs
+func (m *Manifest) SetProgram(imgpath string) error {
+	program, err := m.addProgramFile(imgpath)
+	if err != nil {
+		return err
+	}
+	m.program = program
+	return nil
+}
+
+var programNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// AddProgram adds the program at imgpath as name, the kernel runs it instead
+// of the default program when the exec boot argument or the exec= line of
+// the cloud userdata is name
+func (m *Manifest) AddProgram(name, imgpath string) error {
+	if !programNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid program name %q, use lowercase letters, digits, - and _", name)
+	}
+	program, err := m.addProgramFile(imgpath)
+	if err != nil {
+		return err
+	}
+	if m.programs == nil {
+		m.programs = map[string]string{}
+	}
+	m.programs[name] = program
+	return nil
+}
+
+// SelectProgram makes the program added as name the default program of the
+// image
+func (m *Manifest) SelectProgram(name string) error {
+	program, ok := m.programs[name]
+	if !ok {
+		return fmt.Errorf("unknown program %q, the image has %v", name, m.programNames())
+	}
+	m.program = program
+	return nil
+}
+
+// setupProgram is a program the kernel runs to completion before the
+// program of the image
+type setupProgram struct {
+	program string
+	args    []string
+}
+
+// AddSetupProgram adds the program at imgpath to run with args before the
+// program of the image, like a migration before a server. Setup programs
+// run in the order they are added and the image stops if one fails.
+func (m *Manifest) AddSetupProgram(imgpath string, args []string) error {
+	program, err := m.addProgramFile(imgpath)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{program}
+	}
+	m.setup = append(m.setup, setupProgram{program: program, args: args})
+	return nil
+}
+
+// sortedStringKeys returns the keys of values sorted, for the manifest to be
+// written the same way every time
+func sortedStringKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// programNames returns the names of the programs selectable at boot, sorted
+func (m *Manifest) programNames() []string {
+	names := make([]string, 0, len(m.programs))
+	for name := range m.programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addProgramFile adds the program at imgpath and returns its path in the
+// image, programs nanos can't run are rejected
+func (m *Manifest) addProgramFile(imgpath string) (string, error) {
 	parts := strings.Split(imgpath, "/")
 	if parts[0] == "." {
 		parts = parts[1:]
 	}
-	m.program = path.Join("/", path.Join(parts...))
-	err := m.AddFile(m.program, imgpath)
+	program := path.Join("/", path.Join(parts...))
+	// programs are often built on the host, their libraries still come
+	// from the target root
+	if err := m.addHostFile(program, imgpath); err != nil {
+		return "", err
+	}
+	hostpath, err := m.lookupFile(imgpath)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
+	return program, checkProgramFormat(hostpath)
 }
 
 // AddMount adds mount
 func (m *Manifest) AddMount(label, path string) {
 	dir := strings.TrimPrefix(path, "/")
-	m.children[dir] = map[string]interface{}{}
+	m.root.Children[dir] = NewDirNode()
 	m.mounts[label] = path
 }
 
@@ -87,9 +315,94 @@ func (m *Manifest) AddEnvironmentVariable(name string, value string) {
 
 }
 
-// AddKlibs append klibs to manifest file if they don't exist
-func (m *Manifest) AddKlibs(klibs []string) {
+// UseHashIndex makes HashFiles take the sha256 of files that didn't change
+// since a previous build from the hash index at path, and record the ones
+// it computes there
+func (m *Manifest) UseHashIndex(path string) {
+	m.hashIndex = loadHashIndex(path)
+}
+
+// HashFiles records the sha256 of the content of every file of the image in
+// the manifest, for runtime agents or verification tools to check the files
+// against the build. It returns the hashes by image path.
+func (m *Manifest) HashFiles() (map[string]string, error) {
+	if m.fileHashes == nil {
+		m.fileHashes = make(map[string]string)
+	}
+	hashes := map[string]string{}
+	err := m.root.Walk(func(vmpath string, node ManifestNode) error {
+		file, ok := node.(*FileNode)
+		if !ok {
+			return nil
+		}
+		hash, ok := m.fileHashes[file.HostPath]
+		if !ok {
+			hostpath, err := m.lookupFile(file.HostPath)
+			if err != nil {
+				return err
+			}
+			if hash, err = m.hostFileSHA256(hostpath); err != nil {
+				return err
+			}
+			m.fileHashes[file.HostPath] = hash
+		}
+		hashes[vmpath] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.hashIndex != nil {
+		if err := m.hashIndex.save(); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func (m *Manifest) hostFileSHA256(hostpath string) (string, error) {
+	if m.hashIndex != nil {
+		return m.hashIndex.sha256(hostpath)
+	}
+	f, err := os.Open(hostpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return chunkedSHA256(f)
+}
+
+// AddEnvPassthrough sets the environment variables names of the program to
+// their values in the environment of the build. It fails, setting none of
+// them, when one is not set.
+func (m *Manifest) AddEnvPassthrough(names ...string) error {
+	values := map[string]string{}
+	var missing []string
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		values[name] = value
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables to pass to the image are not set: %s", strings.Join(missing, ", "))
+	}
+
+	for _, name := range names {
+		m.AddEnvironmentVariable(name, values[name])
+	}
+	return nil
+}
+
+// AddKlibs append klibs to manifest file if they don't exist, klibs are
+// the names of files of the klib directory
+func (m *Manifest) AddKlibs(klibs []string) error {
 	for _, klib := range klibs {
+		if klib == "" || klib == "." || klib == ".." || strings.ContainsAny(klib, `/\`) {
+			return fmt.Errorf("invalid klib name %q", klib)
+		}
 		var exists bool
 		for _, mKlib := range m.klibs {
 			if mKlib == klib {
@@ -102,6 +415,50 @@ func (m *Manifest) AddKlibs(klibs []string) {
 			m.klibs = append(m.klibs, klib)
 		}
 	}
+	return nil
+}
+
+// AddKlibDir adds dir to the directories klibs are searched in, before the
+// klib directory of the release. Directories added first are searched
+// first, so locally built klibs can override released ones.
+func (m *Manifest) AddKlibDir(dir string) {
+	m.klibDirs = append(m.klibDirs, dir)
+}
+
+// SetKlibPath adds the klib name to the manifest with the file at hostpath,
+// instead of the one found in the klib directories
+func (m *Manifest) SetKlibPath(name string, hostpath string) error {
+	if _, err := os.Stat(hostpath); err != nil {
+		return WithCode(ErrMkfsMissingHostFile, fmt.Errorf("klib %s: %v", name, err))
+	}
+	if err := m.AddKlibs([]string{name}); err != nil {
+		return err
+	}
+	if m.klibPaths == nil {
+		m.klibPaths = make(map[string]string)
+	}
+	m.klibPaths[name] = hostpath
+	return nil
+}
+
+// klibSearchPath returns the directories klibs are searched in, in order
+func (m *Manifest) klibSearchPath() []string {
+	return append(append([]string{}, m.klibDirs...), getKlibsDir(m.nightly))
+}
+
+// klibHostPath returns the host path of the klib name, set with SetKlibPath
+// or found in the klib directories
+func (m *Manifest) klibHostPath(name string) (string, bool) {
+	if hostpath, ok := m.klibPaths[name]; ok {
+		return hostpath, true
+	}
+	for _, dir := range m.klibSearchPath() {
+		hostpath := filepath.Join(dir, name)
+		if _, err := os.Stat(hostpath); err == nil {
+			return hostpath, true
+		}
+	}
+	return "", false
 }
 
 // AddArgument add commandline arguments to
@@ -120,116 +477,191 @@ func (m *Manifest) AddNoTrace(name string) {
 	m.noTrace = append(m.noTrace, name)
 }
 
-// AddKernel the kernel to use
+// AddKernel the kernel to use, the other files of the boot fs are kept
 func (m *Manifest) AddKernel(path string) {
-	node := make(map[string]interface{})
-	node["kernel"] = path
-	m.boot = node
+	m.boot.Children["kernel"] = &FileNode{HostPath: path}
 }
 
 // AddRelative path
 func (m *Manifest) AddRelative(key string, path string) {
-	m.children[key] = path
+	m.root.Children[key] = &FileNode{HostPath: path}
 }
 
 // AddDirectory adds all files in dir to image
 func (m *Manifest) AddDirectory(dir string) error {
-	err := filepath.Walk(dir, func(hostpath string, info os.FileInfo, err error) error {
+	return m.addTree(dir, func(hostpath string) string {
+		// if the path is relative then root it to image path
+		if hostpath[0] != '/' {
+			return "/" + hostpath
+		}
+		return hostpath
+	})
+}
+
+// AddDirectoryFunc adds the files in dir to the image like AddDirectory,
+// except those filter returns false for. filter is called with the lstat
+// info of every file and directory under dir, a directory it returns false
+// for is skipped with all its content.
+func (m *Manifest) AddDirectoryFunc(dir string, filter func(hostpath string, info os.FileInfo) bool) error {
+	prev := m.filter
+	m.filter = filter
+	defer func() { m.filter = prev }()
+	return m.AddDirectory(dir)
+}
+
+// AddRelativeDirectory adds all files in dir to image
+func (m *Manifest) AddRelativeDirectory(src string) error {
+	return m.addTree(src, func(hostpath string) string {
+		return "/" + strings.TrimPrefix(hostpath, src)
+	})
+}
+
+// AddDirectoryTo adds all files in hostDir to image under vmPrefix, so
+// hostDir/a/b is at vmPrefix/a/b
+func (m *Manifest) AddDirectoryTo(vmPrefix string, hostDir string) error {
+	return m.addTree(hostDir, func(hostpath string) string {
+		rel, err := filepath.Rel(hostDir, hostpath)
 		if err != nil {
+			rel = strings.TrimPrefix(hostpath, hostDir)
+		}
+		return path.Join("/", vmPrefix, filepath.ToSlash(rel))
+	})
+}
+
+// AddDirectoryAs is AddDirectoryTo with the host directory first, adding
+// hostDir/a/b at vmDir/a/b
+func (m *Manifest) AddDirectoryAs(hostDir string, vmDir string) error {
+	return m.AddDirectoryTo(vmDir, hostDir)
+}
+
+// MapDirs adds the host files matching the keys of mappings to the image
+// under the directories of their values. The last element of a key is a
+// pattern matched against the names of the files of the directory and its
+// subdirectories, so ./build/*.so mapped to /lib adds ./build/a.so at
+// /lib/a.so and ./build/x/b.so at /lib/x/b.so. The directory may be a
+// pattern too.
+func (m *Manifest) MapDirs(mappings map[string]string) error {
+	srcs := make([]string, 0, len(mappings))
+	for src := range mappings {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	for _, src := range srcs {
+		if err := m.mapDir(src, mappings[src]); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// if the path is relative then root it to image path
-		var vmpath string
-		if hostpath[0] != '/' {
-			vmpath = "/" + hostpath
-		} else {
-			vmpath = hostpath
+func (m *Manifest) mapDir(src string, dest string) error {
+	dir, pattern := filepath.Split(src)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %s: %v", src, err)
+	}
+
+	dirs := []string{dir}
+	if strings.ContainsAny(dir, "*?[") {
+		var err error
+		if dirs, err = filepath.Glob(filepath.Clean(dir)); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", src, err)
 		}
+	}
 
-		if (info.Mode() & os.ModeSymlink) != 0 {
-			info, err = os.Stat(hostpath)
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+			continue
+		}
+		ignores, err := readOpsIgnore(dir)
+		if err != nil {
+			return err
+		}
+		err = filepath.Walk(dir, func(hostpath string, info os.FileInfo, err error) error {
 			if err != nil {
-				fmt.Printf("warning: %v\n", err)
-				// ignore invalid symlinks
+				return err
+			}
+			if skip, err := m.excludedFromWalk(dir, hostpath, info, ignores); skip {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			m.files.prime(hostpath, info)
+			hostdir, filename := filepath.Split(hostpath)
+			if matched, _ := filepath.Match(pattern, filename); !matched {
 				return nil
 			}
 
-			// add link and continue on
-			err = m.AddLink(vmpath, hostpath)
+			reldir, err := filepath.Rel(dir, hostdir)
 			if err != nil {
 				return err
 			}
-
-			return nil
+			return m.AddFile(path.Join(dest, filepath.ToSlash(reldir), filename), hostpath)
+		})
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if info.IsDir() {
-			parts := strings.FieldsFunc(vmpath, func(c rune) bool { return c == '/' })
-			node := m.children
-			for i := 0; i < len(parts); i++ {
-				if _, ok := node[parts[i]]; !ok {
-					node[parts[i]] = make(map[string]interface{})
-				}
-				if reflect.TypeOf(node[parts[i]]).Kind() == reflect.String {
-					err := fmt.Errorf("directory %s is conflicting with an existing file", hostpath)
-					fmt.Println(err)
-					return err
-				}
-				node = node[parts[i]].(map[string]interface{})
-			}
-		} else {
-			err = m.AddFile(vmpath, hostpath)
-			if err != nil {
-				return err
-			}
+// addTree adds the files under the host directory dir to the image at the
+// paths vmpathOf returns for them
+func (m *Manifest) addTree(dir string, vmpathOf func(hostpath string) string) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if m.walking == nil {
+			m.walking = make(map[string]bool)
 		}
-		return nil
+		if m.walking[real] {
+			return WithCode(ErrMkfsSymlinkLoop, fmt.Errorf("symlink loop adding %s, it is in itself", dir))
+		}
+		m.walking[real] = true
+		defer delete(m.walking, real)
+	}
 
-	})
-	return err
-}
+	ignores, err := readOpsIgnore(dir)
+	if err != nil {
+		return err
+	}
 
-// AddRelativeDirectory adds all files in dir to image
-func (m *Manifest) AddRelativeDirectory(src string) error {
-	err := filepath.Walk(src, func(hostpath string, info os.FileInfo, err error) error {
+	err = filepath.Walk(dir, func(hostpath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if skip, err := m.excludedFromWalk(dir, hostpath, info, ignores); skip {
+			return err
+		}
+		m.files.prime(hostpath, info)
 
-		vmpath := "/" + strings.TrimPrefix(hostpath, src)
+		vmpath := vmpathOf(hostpath)
 
 		if (info.Mode() & os.ModeSymlink) != 0 {
-			info, err = os.Stat(hostpath)
+			if m.symlinks == SymlinkSkip {
+				return nil
+			}
+			info, err = m.files.stat(hostpath)
 			if err != nil {
-				fmt.Printf("warning: %v\n", err)
 				// ignore invalid symlinks
+				m.warn(WarningBrokenSymlink, vmpath, "%v", err)
 				return nil
 			}
 
 			// add link and continue on
-			err = m.AddLink(vmpath, hostpath)
-			if err != nil {
-				return err
-			}
-
-			return nil
+			return m.addTreeLink(dir, vmpath, hostpath, info, vmpathOf)
 		}
 
 		if info.IsDir() {
-			parts := strings.FieldsFunc(vmpath, func(c rune) bool { return c == '/' })
-			node := m.children
-			for i := 0; i < len(parts); i++ {
-				if _, ok := node[parts[i]]; !ok {
-					node[parts[i]] = make(map[string]interface{})
-				}
-				if reflect.TypeOf(node[parts[i]]).Kind() == reflect.String {
-					err := fmt.Errorf("directory %s is conflicting with an existing file", hostpath)
-					fmt.Println(err)
-					return err
-				}
-				node = node[parts[i]].(map[string]interface{})
+			if err := m.MkdirAll(vmpath); err != nil {
+				err = fmt.Errorf("directory %s is conflicting with an existing file", hostpath)
+				fmt.Println(err)
+				return err
 			}
+		} else if !info.Mode().IsRegular() {
+			m.warn(WarningSpecialFile, vmpath, "skipping special file %s", hostpath)
 		} else {
 			err = m.AddFile(vmpath, hostpath)
 			if err != nil {
@@ -241,112 +673,340 @@ func (m *Manifest) AddRelativeDirectory(src string) error {
 	return err
 }
 
+// excludedFromWalk tells whether hostpath, found walking dir, matches the
+// exclude patterns or the ignores of the .opsignore file of dir or is
+// filtered out, and the error that skips it in filepath.Walk
+func (m *Manifest) excludedFromWalk(dir string, hostpath string, info os.FileInfo, ignores []excludePattern) (bool, error) {
+	if hostpath == dir {
+		return false, nil
+	}
+	skip := m.filter != nil && !m.filter(hostpath, info)
+	if !skip && len(m.excludes)+len(ignores) > 0 {
+		if rel, err := filepath.Rel(dir, hostpath); err == nil {
+			rel = filepath.ToSlash(rel)
+			skip = excluded(m.excludes, rel, info.IsDir()) || excluded(ignores, rel, info.IsDir())
+		}
+	}
+	if !skip {
+		return false, nil
+	}
+	if info.IsDir() {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
+
+// MkdirAll creates the directory vmpath in the image, and its missing
+// parents. Directories stay in the image when they are empty.
+func (m *Manifest) MkdirAll(vmpath string) error {
+	parts, err := vmPathParts(vmpath)
+	if err != nil {
+		return err
+	}
+	node := m.fs()
+	for i, part := range parts {
+		if _, ok := node.Children[part]; !ok {
+			m.checkCaseCollision(node, part, "/"+strings.Join(parts[:i+1], "/"))
+			node.Children[part] = NewDirNode()
+		}
+		dir, ok := node.Children[part].(*DirNode)
+		if !ok {
+			return fmt.Errorf("%s: %s is not a directory in the image", vmpath, part)
+		}
+		node = dir
+	}
+	return nil
+}
+
+// fileParent returns the directory of the image the file at vmpath is in,
+// creating the missing ones, and the name of the file
+func (m *Manifest) fileParent(vmpath string) (*DirNode, string, error) {
+	parts, err := vmFileParts(vmpath)
+	if err != nil {
+		return nil, "", err
+	}
+	node := m.fs()
+	for i, part := range parts[:len(parts)-1] {
+		if _, ok := node.Children[part]; !ok {
+			m.checkCaseCollision(node, part, "/"+strings.Join(parts[:i+1], "/"))
+			node.Children[part] = NewDirNode()
+		}
+		dir, ok := node.Children[part].(*DirNode)
+		if !ok {
+			return nil, "", WithCode(ErrMkfsPathConflict, fmt.Errorf("file %s: /%s is a file of the image, not a directory", vmpath, strings.Join(parts[:i+1], "/")))
+		}
+		node = dir
+	}
+	name := parts[len(parts)-1]
+	if _, ok := node.Children[name]; !ok {
+		m.checkCaseCollision(node, name, "/"+strings.Join(parts, "/"))
+	}
+	return node, name, nil
+}
+
+// checkCaseCollision warns when name, about to be added to dir at vmpath,
+// differs from the name of another entry of dir only by case. Hosts with
+// case-insensitive filesystems, the default on macOS, have a single file
+// for both, so one of them silently has the content of the other.
+func (m *Manifest) checkCaseCollision(dir *DirNode, name string, vmpath string) {
+	if m.caseNames == nil {
+		m.caseNames = make(map[*DirNode]map[string]string)
+	}
+	names, ok := m.caseNames[dir]
+	if !ok {
+		names = make(map[string]string, len(dir.Children))
+		for child := range dir.Children {
+			names[strings.ToLower(child)] = child
+		}
+		m.caseNames[dir] = names
+	}
+
+	folded := strings.ToLower(name)
+	if other, ok := names[folded]; ok && other != name {
+		if _, ok := dir.Children[other]; ok {
+			m.warn(WarningCaseCollision, vmpath, "%s differs from %s only by case, case-insensitive hosts have a single file for both", vmpath, path.Join(path.Dir(vmpath), other))
+			return
+		}
+	}
+	names[folded] = name
+}
+
+// vmPathParts returns the components of the image path vmpath, relative to
+// the root of the image whether vmpath is absolute or not. Empty and "."
+// components are dropped and ".." ones applied, paths escaping the root are
+// rejected. Components are normalized with vmName.
+func vmPathParts(vmpath string) ([]string, error) {
+	parts := []string{}
+	for _, part := range strings.Split(filepath.ToSlash(vmpath), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) == 0 {
+				return nil, fmt.Errorf("image path %q is outside of the image", vmpath)
+			}
+			parts = parts[:len(parts)-1]
+		default:
+			name, err := vmName(part)
+			if err != nil {
+				return nil, WithCode(ErrMkfsInvalidPath, fmt.Errorf("image path %q: %v", vmpath, err))
+			}
+			parts = append(parts, name)
+		}
+	}
+	return parts, nil
+}
+
+// vmName returns the name of a file of the image in NFC, so names typed and
+// names read from hosts that decompose them, like macOS, are the same file.
+// Names that aren't UTF-8 or have control characters are rejected, the
+// manifest can't carry them to the image filesystem.
+func vmName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("name %q is not valid UTF-8", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("name %q has the control character %U", name, r)
+		}
+	}
+	return norm.NFC.String(name), nil
+}
+
+// vmFileParts is vmPathParts for the path of a file, which has a name and
+// doesn't end with a slash
+func vmFileParts(vmpath string) ([]string, error) {
+	if strings.HasSuffix(filepath.ToSlash(vmpath), "/") {
+		return nil, fmt.Errorf("image path %q of a file ends with a slash", vmpath)
+	}
+	parts, err := vmPathParts(vmpath)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("image path %q of a file has no name", vmpath)
+	}
+	return parts, nil
+}
+
 // FileExists checks if file is present at path in manifest
 func (m *Manifest) FileExists(filepath string) bool {
-	parts := strings.FieldsFunc(filepath, func(c rune) bool { return c == '/' })
-	node := m.children
-	for i := 0; i < len(parts)-1; i++ {
-		if _, ok := node[parts[i]]; !ok {
-			return false
-		}
-		node = node[parts[i]].(map[string]interface{})
+	if _, err := vmFileParts(filepath); err != nil {
+		return false
+	}
+	_, ok := m.root.Lookup(filepath).(*FileNode)
+	return ok
+}
+
+// addTreeLink adds the symlink at hostpath of the directory dir at vmpath.
+// Links to the files of dir point to them with relative targets, so they
+// are not dangling wherever dir is in the image. Links out of dir are
+// flagged, or replaced with the file they point to when symlinks are
+// materialized.
+func (m *Manifest) addTreeLink(dir string, vmpath string, hostpath string, target os.FileInfo, vmpathOf func(hostpath string) string) error {
+	if m.symlinks == SymlinkDereference {
+		return m.AddLink(vmpath, hostpath)
+	}
+
+	s, err := m.files.readlink(hostpath)
+	if err != nil {
+		return err
 	}
-	pathtest := node[parts[len(parts)-1]]
-	if pathtest != nil && reflect.TypeOf(pathtest).Kind() == reflect.String {
-		return true
+
+	if vmTarget, ok := treeLinkTarget(dir, vmpath, hostpath, s, vmpathOf); ok {
+		return m.addLink(vmpath, hostpath, func(string) string { return vmTarget })
+	}
+	if m.materializeSymlinks && target.Mode().IsRegular() {
+		return m.AddFile(vmpath, hostpath)
 	}
-	return false
+	m.warn(WarningExternalSymlink, vmpath, "symlink %s points to %s, out of %s", hostpath, s, dir)
+	return m.AddLink(vmpath, hostpath)
 }
 
-// AddLink to add a file to manifest
+// treeLinkTarget returns the target relative to vmpath of the link at
+// hostpath with target s, if s is in the directory dir
+func treeLinkTarget(dir string, vmpath string, hostpath string, s string, vmpathOf func(hostpath string) string) (string, bool) {
+	hostTarget := s
+	if !filepath.IsAbs(hostTarget) {
+		hostTarget = filepath.Join(filepath.Dir(hostpath), s)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	absTarget, err := filepath.Abs(hostTarget)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absDir, absTarget)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	vmTarget := vmpathOf(filepath.Join(dir, rel))
+	r, err := filepath.Rel(path.Dir(vmpath), vmTarget)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(r), true
+}
+
+// AddLink to add a file to manifest, following the symlink policy
 func (m *Manifest) AddLink(filepath string, hostpath string) error {
-	parts := strings.FieldsFunc(filepath, func(c rune) bool { return c == '/' })
-	node := m.children
+	switch m.symlinks {
+	case SymlinkDereference:
+		return m.addResolvedLink(filepath, hostpath)
+	case SymlinkSkip:
+		return nil
+	}
+	return m.addLink(filepath, hostpath, func(s string) string { return s })
+}
 
-	for i := 0; i < len(parts)-1; i++ {
-		if _, ok := node[parts[i]]; !ok {
-			node[parts[i]] = make(map[string]interface{})
+// addResolvedLink adds the file or the directory the link at hostpath
+// points to at vmpath
+func (m *Manifest) addResolvedLink(vmpath string, hostpath string) error {
+	info, err := m.files.stat(hostpath)
+	if err != nil {
+		m.warn(WarningBrokenSymlink, vmpath, "%v", err)
+		return nil
+	}
+	switch {
+	case info.IsDir():
+		real, err := filepath.EvalSymlinks(hostpath)
+		if err != nil {
+			return err
+		}
+		if err := m.MkdirAll(vmpath); err != nil {
+			return err
 		}
-		node = node[parts[i]].(map[string]interface{})
+		return m.AddDirectoryTo(vmpath, real)
+	case info.Mode().IsRegular():
+		return m.AddFile(vmpath, hostpath)
+	default:
+		m.warn(WarningSpecialFile, vmpath, "skipping special file %s", hostpath)
+		return nil
 	}
+}
 
-	pathtest := node[parts[len(parts)-1]]
-	if pathtest != nil && reflect.TypeOf(pathtest).Kind() != reflect.String {
-		err := fmt.Errorf("file %s overriding an existing directory", filepath)
-		fmt.Println(err)
+// addLink adds the link at hostpath, target maps its target on the host to
+// the one in the image
+func (m *Manifest) addLink(filepath string, hostpath string, target func(s string) string) error {
+	node, name, err := m.fileParent(filepath)
+	if err != nil {
 		return err
 	}
 
-	if pathtest != nil && reflect.TypeOf(pathtest).Kind() == reflect.String && node[parts[len(parts)-1]] != hostpath {
-		fmt.Printf("warning: overwriting existing file %s hostpath old: %s new: %s\n", filepath, node[parts[len(parts)-1]], hostpath)
+	if err := m.checkOverwrite(node.Children[name], filepath, hostpath); err != nil {
+		return err
 	}
 
-	_, err := lookupFile(m.targetRoot, hostpath)
+	_, err = m.lookupFile(hostpath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "please check your manifest for the missing file: %v\n", err)
-			os.Exit(1)
+			return WithCode(ErrMkfsMissingHostFile, fmt.Errorf("please check your manifest for the missing file: %v", err))
 		}
 		return err
 	}
 
-	s, err := os.Readlink(hostpath)
+	s, err := m.files.readlink(hostpath)
 	if err != nil {
-		fmt.Println("bad link")
-		os.Exit(1)
+		return fmt.Errorf("bad link %s: %v", hostpath, err)
 	}
 
-	node[parts[len(parts)-1]] = link{path: s}
+	t, err := vmName(target(s))
+	if err != nil {
+		return WithCode(ErrMkfsInvalidPath, fmt.Errorf("link %s: target %v", filepath, err))
+	}
+	node.Children[name] = &LinkNode{Target: t}
 	return nil
 }
 
 // AddFile to add a file to manifest
 func (m *Manifest) AddFile(filepath string, hostpath string) error {
-	parts := strings.FieldsFunc(filepath, func(c rune) bool { return c == '/' })
-	node := m.children
-
-	for i := 0; i < len(parts)-1; i++ {
-		if _, ok := node[parts[i]]; !ok {
-			node[parts[i]] = make(map[string]interface{})
-		}
-		node = node[parts[i]].(map[string]interface{})
-	}
-
-	pathtest := node[parts[len(parts)-1]]
-	if pathtest != nil && reflect.TypeOf(pathtest).Kind() != reflect.String {
-		err := fmt.Errorf("file '%s' overriding an existing directory", filepath)
-		fmt.Println(err)
-		os.Exit(1)
+	node, name, err := m.fileParent(filepath)
+	if err != nil {
+		return err
 	}
 
-	if pathtest != nil && reflect.TypeOf(pathtest).Kind() == reflect.String && pathtest != hostpath {
-		fmt.Printf("warning: overwriting existing file %s hostpath old: %s new: %s\n", filepath, pathtest, hostpath)
+	if err := m.checkOverwrite(node.Children[name], filepath, hostpath); err != nil {
+		return err
 	}
 
-	_, err := lookupFile(m.targetRoot, hostpath)
+	_, err = m.lookupFile(hostpath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "please check your manifest for the missing file: %v\n", err)
-			os.Exit(1)
+			return WithCode(ErrMkfsMissingHostFile, fmt.Errorf("please check your manifest for the missing file: %v", err))
 		}
 		return err
 	}
 
-	node[parts[len(parts)-1]] = hostpath
+	node.Children[name] = &FileNode{HostPath: hostpath}
 	return nil
 }
 
-// AddLibrary to add a dependent library
-func (m *Manifest) AddLibrary(path string) {
-	parts := strings.FieldsFunc(path, func(c rune) bool { return c == '/' })
-	node := m.children
-	for i := 0; i < len(parts)-1; i++ {
-		if _, ok := node[parts[i]]; !ok {
-			node[parts[i]] = make(map[string]interface{})
+// checkOverwrite checks that the node at vmpath, if any, can be replaced by
+// the file or link at hostpath. Files are replaced with a warning,
+// directories and links are not.
+func (m *Manifest) checkOverwrite(node ManifestNode, vmpath string, hostpath string) error {
+	switch node := node.(type) {
+	case nil:
+	case *FileNode:
+		if node.HostPath != hostpath {
+			m.warn(WarningOverwrittenFile, vmpath, "overwriting existing file %s hostpath old: %s new: %s", vmpath, node.HostPath, hostpath)
 		}
-		node = node[parts[i]].(map[string]interface{})
+	default:
+		return WithCode(ErrMkfsPathConflict, fmt.Errorf("file %s overriding an existing directory", vmpath))
 	}
-	node[parts[len(parts)-1]] = path
+	return nil
+}
+
+// AddLibrary to add a dependent library
+func (m *Manifest) AddLibrary(path string) error {
+	node, name, err := m.fileParent(path)
+	if err != nil {
+		return err
+	}
+	node.Children[name] = &FileNode{HostPath: path}
+	return nil
 }
 
 // AddUserData adds all files in dir to
@@ -355,65 +1015,124 @@ func (m *Manifest) AddUserData(dir string) {
 	// TODO
 }
 
+// escapeValue quotes s for the manifest when it has characters the manifest
+// parser would stop at
 func escapeValue(s string) string {
-	if strings.Contains(s, "\"") {
+	if s == "" || strings.ContainsAny(s, "\"\\:()[] \t\n") {
+		s = strings.Replace(s, "\\", "\\\\", -1)
 		s = strings.Replace(s, "\"", "\\\"", -1)
-	}
-	if strings.ContainsAny(s, "\":()[] \t\n") {
 		s = "\"" + s + "\""
 	}
 	return s
 }
 
+// manifestWriter is where manifests are written, a *strings.Builder or a
+// *bufio.Writer
+type manifestWriter interface {
+	WriteString(s string) (int, error)
+	WriteRune(r rune) (int, error)
+}
+
 func (m *Manifest) String() string {
-	sb := m.sb
+	var sb strings.Builder
+	m.write(&sb)
+	return sb.String()
+}
+
+// WriteTo writes the manifest to w as it is generated, without holding its
+// whole text in memory
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriterSize(cw, 64<<10)
+	m.write(bw)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (m *Manifest) write(sb manifestWriter) {
 	sb.WriteString("(\n")
 
 	// write boot fs
 
-	if len(m.boot) > 0 {
+	if len(m.boot.Children) > 0 {
 		sb.WriteString("boot:(children:(\n")
-		toString(&m.boot, &sb, 4)
+		writeDir(sb, m.boot, 4, "", nil, nil)
 
-		// include klibs specified in configuration if present in ops klib directory
+		// include klibs specified in configuration if present in a klib directory
 		if len(m.klibs) > 0 {
-			klibs := map[string]interface{}{}
-			klibsPath := getKlibsDir(m.nightly)
-			if _, err := os.Stat(klibsPath); !os.IsNotExist(err) {
-
-				sb.WriteString("    klib:(children:(\n")
-
-				for _, klibName := range m.klibs {
-					klibPath := klibsPath + "/" + klibName
-
-					if _, err := os.Stat(klibPath); !os.IsNotExist(err) {
-						klibs[klibName] = klibPath
-					} else {
-						fmt.Printf("Klib %s not found in directory %s\n", klibName, klibsPath)
-					}
+			klibs := NewDirNode()
+			for _, klibName := range m.klibs {
+				if klibPath, ok := m.klibHostPath(klibName); ok {
+					klibs.Children[klibName] = &FileNode{HostPath: klibPath}
+				} else {
+					fmt.Printf("Klib %s not found in directories %s\n", klibName, strings.Join(m.klibSearchPath(), ", "))
 				}
-				toString(&klibs, &sb, 6)
-
+			}
+			if len(klibs.Children) > 0 {
+				sb.WriteString("    klib:(children:(\n")
+				writeDir(sb, klibs, 6, "", nil, nil)
 				sb.WriteString("    ))\n")
-			} else {
-				fmt.Printf("Klibs directory with path %s not found\n", klibsPath)
 			}
 		}
 
-		sb.WriteString("))\n")
+		sb.WriteString(")")
+		writeTuples(sb, m.bootTuples, " ", "")
+		sb.WriteString(")\n")
 	}
 
 	// write root fs
 	sb.WriteString("children:(\n")
-	toString(&m.children, &sb, 4)
+	writeDir(sb, m.root, 4, "", m.fileHashes, m.nodeAttributes)
 	sb.WriteString(")\n")
 
 	// program
 	if m.program != "" {
 		sb.WriteString("program:")
-		sb.WriteString(m.program)
+		sb.WriteString(escapeValue(m.program))
 		sb.WriteRune('\n')
 	}
+	if len(m.setup) > 0 {
+		sb.WriteString("setup:[")
+		for i, setup := range m.setup {
+			if i > 0 {
+				sb.WriteRune(' ')
+			}
+			args := make([]string, len(setup.args))
+			for j, arg := range setup.args {
+				args[j] = escapeValue(arg)
+			}
+			sb.WriteString("(program:")
+			sb.WriteString(escapeValue(setup.program))
+			sb.WriteString(" arguments:[")
+			sb.WriteString(strings.Join(args, " "))
+			sb.WriteString("])")
+		}
+		sb.WriteString("]\n")
+	}
+	if len(m.programs) > 0 {
+		sb.WriteString("programs:(")
+		for i, name := range m.programNames() {
+			if i > 0 {
+				sb.WriteRune(' ')
+			}
+			sb.WriteString(name)
+			sb.WriteRune(':')
+			sb.WriteString(escapeValue(m.programs[name]))
+		}
+		sb.WriteString(")\n")
+	}
 
 	//
 	if len(m.klibs) > 0 {
@@ -445,7 +1164,6 @@ func (m *Manifest) String() string {
 	// arguments
 	sb.WriteString("arguments:[")
 	if len(m.args) > 0 {
-		fmt.Println(m.args)
 		escapedArgs := make([]string, len(m.args))
 		for i, arg := range m.args {
 			escapedArgs[i] = escapeValue(arg)
@@ -455,46 +1173,84 @@ func (m *Manifest) String() string {
 	sb.WriteString("]\n")
 
 	// debug
-	for k, v := range m.debugFlags {
+	debugFlags := make([]string, 0, len(m.debugFlags))
+	for k := range m.debugFlags {
+		debugFlags = append(debugFlags, k)
+	}
+	sort.Strings(debugFlags)
+	for _, k := range debugFlags {
+		if _, ok := m.rootTuples[k]; ok {
+			continue
+		}
 		sb.WriteString(k)
 		sb.WriteRune(':')
-		sb.WriteRune(v)
+		sb.WriteRune(m.debugFlags[k])
 		sb.WriteRune('\n')
 	}
 
 	// notrace
 	if len(m.noTrace) > 0 {
+		noTrace := make([]string, len(m.noTrace))
+		for i, name := range m.noTrace {
+			noTrace[i] = escapeValue(name)
+		}
 		sb.WriteString("notrace:[")
-		sb.WriteString(strings.Join(m.noTrace, " "))
+		sb.WriteString(strings.Join(noTrace, " "))
 		sb.WriteString("]\n")
 	}
 
 	// environment
-	n := len(m.environment)
 	sb.WriteString("environment:(")
-	for k, v := range m.environment {
-		n = n - 1
-		sb.WriteString(k)
-		sb.WriteRune(':')
-		sb.WriteString(escapeValue(v))
-		if n > 0 {
+	for i, k := range sortedStringKeys(m.environment) {
+		if i > 0 {
 			sb.WriteRune(' ')
 		}
+		sb.WriteString(escapeValue(k))
+		sb.WriteRune(':')
+		sb.WriteString(escapeValue(m.environment[k]))
 	}
 	sb.WriteString(")\n")
 
 	// mounts
 	if len(m.mounts) > 0 {
 		sb.WriteString("mounts:(\n")
-		for k, v := range m.mounts {
+		for _, k := range sortedStringKeys(m.mounts) {
 			sb.WriteString("    ")
-			sb.WriteString(k)
+			sb.WriteString(escapeValue(k))
 			sb.WriteRune(':')
-			sb.WriteString(v)
+			sb.WriteString(escapeValue(m.mounts[k]))
 			sb.WriteRune('\n')
 		}
 		sb.WriteString(")\n")
 	}
+	m.writeTmpfs(sb)
+
+	if m.policy != nil {
+		var allowed []string
+		if m.program != "" {
+			allowed = append(allowed, escapeValue(m.program))
+		}
+		for _, setup := range m.setup {
+			allowed = append(allowed, escapeValue(setup.program))
+		}
+		for _, name := range m.programNames() {
+			if m.programs[name] != m.program {
+				allowed = append(allowed, escapeValue(m.programs[name]))
+			}
+		}
+		for _, p := range m.policy.AllowedPaths {
+			allowed = append(allowed, escapeValue(p))
+		}
+		network := "f"
+		if m.policy.Network {
+			network = "t"
+		}
+		sb.WriteString("policy:(allowed_paths:[")
+		sb.WriteString(strings.Join(allowed, " "))
+		sb.WriteString("] network:")
+		sb.WriteString(network)
+		sb.WriteString(")\n")
+	}
 
 	if m.networkConfig != nil {
 		sb.WriteString("ipaddr:")
@@ -506,47 +1262,56 @@ func (m *Manifest) String() string {
 		sb.WriteRune('\n')
 	}
 
+	writeTuples(sb, m.rootTuples, "", "\n")
+
 	//
 	sb.WriteString(")\n")
-	return sb.String()
 }
 
-func toString(m *map[string]interface{}, sb *strings.Builder, indent int) {
-	for k, v := range *m {
-		sb.WriteString(strings.Repeat(" ", indent))
+// nodeAttributes returns the attributes of the tuple of the entry node at
+// vmpath of the root fs besides its content
+func (m *Manifest) nodeAttributes(vmpath string, node ManifestNode) string {
+	return ownerAttributes(m.owners, vmpath) + m.modTimeAttribute()
+}
 
-		nvalue, nok := v.(link)
-		// link
-		if nok {
-			sb.WriteString(escapeValue(k))
-			sb.WriteString(":(linktarget:")
-			sb.WriteString(escapeValue(nvalue.path))
-			sb.WriteString(")\n")
-			continue
-		}
+// writeDir writes the entries of d, the directory at image path dir, in
+// lexical order with the sha256 of its files by host path from hashes and
+// the attributes of its entries from attrs, if set
+func writeDir(sb manifestWriter, d *DirNode, indent int, dir string, hashes map[string]string, attrs func(vmpath string, node ManifestNode) string) {
+	names := make([]string, 0, len(d.Children))
+	for name := range d.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		value, ok := v.(string)
+	for _, name := range names {
+		sb.WriteString(strings.Repeat(" ", indent))
+		sb.WriteString(escapeValue(name))
 
-		// file
-		if ok {
-			sb.WriteString(escapeValue(k))
+		switch node := d.Children[name].(type) {
+		case *LinkNode:
+			sb.WriteString(":(linktarget:")
+			sb.WriteString(escapeValue(node.Target))
+		case *FileNode:
 			sb.WriteString(":(contents:(host:")
-			sb.WriteString(escapeValue(value))
-			sb.WriteString("))\n")
-
-			// dir
-		} else {
-			sb.WriteString(k)
+			sb.WriteString(escapeValue(node.HostPath))
+			sb.WriteString(")")
+			if hash, ok := hashes[node.HostPath]; ok {
+				sb.WriteString(" sha256:")
+				sb.WriteString(hash)
+			}
+		case *DirNode:
 			sb.WriteString(":(children:(")
-			// recur
-			ch := v.(map[string]interface{})
-			if len(ch) > 0 {
+			if len(node.Children) > 0 {
 				sb.WriteRune('\n')
-				toString(&ch, sb, indent+4)
+				writeDir(sb, node, indent+4, dir+"/"+name, hashes, attrs)
 				sb.WriteString(strings.Repeat(" ", indent))
 			}
-
-			sb.WriteString("))\n")
+			sb.WriteString(")")
 		}
+		if attrs != nil {
+			sb.WriteString(attrs(dir+"/"+name, d.Children[name]))
+		}
+		sb.WriteString(")\n")
 	}
 }
@@ -0,0 +1,106 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// benchmarkTree returns a directory of n small files, 100 per directory,
+// for benchmarks of large images
+func benchmarkTree(b *testing.B, n int) string {
+	b.Helper()
+	dir, err := ioutil.TempDir("", "bench-tree")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%04d", i/100))
+		if i%100 == 0 {
+			if err := os.Mkdir(sub, 0755); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(sub, fmt.Sprintf("f%02d.dat", i%100)), []byte("x"), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func BenchmarkAddDirectory(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		dir := benchmarkTree(b, n)
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := NewManifest("")
+				if err := m.AddDirectoryTo("/data", dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		os.RemoveAll(dir)
+	}
+}
+
+func BenchmarkManifestWriteTo(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		m := NewManifest("")
+		m.SetModTime(time.Unix(1600000000, 0))
+		for i := 0; i < n; i++ {
+			if err := m.AddLibrary(fmt.Sprintf("/data/d%04d/f%02d.dat", i/100, i%100)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := m.WriteTo(ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExecute measures mkfs writing an image of 10k files, with the
+// mkfs of the latest release
+func BenchmarkExecute(b *testing.B) {
+	mkfs := path.Join(GetOpsHome(), LatestReleaseVersion, "mkfs")
+	if _, err := os.Stat(mkfs); err != nil {
+		b.Skip("mkfs of the latest release not found: ", err)
+	}
+
+	dir := benchmarkTree(b, 10000)
+	defer os.RemoveAll(dir)
+	m := NewManifest("")
+	if err := m.AddDirectoryTo("/data", dir); err != nil {
+		b.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "manifest")
+	if err := writeManifestFile(m, manifest); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(manifest)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cmd := NewMkfsCommand(mkfs)
+		cmd.SetStdin(f)
+		cmd.SetFileSystemPath(filepath.Join(dir, "image"))
+		cmd.SetupCommand()
+		err = cmd.Execute()
+		f.Close()
+		if err != nil {
+			b.Fatalf("%v: %s", err, cmd.GetOutput())
+		}
+	}
+}
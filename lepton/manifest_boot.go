@@ -0,0 +1,42 @@
+package lepton
+
+// AddBootFile adds the file at hostpath to the boot fs of the image at
+// vmpath, like AddFile does to the root fs
+func (m *Manifest) AddBootFile(vmpath string, hostpath string) error {
+	return m.onBootFS(func() error {
+		return m.AddFile(vmpath, hostpath)
+	})
+}
+
+// AddBootDirectory adds all files in dir to the boot fs of the image, like
+// AddDirectory does to the root fs
+func (m *Manifest) AddBootDirectory(dir string) error {
+	return m.onBootFS(func() error {
+		return m.AddDirectory(dir)
+	})
+}
+
+// AddBootDirectoryTo adds all files in hostDir to the boot fs of the image
+// under vmPrefix, like AddDirectoryTo does to the root fs
+func (m *Manifest) AddBootDirectoryTo(vmPrefix string, hostDir string) error {
+	return m.onBootFS(func() error {
+		return m.AddDirectoryTo(vmPrefix, hostDir)
+	})
+}
+
+// onBootFS runs fn with the files it adds going to the boot fs, with the
+// validations and warnings of the root fs
+func (m *Manifest) onBootFS(fn func() error) error {
+	prev := m.adding
+	m.adding = m.boot
+	defer func() { m.adding = prev }()
+	return fn()
+}
+
+// fs returns the tree of the filesystem files are being added to
+func (m *Manifest) fs() *DirNode {
+	if m.adding != nil {
+		return m.adding
+	}
+	return m.root
+}
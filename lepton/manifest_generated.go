@@ -0,0 +1,77 @@
+package lepton
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SetGeneratedDir sets the host directory the content of AddFileFromReader
+// and AddFileBytes is written to, mkfs reads the files of the image from the
+// host. A temporary directory is created when it is not set.
+func (m *Manifest) SetGeneratedDir(dir string) {
+	m.generatedDir = dir
+}
+
+// AddFileFromReader adds a file with the content of r to the image at
+// vmpath, like a config generated at build time. The content is written to
+// a file of the generated directory, which must be kept until the image is
+// written, builds remove it once mkfs has run.
+func (m *Manifest) AddFileFromReader(vmpath string, r io.Reader) error {
+	if _, err := vmFileParts(vmpath); err != nil {
+		return err
+	}
+	if m.generatedDir == "" {
+		dir, err := ioutil.TempDir("", "ops-generated")
+		if err != nil {
+			return err
+		}
+		m.generatedDir = dir
+		m.generatedTemp = true
+	}
+
+	f, err := ioutil.TempFile(m.generatedDir, "generated")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = m.addHostFile(vmpath, f.Name())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	m.generated = append(m.generated, f.Name())
+	return nil
+}
+
+// AddFileBytes adds a file with content data to the image at vmpath, like
+// AddFileFromReader
+func (m *Manifest) AddFileBytes(vmpath string, data []byte) error {
+	return m.AddFileFromReader(vmpath, bytes.NewReader(data))
+}
+
+// RemoveGeneratedFiles removes the files AddFileFromReader and AddFileBytes
+// wrote, once the image is written
+func (m *Manifest) RemoveGeneratedFiles() error {
+	var err error
+	for _, file := range m.generated {
+		if rerr := os.Remove(file); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+			err = rerr
+		}
+	}
+	m.generated = nil
+	if m.generatedTemp {
+		if rerr := os.RemoveAll(m.generatedDir); rerr != nil && err == nil {
+			err = rerr
+		}
+		m.generatedDir = ""
+		m.generatedTemp = false
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddFileFromReader(t *testing.T) {
+	m := NewManifest("")
+	if err := m.AddFileFromReader("/etc/app/config.json", strings.NewReader(`{"port":80}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFileBytes("/etc/motd", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	defer m.RemoveGeneratedFiles()
+
+	for vmpath, content := range map[string]string{"/etc/app/config.json": `{"port":80}`, "/etc/motd": "hello"} {
+		file, ok := m.root.Lookup(vmpath).(*FileNode)
+		if !ok {
+			t.Fatalf("expected %s in the image", vmpath)
+		}
+		data, err := ioutil.ReadFile(file.HostPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: got %q, want %q", vmpath, data, content)
+		}
+	}
+
+	if err := m.AddFileBytes("/", []byte("x")); err == nil {
+		t.Error("expected an error for the root")
+	}
+	if err := m.AddFileBytes("/etc/motd/x", []byte("x")); err == nil {
+		t.Error("expected an error for a file under a file")
+	}
+	if len(m.generated) != 2 {
+		t.Errorf("expected the files of failed adds to be removed, got %v", m.generated)
+	}
+
+	dir := m.generatedDir
+	if err := m.RemoveGeneratedFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", dir, err)
+	}
+}
@@ -0,0 +1,290 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestJSONVersion is the version of the json format of manifests,
+// manifests of other versions are rejected when read
+const manifestJSONVersion = 1
+
+// manifestJSON is the json format of a Manifest
+type manifestJSON struct {
+	Version          int                    `json:"version"`
+	TargetRoot       string                 `json:"target_root,omitempty"`
+	StrictTargetRoot bool                   `json:"strict_target_root,omitempty"`
+	HostFallback     []string               `json:"host_fallback,omitempty"`
+	Nightly          bool                   `json:"nightly,omitempty"`
+	Boot             *nodeJSON              `json:"boot,omitempty"`
+	Root             *nodeJSON              `json:"root"`
+	Program          string                 `json:"program,omitempty"`
+	Programs         map[string]string      `json:"programs,omitempty"`
+	Setup            []setupProgramJSON     `json:"setup,omitempty"`
+	Arguments        []string               `json:"arguments,omitempty"`
+	DebugFlags       map[string]string      `json:"debug_flags,omitempty"`
+	NoTrace          []string               `json:"notrace,omitempty"`
+	Environment      map[string]string      `json:"environment,omitempty"`
+	Mounts           map[string]string      `json:"mounts,omitempty"`
+	Tmpfs            map[string]int64       `json:"tmpfs,omitempty"`
+	Klibs            []string               `json:"klibs,omitempty"`
+	KlibDirs         []string               `json:"klib_dirs,omitempty"`
+	KlibPaths        map[string]string      `json:"klib_paths,omitempty"`
+	FileHashes       map[string]string      `json:"file_hashes,omitempty"`
+	Owners           map[string]Owner       `json:"owners,omitempty"`
+	ModTime          int64                  `json:"mtime,omitempty"`
+	NetworkConfig    *ManifestNetworkConfig `json:"network_config,omitempty"`
+	Policy           *Policy                `json:"policy,omitempty"`
+	KernelTuples     []string               `json:"kernel_tuples,omitempty"`
+	RootTuples       map[string]interface{} `json:"root_tuples,omitempty"`
+	BootTuples       map[string]interface{} `json:"boot_tuples,omitempty"`
+}
+
+// nodeJSON is a node of the trees of a manifest in json, the field set
+// tells its kind
+type nodeJSON struct {
+	Children map[string]*nodeJSON `json:"children,omitempty"`
+	Host     string               `json:"host,omitempty"`
+	Link     string               `json:"linktarget,omitempty"`
+}
+
+type setupProgramJSON struct {
+	Program   string   `json:"program"`
+	Arguments []string `json:"arguments"`
+}
+
+// MarshalJSON returns the manifest as json, to save, inspect or version it
+// and build the same image from it later without adding its files again.
+// Files are recorded by host path, they are read when the image is built.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	mj := manifestJSON{
+		Version:          manifestJSONVersion,
+		TargetRoot:       m.targetRoot,
+		StrictTargetRoot: m.strictTargetRoot,
+		Nightly:          m.nightly,
+		Root:             dirToJSON(m.root),
+		Program:          m.program,
+		Programs:         m.programs,
+		Arguments:        m.args,
+		NoTrace:          m.noTrace,
+		Environment:      m.environment,
+		Mounts:           m.mounts,
+		Tmpfs:            m.tmpfs,
+		Klibs:            m.klibs,
+		KlibDirs:         m.klibDirs,
+		KlibPaths:        m.klibPaths,
+		FileHashes:       m.fileHashes,
+		Owners:           m.owners,
+		NetworkConfig:    m.networkConfig,
+		Policy:           m.policy,
+		KernelTuples:     m.kernelTuples,
+		RootTuples:       m.rootTuples,
+		BootTuples:       m.bootTuples,
+	}
+	for hostpath := range m.hostFallback {
+		mj.HostFallback = append(mj.HostFallback, hostpath)
+	}
+	sort.Strings(mj.HostFallback)
+	if !m.modTime.IsZero() {
+		mj.ModTime = m.modTime.Unix()
+	}
+	if len(m.boot.Children) > 0 {
+		mj.Boot = dirToJSON(m.boot)
+	}
+	for _, setup := range m.setup {
+		mj.Setup = append(mj.Setup, setupProgramJSON{Program: setup.program, Arguments: setup.args})
+	}
+	if len(m.debugFlags) > 0 {
+		mj.DebugFlags = make(map[string]string, len(m.debugFlags))
+		for name, value := range m.debugFlags {
+			mj.DebugFlags[name] = string(value)
+		}
+	}
+	return json.Marshal(mj)
+}
+
+// UnmarshalJSON replaces the content of the manifest by the manifest data
+// returned by MarshalJSON
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	var mj manifestJSON
+	if err := json.Unmarshal(data, &mj); err != nil {
+		return err
+	}
+	if mj.Version != manifestJSONVersion {
+		return fmt.Errorf("unsupported manifest version %d, expected %d", mj.Version, manifestJSONVersion)
+	}
+
+	n := NewManifest(mj.TargetRoot)
+	n.strictTargetRoot = mj.StrictTargetRoot
+	for _, hostpath := range mj.HostFallback {
+		if n.hostFallback == nil {
+			n.hostFallback = make(map[string]bool)
+		}
+		n.hostFallback[hostpath] = true
+	}
+	n.nightly = mj.Nightly
+	// a manifest of NewManifest keeps its warning output
+	if m.files != nil {
+		n.warningOutput = m.warningOutput
+	}
+
+	var err error
+	if mj.Root != nil {
+		if n.root, err = dirFromJSON(mj.Root); err != nil {
+			return fmt.Errorf("root: %v", err)
+		}
+	}
+	if mj.Boot != nil {
+		if n.boot, err = dirFromJSON(mj.Boot); err != nil {
+			return fmt.Errorf("boot: %v", err)
+		}
+	}
+
+	n.program = mj.Program
+	n.programs = mj.Programs
+	for _, setup := range mj.Setup {
+		n.setup = append(n.setup, setupProgram{program: setup.Program, args: setup.Arguments})
+	}
+	n.args = mj.Arguments
+	for name, value := range mj.DebugFlags {
+		runes := []rune(value)
+		if len(runes) != 1 {
+			return fmt.Errorf("invalid debug flag %s:%q", name, value)
+		}
+		n.debugFlags[name] = runes[0]
+	}
+	n.noTrace = mj.NoTrace
+	if mj.Environment != nil {
+		n.environment = mj.Environment
+	}
+	if mj.Mounts != nil {
+		n.mounts = mj.Mounts
+	}
+	n.tmpfs = mj.Tmpfs
+	n.klibs = mj.Klibs
+	n.klibDirs = mj.KlibDirs
+	n.klibPaths = mj.KlibPaths
+	n.fileHashes = mj.FileHashes
+	n.owners = mj.Owners
+	if mj.ModTime != 0 {
+		n.modTime = time.Unix(mj.ModTime, 0).UTC()
+	}
+	n.networkConfig = mj.NetworkConfig
+	n.policy = mj.Policy
+	n.kernelTuples = mj.KernelTuples
+
+	if n.rootTuples, err = tuplesFromJSON(mj.RootTuples); err != nil {
+		return fmt.Errorf("root tuples: %v", err)
+	}
+	if n.bootTuples, err = tuplesFromJSON(mj.BootTuples); err != nil {
+		return fmt.Errorf("boot tuples: %v", err)
+	}
+
+	*m = *n
+	return nil
+}
+
+// ReadManifestJSON returns the manifest saved as json at path
+func ReadManifestJSON(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := NewManifest("")
+	if err := m.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func dirToJSON(d *DirNode) *nodeJSON {
+	children := make(map[string]*nodeJSON, len(d.Children))
+	for name, node := range d.Children {
+		switch node := node.(type) {
+		case *DirNode:
+			children[name] = dirToJSON(node)
+		case *FileNode:
+			children[name] = &nodeJSON{Host: node.HostPath}
+		case *LinkNode:
+			children[name] = &nodeJSON{Link: node.Target}
+		}
+	}
+	return &nodeJSON{Children: children}
+}
+
+func dirFromJSON(nj *nodeJSON) (*DirNode, error) {
+	d := NewDirNode()
+	for name, child := range nj.Children {
+		if child == nil {
+			return nil, fmt.Errorf("%s: empty node", name)
+		}
+		if strings.Contains(name, "/") {
+			return nil, WithCode(ErrMkfsInvalidPath, fmt.Errorf("%s: names can't have slashes", name))
+		}
+		name, err := vmName(name)
+		if err != nil {
+			return nil, WithCode(ErrMkfsInvalidPath, err)
+		}
+		switch {
+		case child.Host != "" && child.Link == "" && child.Children == nil:
+			d.Children[name] = &FileNode{HostPath: child.Host}
+		case child.Link != "" && child.Host == "" && child.Children == nil:
+			d.Children[name] = &LinkNode{Target: child.Link}
+		case child.Host == "" && child.Link == "":
+			sub, err := dirFromJSON(child)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%v", name, err)
+			}
+			d.Children[name] = sub
+		default:
+			return nil, fmt.Errorf("%s: node is more than one of a file, a link and a directory", name)
+		}
+	}
+	return d, nil
+}
+
+// tuplesFromJSON returns the tuples decoded from json with the types of
+// SetRootTuple: integers, []string vectors and tuples of those
+func tuplesFromJSON(tuples map[string]interface{}) (map[string]interface{}, error) {
+	if tuples == nil {
+		return nil, nil
+	}
+	for key, value := range tuples {
+		v, err := tupleFromJSON(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		tuples[key] = v
+	}
+	return tuples, nil
+}
+
+func tupleFromJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("vector value %v is not a string", s)
+			}
+			values[i] = str
+		}
+		return values, nil
+	case map[string]interface{}:
+		return tuplesFromJSON(v)
+	}
+	return nil, fmt.Errorf("unsupported tuple value %v of type %T", value, value)
+}
@@ -0,0 +1,71 @@
+package lepton
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestManifestJSON(t *testing.T) {
+	m := NewManifest("")
+	m.AddKernel("kernel/kernel")
+	if err := m.SetProgram("../data/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddSetupProgram("../data/main", []string{"main", "migrate"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/lib"); err != nil {
+		t.Fatal(err)
+	}
+	m.Root().Lookup("/lib").(*DirNode).Children["libc.so"] = &LinkNode{Target: "/lib/libc.so.6"}
+	if err := m.AddTmpfs("/tmp", "64M"); err != nil {
+		t.Fatal(err)
+	}
+	m.AddArgument("main")
+	m.AddEnvironmentVariable("PORT", "8080")
+	m.AddDebugFlag("trace", 't')
+	m.SetPolicy(Policy{AllowedPaths: []string{"/etc"}, Network: true})
+	if err := m.SetRootTuple("klib/option", map[string]interface{}{"size": 4096, "names": []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewManifest("")
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("got %s, want %s", again, data)
+	}
+	if _, ok := loaded.Root().Lookup("/lib/libc.so").(*LinkNode); !ok {
+		t.Errorf("expected /lib/libc.so to be a link, got %#v", loaded.Root().Lookup("/lib/libc.so"))
+	}
+	want := map[string]interface{}{"option": map[string]interface{}{"size": int64(4096), "names": []string{"a", "b"}}}
+	if got := loaded.rootTuples["klib"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tuples %#v, want %#v", got, want)
+	}
+	if loaded.debugFlags["trace"] != 't' {
+		t.Errorf("got debug flags %v", loaded.debugFlags)
+	}
+
+	t.Run("should reject other versions and ambiguous nodes", func(t *testing.T) {
+		for _, data := range []string{
+			`{"version":2,"root":{}}`,
+			`{"version":1,"root":{"children":{"a":{"host":"a","linktarget":"b"}}}}`,
+		} {
+			if err := json.Unmarshal([]byte(data), NewManifest("")); err == nil {
+				t.Errorf("expected %s to fail", data)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SetModTime sets the modification time of every file, directory and
+// symlink of the image to t, for images that are the same byte for byte
+// whenever they are built. The entries of the image have no modification
+// time when t is zero, the default.
+func (m *Manifest) SetModTime(t time.Time) {
+	m.modTime = t
+}
+
+// modTimeAttribute returns the mtime attribute of the tuples of the entries,
+// empty when no modification time is set
+func (m *Manifest) modTimeAttribute() string {
+	if m.modTime.IsZero() {
+		return ""
+	}
+	return " mtime:" + strconv.FormatInt(m.modTime.Unix(), 10)
+}
+
+// ParseModTime parses the ModTime of a config, an RFC 3339 time or seconds
+// since the epoch. The SOURCE_DATE_EPOCH environment variable of
+// reproducible builds is used when s is empty, the zero time is returned
+// when it is not set either.
+func ParseModTime(s string) (time.Time, error) {
+	if s == "" {
+		s = os.Getenv("SOURCE_DATE_EPOCH")
+		if s == "" {
+			return time.Time{}, nil
+		}
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid modification time %q, expected RFC 3339 or seconds since the epoch", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,88 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseModTime(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	for _, tt := range []struct {
+		s    string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"1600000000", time.Unix(1600000000, 0)},
+		{"2020-09-13T12:26:40Z", time.Unix(1600000000, 0)},
+	} {
+		got, err := ParseModTime(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	if got, err := ParseModTime(""); err != nil || !got.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("got %v, %v, want the time of SOURCE_DATE_EPOCH", got, err)
+	}
+	if _, err := ParseModTime("yesterday"); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
+
+func TestManifestModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := time.Unix(1500000000, 0)
+	if err := os.Chtimes(file, host, host); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("")
+	if err := m.AddFile("/etc/a", file); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.String(); strings.Contains(s, "mtime:") || !strings.Contains(s, "a:(contents:(host:"+file+"))") {
+		t.Errorf("expected no modification time by default in\n%s", s)
+	}
+
+	m.SetModTime(time.Unix(1600000000, 0))
+	s := m.String()
+	for _, want := range []string{"a:(contents:(host:" + file + ") mtime:1600000000)", "\n    ) mtime:1600000000)"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %s in\n%s", want, s)
+		}
+	}
+	if strings.Count(s, "mtime:") != 2 || strings.Contains(s, strconv.Itoa(1500000000)) {
+		t.Errorf("expected the fixed modification time in\n%s", s)
+	}
+
+	data, err := m.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewManifest("")
+	if err := loaded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.modTime.Equal(m.modTime) {
+		t.Errorf("got modification time %v, want %v", loaded.modTime, m.modTime)
+	}
+}
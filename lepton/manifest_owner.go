@@ -0,0 +1,50 @@
+package lepton
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Owner is the user and group owning a file, directory or symlink of an
+// image
+type Owner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// SetOwner sets the user and group owning the file, directory or symlink at
+// vmpath of the image, for programs checking the ownership of their files.
+// Entries without an owner are owned by root. The kernel must read the uid
+// and gid tuples, see SetKernelTuples.
+func (m *Manifest) SetOwner(vmpath string, uid, gid int) error {
+	parts, err := vmPathParts(vmpath)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("the root directory of the image can't have an owner")
+	}
+	if uid < 0 || gid < 0 {
+		return fmt.Errorf("%s: invalid owner %d:%d", vmpath, uid, gid)
+	}
+	if m.root.Lookup(vmpath) == nil {
+		return fmt.Errorf("%s is not in the image", vmpath)
+	}
+
+	if m.owners == nil {
+		m.owners = make(map[string]Owner)
+	}
+	m.owners["/"+strings.Join(parts, "/")] = Owner{UID: uid, GID: gid}
+	return nil
+}
+
+// ownerAttributes returns the attributes of the tuple of the entry at vmpath
+// for its owner in owners, if any
+func ownerAttributes(owners map[string]Owner, vmpath string) string {
+	owner, ok := owners[vmpath]
+	if !ok {
+		return ""
+	}
+	return " uid:" + strconv.Itoa(owner.UID) + " gid:" + strconv.Itoa(owner.GID)
+}
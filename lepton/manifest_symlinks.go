@@ -0,0 +1,50 @@
+package lepton
+
+import "fmt"
+
+// SymlinkPolicy is what a manifest adds for the symlinks of the files and
+// directories added to it
+type SymlinkPolicy string
+
+const (
+	// SymlinkPreserve adds symlinks as symlinks, the default. Links to
+	// files of an added directory point to them in the image.
+	SymlinkPreserve SymlinkPolicy = "preserve"
+	// SymlinkDereference adds the files and directories symlinks point to
+	// in place of the symlinks, so the image has none
+	SymlinkDereference SymlinkPolicy = "dereference"
+	// SymlinkSkip leaves symlinks out of the image
+	SymlinkSkip SymlinkPolicy = "skip"
+)
+
+// SetSymlinkPolicy sets what is added for the symlinks of the files and
+// directories added to the manifest
+func (m *Manifest) SetSymlinkPolicy(policy SymlinkPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	m.symlinks = policy
+	return nil
+}
+
+// WithSymlinkPolicy runs add with the symlinks of the files it adds handled
+// with policy instead of the policy of the manifest, like
+//
+//	m.WithSymlinkPolicy(SymlinkSkip, func() error { return m.AddDirectory(dir) })
+func (m *Manifest) WithSymlinkPolicy(policy SymlinkPolicy, add func() error) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	prev := m.symlinks
+	m.symlinks = policy
+	defer func() { m.symlinks = prev }()
+	return add()
+}
+
+func (p SymlinkPolicy) validate() error {
+	switch p {
+	case SymlinkPreserve, SymlinkDereference, SymlinkSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid symlink policy %q, use %s, %s or %s", p, SymlinkPreserve, SymlinkDereference, SymlinkSkip)
+}
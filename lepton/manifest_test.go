@@ -1,10 +1,15 @@
 package lepton
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -24,18 +29,69 @@ func TestAddKernel(t *testing.T) {
 	m := NewManifest("")
 	m.AddKernel("kernel/kernel")
 	var sb strings.Builder
-	toString(&m.boot, &sb, 0)
+	writeDir(&sb, m.boot, 0, "", nil, nil)
 	s := sb.String()
 	if s != kernel {
 		t.Errorf("Expected:%v Actual:%v", kernel, s)
 	}
 }
 
+func TestAddBootFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"config", "klib/a", "klib/b"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.AddBootFile("/etc/config", filepath.Join(dir, "config")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddBootDirectoryTo("/klib", filepath.Join(dir, "klib")); err != nil {
+		t.Fatal(err)
+	}
+	m.AddKernel("kernel/kernel")
+
+	for _, file := range []string{"/kernel", "/etc/config", "/klib/a", "/klib/b"} {
+		if _, ok := m.boot.Lookup(file).(*FileNode); !ok {
+			t.Errorf("expected %s in the boot fs", file)
+		}
+	}
+	for _, file := range []string{"/etc/config", "/klib"} {
+		if m.root.Lookup(file) != nil {
+			t.Errorf("expected no %s in the root fs", file)
+		}
+	}
+
+	if err := m.AddBootFile("/etc/config/x", filepath.Join(dir, "config")); err == nil {
+		t.Error("expected an error adding a file under a file")
+	}
+	if err := m.AddBootFile("/kernel/../../x", filepath.Join(dir, "config")); err == nil {
+		t.Error("expected an error for a path out of the boot fs")
+	}
+	if err := m.AddFile("/etc/config", filepath.Join(dir, "config")); err != nil {
+		t.Fatal(err)
+	}
+	if m.boot.Lookup("/etc/config") == nil || m.root.Lookup("/etc/config") == nil {
+		t.Error("expected /etc/config in both filesystems")
+	}
+}
+
 func TestAddRelativePath(t *testing.T) {
 	m := NewManifest("")
 	m.AddRelative("hw", "examples/hw")
 	var sb strings.Builder
-	toString(&m.children, &sb, 0)
+	writeDir(&sb, m.root, 0, "", nil, nil)
 	s := sb.String()
 	if s != relpath {
 		t.Errorf("Expected:%v Actual:%v", relpath, s)
@@ -46,7 +102,7 @@ func TestAddLibs(t *testing.T) {
 	m := NewManifest("")
 	m.AddLibrary("/lib/x86_64-linux-gnu/libc.so.6")
 	var sb strings.Builder
-	toString(&m.children, &sb, 0)
+	writeDir(&sb, m.root, 0, "", nil, nil)
 	s := sb.String()
 	if s != lib {
 		t.Errorf("Expected:%v Actual:%v", lib, s)
@@ -64,6 +120,99 @@ func TestManifestWithDeps(t *testing.T) {
 	m.AddDirectory("../data/static")
 }
 
+func TestManifestStrictTargetRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the program stands for its libraries, only their lookup matters
+	program, err := ioutil.ReadFile("../data/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lib := range []string{"/lib64/ld-linux-x86-64.so.2", "/lib/x86_64-linux-gnu/libc.so.6", "/lib/x86_64-linux-gnu/libpthread.so.0"} {
+		path := filepath.Join(dir, lib)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, program, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewConfig()
+	c.Program = "../data/main"
+	c.TargetRoot = dir
+	c.TargetRootStrict = true
+	m, err := BuildManifest(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the program and the files ops generates are taken from the host
+	for _, file := range []string{"/data/main", "/etc/resolv.conf", "/etc/passwd", "/proc/sys/kernel/hostname", "/etc/nsswitch.conf"} {
+		if !m.FileExists(file) {
+			t.Errorf("expected %s in the image", file)
+		}
+	}
+	provenance, err := m.Provenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := provenance["/lib/x86_64-linux-gnu/libc.so.6"]; p.Source != FileFromTargetRoot {
+		t.Errorf("expected libc from the target root, got %+v", p)
+	}
+	if p := provenance["/etc/resolv.conf"]; p.Source != FileFromHost {
+		t.Errorf("expected the generated resolv.conf from the host, got %+v", p)
+	}
+
+	if err := m.AddFileBytes("/etc/app.conf", []byte("debug")); err != nil {
+		t.Errorf("generated files should be taken from the host: %v", err)
+	}
+	m.RemoveGeneratedFiles()
+	if err := m.AddFile("/etc/hosts", "/etc/hosts"); err == nil {
+		t.Error("expected files missing from a strict target root to fail")
+	}
+}
+
+func TestAddFilesFromPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := filepath.Join(dir, "node_v14")
+	if err := os.MkdirAll(filepath.Join(pkg, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkg, "bin", "node"), []byte("node"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("")
+	if err := addFilesFromPackage(pkg, m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.FileExists("/node_v14/bin/node") {
+		t.Error("expected the files of the package under its name")
+	}
+
+	// a file of the sysroot in the way of the package directory
+	if err := os.MkdirAll(filepath.Join(pkg, "sysroot"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkg, "sysroot", "node_v14"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = addFilesFromPackage(pkg, NewManifest(""))
+	if code, _ := ErrorCodeOf(err); code != ErrMkfsPathConflict {
+		t.Errorf("expected %s, got %v", ErrMkfsPathConflict, err)
+	}
+}
+
 func TestSerializeManifest(t *testing.T) {
 	m := NewManifest("")
 	m.AddUserProgram("/bin/ls")
@@ -107,6 +256,57 @@ func TestAddKlibs(t *testing.T) {
 	})
 }
 
+func TestKlibSearchPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "klibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	debug := filepath.Join(dir, "debug")
+	local := filepath.Join(dir, "local")
+	for _, file := range []string{"debug/ntp", "local/ntp", "local/tls", "radar.dbg"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	m.AddKlibDir(debug)
+	m.AddKlibDir(local)
+	if err := m.AddKlibs([]string{"ntp", "tls"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetKlibPath("radar", filepath.Join(dir, "radar.dbg")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"ntp":   filepath.Join(debug, "ntp"),
+		"tls":   filepath.Join(local, "tls"),
+		"radar": filepath.Join(dir, "radar.dbg"),
+	}
+	for klib, hostpath := range want {
+		if got, ok := m.klibHostPath(klib); !ok || got != hostpath {
+			t.Errorf("klib %s: got %s, want %s", klib, got, hostpath)
+		}
+	}
+	if !reflect.DeepEqual(m.klibs, []string{"ntp", "tls", "radar"}) {
+		t.Errorf("unexpected klibs %v", m.klibs)
+	}
+
+	if err := m.SetKlibPath("gone", filepath.Join(dir, "gone")); err == nil {
+		t.Error("expected an error for a missing klib file")
+	}
+	if err := m.SetKlibPath("../ntp", filepath.Join(dir, "radar.dbg")); err == nil {
+		t.Error("expected an error for an invalid klib name")
+	}
+}
+
 func TestAddRadarEnvAddKlibs(t *testing.T) {
 	m := NewManifest("")
 	m.AddEnvironmentVariable("RADAR_KEY", "TEST")
@@ -118,3 +318,802 @@ func TestAddRadarEnvAddKlibs(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestSetPolicy(t *testing.T) {
+	m := NewManifest("")
+	m.program = "/app"
+	if strings.Contains(m.String(), "policy:") {
+		t.Error("expected no policy")
+	}
+
+	m.SetPolicy(Policy{AllowedPaths: []string{"/etc/app.conf", "/data/a file"}})
+	want := "policy:(allowed_paths:[/app /etc/app.conf \"/data/a file\"] network:f)\n"
+	if s := m.String(); !strings.Contains(s, want) {
+		t.Errorf("expected %q in %s", want, s)
+	}
+
+	m.SetPolicy(Policy{AllowedPaths: []string{"/etc/app.conf"}, Network: true})
+	want = "policy:(allowed_paths:[/app /etc/app.conf] network:t)\n"
+	if s := m.String(); !strings.Contains(s, want) {
+		t.Errorf("expected %q in %s", want, s)
+	}
+}
+
+func TestAddDirectoryTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"index.html", "css/site.css"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.AddDirectoryTo("/srv/www", dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"/srv/www/index.html", "/srv/www/css/site.css"} {
+		if !m.FileExists(file) {
+			t.Errorf("expected %s in the image", file)
+		}
+	}
+	if m.FileExists(filepath.Join(dir, "index.html")) {
+		t.Error("expected the host directory not to be in the image")
+	}
+
+	if err := m.AddDirectoryAs(dir, "/static"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.FileExists("/static/css/site.css") {
+		t.Error("expected /static/css/site.css in the image")
+	}
+}
+
+func TestAddDirectoryFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]int{"small.txt": 10, "large.bin": 4096, "cache/small.txt": 10}
+	for file, size := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	err = m.AddDirectoryFunc(dir, func(hostpath string, info os.FileInfo) bool {
+		if info.IsDir() {
+			return info.Name() != "cache"
+		}
+		return info.Size() < 1024
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.FileExists(filepath.Join(dir, "small.txt")) {
+		t.Error("expected small.txt in the image")
+	}
+	for _, file := range []string{"large.bin", "cache"} {
+		if m.root.Lookup(filepath.Join(dir, file)) != nil {
+			t.Errorf("expected no %s in the image", file)
+		}
+	}
+
+	// the filter only applies to the directory it is passed with
+	if err := m.AddDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !m.FileExists(filepath.Join(dir, "large.bin")) {
+		t.Error("expected large.bin in the image")
+	}
+}
+
+func TestMapDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"a.so", "a.o", "x/b.so", "plugins/one/p.so", "plugins/two/q.so", "plugins/two/README"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	err = m.MapDirs(map[string]string{
+		filepath.Join(dir, "*.so"):                 "/lib",
+		filepath.Join(dir, "plugins", "*", "*.so"): "/plugins",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"/lib/a.so", "/lib/x/b.so", "/lib/plugins/one/p.so", "/plugins/p.so", "/plugins/q.so"} {
+		if !m.FileExists(file) {
+			t.Errorf("expected %s in the image", file)
+		}
+	}
+	for _, file := range []string{"/lib/a.o", "/plugins/README"} {
+		if m.FileExists(file) {
+			t.Errorf("expected no %s in the image", file)
+		}
+	}
+
+	if err := m.MapDirs(map[string]string{filepath.Join(dir, "[.so"): "/lib"}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestMkdirAll(t *testing.T) {
+	m := NewManifest("")
+	for _, dir := range []string{"/var/log/app/", "tmp", "//data/../srv//cache", "/"} {
+		if err := m.MkdirAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]interface{}{
+		"var": map[string]interface{}{
+			"log": map[string]interface{}{
+				"app": map[string]interface{}{},
+			},
+		},
+		"tmp": map[string]interface{}{},
+		"srv": map[string]interface{}{
+			"cache": map[string]interface{}{},
+		},
+	}
+	if !reflect.DeepEqual(m.root.ToMap(), want) {
+		t.Errorf("got %v, want %v", m.root.ToMap(), want)
+	}
+	if s := m.String(); !strings.Contains(s, "app:(children:())") {
+		t.Errorf("expected an empty app directory in %s", s)
+	}
+
+	m.AddLibrary("/lib/libc.so.6")
+	if err := m.MkdirAll("/lib/libc.so.6/dir"); err == nil {
+		t.Error("expected an error creating a directory under a file")
+	}
+}
+
+func TestVMPathNormalization(t *testing.T) {
+	for _, tt := range []struct {
+		vmpath string
+		want   []string
+	}{
+		{"/a/b", []string{"a", "b"}},
+		{"a/b", []string{"a", "b"}},
+		{"a//b", []string{"a", "b"}},
+		{"./x", []string{"x"}},
+		{"/a/./b/", []string{"a", "b"}},
+		{"a/../b", []string{"b"}},
+		{"/", []string{}},
+		{"", []string{}},
+	} {
+		got, err := vmPathParts(tt.vmpath)
+		if err != nil {
+			t.Errorf("%q: %v", tt.vmpath, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.vmpath, got, tt.want)
+		}
+	}
+
+	for _, vmpath := range []string{"..", "../x", "/a/../../x", "a/b/../../../x"} {
+		if _, err := vmPathParts(vmpath); err == nil {
+			t.Errorf("%q: expected an error escaping the image", vmpath)
+		}
+	}
+	for _, vmpath := range []string{"", "/", "a/..", "/a/", "/a/b//"} {
+		if _, err := vmFileParts(vmpath); err == nil {
+			t.Errorf("%q: expected an error for the path of a file", vmpath)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.AddLibrary("//lib/./x86_64-linux-gnu/../libc.so.6"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.FileExists("/lib/libc.so.6") || !m.FileExists("lib//libc.so.6") {
+		t.Errorf("expected /lib/libc.so.6 in %v", m.root.ToMap())
+	}
+	if err := m.AddLibrary("/../lib/libm.so.6"); err == nil {
+		t.Error("expected an error adding a library outside of the image")
+	}
+	if err := m.MkdirAll("/srv/../../data"); err == nil {
+		t.Error("expected an error creating a directory outside of the image")
+	}
+	if m.FileExists("/lib/") {
+		t.Error("a directory is not a file")
+	}
+}
+
+func TestAddDirectoryLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{filepath.Join(tree, "a.txt"), filepath.Join(dir, "outside.txt")} {
+		if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"sub/rel": "../a.txt",
+		"sub/abs": filepath.Join(tree, "a.txt"),
+		"ext":     "../outside.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(tree, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	m.SetWarningOutput(nil)
+	if err := m.AddDirectoryTo("/app", tree); err != nil {
+		t.Fatal(err)
+	}
+	app := m.root.ToMap()["app"].(map[string]interface{})
+	sub := app["sub"].(map[string]interface{})
+	for name, got := range map[string]interface{}{"sub/rel": sub["rel"], "sub/abs": sub["abs"], "ext": app["ext"]} {
+		want := LinkNode{Target: "../a.txt"}
+		if name == "ext" {
+			want = LinkNode{Target: "../outside.txt"}
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	if w := m.Warnings(); len(w) != 1 || w[0].Category != WarningExternalSymlink || w[0].Path != "/app/ext" {
+		t.Errorf("expected an external symlink warning for /app/ext, got %v", w)
+	}
+
+	m = NewManifest("")
+	m.SetWarningOutput(nil)
+	m.SetMaterializeSymlinks(true)
+	if err := m.AddDirectoryTo("/app", tree); err != nil {
+		t.Fatal(err)
+	}
+	app = m.root.ToMap()["app"].(map[string]interface{})
+	if got, want := app["ext"], filepath.Join(tree, "ext"); got != want {
+		t.Errorf("got %v, want the materialized file %v", got, want)
+	}
+	if got, want := app["sub"].(map[string]interface{})["rel"], (LinkNode{Target: "../a.txt"}); got != want {
+		t.Errorf("got %v, want the link inside the directory %v", got, want)
+	}
+	if w := m.Warnings(); len(w) != 0 {
+		t.Errorf("unexpected warnings %v", w)
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := filepath.Join(dir, "tree")
+	libs := filepath.Join(dir, "libs")
+	for _, d := range []string{tree, libs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(tree, "a.txt"), filepath.Join(libs, "x.so")} {
+		if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{"b.txt": "a.txt", "lib": "../libs"} {
+		if err := os.Symlink(target, filepath.Join(tree, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	m.SetWarningOutput(nil)
+	m.SetResolveSymlinks(true)
+	if err := m.AddDirectoryTo("/app", tree); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"app": map[string]interface{}{
+			"a.txt": filepath.Join(tree, "a.txt"),
+			"b.txt": filepath.Join(tree, "b.txt"),
+			"lib": map[string]interface{}{
+				"x.so": filepath.Join(libs, "x.so"),
+			},
+		},
+	}
+	if !reflect.DeepEqual(m.root.ToMap(), want) {
+		t.Errorf("got %v, want %v", m.root.ToMap(), want)
+	}
+
+	if err := os.Symlink("..", filepath.Join(libs, "up")); err != nil {
+		t.Fatal(err)
+	}
+	m = NewManifest("")
+	m.SetResolveSymlinks(true)
+	err = m.AddDirectoryTo("/app", tree)
+	if code, _ := ErrorCodeOf(err); code != ErrMkfsSymlinkLoop {
+		t.Errorf("expected a symlink loop error, got %v", err)
+	}
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy SymlinkPolicy
+		b      interface{}
+	}{
+		{SymlinkPreserve, LinkNode{Target: "a.txt"}},
+		{SymlinkDereference, filepath.Join(dir, "b.txt")},
+		{SymlinkSkip, nil},
+	}
+	for _, tt := range tests {
+		m := NewManifest("")
+		if err := m.SetSymlinkPolicy(tt.policy); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddDirectoryTo("/app", dir); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{"a.txt": filepath.Join(dir, "a.txt")}
+		if tt.b != nil {
+			want["b.txt"] = tt.b
+		}
+		if got := m.root.Children["app"].(*DirNode).ToMap(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.policy, got, want)
+		}
+	}
+
+	// the policy of a call overrides the one of the manifest for the call
+	m := NewManifest("")
+	err = m.WithSymlinkPolicy(SymlinkSkip, func() error {
+		return m.AddDirectoryTo("/skipped", dir)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDirectoryTo("/kept", dir); err != nil {
+		t.Fatal(err)
+	}
+	if m.root.Lookup("/skipped/b.txt") != nil {
+		t.Error("expected no /skipped/b.txt in the image")
+	}
+	if _, ok := m.root.Lookup("/kept/b.txt").(*LinkNode); !ok {
+		t.Error("expected the /kept/b.txt symlink in the image")
+	}
+
+	if err := m.SetSymlinkPolicy("follow"); err == nil {
+		t.Error("expected an error for an invalid policy")
+	}
+}
+
+func TestAddEnvPassthrough(t *testing.T) {
+	os.Setenv("OPS_TEST_PASSED", "a b")
+	defer os.Unsetenv("OPS_TEST_PASSED")
+	os.Unsetenv("OPS_TEST_UNSET")
+
+	m := NewManifest("")
+	if err := m.AddEnvPassthrough("OPS_TEST_PASSED"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.environment["OPS_TEST_PASSED"]; got != "a b" {
+		t.Errorf("got %q", got)
+	}
+
+	m = NewManifest("")
+	err := m.AddEnvPassthrough("OPS_TEST_PASSED", "OPS_TEST_UNSET")
+	if err == nil || !strings.Contains(err.Error(), "OPS_TEST_UNSET") {
+		t.Errorf("expected an error naming the unset variable, got %v", err)
+	}
+	if len(m.environment) != 0 {
+		t.Errorf("expected no variables set, got %v", m.environment)
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(file, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManifest("")
+	for _, vmpath := range []string{"/etc/a", "/b"} {
+		if err := m.AddFile(vmpath, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashes, err := m.HashFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if want := map[string]string{"/etc/a": sum, "/b": sum}; !reflect.DeepEqual(hashes, want) {
+		t.Errorf("got %v, want %v", hashes, want)
+	}
+	if s := m.String(); !strings.Contains(s, "a:(contents:(host:"+file+") sha256:"+sum+")") {
+		t.Errorf("expected the hash of a in %s", s)
+	}
+}
+
+func TestManifestPrograms(t *testing.T) {
+	m := NewManifest("")
+	if err := m.SetProgram("../data/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddProgram("worker", "../data/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddProgram("Worker", "../data/main"); err == nil {
+		t.Error("expected an error for an invalid program name")
+	}
+	if err := m.SelectProgram("server"); err == nil {
+		t.Error("expected an error for an unknown program")
+	}
+	if err := m.SelectProgram("worker"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.String()
+	if !strings.Contains(s, "programs:(worker:/data/main)\n") {
+		t.Errorf("programs missing from manifest:\n%s", s)
+	}
+}
+
+func TestManifestSetupPrograms(t *testing.T) {
+	m := NewManifest("")
+	if err := m.SetProgram("../data/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddSetupProgram("../data/main", []string{"main", "migrate", "--to", "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddSetupProgram("../data/main", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "setup:[(program:/data/main arguments:[main migrate --to v2]) (program:/data/main arguments:[/data/main])]\n"
+	if s := m.String(); !strings.Contains(s, want) {
+		t.Errorf("setup missing from manifest:\n%s", s)
+	}
+}
+
+func TestManifestWriteEscapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "escapes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("../data/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := filepath.Join(dir, "my app", "main")
+	if err := os.Mkdir(filepath.Dir(program), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(program, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("")
+	if err := m.SetProgram(program); err != nil {
+		t.Fatal(err)
+	}
+	m.AddEnvironmentVariable("MY VAR", "a b")
+	m.AddMount("my:data", "/mnt/my data")
+	m.AddNoTrace("write")
+	m.AddNoTrace("read(2)")
+
+	s := m.String()
+	for _, want := range []string{
+		"program:\"" + program + "\"\n",
+		"environment:(\"MY VAR\":\"a b\")\n",
+		"    \"my:data\":\"/mnt/my data\"\n",
+		"notrace:[write \"read(2)\"]\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %s in manifest\n%s", want, s)
+		}
+	}
+}
+
+func TestManifestPathConflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManifest("")
+	if err := m.AddFile("/etc/a", file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vmpath := range []string{"/etc", "/etc/a/b"} {
+		err := m.AddFile(vmpath, file)
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsPathConflict {
+			t.Errorf("%s: got %v, want %s", vmpath, err, ErrMkfsPathConflict)
+		}
+	}
+	if err := m.AddLibrary("/etc/a/lib.so"); err == nil {
+		t.Error("a library can't be under a file")
+	}
+	if err := m.AddFile("/etc/b", filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing host files can't be added")
+	}
+	if err := m.AddKlibs([]string{"../radar"}); err == nil {
+		t.Error("klibs are names of the klib directory")
+	}
+}
+
+func TestManifestSpecialPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "special paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// café is decomposed, the way macOS stores names
+	names := []string{"my file.txt", "cafe\u0301", "a$(b);c&d", `quote"d`, `back\slash`, "日本語"}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManifest("")
+	if err := m.AddDirectoryTo("/srv", dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"my file.txt", "caf\u00e9", "cafe\u0301", "a$(b);c&d", `quote"d`, `back\slash`, "日本語"} {
+		if !m.FileExists("/srv/" + name) {
+			t.Errorf("expected /srv/%s in %v", name, m.root.ToMap())
+		}
+	}
+
+	s := m.String()
+	for _, want := range []string{
+		`"my file.txt":(contents:(host:"` + dir + `/my file.txt"))`,
+		`"a$(b);c&d":(contents:`,
+		`"quote\"d":(contents:`,
+		`"back\\slash":(contents:`,
+		"caf\u00e9:(contents:(host:\"" + dir + "/cafe\u0301\"))",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %s in manifest\n%s", want, s)
+		}
+	}
+
+	data, err := m.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewManifest("")
+	if err := loaded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.root, m.root) {
+		t.Errorf("got %v, want %v", loaded.root.ToMap(), m.root.ToMap())
+	}
+
+	for _, vmpath := range []string{"/etc/a\x00b", "/etc/tab\tname", "/etc/new\nline", "/etc/\xff"} {
+		err := m.AddFile(vmpath, filepath.Join(dir, "my file.txt"))
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsInvalidPath {
+			t.Errorf("%q: got %v, want %s", vmpath, err, ErrMkfsInvalidPath)
+		}
+	}
+}
+
+func TestManifestCaseCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("")
+	m.SetWarningOutput(nil)
+	if err := m.MkdirAll("/Data"); err != nil {
+		t.Fatal(err)
+	}
+	for _, vmpath := range []string{"/etc/hosts", "/etc/hosts", "/etc/Hosts", "/data/hosts", "/Data/hosts"} {
+		if err := m.AddFile(vmpath, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"/etc/Hosts", "/data"}
+	got := []string{}
+	for _, w := range m.Warnings() {
+		if w.Category == WarningCaseCollision {
+			got = append(got, w.Path)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got case collisions %v, want %v", got, want)
+	}
+	if err := checkWarnings(m.Warnings(), []string{"case-collision"}); err == nil {
+		t.Error("expected case collisions to fail the build")
+	}
+}
+
+func TestManifestOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "owners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManifest("")
+	if err := m.AddFile("/etc/app/app.conf", file); err != nil {
+		t.Fatal(err)
+	}
+	m.Root().Lookup("/etc/app").(*DirNode).Children["current"] = &LinkNode{Target: "app.conf"}
+	m.SetModTime(time.Unix(1000, 0))
+
+	for _, vmpath := range []string{"/etc/app/app.conf", "/etc/app", "etc/app/current"} {
+		if err := m.SetOwner(vmpath, 1000, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, vmpath := range []string{"/", "/etc/missing"} {
+		if err := m.SetOwner(vmpath, 1000, 100); err == nil {
+			t.Errorf("%s: expected an error", vmpath)
+		}
+	}
+	if err := m.SetOwner("/etc", -1, 0); err == nil {
+		t.Error("expected an error for a negative uid")
+	}
+
+	s := m.String()
+	for _, want := range []string{
+		"app.conf:(contents:(host:" + file + ") uid:1000 gid:100 mtime:1000)",
+		"current:(linktarget:app.conf uid:1000 gid:100 mtime:1000)",
+		"\n        ) uid:1000 gid:100 mtime:1000)\n",
+		"etc:(children:(",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %s in manifest\n%s", want, s)
+		}
+	}
+	if strings.Count(s, "uid:") != 3 {
+		t.Errorf("expected 3 owners in manifest\n%s", s)
+	}
+
+	data, err := m.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewManifest("")
+	if err := loaded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.owners, m.owners) {
+		t.Errorf("got owners %v, want %v", loaded.owners, m.owners)
+	}
+}
+
+func TestManifestWriteTo(t *testing.T) {
+	m := NewManifest("")
+	m.AddKernel("kernel/kernel")
+	for _, lib := range []string{"/lib/c.so", "/lib/b.so", "/usr/lib/a.so", "/lib/a.so"} {
+		if err := m.AddLibrary(lib); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.AddArgument("main")
+
+	var b bytes.Buffer
+	n, err := m.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m.String(); b.String() != s || n != int64(len(s)) {
+		t.Errorf("wrote %d bytes %s, want %s", n, b.String(), s)
+	}
+
+	want := `    lib:(children:(
+        a.so:(contents:(host:/lib/a.so))
+        b.so:(contents:(host:/lib/b.so))
+        c.so:(contents:(host:/lib/c.so))
+    ))
+    usr:(children:(
+`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("expected entries in lexical order, got\n%s", b.String())
+	}
+}
+
+func TestManifestUUIDStable(t *testing.T) {
+	newManifest := func() *Manifest {
+		m := NewManifest("")
+		m.AddKernel("kernel/kernel")
+		for i := 0; i < 10; i++ {
+			n := strconv.Itoa(i)
+			m.AddEnvironmentVariable("VAR"+n, n)
+			m.AddDebugFlag("flag"+n, 't')
+			m.AddMount("vol"+n, "/mnt/"+n)
+		}
+		return m
+	}
+
+	want, err := readerHashUUID(strings.NewReader(newManifest().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		got, err := readerHashUUID(strings.NewReader(newManifest().String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got UUID %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package lepton
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// ManifestNode is a file, directory or symlink of the tree of an image, a
+// *FileNode, *DirNode or *LinkNode
+type ManifestNode interface {
+	manifestNode()
+}
+
+// DirNode is a directory of an image
+type DirNode struct {
+	Children map[string]ManifestNode
+}
+
+// FileNode is a file of an image, with the content of a host file
+type FileNode struct {
+	HostPath string
+}
+
+// LinkNode is a symlink of an image
+type LinkNode struct {
+	Target string
+}
+
+func (*DirNode) manifestNode()  {}
+func (*FileNode) manifestNode() {}
+func (*LinkNode) manifestNode() {}
+
+// Root returns the tree of the root filesystem of the image, changes to it
+// are in the manifest written
+func (m *Manifest) Root() *DirNode {
+	return m.root
+}
+
+// Boot returns the tree of the boot filesystem of the image, with the kernel
+func (m *Manifest) Boot() *DirNode {
+	return m.boot
+}
+
+// NewDirNode returns an empty directory
+func NewDirNode() *DirNode {
+	return &DirNode{Children: make(map[string]ManifestNode)}
+}
+
+// Lookup returns the node at the image path vmpath relative to d, nil when
+// there is none
+func (d *DirNode) Lookup(vmpath string) ManifestNode {
+	parts, err := vmPathParts(vmpath)
+	if err != nil {
+		return nil
+	}
+	var node ManifestNode = d
+	for _, part := range parts {
+		dir, ok := node.(*DirNode)
+		if !ok {
+			return nil
+		}
+		if node, ok = dir.Children[part]; !ok {
+			return nil
+		}
+	}
+	return node
+}
+
+// Walk calls fn with the image path, relative to d, of every node under d
+// in lexical order, directories before their children. Walk stops at the
+// first error of fn.
+func (d *DirNode) Walk(fn func(vmpath string, node ManifestNode) error) error {
+	return d.walk("/", fn)
+}
+
+func (d *DirNode) walk(dir string, fn func(vmpath string, node ManifestNode) error) error {
+	names := make([]string, 0, len(d.Children))
+	for name := range d.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vmpath := path.Join(dir, name)
+		node := d.Children[name]
+		if err := fn(vmpath, node); err != nil {
+			return err
+		}
+		if sub, ok := node.(*DirNode); ok {
+			if err := sub.walk(vmpath, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ToMap returns the tree of d as nested maps, with files as host path
+// strings and symlinks as LinkNode values, for callers comparing or
+// serializing trees
+func (d *DirNode) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(d.Children))
+	for name, node := range d.Children {
+		switch node := node.(type) {
+		case *DirNode:
+			m[name] = node.ToMap()
+		case *FileNode:
+			m[name] = node.HostPath
+		case *LinkNode:
+			m[name] = LinkNode{Target: node.Target}
+		}
+	}
+	return m
+}
+
+// DirFromMap returns the directory of the tree m, in the representation of
+// ToMap
+func DirFromMap(m map[string]interface{}) (*DirNode, error) {
+	d := NewDirNode()
+	for name, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			sub, err := DirFromMap(v)
+			if err != nil {
+				return nil, err
+			}
+			d.Children[name] = sub
+		case string:
+			d.Children[name] = &FileNode{HostPath: v}
+		case LinkNode:
+			d.Children[name] = &LinkNode{Target: v.Target}
+		default:
+			return nil, fmt.Errorf("%s: unexpected manifest node %T", name, v)
+		}
+	}
+	return d, nil
+}
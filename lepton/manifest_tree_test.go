@@ -0,0 +1,45 @@
+package lepton
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManifestTree(t *testing.T) {
+	m := NewManifest("")
+	m.AddLibrary("/lib/libc.so.6")
+	m.MkdirAll("/tmp")
+	m.Root().Children["lib"].(*DirNode).Children["libc.so"] = &LinkNode{Target: "libc.so.6"}
+
+	if f, ok := m.Root().Lookup("/lib/libc.so.6").(*FileNode); !ok || f.HostPath != "/lib/libc.so.6" {
+		t.Errorf("got %v", m.Root().Lookup("/lib/libc.so.6"))
+	}
+	if n := m.Root().Lookup("/lib/libc.so.6/x"); n != nil {
+		t.Errorf("files have no children, got %v", n)
+	}
+
+	var paths []string
+	m.Root().Walk(func(vmpath string, node ManifestNode) error {
+		paths = append(paths, vmpath)
+		return nil
+	})
+	if want := []string{"/lib", "/lib/libc.so", "/lib/libc.so.6", "/tmp"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	tree := m.Root().ToMap()
+	want := map[string]interface{}{
+		"lib": map[string]interface{}{"libc.so.6": "/lib/libc.so.6", "libc.so": LinkNode{Target: "libc.so.6"}},
+		"tmp": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("got %v, want %v", tree, want)
+	}
+	dir, err := DirFromMap(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dir, m.Root()) {
+		t.Errorf("converting back got %v, want %v", dir, m.Root())
+	}
+}
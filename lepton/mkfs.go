@@ -12,6 +12,89 @@ var (
 	errMKFSSetupCommandRequired = fmt.Errorf("SetupCommand must run before")
 )
 
+// BuildStage identifies a point of the image build where hooks are run
+type BuildStage int
+
+const (
+	// PreResolve runs before the manifest is assembled
+	PreResolve BuildStage = iota
+	// PostResolve runs after the manifest is assembled
+	PostResolve
+	// PreWrite runs right before mkfs writes the image
+	PreWrite
+	// PostWrite runs after mkfs has written the image
+	PostWrite
+)
+
+func (s BuildStage) String() string {
+	switch s {
+	case PreResolve:
+		return "pre-resolve"
+	case PostResolve:
+		return "post-resolve"
+	case PreWrite:
+		return "pre-write"
+	case PostWrite:
+		return "post-write"
+	}
+	return fmt.Sprintf("stage(%d)", int(s))
+}
+
+// BuildHook is a function run at a build stage, returning an error aborts
+// the build
+type BuildHook func(report *BuildReport) error
+
+// HookError is returned when a build hook fails
+type HookError struct {
+	Stage BuildStage
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the error returned by the hook
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of hook failures
+func (e *HookError) ErrorCode() ErrorCode {
+	return ErrMkfsHookFailed
+}
+
+// BuildHooks holds the hooks run at each stage of an image build
+type BuildHooks struct {
+	hooks map[BuildStage][]BuildHook
+}
+
+// NewBuildHooks returns an empty set of build hooks
+func NewBuildHooks() *BuildHooks {
+	return &BuildHooks{
+		hooks: make(map[BuildStage][]BuildHook),
+	}
+}
+
+// Add registers a hook to run at the given build stage, hooks of the same
+// stage run in registration order
+func (h *BuildHooks) Add(stage BuildStage, hook BuildHook) {
+	h.hooks[stage] = append(h.hooks[stage], hook)
+}
+
+// Run runs the hooks registered for stage and stops at the first error
+func (h *BuildHooks) Run(stage BuildStage, report *BuildReport) error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range h.hooks[stage] {
+		if err := hook(report); err != nil {
+			return &HookError{Stage: stage, Err: err}
+		}
+	}
+	return nil
+}
+
 // MkfsCommand wraps mkfs calls
 type MkfsCommand struct {
 	binaryPath string
@@ -19,6 +102,9 @@ type MkfsCommand struct {
 	stdin      *os.File
 	output     []byte
 	command    *exec.Cmd
+	hooks      *BuildHooks
+	uuid       string
+	label      string
 }
 
 // NewMkfsCommand returns an instance of MkfsCommand
@@ -30,9 +116,69 @@ func NewMkfsCommand(binaryPath string) *MkfsCommand {
 		args:       args,
 		stdin:      nil,
 		command:    nil,
+		hooks:      NewBuildHooks(),
 	}
 }
 
+// NewMkfsCommandFromConfig returns the mkfs command writing the image of c
+// to RunConfig.Imagename, with the boot, target root, size, label and build
+// hooks of c
+func NewMkfsCommandFromConfig(c *Config) *MkfsCommand {
+	m := NewMkfsCommand(c.Mkfs)
+	m.SetHooks(c.BuildHooks)
+
+	if c.TargetRoot != "" {
+		m.SetTargetRoot(c.TargetRoot)
+	}
+
+	if c.BaseVolumeSz != "" {
+		m.SetFileSystemSize(c.BaseVolumeSz)
+	}
+
+	m.SetBoot(c.Boot)
+	m.SetFileSystemPath(c.RunConfig.Imagename)
+	if c.Label != "" {
+		m.SetLabel(c.Label)
+	}
+	return m
+}
+
+// AddHook registers a hook to run at the given build stage
+func (m *MkfsCommand) AddHook(stage BuildStage, hook BuildHook) {
+	m.hooks.Add(stage, hook)
+}
+
+// SetHooks replaces the hooks run by the command
+func (m *MkfsCommand) SetHooks(hooks *BuildHooks) {
+	m.hooks = hooks
+}
+
+// RunHooks runs the hooks registered for stage
+func (m *MkfsCommand) RunHooks(stage BuildStage, report *BuildReport) error {
+	return m.hooks.Run(stage, report)
+}
+
+// SetUUID adds argument that sets the filesystem UUID instead of mkfs
+// generating one
+func (m *MkfsCommand) SetUUID(uuid string) {
+	m.uuid = uuid
+	m.args = append(m.args, "-u", uuid)
+}
+
+// SetUUIDSource sets the UUID of the filesystem from the UUID source of
+// sources, when it has one
+func (m *MkfsCommand) SetUUIDSource(sources *Sources) error {
+	if sources == nil || sources.UUID == nil {
+		return nil
+	}
+	uuid, err := sources.UUID()
+	if err != nil {
+		return err
+	}
+	m.SetUUID(uuid)
+	return nil
+}
+
 // SetupCommand instantiates a command with the args assigned
 func (m *MkfsCommand) SetupCommand() {
 	m.command = exec.Command(m.binaryPath, m.args...)
@@ -66,11 +212,18 @@ func (m *MkfsCommand) SetFileSystemPath(fsPath string) {
 	m.args = append(m.args, fsPath)
 }
 
-// SetLabel add label argument that sets file system label
+// SetLabel add label argument that sets file system label, mkfs writes it
+// into the filesystem header
 func (m *MkfsCommand) SetLabel(label string) {
+	m.label = label
 	m.args = append(m.args, "-l", label)
 }
 
+// GetLabel returns the label of file system built, empty when it isn't set
+func (m *MkfsCommand) GetLabel() string {
+	return m.label
+}
+
 // SetStdin sets process's standard input
 func (m *MkfsCommand) SetStdin(file *os.File) {
 	m.stdin = file
@@ -108,8 +261,12 @@ func (m *MkfsCommand) GetArgs() []string {
 	return m.args
 }
 
-// GetUUID returns the uuid of file system built
+// GetUUID returns the uuid of file system built, the one set or the one
+// mkfs reports generating
 func (m *MkfsCommand) GetUUID() string {
+	if m.uuid != "" {
+		return m.uuid
+	}
 	return uuidFromMKFS(m.output)
 }
 
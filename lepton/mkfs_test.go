@@ -1,7 +1,12 @@
 package lepton
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -15,6 +20,7 @@ func TestMKFSCommand(t *testing.T) {
 		mkfs.SetBoot("boot")
 		mkfs.SetEmptyFileSystem()
 		mkfs.SetLabel("label")
+		mkfs.SetUUID("uuid")
 
 		got := mkfs.GetArgs()
 		want := []string{
@@ -28,10 +34,220 @@ func TestMKFSCommand(t *testing.T) {
 			"-e",
 			"-l",
 			"label",
+			"-u",
+			"uuid",
 		}
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got %v want %v", got, want)
 		}
+		if mkfs.GetUUID() != "uuid" {
+			t.Errorf("got uuid %q", mkfs.GetUUID())
+		}
+		if mkfs.GetLabel() != "label" {
+			t.Errorf("got label %q", mkfs.GetLabel())
+		}
+	})
+}
+
+func TestNewMkfsCommandFromConfig(t *testing.T) {
+	c := &Config{Mkfs: "mkfs", Boot: "boot.img", TargetRoot: "/sysroot", BaseVolumeSz: "64M", Label: "app"}
+	c.RunConfig.Imagename = "app.img"
+
+	got := NewMkfsCommandFromConfig(c).GetArgs()
+	want := []string{"-r", "/sysroot", "-s", "64M", "-b", "boot.img", "app.img", "-l", "app"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestBuildHooks(t *testing.T) {
+	t.Run("should run hooks of a stage in registration order", func(t *testing.T) {
+		mkfs := NewMkfsCommand("")
+		var got []string
+		mkfs.AddHook(PreWrite, func(r *BuildReport) error {
+			got = append(got, "first")
+			return nil
+		})
+		mkfs.AddHook(PostWrite, func(r *BuildReport) error {
+			got = append(got, "post")
+			return nil
+		})
+		mkfs.AddHook(PreWrite, func(r *BuildReport) error {
+			got = append(got, "second")
+			return nil
+		})
+
+		if err := mkfs.RunHooks(PreWrite, &BuildReport{}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"first", "second"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("should stop at the first failing hook", func(t *testing.T) {
+		hooks := NewBuildHooks()
+		errPolicy := errors.New("policy check failed")
+		called := false
+		hooks.Add(PreResolve, func(r *BuildReport) error {
+			return errPolicy
+		})
+		hooks.Add(PreResolve, func(r *BuildReport) error {
+			called = true
+			return nil
+		})
+
+		err := hooks.Run(PreResolve, &BuildReport{})
+		var hookErr *HookError
+		if !errors.As(err, &hookErr) || hookErr.Stage != PreResolve {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !errors.Is(err, errPolicy) {
+			t.Errorf("hook error should wrap %v", errPolicy)
+		}
+		if called {
+			t.Errorf("hook after failing hook should not run")
+		}
+	})
+
+	t.Run("should allow running nil hooks", func(t *testing.T) {
+		var hooks *BuildHooks
+		if err := hooks.Run(PostWrite, &BuildReport{}); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+// stubMkfsConfig returns a config whose mkfs binary is a shell script in dir
+// writing its arguments to the image file
+func stubMkfsConfig(t *testing.T, dir string) *Config {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub mkfs requires a unix shell")
+	}
+
+	mkfs := filepath.Join(dir, "mkfs")
+	script := "#!/bin/sh\ncat > /dev/null\nfor a; do last=$a; done\necho \"$@\" > \"$last\"\n"
+	if err := ioutil.WriteFile(mkfs, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig()
+	c.Mkfs = mkfs
+	c.Boot = "boot.img"
+	c.BuildDir = filepath.Join(dir, "build")
+	c.RunConfig.Imagename = filepath.Join(dir, "image")
+	return c
+}
+
+func emptyManifest(c *Config) (*Manifest, error) {
+	return NewManifest(""), nil
+}
+
+func TestBuildImageRunsHooks(t *testing.T) {
+	t.Run("should run every stage in order", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "mkfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		c := stubMkfsConfig(t, dir)
+		c.BuildHooks = NewBuildHooks()
+
+		var got []BuildStage
+		record := func(stage BuildStage) BuildHook {
+			return func(r *BuildReport) error {
+				got = append(got, stage)
+				switch stage {
+				case PreResolve:
+					if r.Manifest != nil {
+						t.Errorf("manifest should not be resolved in %s", stage)
+					}
+				case PostResolve, PreWrite:
+					if r.Manifest == nil {
+						t.Errorf("manifest should be resolved in %s", stage)
+					}
+				case PostWrite:
+					if r.FinishedAt.IsZero() || r.FinishedAt.Before(r.StartedAt) {
+						t.Errorf("unexpected finish time %v", r.FinishedAt)
+					}
+					if _, err := os.Stat(r.ImagePath); err != nil {
+						t.Errorf("image should be written in %s: %v", stage, err)
+					}
+				}
+				return nil
+			}
+		}
+		for _, stage := range []BuildStage{PostWrite, PreWrite, PostResolve, PreResolve} {
+			c.BuildHooks.Add(stage, record(stage))
+		}
+
+		if err := buildImageWithHooks(c, emptyManifest); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []BuildStage{PreResolve, PostResolve, PreWrite, PostWrite}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("should abort the build when a hook fails", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "mkfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		c := stubMkfsConfig(t, dir)
+		c.BuildHooks = NewBuildHooks()
+		errPolicy := errors.New("policy check failed")
+		c.BuildHooks.Add(PreWrite, func(r *BuildReport) error {
+			return errPolicy
+		})
+		c.BuildHooks.Add(PostWrite, func(r *BuildReport) error {
+			t.Errorf("post-write should not run after a failing hook")
+			return nil
+		})
+
+		err = buildImageWithHooks(c, emptyManifest)
+		if !errors.Is(err, errPolicy) {
+			t.Errorf("got %v want %v", err, errPolicy)
+		}
+	})
+
+	t.Run("should not accumulate mkfs arguments across builds", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "mkfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		c := stubMkfsConfig(t, dir)
+		c.BuildHooks = NewBuildHooks()
+		builds := 0
+		c.BuildHooks.Add(PostWrite, func(r *BuildReport) error {
+			builds++
+			return nil
+		})
+
+		var outputs []string
+		for i := 0; i < 2; i++ {
+			if err := buildImageWithHooks(c, emptyManifest); err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadFile(c.RunConfig.Imagename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, string(out))
+		}
+
+		if builds != 2 {
+			t.Errorf("got %d builds want 2", builds)
+		}
+		if outputs[0] != outputs[1] {
+			t.Errorf("mkfs arguments changed between builds: %q %q", outputs[0], outputs[1])
+		}
 	})
 }
@@ -0,0 +1,72 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"path"
+)
+
+const nsswitchConfPath = "/etc/nsswitch.conf"
+
+// nssModules are the glibc modules the sources of the default nsswitch.conf
+// load, glibc opens them at run time so they are not in the dependencies of
+// programs, and without them name resolution fails silently
+var nssModules = []string{"libnss_files.so.2", "libnss_dns.so.2"}
+
+// defaultNsswitchConf is the nsswitch.conf of images whose target root has
+// none, it only uses the sources of nssModules
+const defaultNsswitchConf = `passwd: files
+group: files
+hosts: files dns
+networks: files
+protocols: files
+services: files
+`
+
+// addNSSModules adds the nss modules of the glibc of deps, the libraries of
+// a program, from the directory of its libc and their own libraries, and an
+// nsswitch.conf. It returns the libraries added, programs not linked with
+// glibc are left alone.
+func addNSSModules(m *Manifest, c *Config, deps []string) ([]string, error) {
+	var libc string
+	for _, dep := range deps {
+		if path.Base(dep) == "libc.so.6" {
+			libc = dep
+			break
+		}
+	}
+	if libc == "" {
+		return nil, nil
+	}
+
+	added := []string{}
+	for _, module := range nssModules {
+		lib := path.Join(path.Dir(libc), module)
+		if _, err := m.lookupFile(lib); err != nil {
+			continue
+		}
+		libs, err := resolveSharedLibs(m.targetRoot, lib, m.strictTargetRoot, c.LibraryPaths)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range append(libs, lib) {
+			if err := m.AddLibrary(dep); err != nil {
+				return nil, err
+			}
+			added = append(added, dep)
+		}
+	}
+
+	if m.FileExists(nsswitchConfPath) {
+		return added, nil
+	}
+	if m.targetRoot != "" {
+		if _, err := m.files.lookupFile(m.targetRoot, nsswitchConfPath, true); err == nil {
+			return added, m.AddFile(nsswitchConfPath, nsswitchConfPath)
+		}
+	}
+	conf := path.Join(getImageTempDir(c), "nsswitch.conf")
+	if err := ioutil.WriteFile(conf, []byte(defaultNsswitchConf), 0644); err != nil {
+		return nil, err
+	}
+	return added, m.addHostFile(nsswitchConfPath, conf)
+}
@@ -0,0 +1,72 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAddNSSModules(t *testing.T) {
+	if ldLibraryPath, ok := os.LookupEnv("LD_LIBRARY_PATH"); ok {
+		os.Unsetenv("LD_LIBRARY_PATH")
+		defer os.Setenv("LD_LIBRARY_PATH", ldLibraryPath)
+	}
+
+	dir, err := ioutil.TempDir("", "nss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+
+	libc := "/lib/x86_64-linux-gnu/libc.so.6"
+	writeDynamicELF(t, filepath.Join(root, libc), "", nil, "")
+	writeDynamicELF(t, filepath.Join(root, "/lib/x86_64-linux-gnu/libresolv.so.2"), "", []string{"libc.so.6"}, "")
+	writeDynamicELF(t, filepath.Join(root, "/lib/x86_64-linux-gnu/libnss_dns.so.2"), "", []string{"libresolv.so.2", "libc.so.6"}, "")
+	writeDynamicELF(t, filepath.Join(root, "/lib/x86_64-linux-gnu/libnss_files.so.2"), "", []string{"libc.so.6"}, "")
+
+	t.Run("should add the modules of glibc and a default nsswitch.conf", func(t *testing.T) {
+		c := &Config{BuildDir: dir}
+		m := NewManifest(root)
+		m.SetStrictTargetRoot(true)
+		added, err := addNSSModules(m, c, []string{libc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			libc,
+			"/lib/x86_64-linux-gnu/libnss_files.so.2",
+			"/lib/x86_64-linux-gnu/libresolv.so.2",
+			libc,
+			"/lib/x86_64-linux-gnu/libnss_dns.so.2",
+		}
+		if !reflect.DeepEqual(added, want) {
+			t.Errorf("got %v, want %v", added, want)
+		}
+		file, ok := m.Root().Lookup(nsswitchConfPath).(*FileNode)
+		if !ok || file.HostPath != filepath.Join(dir, "nsswitch.conf") {
+			t.Errorf("expected a default nsswitch.conf, got %#v", m.Root().Lookup(nsswitchConfPath))
+		}
+	})
+
+	t.Run("should take nsswitch.conf from the target root", func(t *testing.T) {
+		writeRootFile(t, root, nsswitchConfPath, "hosts: files dns\n")
+		m := NewManifest(root)
+		if _, err := addNSSModules(m, &Config{BuildDir: dir}, []string{libc}); err != nil {
+			t.Fatal(err)
+		}
+		if file, ok := m.Root().Lookup(nsswitchConfPath).(*FileNode); !ok || file.HostPath != nsswitchConfPath {
+			t.Errorf("expected the nsswitch.conf of the target root, got %#v", m.Root().Lookup(nsswitchConfPath))
+		}
+	})
+
+	t.Run("should leave programs not linked with glibc alone", func(t *testing.T) {
+		m := NewManifest(root)
+		added, err := addNSSModules(m, &Config{BuildDir: dir}, []string{"/lib/ld-musl-x86_64.so.1"})
+		if err != nil || len(added) != 0 || m.FileExists(nsswitchConfPath) {
+			t.Errorf("got %v, %v", added, err)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package lepton
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
@@ -44,9 +45,42 @@ func (p *OnPrem) ResizeImage(ctx *Context, imagename string, hbytes string) erro
 		return err
 	}
 
+	// truncating below the current size would cut off image content
+	fi, err := os.Stat(imgpath)
+	if err != nil {
+		return err
+	}
+	if bytes < fi.Size() {
+		return WithCode(ErrImageSizeExceeded, fmt.Errorf("image %s is %d bytes, larger than the requested %d bytes", imagename, fi.Size(), bytes))
+	}
+
 	return os.Truncate(imgpath, bytes)
 }
 
+// VerifyImage compares the local image imagename to the image file at
+// imagePath
+func (p *OnPrem) VerifyImage(ctx *Context, imagename string, imagePath string) (*ImageVerification, error) {
+	imgpath := path.Join(localImageDir, imagename)
+	if _, err := os.Stat(imgpath); os.IsNotExist(err) && !strings.HasSuffix(imagename, ".img") {
+		imgpath += ".img"
+	}
+
+	f, err := os.Open(imgpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sum, err := readerSHA256(f)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ImageVerification{Image: imgpath, Method: "sha256 of the image file"}
+	v.Mismatches, err = compareFileSHA256(imagePath, sum)
+	return v, err
+}
+
 // GetImages return all images on prem
 func (p *OnPrem) GetImages(ctx *Context) (images []CloudImage, err error) {
 	opshome := GetOpsHome()
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -73,6 +74,7 @@ func (p *OnPrem) GetInstances(ctx *Context) (instances []CloudInstance, err erro
 			Image:      i.Image,
 			Status:     "Running",
 			Created:    Time2Human(f.ModTime()),
+			CreatedAt:  f.ModTime(),
 			PrivateIps: []string{"127.0.0.1"},
 			PublicIps:  strings.Split(i.portList(), ","),
 		})
@@ -137,14 +139,26 @@ func (p *OnPrem) DeleteInstance(ctx *Context, instancename string) error {
 		fmt.Println(err)
 	}
 
+	opshome := GetOpsHome()
+	ipath := path.Join(opshome, "instances", instancename)
+
+	// power down gracefully when possible so attached volumes are left
+	// consistent
+	var i instance
+	if body, err := ioutil.ReadFile(ipath); err == nil && json.Unmarshal(body, &i) == nil && i.QMP != "" {
+		err := qmpPowerdown(i.QMP, qemuShutdownTimeout)
+		os.Remove(i.QMP)
+		if err == nil {
+			return os.Remove(ipath)
+		}
+	}
+
 	// yolo
 	err = sysKill(pid)
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	opshome := GetOpsHome()
-	ipath := path.Join(opshome, "instances", instancename)
 	err = os.Remove(ipath)
 	if err != nil {
 		return err
@@ -153,6 +167,60 @@ func (p *OnPrem) DeleteInstance(ctx *Context, instancename string) error {
 	return nil
 }
 
+// SuspendInstance saves the state of an on premise instance to file and stops
+// it, creating an instance with RunConfig.RestoreState set to file resumes it
+func (p *OnPrem) SuspendInstance(ctx *Context, instancename string, file string) error {
+	opshome := GetOpsHome()
+	ipath := path.Join(opshome, "instances", instancename)
+
+	body, err := ioutil.ReadFile(ipath)
+	if err != nil {
+		return err
+	}
+
+	var i instance
+	if err := json.Unmarshal(body, &i); err != nil {
+		return err
+	}
+	if i.QMP == "" {
+		return fmt.Errorf("instance %s was started without QMP and can't be suspended", instancename)
+	}
+
+	if err := qmpSaveState(i.QMP, file); err != nil {
+		return err
+	}
+
+	os.Remove(i.QMP)
+	return os.Remove(ipath)
+}
+
+// ScreenshotInstance writes the display of an on premise instance to file,
+// as png when file ends with .png and ppm otherwise. The instance needs a
+// display or a VNC port.
+func (p *OnPrem) ScreenshotInstance(ctx *Context, instancename string, file string) error {
+	opshome := GetOpsHome()
+	ipath := path.Join(opshome, "instances", instancename)
+
+	body, err := ioutil.ReadFile(ipath)
+	if err != nil {
+		return err
+	}
+
+	var i instance
+	if err := json.Unmarshal(body, &i); err != nil {
+		return err
+	}
+	if i.QMP == "" {
+		return fmt.Errorf("instance %s was started without QMP and can't take screenshots", instancename)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	return qmpScreendump(i.QMP, abs)
+}
+
 // PrintInstanceLogs writes instance logs to console
 func (p *OnPrem) PrintInstanceLogs(ctx *Context, instancename string, watch bool) error {
 	l, err := p.GetInstanceLogs(ctx, instancename)
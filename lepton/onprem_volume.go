@@ -27,7 +27,9 @@ const (
 )
 
 var (
-	errVolumeNotFound = func(id string) error { return errors.Errorf("volume with UUID %s not found", id) }
+	errVolumeNotFound = func(id string) error {
+		return WithCode(ErrVolumeNotFound, errors.Errorf("volume with UUID %s not found", id))
+	}
 )
 
 // CreateVolume creates volume for onprem image
@@ -112,7 +114,8 @@ func (op *OnPrem) parseSize(vol NanosVolume) string {
 // buildVolumeManifest builds manifests for non-empty volume
 func buildVolumeManifest(conf *Config, out string) error {
 	m := &Manifest{
-		children:    make(map[string]interface{}),
+		root:        NewDirNode(),
+		boot:        NewDirNode(),
 		debugFlags:  make(map[string]rune),
 		environment: make(map[string]string),
 	}
@@ -322,7 +325,7 @@ func AddMounts(mounts []string, config *Config) error {
 		}
 
 		if len(vols) == 0 {
-			return fmt.Errorf("volume with uuid/label %s not found", lm[0])
+			return WithCode(ErrVolumeNotFound, fmt.Errorf("volume with uuid/label %s not found", lm[0]))
 		} else if len(vols) > 1 {
 			return fmt.Errorf("ambiguous volume uuid/label: %s: multiple volumes found", lm[0])
 		}
@@ -354,7 +357,7 @@ func addMounts(config *Config) error {
 		}
 
 		if len(vols) == 0 {
-			return fmt.Errorf("volume with uuid/label %s not found", label)
+			return WithCode(ErrVolumeNotFound, fmt.Errorf("volume with uuid/label %s not found", label))
 		} else if len(vols) > 1 {
 			return fmt.Errorf("ambiguous volume uuid/label: %s: multiple volumes found", label)
 		}
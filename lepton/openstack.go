@@ -3,6 +3,7 @@ package lepton
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
@@ -22,6 +23,10 @@ type OpenStack struct {
 
 // Initialize OpenStack related things
 func (o *OpenStack) Initialize(config *ProviderConfig) error {
+	_, err := ResolveCredentials("openstack", config.Profile)
+	if err != nil {
+		return err
+	}
 
 	opts, err := openstack.AuthOptionsFromEnv()
 
@@ -29,10 +34,14 @@ func (o *OpenStack) Initialize(config *ProviderConfig) error {
 		return err
 	}
 
-	o.provider, err = openstack.AuthenticatedClient(opts)
+	o.provider, err = openstack.NewClient(opts.IdentityEndpoint)
 	if err != nil {
 		return err
 	}
+	o.provider.HTTPClient = http.Client{Transport: RetryPolicyFromConfig(config).Transport(nil)}
+	if err := openstack.Authenticate(o.provider, opts); err != nil {
+		return err
+	}
 
 	return nil
 }
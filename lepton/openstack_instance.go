@@ -52,10 +52,12 @@ func getOpenStackInstances(provider *gophercloud.ProviderClient, opts servers.Li
 				}
 
 				cinstance := CloudInstance{
-					ID:      s.ID,
-					Name:    s.Name,
-					Status:  s.Status,
-					Created: s.Created.Format("2006-01-02 15:04:05"),
+					ID:        s.ID,
+					Name:      s.Name,
+					Status:    s.Status,
+					Created:   s.Created.Format("2006-01-02 15:04:05"),
+					CreatedAt: s.Created,
+					Tags:      s.Metadata,
 				}
 
 				if ipv4 != "" {
@@ -52,6 +52,7 @@ func DownloadPackage(name string) (string, error) {
 			return "", err
 		}
 	}
+	MarkCacheUsed(packagepath)
 	return packagepath, nil
 }
 
@@ -232,21 +233,20 @@ func ExtractPackage(archive string, dest string) {
 
 // BuildImageFromPackage builds nanos image using a package
 func BuildImageFromPackage(packagepath string, c Config) error {
-	m, err := BuildPackageManifest(packagepath, &c)
-	if err != nil {
-		return errors.Wrap(err, 1)
-	}
+	return buildImageWithHooks(&c, func(c *Config) (*Manifest, error) {
+		m, err := BuildPackageManifest(packagepath, c)
+		if err != nil {
+			return nil, err
+		}
 
-	if c.RunConfig.IPAddr != "" {
-		m.AddNetworkConfig(&ManifestNetworkConfig{
-			IP:      c.RunConfig.IPAddr,
-			Gateway: c.RunConfig.Gateway,
-			NetMask: c.RunConfig.NetMask,
-		})
-	}
+		if c.RunConfig.IPAddr != "" {
+			m.AddNetworkConfig(&ManifestNetworkConfig{
+				IP:      c.RunConfig.IPAddr,
+				Gateway: c.RunConfig.Gateway,
+				NetMask: c.RunConfig.NetMask,
+			})
+		}
 
-	if err := buildImage(&c, m); err != nil {
-		return errors.Wrap(err, 1)
-	}
-	return nil
+		return m, nil
+	})
 }
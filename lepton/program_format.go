@@ -0,0 +1,132 @@
+package lepton
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// programFormat is the format of the programs nanos runs
+const programFormat = "Linux ELF 64-bit x86-64 executable"
+
+var elfMachineNames = map[elf.Machine]string{
+	elf.EM_X86_64:  "x86-64",
+	elf.EM_386:     "x86",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_RISCV:   "riscv",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390x",
+}
+
+var peMachineNames = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_AMD64: "x86-64",
+	pe.IMAGE_FILE_MACHINE_I386:  "x86",
+	0xaa64:                      "arm64",
+}
+
+var machoCPUNames = map[macho.Cpu]string{
+	macho.CpuAmd64: "x86-64",
+	macho.Cpu386:   "x86",
+	macho.CpuArm64: "arm64",
+	macho.CpuArm:   "arm",
+}
+
+// checkProgramFormat returns an error naming the format of the program at
+// path when nanos can't run it, like a Mach-O built on macOS or an arm64 ELF
+func checkProgramFormat(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	found, ok := describeProgramFormat(f)
+	if ok {
+		return nil
+	}
+	err = fmt.Errorf("program %s is a %s, expected a %s", path, found, programFormat)
+	if strings.HasPrefix(found, "script") {
+		err = fmt.Errorf("%v, run its interpreter with the script as argument", err)
+	}
+	return WithCode(ErrMkfsUnsupportedProgram, err)
+}
+
+// describeProgramFormat returns the format of the executable read from r
+// and whether nanos runs it
+func describeProgramFormat(r io.ReaderAt) (string, bool) {
+	magic := make([]byte, 4)
+	if n, _ := r.ReadAt(magic, 0); n < 2 {
+		return "file too short to be an executable", false
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte(elf.ELFMAG)):
+		return describeELF(r)
+	case magic[0] == 'M' && magic[1] == 'Z':
+		return describePE(r), false
+	case magic[0] == '#' && magic[1] == '!':
+		line, _ := bufio.NewReader(io.NewSectionReader(r, 2, 256)).ReadString('\n')
+		return fmt.Sprintf("script for %s", strings.TrimSpace(line)), false
+	}
+
+	be, le := binary.BigEndian.Uint32(magic), binary.LittleEndian.Uint32(magic)
+	switch {
+	case be == macho.MagicFat:
+		return "macOS Mach-O universal binary", false
+	case le == macho.Magic32 || le == macho.Magic64 || be == macho.Magic32 || be == macho.Magic64:
+		desc := "macOS Mach-O executable"
+		if f, err := macho.NewFile(r); err == nil {
+			desc = fmt.Sprintf("macOS Mach-O %s executable", cpuName(machoCPUNames[f.Cpu], f.Cpu.String()))
+		}
+		return desc, false
+	}
+	return "file of unknown format, not an executable", false
+}
+
+func describeELF(r io.ReaderAt) (string, bool) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Sprintf("malformed ELF file (%v)", err), false
+	}
+
+	bits := "32-bit"
+	if f.Class == elf.ELFCLASS64 {
+		bits = "64-bit"
+	}
+	system := "Linux"
+	if f.OSABI != elf.ELFOSABI_NONE && f.OSABI != elf.ELFOSABI_LINUX {
+		system = strings.TrimPrefix(f.OSABI.String(), "ELFOSABI_")
+	}
+	kind := "executable"
+	if f.Type == elf.ET_REL {
+		kind = "object file"
+	} else if f.Type == elf.ET_CORE {
+		kind = "core dump"
+	}
+	desc := fmt.Sprintf("%s ELF %s %s %s", system, bits, cpuName(elfMachineNames[f.Machine], f.Machine.String()), kind)
+	return desc, desc == programFormat
+}
+
+func describePE(r io.ReaderAt) string {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return "Windows PE executable"
+	}
+	defer f.Close()
+	return fmt.Sprintf("Windows PE %s executable", cpuName(peMachineNames[f.Machine], fmt.Sprintf("machine 0x%x", f.Machine)))
+}
+
+func cpuName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
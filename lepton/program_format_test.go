@@ -0,0 +1,80 @@
+package lepton
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// elfHeader returns the header of an empty 64-bit little endian ELF
+func elfHeader(osabi elf.OSABI, typ elf.Type, machine elf.Machine) []byte {
+	hdr := elf.Header64{
+		Type:      uint16(typ),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Ehsize:    64,
+		Phentsize: 56,
+		Shentsize: 64,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(osabi)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	return buf.Bytes()
+}
+
+func TestDescribeProgramFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		ok   bool
+	}{
+		{"linux amd64", elfHeader(elf.ELFOSABI_NONE, elf.ET_EXEC, elf.EM_X86_64), programFormat, true},
+		{"linux amd64 pie", elfHeader(elf.ELFOSABI_LINUX, elf.ET_DYN, elf.EM_X86_64), programFormat, true},
+		{"linux arm64", elfHeader(elf.ELFOSABI_NONE, elf.ET_EXEC, elf.EM_AARCH64), "Linux ELF 64-bit arm64 executable", false},
+		{"freebsd", elfHeader(elf.ELFOSABI_FREEBSD, elf.ET_EXEC, elf.EM_X86_64), "FREEBSD ELF 64-bit x86-64 executable", false},
+		{"object file", elfHeader(elf.ELFOSABI_NONE, elf.ET_REL, elf.EM_X86_64), "Linux ELF 64-bit x86-64 object file", false},
+		{"mach-o", []byte{0xcf, 0xfa, 0xed, 0xfe, 0x07, 0x00, 0x00, 0x01}, "macOS Mach-O", false},
+		{"universal", []byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 2}, "macOS Mach-O universal binary", false},
+		{"pe", []byte("MZ\x90\x00"), "Windows PE executable", false},
+		{"script", []byte("#!/usr/bin/env python3\nprint(1)\n"), "script for /usr/bin/env python3", false},
+		{"text", []byte("hello"), "unknown format", false},
+	}
+	for _, tt := range tests {
+		got, ok := describeProgramFormat(bytes.NewReader(tt.data))
+		if ok != tt.ok || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSetProgramUnsupportedFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	program := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(program, elfHeader(elf.ELFOSABI_NONE, elf.ET_EXEC, elf.EM_AARCH64), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewManifest("").SetProgram(program)
+	if code, _ := ErrorCodeOf(err); code != ErrMkfsUnsupportedProgram {
+		t.Fatalf("got %v, want %s", err, ErrMkfsUnsupportedProgram)
+	}
+	if !strings.Contains(err.Error(), "arm64") || !strings.Contains(err.Error(), programFormat) {
+		t.Errorf("error should name the found and expected formats: %v", err)
+	}
+}
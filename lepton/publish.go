@@ -0,0 +1,179 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PublishTarget is a provider, and optionally a zone of it, an image is
+// published to
+type PublishTarget struct {
+	Provider string
+	Zone     string
+}
+
+// ParsePublishTarget parses targets like "gcp" or "aws:us-west-2", the zone
+// of the config is used when there is none
+func ParsePublishTarget(s string) (PublishTarget, error) {
+	parts := strings.SplitN(s, ":", 2)
+	target := PublishTarget{Provider: strings.TrimSpace(parts[0])}
+	if len(parts) == 2 {
+		target.Zone = strings.TrimSpace(parts[1])
+	}
+	if target.Provider == "" {
+		return target, fmt.Errorf("invalid publish target %q, expected <provider>[:<zone>]", s)
+	}
+	return target, nil
+}
+
+// Name returns the provider and zone of t
+func (t PublishTarget) Name() string {
+	if t.Zone == "" {
+		return t.Provider
+	}
+	return t.Provider + ":" + t.Zone
+}
+
+// Publisher creates the same built image on several providers and zones
+// concurrently. The image must have been built with what every provider
+// needs, like the cloud_init klib for azure.
+type Publisher struct {
+	// Config is the config every target starts from, its cloud image name
+	// is the name of the published images
+	Config *Config
+
+	// ImagePath is the built image
+	ImagePath string
+
+	// Targets are the providers and zones the image is published to
+	Targets []PublishTarget
+
+	// Concurrency is the number of targets published to at the same time,
+	// all of them when zero
+	Concurrency int
+
+	// NewProvider returns the initialized provider of a name
+	NewProvider func(name string, c *ProviderConfig) (Provider, error)
+}
+
+// PublishResult is the outcome of publishing to one target
+type PublishResult struct {
+	Target   PublishTarget
+	Duration time.Duration
+	Err      error
+}
+
+// PublishReport is the combined outcome of publishing an image
+type PublishReport struct {
+	ImageName string
+	Results   []PublishResult
+	Duration  time.Duration
+}
+
+// Failed returns the results of the targets the image failed to be
+// published to
+func (r *PublishReport) Failed() []PublishResult {
+	failed := []PublishResult{}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Publish publishes the image to every target and returns their results in
+// the order of Targets, the error lists the targets that failed
+func (pb *Publisher) Publish() (*PublishReport, error) {
+	if pb.Config == nil || pb.NewProvider == nil {
+		return nil, fmt.Errorf("publisher needs a config and a provider factory")
+	}
+	if len(pb.Targets) == 0 {
+		return nil, fmt.Errorf("no publish targets")
+	}
+	if _, err := os.Stat(pb.ImagePath); err != nil {
+		return nil, err
+	}
+
+	concurrency := pb.Concurrency
+	if concurrency <= 0 {
+		concurrency = len(pb.Targets)
+	}
+
+	started := time.Now()
+	report := &PublishReport{
+		ImageName: pb.Config.CloudConfig.ImageName,
+		Results:   make([]PublishResult, len(pb.Targets)),
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, t := range pb.Targets {
+		wg.Add(1)
+		go func(i int, t PublishTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			err := pb.publishTarget(t)
+			report.Results[i] = PublishResult{Target: t, Duration: time.Since(start), Err: err}
+		}(i, t)
+	}
+	wg.Wait()
+	report.Duration = time.Since(started)
+
+	failed := []string{}
+	for _, result := range report.Failed() {
+		failed = append(failed, fmt.Sprintf("%s: %v", result.Target.Name(), result.Err))
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("failed to publish to %d of %d targets: %s", len(failed), len(pb.Targets), strings.Join(failed, "; "))
+	}
+	return report, nil
+}
+
+func (pb *Publisher) publishTarget(t PublishTarget) error {
+	c, err := copyConfig(pb.Config)
+	if err != nil {
+		return err
+	}
+	c.CloudConfig.Platform = t.Provider
+	if t.Zone != "" {
+		c.CloudConfig.Zone = t.Zone
+	}
+
+	// providers write the files they upload next to the image, every target
+	// gets its own link to it so they don't overwrite each other's
+	dir, err := ioutil.TempDir("", "publish-"+strings.Replace(t.Name(), ":", "-", -1))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	imagePath, err := filepath.Abs(pb.ImagePath)
+	if err != nil {
+		return err
+	}
+	c.RunConfig.Imagename = filepath.Join(dir, filepath.Base(imagePath))
+	if err := os.Symlink(imagePath, c.RunConfig.Imagename); err != nil {
+		return err
+	}
+
+	p, err := pb.NewProvider(t.Provider, &c.CloudConfig)
+	if err != nil {
+		return err
+	}
+	ctx := NewContext(c)
+
+	path, err := p.CustomizeImage(ctx)
+	if err != nil {
+		return err
+	}
+	return p.CreateImage(ctx, path)
+}
@@ -0,0 +1,99 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type fakePublishProvider struct {
+	Provider
+	mu        *sync.Mutex
+	published map[string]string
+	broken    bool
+}
+
+func (p *fakePublishProvider) CustomizeImage(ctx *Context) (string, error) {
+	return ctx.config.RunConfig.Imagename, nil
+}
+
+func (p *fakePublishProvider) CreateImage(ctx *Context, imagePath string) error {
+	if p.broken {
+		return fmt.Errorf("broken provider")
+	}
+	data, err := ioutil.ReadFile(imagePath)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published[ctx.config.CloudConfig.Platform+":"+ctx.config.CloudConfig.Zone] = string(data)
+	return nil
+}
+
+func TestParsePublishTarget(t *testing.T) {
+	target, err := ParsePublishTarget("aws:us-west-2")
+	if err != nil || target != (PublishTarget{Provider: "aws", Zone: "us-west-2"}) {
+		t.Errorf("got %+v, %v", target, err)
+	}
+	target, err = ParsePublishTarget("gcp")
+	if err != nil || target != (PublishTarget{Provider: "gcp"}) {
+		t.Errorf("got %+v, %v", target, err)
+	}
+	if _, err := ParsePublishTarget(":us-west-2"); err == nil {
+		t.Error("expected an error for a target without provider")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imagePath := filepath.Join(dir, "app.img")
+	if err := ioutil.WriteFile(imagePath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig()
+	c.CloudConfig.ImageName = "app"
+	c.CloudConfig.Zone = "us-central1-a"
+
+	var mu sync.Mutex
+	published := map[string]string{}
+	pb := &Publisher{
+		Config:    c,
+		ImagePath: imagePath,
+		Targets: []PublishTarget{
+			{Provider: "gcp"},
+			{Provider: "aws", Zone: "us-west-2"},
+			{Provider: "aws", Zone: "eu-west-1"},
+			{Provider: "azure"},
+		},
+		NewProvider: func(name string, c *ProviderConfig) (Provider, error) {
+			return &fakePublishProvider{mu: &mu, published: published, broken: name == "azure"}, nil
+		},
+	}
+
+	report, err := pb.Publish()
+	if err == nil {
+		t.Fatal("expected the azure target to fail")
+	}
+	if len(report.Results) != 4 || len(report.Failed()) != 1 || report.Failed()[0].Target.Provider != "azure" {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+
+	for _, name := range []string{"gcp:us-central1-a", "aws:us-west-2", "aws:eu-west-1"} {
+		if published[name] != "image" {
+			t.Errorf("%s not published with the image: %q", name, published[name])
+		}
+	}
+	if c.CloudConfig.Platform != "" || c.CloudConfig.Zone != "us-central1-a" {
+		t.Errorf("config of the publisher changed: %+v", c.CloudConfig)
+	}
+}
@@ -27,6 +27,7 @@ type errQemuCannotExecute struct{ errCustom }
 type errQemuCannotGetQemuVersion struct{ errCustom }
 type errQemuHWAccelNotSupported struct{ errCustom }
 type errQemuHWAccelNoUserRights struct{ errCustom }
+type errQemuHWAccelWrongArch struct{ errCustom }
 
 func qemuAccelWarningMessage(err error) (message string, terminate bool) {
 	var (
@@ -35,6 +36,7 @@ func qemuAccelWarningMessage(err error) (message string, terminate bool) {
 		targetErrQemuCannotExecute           *errQemuCannotExecute
 		targetQemuHWAccelNoUserRights        *errQemuHWAccelNoUserRights
 		targetQemuHWAccelNotSupported        *errQemuHWAccelNotSupported
+		targetQemuHWAccelWrongArch           *errQemuHWAccelWrongArch
 	)
 	if errors.As(err, &targetErrQemuHWAccelDisabledInConfig) {
 		return fmt.Sprintf(WarningColor, "You have disabled hardware acceleration\n"), false
@@ -56,7 +58,12 @@ func qemuAccelWarningMessage(err error) (message string, terminate bool) {
 
 	if errors.As(err, &targetQemuHWAccelNotSupported) {
 		return fmt.Sprintf(WarningColor, "You specified hardware acceleration, but it is not supported\n"+
-			"Are you running inside a vm? If so disable accel with --accel=false\n"), false
+			"Are you running inside a vm or a container without /dev/kvm? If so disable accel with --accel=false\n"), false
+	}
+
+	if errors.As(err, &targetQemuHWAccelWrongArch) {
+		return fmt.Sprintf(WarningColor, "Hardware acceleration only runs guests of the host architecture\n"+
+			"x86_64 images are emulated on this host, disable accel with --accel=false to silence this warning\n"), false
 	}
 
 	return fmt.Sprintf(WarningColor, "Hardware acceleration cannot be used on the current host"), false
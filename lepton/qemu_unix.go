@@ -1,11 +1,13 @@
-// +build linux darwin
+// +build linux darwin windows
 
 package lepton
 
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -17,8 +19,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-
-	"golang.org/x/sys/unix"
+	"time"
 )
 
 const qemuBaseCommand = "qemu-system-x86_64"
@@ -36,6 +37,7 @@ type device struct {
 	devtype string
 	mac     string
 	devid   string
+	queues  int
 }
 
 type netdev struct {
@@ -45,6 +47,8 @@ type netdev struct {
 	script     string
 	downscript string
 	hports     []portfwd
+	queues     int
+	vhost      bool
 }
 
 type portfwd struct {
@@ -68,6 +72,8 @@ type qemu struct {
 	display display
 	serial  serial
 	flags   []string
+	qmp     string
+	report  RunReport
 }
 
 func (d display) String() string {
@@ -106,6 +112,10 @@ func (dv device) String() string {
 	if len(dv.mac) > 0 {
 		sb.WriteString(fmt.Sprintf(",mac=%s", dv.mac))
 	}
+	if dv.queues > 1 {
+		// a vector per tx and rx queue, plus config and control
+		sb.WriteString(fmt.Sprintf(",mq=on,vectors=%d", 2*dv.queues+2))
+	}
 	return sb.String()
 }
 
@@ -127,6 +137,12 @@ func (nd netdev) String() string {
 			sb.WriteString(",downscript=no")
 		}
 	}
+	if nd.queues > 1 {
+		sb.WriteString(fmt.Sprintf(",queues=%d", nd.queues))
+	}
+	if nd.vhost {
+		sb.WriteString(",vhost=on")
+	}
 	for _, hport := range nd.hports {
 		sb.WriteString(fmt.Sprintf(",%s", hport))
 	}
@@ -146,15 +162,71 @@ func (pf portfwd) String() string {
 	return fmt.Sprintf("hostfwd=%s::%v-:%v", pf.proto, fromPort, toPort)
 }
 
+// Stop powers the guest down through QMP, so attached volumes are left
+// consistent, and kills qemu if it doesn't exit in time
 func (q *qemu) Stop() {
-	if q.cmd != nil {
-		if err := q.cmd.Process.Kill(); err != nil {
-			fmt.Println(err)
+	if q.cmd != nil && q.cmd.Process != nil {
+		if q.qmp == "" || qmpPowerdown(q.qmp, qemuShutdownTimeout) != nil {
+			if err := q.cmd.Process.Kill(); err != nil {
+				fmt.Println(err)
+			}
 		}
 
 		// do not print errors as the command could be started with Run()
 		q.cmd.Wait()
 	}
+
+	if q.qmp != "" {
+		os.Remove(q.qmp)
+	}
+}
+
+// Reset resets the guest as if the machine was power cycled
+func (q *qemu) Reset() error {
+	return q.execute("system_reset")
+}
+
+// Pause stops the guest vcpus
+func (q *qemu) Pause() error {
+	return q.execute("stop")
+}
+
+// Resume restarts the guest vcpus after Pause
+func (q *qemu) Resume() error {
+	return q.execute("cont")
+}
+
+// Suspend saves the guest state to file and stops qemu, booting with
+// RunConfig.RestoreState set to file resumes the guest where it was
+func (q *qemu) Suspend(file string) error {
+	if q.qmp == "" {
+		return errors.New("qemu is not running")
+	}
+	if err := qmpSaveState(q.qmp, file); err != nil {
+		return err
+	}
+
+	if q.cmd != nil {
+		q.cmd.Wait()
+	}
+	os.Remove(q.qmp)
+	return nil
+}
+
+// Screenshot writes the display of the guest to file, as png when file ends
+// with .png and ppm otherwise
+func (q *qemu) Screenshot(file string) error {
+	if q.qmp == "" {
+		return errors.New("qemu is not running")
+	}
+	return qmpScreendump(q.qmp, file)
+}
+
+func (q *qemu) execute(command string) error {
+	if q.qmp == "" {
+		return errors.New("qemu is not running")
+	}
+	return qmpExecute(q.qmp, command)
 }
 
 func logv(rconfig *RunConfig, msg string) {
@@ -182,7 +254,35 @@ func (q *qemu) Command(rconfig *RunConfig) *exec.Cmd {
 	return q.cmd
 }
 
+// Report describes the last run started
+func (q *qemu) Report() RunReport {
+	return q.report
+}
+
+// pinVCPUs pins the vCPU threads of the running qemu to the host CPUs of
+// RunConfig.CPUAffinity
+func (q *qemu) pinVCPUs(rconfig *RunConfig) error {
+	if len(rconfig.CPUAffinity) == 0 {
+		return nil
+	}
+
+	threads, err := qmpVCPUThreads(q.qmp)
+	if err != nil {
+		return fmt.Errorf("cannot pin vcpus: %v", err)
+	}
+
+	for n, tid := range threads {
+		cpu := rconfig.CPUAffinity[n%len(rconfig.CPUAffinity)]
+		if err := setThreadAffinity(tid, cpu); err != nil {
+			return fmt.Errorf("cannot pin vcpu %d to cpu %d: %v", n, cpu, err)
+		}
+		logv(rconfig, fmt.Sprintf("pinned vcpu %d to cpu %d", n, cpu))
+	}
+	return nil
+}
+
 func (q *qemu) Start(rconfig *RunConfig) error {
+	q.report.StartedAt = time.Now()
 	if q.cmd == nil {
 		q.Command(rconfig)
 		q.cmd.Stdout = os.Stdout
@@ -190,8 +290,11 @@ func (q *qemu) Start(rconfig *RunConfig) error {
 	}
 
 	if rconfig.OnPrem {
-		err := q.cmd.Start()
-		if err != nil {
+		if err := q.cmd.Start(); err != nil {
+			return err
+		}
+
+		if err := q.pinVCPUs(rconfig); err != nil {
 			fmt.Println(err)
 		}
 
@@ -205,6 +308,8 @@ func (q *qemu) Start(rconfig *RunConfig) error {
 		i := instance{
 			Image: sbase[0],
 			Ports: rconfig.Ports,
+			QMP:   q.qmp,
+			Accel: q.report.Accelerator,
 		}
 
 		d1, err := json.Marshal(i)
@@ -219,14 +324,69 @@ func (q *qemu) Start(rconfig *RunConfig) error {
 
 	} else {
 
-		if err := q.cmd.Run(); err != nil {
+		if rconfig.SerialLog != "" {
+			serialLog, err := NewSerialLog(rconfig.SerialLog, rconfig.SerialLogSize, rconfig.SerialLogBackups)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := serialLog.Close(); err != nil {
+					fmt.Println(err)
+				}
+			}()
+			if q.cmd.Stdout != nil {
+				q.cmd.Stdout = io.MultiWriter(q.cmd.Stdout, serialLog)
+			} else {
+				q.cmd.Stdout = serialLog
+			}
+		}
+
+		if err := q.cmd.Start(); err != nil {
+			return err
+		}
+
+		if err := q.pinVCPUs(rconfig); err != nil {
 			fmt.Println(err)
 		}
+
+		return q.wait(rconfig)
 	}
 
 	return nil
 }
 
+// wait waits for qemu to exit, the error is a GuestExitError when the
+// kernel reported a non zero exit status of the program
+func (q *qemu) wait(rconfig *RunConfig) error {
+	err := q.cmd.Wait()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return err
+	}
+	if !rconfig.PropagateExitStatus {
+		// kernels halt qemu with odd codes, which are only the exit status
+		// of the program with PropagateStatus, and qemu fails with 1 too
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if code := exitErr.ExitCode(); code < 0 || code&1 == 0 {
+				return fmt.Errorf("qemu failed: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if err == nil {
+		return errors.New("qemu exited without the exit status of the program")
+	}
+	status, ok := guestExitStatus(err)
+	if !ok {
+		return fmt.Errorf("qemu failed: %v", err)
+	}
+	q.report.ExitStatus = status
+	if status != 0 {
+		return &GuestExitError{Status: status}
+	}
+	return nil
+}
+
 func (q *qemu) addDrive(id, image, ifaceType string) {
 	drv := drive{
 		path:   image,
@@ -241,6 +401,26 @@ func (q *qemu) addDisplay(dispType string) {
 	q.display = display{disptype: dispType}
 }
 
+// setDisplay adds the display and VNC server of rconfig, with a vga device
+// when the guest has either
+func (q *qemu) setDisplay(rconfig *RunConfig) {
+	dispType := rconfig.Display
+	if dispType == "" {
+		dispType = "none"
+	}
+	q.addDisplay(dispType)
+
+	if rconfig.VNCPort > 0 {
+		q.addOption("-vnc", fmt.Sprintf("127.0.0.1:%d", rconfig.VNCPort-VNCBasePort))
+	}
+
+	if dispType == "none" && rconfig.VNCPort == 0 {
+		q.addOption("-vga", "none")
+	} else {
+		q.addOption("-vga", "std")
+	}
+}
+
 func (q *qemu) addSerial(serialType string) {
 	q.serial = serial{serialtype: serialType}
 }
@@ -282,6 +462,28 @@ func (q *qemu) addNetDevice(devType, ifaceName, mac string, hostPorts []string,
 	q.ifaces = append(q.ifaces, ndv)
 }
 
+// setNetPerformance enables multiqueue and vhost-net on the tap interfaces,
+// user mode networking supports neither
+func (q *qemu) setNetPerformance(rconfig *RunConfig) {
+	if rconfig.NetQueues <= 1 && !rconfig.VHost {
+		return
+	}
+
+	for i := range q.ifaces {
+		if q.ifaces[i].nettype != "tap" {
+			fmt.Printf(WarningColor, "Multiqueue and vhost need bridged networking, ignoring them\n")
+			continue
+		}
+		q.ifaces[i].queues = rconfig.NetQueues
+		q.ifaces[i].vhost = rconfig.VHost
+		for j := range q.devices {
+			if q.devices[j].devid == q.ifaces[i].id {
+				q.devices[j].queues = rconfig.NetQueues
+			}
+		}
+	}
+}
+
 func (q *qemu) addDiskDevice(id, driver string) {
 	dv := device{
 		driver:  driver,
@@ -292,6 +494,13 @@ func (q *qemu) addDiskDevice(id, driver string) {
 }
 
 // Randomly generate Bytes for mac address
+// qmpSocketPath returns a unique path for the QMP socket of a new qemu
+func qmpSocketPath() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ops-%x.qmp", b))
+}
+
 func generateMac() string {
 	octets := make([]byte, 6)
 	_, err := rand.Read(octets)
@@ -320,10 +529,6 @@ func parseQemuVersion(data []byte) string {
 
 // kvmAvailable returns nil if the current user have read and write access to /dev/kvm
 // or error in other case
-func kvmAvailable() error {
-	return syscall.Access("/dev/kvm", unix.R_OK|unix.W_OK)
-}
-
 // versionCompare compares Qemu version numbers. If the the first argument is
 // greater then true is returned, if the second argument is greater
 // then versionCompare returns false, otherwise it returns true.
@@ -392,6 +597,7 @@ func (q *qemu) setAccel(rconfig *RunConfig) {
 		supportedErr error = &errQemuHWAccelDisabledInConfig{errCustom{"Hardware acceleration disabled in config", nil}}
 	)
 
+	q.report.Accelerator = "tcg"
 	if rconfig.Accel {
 		isAdded, supportedErr = q.addAccel()
 	}
@@ -406,8 +612,12 @@ func (q *qemu) setAccel(rconfig *RunConfig) {
 		}
 		if isAdded {
 			fmt.Printf(WarningColor, "Anyway, we will try to enable hardware acceleration\n")
+		} else if rconfig.Accel {
+			fmt.Printf(WarningColor, "Falling back to software emulation (tcg), which is much slower\n")
 		}
 	}
+
+	logv(rconfig, "accelerator: "+q.report.Accelerator)
 }
 
 // addAccel - trying to enable hardware acceleration and check if it is supported.
@@ -426,32 +636,57 @@ func (q *qemu) addAccel() (bool, error) {
 		return false, &errQemuNotInstalled{errCustom{"QEMU not found", nil}}
 	}
 
-	const hvfSupportedVersion = "2.12" // https://wiki.qemu.org/ChangeLog/2.12#Host_support
+	const (
+		hvfSupportedVersion  = "2.12" // https://wiki.qemu.org/ChangeLog/2.12#Host_support
+		whpxSupportedVersion = "3.0"  // https://wiki.qemu.org/ChangeLog/3.0#Host_support
+	)
 	qemuVersion, err := QemuVersion()
 	if err != nil {
 		return false, &errQemuCannotGetQemuVersion{errCustom{"cannot get QEMU version", err}}
 	}
 
+	// hypervisors only run guests of the host architecture, and images are
+	// x86_64, so on apple silicon the guest is emulated
+	if runtime.GOARCH != "amd64" {
+		return false, &errQemuHWAccelWrongArch{errCustom{"cannot accelerate x86_64 guests on " + runtime.GOARCH, nil}}
+	}
+
 	ok, err := hvSupport()
 	if !(ok && err == nil) {
 		return false, &errQemuHWAccelNotSupported{errCustom{"Hardware acceleration not supported", err}}
 	}
 
-	if runtime.GOOS == "darwin" {
+	switch runtime.GOOS {
+	case "darwin":
 		if ok, _ := q.versionCompare(qemuVersion, hvfSupportedVersion); ok {
 			q.addOption("-accel", "hvf")
 			q.addOption("-cpu", "host")
+			q.report.Accelerator = "hvf"
 			return true, nil
 		}
 		return false, &errQemuHWAccelNotSupported{errCustom{"Hardware acceleration not supported", nil}}
-	}
 
-	if runtime.GOOS == "linux" {
-		q.addFlag("-machine accel=kvm:tcg")
-		q.addOption("-cpu", "host")
+	case "windows":
+		// whpx doesn't support the in kernel irqchip of q35
+		if ok, _ := q.versionCompare(qemuVersion, whpxSupportedVersion); ok {
+			q.addOption("-accel", "whpx,kernel-irqchip=off")
+			q.report.Accelerator = "whpx"
+			return true, nil
+		}
+		return false, &errQemuHWAccelNotSupported{errCustom{"Hardware acceleration not supported", nil}}
+
+	case "linux":
+		// -cpu host doesn't work with tcg, so only enable kvm when it can
+		// be used, as in containers and CI /dev/kvm is often missing
 		if err := kvmAvailable(); err != nil {
-			return true, &errQemuHWAccelNotSupported{errCustom{"Hardware acceleration not supported", err}}
+			if os.IsPermission(err) {
+				return false, &errQemuHWAccelNoUserRights{errCustom{"no rights on /dev/kvm", err}}
+			}
+			return false, &errQemuHWAccelNotSupported{errCustom{"/dev/kvm not available", err}}
 		}
+		q.addFlag("-machine accel=kvm")
+		q.addOption("-cpu", "host")
+		q.report.Accelerator = "kvm"
 		return true, nil
 	}
 
@@ -490,7 +725,8 @@ func (q *qemu) setConfig(rconfig *RunConfig) {
 	q.setAccel(rconfig)
 
 	q.addNetDevice(netDevType, ifaceName, "", rconfig.Ports, rconfig.UDP)
-	q.addDisplay("none")
+	q.setNetPerformance(rconfig)
+	q.setDisplay(rconfig)
 
 	if rconfig.OnPrem {
 		q.addSerial("file:/tmp/" + rconfig.BaseName + ".log")
@@ -498,12 +734,23 @@ func (q *qemu) setConfig(rconfig *RunConfig) {
 		q.addSerial("stdio")
 	}
 
+	q.qmp = qmpSocketPath()
+	q.addOption("-qmp", "unix:"+q.qmp+",server,nowait")
+
 	q.addFlag("-no-reboot")
 	q.addOption("-cpu", "max")
-	q.addOption("-vga", "none")
 
-	if rconfig.CPUs > 0 {
-		q.addOption("-smp", strconv.Itoa(rconfig.CPUs))
+	if smp := smpOption(rconfig); smp != "" {
+		q.addOption("-smp", smp)
+	}
+
+	if backend := memoryBackend(rconfig); backend != "" {
+		q.addOption("-object", backend)
+		q.addOption("-numa", "node,memdev=mem0")
+	}
+
+	if rconfig.MemoryBalloon {
+		q.addOption("-device", "virtio-balloon-pci,bus=pci.1,addr=0x0,id=balloon0")
 	}
 
 	// we could perhaps cascade for different versions of qemu here but
@@ -513,21 +760,68 @@ func (q *qemu) setConfig(rconfig *RunConfig) {
 	q.addOption("-device", "isa-debug-exit")
 	q.addOption("-m", rconfig.Memory)
 
-	if rconfig.GdbPort > 0 {
-		gdbProtoStr := fmt.Sprintf("tcp::%d", rconfig.GdbPort)
-		q.addOption("-gdb", gdbProtoStr)
+	// the gdb stub is started on GdbPort, debug runs also wait for gdb to
+	// attach before booting
+	if rconfig.GdbPort > 0 || rconfig.Debug {
+		gdbPort := rconfig.GdbPort
+		if gdbPort == 0 {
+			gdbPort = DefaultGdbPort
+		}
+		q.addOption("-gdb", fmt.Sprintf("tcp::%d", gdbPort))
 	}
 
 	if rconfig.Debug {
-		gdbPort := "1234"
-		if rconfig.GdbPort != 0 {
-			gdbPort = strconv.Itoa(rconfig.GdbPort)
-		}
+		q.addFlag("-S")
+	}
+}
+
+// hugePagesPath is where hugetlbfs is usually mounted
+const hugePagesPath = "/dev/hugepages"
+
+// memoryBackend returns the -object value of the guest memory backend, or ""
+// when the default backend is enough
+func memoryBackend(rconfig *RunConfig) string {
+	if !rconfig.HugePages && rconfig.NUMANodes == "" {
+		return ""
+	}
 
-		q.addOption("-s", "-S")
-		fmt.Printf("Waiting for gdb connection. Connect to qemu through \"(gdb) target remote localhost:%s\"\n", gdbPort)
-		fmt.Println("See further instructions in https://nanovms.gitbook.io/ops/debugging")
+	backend := "memory-backend-ram,id=mem0,size=" + rconfig.Memory
+	if rconfig.HugePages {
+		backend = "memory-backend-file,id=mem0,size=" + rconfig.Memory + ",mem-path=" + hugePagesPath + ",share=on,prealloc=on"
 	}
+	if rconfig.NUMANodes != "" {
+		backend += ",host-nodes=" + rconfig.NUMANodes + ",policy=bind"
+	}
+	return backend
+}
+
+// smpOption returns the -smp value for the CPU count and topology of rconfig
+func smpOption(rconfig *RunConfig) string {
+	topology := rconfig.Sockets > 0 || rconfig.Cores > 0 || rconfig.Threads > 0
+	if rconfig.CPUs <= 0 && !topology {
+		return ""
+	}
+
+	cpus := rconfig.CPUs
+	if !topology {
+		return strconv.Itoa(cpus)
+	}
+
+	sockets, cores, threads := rconfig.Sockets, rconfig.Cores, rconfig.Threads
+	if sockets <= 0 {
+		sockets = 1
+	}
+	if cores <= 0 {
+		cores = 1
+	}
+	if threads <= 0 {
+		threads = 1
+	}
+	if cpus < sockets*cores*threads {
+		cpus = sockets * cores * threads
+	}
+
+	return fmt.Sprintf("%d,sockets=%d,cores=%d,threads=%d", cpus, sockets, cores, threads)
 }
 
 func (q *qemu) isInstalled() bool {
@@ -574,7 +868,14 @@ func (q *qemu) Args(rconfig *RunConfig) []string {
 	args = append(args, q.serial.String())
 
 	// The returned args must tokenized by whitespace
-	return strings.Fields(strings.Join(args, " "))
+	args = strings.Fields(strings.Join(args, " "))
+
+	// the restore command is run by sh and may contain whitespace
+	if rconfig.RestoreState != "" {
+		args = append(args, "-incoming", "exec:cat "+shellQuote(rconfig.RestoreState))
+	}
+
+	return args
 }
 
 func newQemu() Hypervisor {
@@ -5,6 +5,7 @@ package lepton
 import (
 	. "fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -49,6 +50,17 @@ func TestStringNetDevWithHostPortForwarding(t *testing.T) {
 	checkQemuString(testNetDev, expected, t)
 }
 
+func TestNetPerformance(t *testing.T) {
+	q := qemu{}
+	q.addNetDevice("tap", "tap0", "", nil, false)
+	q.setNetPerformance(&RunConfig{NetQueues: 4, VHost: true})
+
+	checkQemuString(q.ifaces[0], "-netdev tap,id=n0,ifname=tap0,script=no,downscript=no,queues=4,vhost=on", t)
+
+	q.devices[0].mac = "7e:b8:7e:87:4a:ea"
+	checkQemuString(q.devices[0], "-device virtio-net,bus=pci.3,addr=0x0,netdev=n0,mac=7e:b8:7e:87:4a:ea,mq=on,vectors=10", t)
+}
+
 func TestStringNetDevWithTypeUser(t *testing.T) {
 	// The 'downscript' and 'script' parameters are not valid for 'user'
 	// device type so we don't render them to the string in that case even
@@ -65,6 +77,22 @@ func TestStringDisplay(t *testing.T) {
 	checkQemuString(testDisplay, expected, t)
 }
 
+func TestSetDisplay(t *testing.T) {
+	q := qemu{}
+	q.setDisplay(&RunConfig{})
+	checkQemuString(q.display, "-display none", t)
+	if got := strings.Join(q.flags, " "); got != "-vga none" {
+		t.Errorf("got %q", got)
+	}
+
+	q = qemu{}
+	q.setDisplay(&RunConfig{VNCPort: 5901})
+	checkQemuString(q.display, "-display none", t)
+	if got := strings.Join(q.flags, " "); got != "-vnc 127.0.0.1:1 -vga std" {
+		t.Errorf("got %q", got)
+	}
+}
+
 func TestStringSerial(t *testing.T) {
 	testSerial := &serial{serialtype: "stdio"}
 	expected := "-serial stdio"
@@ -173,6 +201,54 @@ func TestVersionCompareMalformat(t *testing.T) {
 	}
 }
 
+func TestSetAccelDisabled(t *testing.T) {
+	q := qemu{}
+	q.setAccel(&RunConfig{Accel: false})
+
+	if q.Report().Accelerator != "tcg" {
+		t.Errorf("got accelerator %q, want tcg", q.Report().Accelerator)
+	}
+	if len(q.flags) != 0 {
+		t.Errorf("expected no accelerator flags, got %v", q.flags)
+	}
+}
+
+func TestSmpOption(t *testing.T) {
+	tests := []struct {
+		rconfig  RunConfig
+		expected string
+	}{
+		{RunConfig{}, ""},
+		{RunConfig{CPUs: 2}, "2"},
+		{RunConfig{CPUs: 1, Sockets: 2, Cores: 2}, "4,sockets=2,cores=2,threads=1"},
+		{RunConfig{CPUs: 8, Cores: 4, Threads: 2}, "8,sockets=1,cores=4,threads=2"},
+	}
+
+	for _, tt := range tests {
+		if got := smpOption(&tt.rconfig); got != tt.expected {
+			t.Errorf("smpOption(%+v) = %q, want %q", tt.rconfig, got, tt.expected)
+		}
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	tests := []struct {
+		rconfig  RunConfig
+		expected string
+	}{
+		{RunConfig{Memory: "2G"}, ""},
+		{RunConfig{Memory: "2G", NUMANodes: "0"}, "memory-backend-ram,id=mem0,size=2G,host-nodes=0,policy=bind"},
+		{RunConfig{Memory: "2G", HugePages: true}, "memory-backend-file,id=mem0,size=2G,mem-path=/dev/hugepages,share=on,prealloc=on"},
+		{RunConfig{Memory: "1G", HugePages: true, NUMANodes: "0-1"}, "memory-backend-file,id=mem0,size=1G,mem-path=/dev/hugepages,share=on,prealloc=on,host-nodes=0-1,policy=bind"},
+	}
+
+	for _, tt := range tests {
+		if got := memoryBackend(&tt.rconfig); got != tt.expected {
+			t.Errorf("memoryBackend(%+v) = %q, want %q", tt.rconfig, got, tt.expected)
+		}
+	}
+}
+
 func checkQemuString(qr Stringer, expected string, t *testing.T) {
 	actual := qr.String()
 	if expected != actual {
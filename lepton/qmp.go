@@ -0,0 +1,246 @@
+package lepton
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// qemuShutdownTimeout is how long to wait for a guest to power down before
+// killing qemu
+const qemuShutdownTimeout = 30 * time.Second
+
+// qmpTimeout bounds connecting to the QMP socket and waiting for replies
+const qmpTimeout = 5 * time.Second
+
+// qmpMessage is a greeting, reply or event read from QMP
+type qmpMessage struct {
+	Greeting json.RawMessage `json:"QMP"`
+	Return   json.RawMessage `json:"return"`
+	Event    string          `json:"event"`
+	Error    *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// qmpClient talks to the QEMU machine protocol on a unix socket
+type qmpClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+// dialQMP connects to the QMP socket at path and negotiates capabilities
+func dialQMP(path string) (*qmpClient, error) {
+	conn, err := net.DialTimeout("unix", path, qmpTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &qmpClient{conn: conn, dec: json.NewDecoder(conn)}
+
+	conn.SetDeadline(time.Now().Add(qmpTimeout))
+	var greeting qmpMessage
+	if err := c.dec.Decode(&greeting); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if greeting.Greeting == nil {
+		conn.Close()
+		return nil, errors.New("qmp: missing greeting")
+	}
+
+	if err := c.execute("qmp_capabilities"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// execute runs a QMP command without arguments and waits for its reply
+func (c *qmpClient) execute(command string) error {
+	return c.call(command, nil, nil)
+}
+
+// call runs a QMP command with args and decodes its reply into result,
+// skipping events received in between. Nil args and result are omitted.
+func (c *qmpClient) call(command string, args, result interface{}) error {
+	c.conn.SetDeadline(time.Now().Add(qmpTimeout))
+
+	req := map[string]interface{}{"execute": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return err
+	}
+
+	for {
+		var msg qmpMessage
+		if err := c.dec.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("qmp: %s: %s", command, msg.Error.Desc)
+		}
+		if msg.Return != nil {
+			if result != nil {
+				return json.Unmarshal(msg.Return, result)
+			}
+			return nil
+		}
+	}
+}
+
+// waitForExit waits for QEMU to close the connection, which it does when it
+// exits
+func (c *qmpClient) waitForExit(timeout time.Duration) error {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	for {
+		var msg qmpMessage
+		err := c.dec.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *qmpClient) Close() error {
+	return c.conn.Close()
+}
+
+// qmpExecute connects to the QMP socket at path and runs command
+func qmpExecute(path, command string) error {
+	c, err := dialQMP(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.execute(command)
+}
+
+// qmpPowerdown asks the guest at the QMP socket path to power down and waits
+// up to timeout for QEMU to exit
+func qmpPowerdown(path string, timeout time.Duration) error {
+	c, err := dialQMP(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.execute("system_powerdown"); err != nil {
+		return err
+	}
+
+	return c.waitForExit(timeout)
+}
+
+// qmpSaveState pauses the guest at the QMP socket path, saves its state to
+// file and quits qemu. The state restores with qemu -incoming.
+func qmpSaveState(path, file string) error {
+	c, err := dialQMP(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.execute("stop"); err != nil {
+		return err
+	}
+
+	uri := "exec:cat > " + shellQuote(file)
+	if err := c.call("migrate", map[string]string{"uri": uri}, nil); err != nil {
+		c.execute("cont")
+		return err
+	}
+
+	for {
+		var status struct {
+			Status    string `json:"status"`
+			ErrorDesc string `json:"error-desc"`
+		}
+		if err := c.call("query-migrate", nil, &status); err != nil {
+			return err
+		}
+
+		switch status.Status {
+		case "completed":
+			if err := c.execute("quit"); err != nil && err != io.EOF {
+				return err
+			}
+			return nil
+		case "failed", "cancelled":
+			c.execute("cont")
+			return fmt.Errorf("saving state to %s %s: %s", file, status.Status, status.ErrorDesc)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// shellQuote quotes s for sh, which qemu runs exec: migration commands with
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// qmpVCPUThreads returns the host thread ids of the vCPUs of the qemu at the
+// QMP socket path, indexed by vCPU, waiting for qemu to create the socket
+func qmpVCPUThreads(path string) ([]int, error) {
+	var (
+		c   *qmpClient
+		err error
+	)
+	for start := time.Now(); time.Since(start) < qmpTimeout; time.Sleep(50 * time.Millisecond) {
+		if c, err = dialQMP(path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var cpus []struct {
+		CPUIndex int `json:"cpu-index"`
+		ThreadID int `json:"thread-id"`
+	}
+	if err := c.call("query-cpus-fast", nil, &cpus); err != nil {
+		return nil, err
+	}
+
+	threads := make([]int, len(cpus))
+	for _, cpu := range cpus {
+		if cpu.CPUIndex < 0 || cpu.CPUIndex >= len(cpus) {
+			return nil, fmt.Errorf("qmp: unexpected cpu index %d", cpu.CPUIndex)
+		}
+		threads[cpu.CPUIndex] = cpu.ThreadID
+	}
+	return threads, nil
+}
+
+// qmpScreendump writes the display of the guest at the QMP socket path to
+// file, as png when file ends with .png and ppm otherwise. The guest needs a
+// vga device, which runs with a Display or a VNCPort have.
+func qmpScreendump(path, file string) error {
+	c, err := dialQMP(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	args := map[string]string{"filename": file}
+	if strings.EqualFold(filepath.Ext(file), ".png") {
+		args["format"] = "png"
+	}
+	return c.call("screendump", args, nil)
+}
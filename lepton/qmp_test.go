@@ -0,0 +1,196 @@
+package lepton
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeQMP serves a single QMP connection, replying to commands with the
+// replies set for them and closing the connection on system_powerdown and
+// quit
+func fakeQMP(t *testing.T, dir string, replies map[string]string) (string, <-chan string) {
+	path := filepath.Join(dir, "qmp.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan string, 10)
+	go func() {
+		defer l.Close()
+		defer close(commands)
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(`{"QMP": {"version": {}, "capabilities": []}}` + "\n"))
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var cmd struct {
+				Execute string `json:"execute"`
+			}
+			json.Unmarshal(scanner.Bytes(), &cmd)
+			commands <- cmd.Execute
+
+			reply, ok := replies[cmd.Execute]
+			if !ok {
+				reply = `{"return": {}}`
+			}
+			conn.Write([]byte(`{"event": "RESUME", "timestamp": {}}` + "\n" + reply + "\n"))
+
+			if cmd.Execute == "system_powerdown" || cmd.Execute == "quit" {
+				return
+			}
+		}
+	}()
+
+	return path, commands
+}
+
+func TestQMPExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, commands := fakeQMP(t, dir, nil)
+
+	if err := qmpExecute(path, "system_reset"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"qmp_capabilities", "system_reset"} {
+		if cmd := <-commands; cmd != expected {
+			t.Errorf("got command %q, want %q", cmd, expected)
+		}
+	}
+}
+
+func TestQMPExecuteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, _ := fakeQMP(t, dir, map[string]string{
+		"stop": `{"error": {"class": "GenericError", "desc": "not running"}}`,
+	})
+
+	err = qmpExecute(path, "stop")
+	if err == nil || err.Error() != "qmp: stop: not running" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestQMPPowerdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, _ := fakeQMP(t, dir, nil)
+
+	if err := qmpPowerdown(path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQMPSaveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, commands := fakeQMP(t, dir, map[string]string{
+		"query-migrate": `{"return": {"status": "completed"}}`,
+	})
+
+	if err := qmpSaveState(path, "/tmp/state"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"qmp_capabilities", "stop", "migrate", "query-migrate", "quit"} {
+		if cmd := <-commands; cmd != expected {
+			t.Errorf("got command %q, want %q", cmd, expected)
+		}
+	}
+}
+
+func TestQMPSaveStateFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, _ := fakeQMP(t, dir, map[string]string{
+		"query-migrate": `{"return": {"status": "failed", "error-desc": "disk full"}}`,
+	})
+
+	err = qmpSaveState(path, "/tmp/state")
+	if err == nil || err.Error() != "saving state to /tmp/state failed: disk full" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestQMPScreendump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, commands := fakeQMP(t, dir, map[string]string{
+		"screendump": `{"error": {"class": "GenericError", "desc": "no surface"}}`,
+	})
+
+	err = qmpScreendump(path, "/tmp/screen.png")
+	if err == nil || err.Error() != "qmp: screendump: no surface" {
+		t.Errorf("got %v", err)
+	}
+
+	for _, expected := range []string{"qmp_capabilities", "screendump"} {
+		if cmd := <-commands; cmd != expected {
+			t.Errorf("got command %q, want %q", cmd, expected)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("it's here"); got != `'it'\''s here'` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestQMPVCPUThreads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, _ := fakeQMP(t, dir, map[string]string{
+		"query-cpus-fast": `{"return": [{"cpu-index": 1, "thread-id": 102}, {"cpu-index": 0, "thread-id": 101}]}`,
+	})
+
+	threads, err := qmpVCPUThreads(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 || threads[0] != 101 || threads[1] != 102 {
+		t.Errorf("got %v", threads)
+	}
+}
@@ -0,0 +1,330 @@
+package lepton
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ImageRef names an image of a registry by name and either a tag or the
+// hash of the manifest it was built from
+type ImageRef struct {
+	Name         string
+	Tag          string
+	ManifestHash string
+}
+
+var (
+	imageNameRegexp    = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	imageTagRegexp     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	manifestHashRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// ParseImageRef parses references like "app", "app:1.0" or "app@<manifest
+// sha256>", the tag defaults to latest
+func ParseImageRef(s string) (ImageRef, error) {
+	ref := ImageRef{Name: s, Tag: "latest"}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		ref = ImageRef{Name: s[:i], ManifestHash: strings.TrimPrefix(s[i+1:], "sha256:")}
+		if !manifestHashRegexp.MatchString(ref.ManifestHash) {
+			return ref, fmt.Errorf("invalid manifest hash in image reference %q", s)
+		}
+	} else if i := strings.LastIndex(s, ":"); i >= 0 && !strings.Contains(s[i:], "/") {
+		ref = ImageRef{Name: s[:i], Tag: s[i+1:]}
+		if !imageTagRegexp.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("invalid tag in image reference %q", s)
+		}
+	}
+	if !imageNameRegexp.MatchString(ref.Name) {
+		return ref, fmt.Errorf("invalid image name in image reference %q", s)
+	}
+	return ref, nil
+}
+
+func (r ImageRef) String() string {
+	if r.ManifestHash != "" {
+		return r.Name + "@sha256:" + r.ManifestHash
+	}
+	return r.Name + ":" + r.Tag
+}
+
+// RegistryFiles are the files of a build stored in a registry
+type RegistryFiles struct {
+	// Image is the image file
+	Image string
+
+	// Manifest is the manifest the image was built from, written with
+	// Config.ManifestName. The image can be pulled by its hash.
+	Manifest string
+
+	// Report is the build report of the image, written with
+	// Config.ReportName
+	Report string
+}
+
+// pulledRegistryFiles returns the files an image pulled to imagePath is
+// written to, its manifest and build report are written next to it
+func pulledRegistryFiles(imagePath string) RegistryFiles {
+	return RegistryFiles{
+		Image:    imagePath,
+		Manifest: imagePath + ".manifest",
+		Report:   imagePath + ".report.json",
+	}
+}
+
+// RegistryImage describes an image stored in a registry
+type RegistryImage struct {
+	Name string
+	Tag  string
+
+	// Digest is the sha256 of the image file
+	Digest string
+
+	// ManifestHash is the sha256 of the manifest the image was built from,
+	// when it was pushed with it
+	ManifestHash string
+
+	// ReportDigest is the sha256 of the build report of the image, when it
+	// was pushed with it
+	ReportDigest string
+
+	Size   int64
+	Pushed time.Time
+}
+
+// Registry stores built images to share them, like container images
+type Registry interface {
+	// Push stores the files of a build as ref, tagged with ref.Tag and
+	// findable by the hash of the manifest when there is one
+	Push(ref ImageRef, files RegistryFiles) (*RegistryImage, error)
+
+	// Pull writes the image of ref to imagePath, and its manifest and build
+	// report next to it with the .manifest and .report.json extensions,
+	// checking their digests
+	Pull(ref ImageRef, imagePath string) (*RegistryImage, error)
+}
+
+// NewRegistry returns the registry at location, a local directory, a
+// bucket like s3://bucket/prefix or gs://bucket/prefix, or an OCI registry
+// like oci://registry.example.com/team
+func NewRegistry(location string, c *Config) (Registry, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// a plain or windows path
+		return &storeRegistry{store: dirStore(location)}, nil
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return &storeRegistry{store: dirStore(u.Path)}, nil
+	case "s3":
+		return &storeRegistry{store: &s3Store{bucket: u.Host, prefix: prefix, region: c.CloudConfig.Zone}}, nil
+	case "gs":
+		return &storeRegistry{store: &gcsStore{bucket: u.Host, prefix: prefix}}, nil
+	case "oci":
+		return &ociRegistry{host: u.Host, prefix: prefix}, nil
+	}
+	return nil, fmt.Errorf("unsupported registry %q, expected a directory, s3://, gs:// or oci://", location)
+}
+
+// registryStore is the storage of a storeRegistry, objects are named by
+// slash separated keys
+type registryStore interface {
+	put(key string, r io.Reader) error
+	get(key string) (io.ReadCloser, error)
+}
+
+// storeRegistry keeps images in a registryStore as
+//
+//	<name>/blobs/sha256-<digest>       the images, manifests and reports
+//	<name>/tags/<tag>.json             the RegistryImage of tags
+//	<name>/manifests/<hash>.json       the RegistryImage of manifest hashes
+type storeRegistry struct {
+	store registryStore
+}
+
+func (r *storeRegistry) Push(ref ImageRef, files RegistryFiles) (*RegistryImage, error) {
+	image, err := newRegistryImage(ref, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range image.blobs(files) {
+		f, err := os.Open(blob.path)
+		if err != nil {
+			return nil, err
+		}
+		err = r.store.put(path.Join(ref.Name, "blobs", "sha256-"+blob.digest), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(image, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{path.Join(ref.Name, "tags", image.Tag+".json")}
+	if image.ManifestHash != "" {
+		keys = append(keys, path.Join(ref.Name, "manifests", image.ManifestHash+".json"))
+	}
+	for _, key := range keys {
+		if err := r.store.put(key, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+func (r *storeRegistry) Pull(ref ImageRef, imagePath string) (*RegistryImage, error) {
+	key := path.Join(ref.Name, "tags", ref.Tag+".json")
+	if ref.ManifestHash != "" {
+		key = path.Join(ref.Name, "manifests", ref.ManifestHash+".json")
+	}
+	rc, err := r.store.get(key)
+	if err != nil {
+		return nil, fmt.Errorf("image %s not found: %v", ref, err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	image := &RegistryImage{}
+	if err := json.Unmarshal(data, image); err != nil {
+		return nil, fmt.Errorf("image %s: %v", ref, err)
+	}
+
+	for _, blob := range image.blobs(pulledRegistryFiles(imagePath)) {
+		rc, err := r.store.get(path.Join(ref.Name, "blobs", "sha256-"+blob.digest))
+		if err != nil {
+			return nil, err
+		}
+		err = writeRegistryBlob(blob, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %v", ref, err)
+		}
+	}
+	return image, nil
+}
+
+// registryBlob is a file of an image in a registry
+type registryBlob struct {
+	path   string
+	digest string
+}
+
+// blobs returns the files of image, at the paths of files
+func (image *RegistryImage) blobs(files RegistryFiles) []registryBlob {
+	blobs := []registryBlob{{path: files.Image, digest: image.Digest}}
+	if image.ManifestHash != "" {
+		blobs = append(blobs, registryBlob{path: files.Manifest, digest: image.ManifestHash})
+	}
+	if image.ReportDigest != "" {
+		blobs = append(blobs, registryBlob{path: files.Report, digest: image.ReportDigest})
+	}
+	return blobs
+}
+
+// newRegistryImage describes the files of a build pushed as ref
+func newRegistryImage(ref ImageRef, files RegistryFiles) (*RegistryImage, error) {
+	if ref.Tag == "" {
+		return nil, fmt.Errorf("images are pushed with a tag")
+	}
+
+	image := &RegistryImage{Name: ref.Name, Tag: ref.Tag, Pushed: time.Now().UTC()}
+	var err error
+	if image.Digest, image.Size, err = fileSHA256(files.Image); err != nil {
+		return nil, err
+	}
+	if files.Manifest != "" {
+		if image.ManifestHash, _, err = fileSHA256(files.Manifest); err != nil {
+			return nil, err
+		}
+	}
+	if files.Report != "" {
+		if image.ReportDigest, _, err = fileSHA256(files.Report); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+// fileSHA256 returns the hex sha256 and the size of the file at path
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	digest, err := readerSHA256(f)
+	return digest, fi.Size(), err
+}
+
+// writeRegistryBlob writes the content of blob read from r to its path,
+// through a temporary file renamed once its digest was checked
+func writeRegistryBlob(blob registryBlob, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(blob.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(blob.path), filepath.Base(blob.path)+".pull")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	digest, err := readerSHA256(io.TeeReader(r, tmp))
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if digest != blob.digest {
+		return fmt.Errorf("%s has digest %s, expected %s", filepath.Base(blob.path), digest, blob.digest)
+	}
+	return os.Rename(tmp.Name(), blob.path)
+}
+
+// dirStore is a registryStore in a local directory
+type dirStore string
+
+func (d dirStore) put(key string, r io.Reader) error {
+	p := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (d dirStore) get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(key)))
+}
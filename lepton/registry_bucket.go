@@ -0,0 +1,102 @@
+package lepton
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	storage "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Store is a registryStore in an s3 bucket
+type s3Store struct {
+	bucket string
+	prefix string
+	region string
+}
+
+func (s *s3Store) session() (*session.Session, error) {
+	return session.NewSession(&aws.Config{Region: aws.String(s.region)})
+}
+
+func (s *s3Store) put(key string, r io.Reader) error {
+	sess, err := s.session()
+	if err != nil {
+		return err
+	}
+	_, err = s3manager.NewUploader(sess).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *s3Store) get(key string) (io.ReadCloser, error) {
+	sess, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+	out, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// gcsStore is a registryStore in a gcs bucket
+type gcsStore struct {
+	bucket string
+	prefix string
+}
+
+func (s *gcsStore) put(key string, r io.Reader) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("%v, have you set GOOGLE_APPLICATION_CREDENTIALS?", err)
+	}
+	defer client.Close()
+
+	w := client.Bucket(s.bucket).Object(path.Join(s.prefix, key)).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *gcsStore) get(key string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%v, have you set GOOGLE_APPLICATION_CREDENTIALS?", err)
+	}
+
+	r, err := client.Bucket(s.bucket).Object(path.Join(s.prefix, key)).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &gcsReader{Reader: r, client: client}, nil
+}
+
+// gcsReader closes the client of the object it reads with it
+type gcsReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r *gcsReader) Close() error {
+	err := r.Reader.Close()
+	r.client.Close()
+	return err
+}
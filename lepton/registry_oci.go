@@ -0,0 +1,398 @@
+package lepton
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.nanovms.ops.image.config.v1+json"
+
+	// ociManifestHashAnnotation is the annotation of the manifest hash of
+	// images, they are also tagged mh-<hash> to be pulled by it
+	ociManifestHashAnnotation = "com.nanovms.ops.manifest-hash"
+	ociTitleAnnotation        = "org.opencontainers.image.title"
+	ociCreatedAnnotation      = "org.opencontainers.image.created"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociRegistry stores images as artifacts of an OCI registry, with a layer
+// for the image, its manifest and its build report, which oras can pull
+// too. Credentials are read from OPS_REGISTRY_USERNAME
+// and OPS_REGISTRY_PASSWORD.
+type ociRegistry struct {
+	host   string
+	prefix string
+
+	// insecure uses http instead of https
+	insecure bool
+
+	client *http.Client
+	token  string
+}
+
+// ociLayer is the media type and title extension of a file of a build
+type ociLayer struct {
+	mediaType string
+	ext       string
+}
+
+var (
+	ociImageLayer    = ociLayer{"application/vnd.nanovms.ops.image.v1.raw", ".img"}
+	ociManifestLayer = ociLayer{"application/vnd.nanovms.ops.manifest.v1", ".manifest"}
+	ociReportLayer   = ociLayer{"application/vnd.nanovms.ops.build-report.v1+json", ".report.json"}
+)
+
+// ociLayers returns the layers of the blobs of image, in the order of
+// RegistryImage.blobs
+func ociLayers(image *RegistryImage) []ociLayer {
+	layers := []ociLayer{ociImageLayer}
+	if image.ManifestHash != "" {
+		layers = append(layers, ociManifestLayer)
+	}
+	if image.ReportDigest != "" {
+		layers = append(layers, ociReportLayer)
+	}
+	return layers
+}
+
+func (r *ociRegistry) Push(ref ImageRef, files RegistryFiles) (*RegistryImage, error) {
+	image, err := newRegistryImage(ref, files)
+	if err != nil {
+		return nil, err
+	}
+	repo := path.Join(r.prefix, ref.Name)
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Annotations:   map[string]string{ociCreatedAnnotation: image.Pushed.Format(time.RFC3339)},
+	}
+	layers := ociLayers(image)
+	for i, blob := range image.blobs(files) {
+		f, err := os.Open(blob.path)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		desc := ociDescriptor{
+			MediaType:   layers[i].mediaType,
+			Digest:      "sha256:" + blob.digest,
+			Size:        fi.Size(),
+			Annotations: map[string]string{ociTitleAnnotation: path.Base(ref.Name) + layers[i].ext},
+		}
+		err = r.pushBlob(repo, desc, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	config := []byte("{}")
+	manifest.Config = ociDescriptor{
+		MediaType: ociConfigMediaType,
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(config)),
+		Size:      int64(len(config)),
+	}
+	if err := r.pushBlob(repo, manifest.Config, bytes.NewReader(config)); err != nil {
+		return nil, err
+	}
+
+	tags := []string{ref.Tag}
+	if image.ManifestHash != "" {
+		manifest.Annotations[ociManifestHashAnnotation] = image.ManifestHash
+		tags = append(tags, "mh-"+image.ManifestHash)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		resp, err := r.do("PUT", repo, "/manifests/"+tag, bytes.NewReader(data), int64(len(data)), ociManifestMediaType)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return nil, fmt.Errorf("pushing manifest of %s: %s", ref, resp.Status)
+		}
+	}
+	return image, nil
+}
+
+func (r *ociRegistry) Pull(ref ImageRef, imagePath string) (*RegistryImage, error) {
+	repo := path.Join(r.prefix, ref.Name)
+	tag := ref.Tag
+	if ref.ManifestHash != "" {
+		tag = "mh-" + ref.ManifestHash
+	}
+
+	resp, err := r.do("GET", repo, "/manifests/"+tag, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image %s not found: %s", ref, resp.Status)
+	}
+	manifest := ociManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("image %s: %v", ref, err)
+	}
+
+	image := &RegistryImage{Name: ref.Name, Tag: ref.Tag}
+	image.Pushed, _ = time.Parse(time.RFC3339, manifest.Annotations[ociCreatedAnnotation])
+	for _, layer := range manifest.Layers {
+		digest := strings.TrimPrefix(layer.Digest, "sha256:")
+		switch layer.MediaType {
+		case ociImageLayer.mediaType:
+			image.Digest, image.Size = digest, layer.Size
+		case ociManifestLayer.mediaType:
+			image.ManifestHash = digest
+		case ociReportLayer.mediaType:
+			image.ReportDigest = digest
+		}
+	}
+	if image.Digest == "" {
+		return nil, fmt.Errorf("%s is not an ops image", ref)
+	}
+
+	for _, blob := range image.blobs(pulledRegistryFiles(imagePath)) {
+		resp, err := r.do("GET", repo, "/blobs/sha256:"+blob.digest, nil, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("pulling %s: %s", ref, resp.Status)
+		}
+		err = writeRegistryBlob(blob, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %v", ref, err)
+		}
+	}
+	return image, nil
+}
+
+// pushBlob uploads the blob of desc read from body, unless the registry has
+// it already
+func (r *ociRegistry) pushBlob(repo string, desc ociDescriptor, body io.Reader) error {
+	resp, err := r.do("HEAD", repo, "/blobs/"+desc.Digest, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = r.do("POST", repo, "/blobs/uploads/", nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("starting upload of %s: %s", desc.Digest, resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = r.request("PUT", location.String(), body, desc.Size, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("uploading %s: %s", desc.Digest, resp.Status)
+	}
+	return nil
+}
+
+// do sends a request to the endpoint of repo, like /manifests/latest
+func (r *ociRegistry) do(method, repo, endpoint string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	scheme := "https"
+	if r.insecure {
+		scheme = "http"
+	}
+	return r.request(method, fmt.Sprintf("%s://%s/v2/%s%s", scheme, r.host, repo, endpoint), body, size, contentType)
+}
+
+// request sends a request authenticated as the registry asks, only bodies
+// that can be read again are sent once more after an authentication
+// challenge
+func (r *ociRegistry) request(method, u string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	if r.client == nil {
+		r.client = &http.Client{}
+	}
+
+	send := func() (*http.Response, error) {
+		// the transport closes request bodies, the caller closes its files
+		var rc io.ReadCloser
+		if body != nil {
+			rc = ioutil.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, u, rc)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if strings.Contains(u, "/manifests/") {
+			req.Header.Set("Accept", ociManifestMediaType)
+		}
+		r.authorize(req)
+		return r.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if err := r.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	if seeker, ok := body.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if body != nil {
+		return nil, fmt.Errorf("registry %s asked to authenticate again", r.host)
+	}
+	return send()
+}
+
+func (r *ociRegistry) authorize(req *http.Request) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	} else if user := os.Getenv("OPS_REGISTRY_USERNAME"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("OPS_REGISTRY_PASSWORD"))
+	}
+}
+
+// authenticate gets the token of a bearer challenge, basic challenges are
+// answered by authorize with the credentials of the environment
+func (r *ociRegistry) authenticate(challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		if os.Getenv("OPS_REGISTRY_USERNAME") == "" {
+			return fmt.Errorf("registry %s needs credentials, set OPS_REGISTRY_USERNAME and OPS_REGISTRY_PASSWORD", r.host)
+		}
+		return fmt.Errorf("registry %s refused the credentials of OPS_REGISTRY_USERNAME", r.host)
+	}
+
+	params := parseAuthParams(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("registry %s sent an invalid challenge %q", r.host, challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if user := os.Getenv("OPS_REGISTRY_USERNAME"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("OPS_REGISTRY_PASSWORD"))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("authenticating to registry %s: %s", r.host, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
+
+// parseAuthParams parses the comma separated key="value" parameters of an
+// authentication challenge
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params
+}
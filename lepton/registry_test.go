@@ -0,0 +1,238 @@
+package lepton
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseImageRef(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		s    string
+		want ImageRef
+	}{
+		{"app", ImageRef{Name: "app", Tag: "latest"}},
+		{"team/app:1.0", ImageRef{Name: "team/app", Tag: "1.0"}},
+		{"app@sha256:" + hash, ImageRef{Name: "app", ManifestHash: hash}},
+		{"app@" + hash, ImageRef{Name: "app", ManifestHash: hash}},
+	}
+	for _, tt := range tests {
+		got, err := ParseImageRef(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseImageRef(%q) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"../app", "App", "app:", "app@1234", "/app", "localhost:5000/app"} {
+		if _, err := ParseImageRef(s); err == nil {
+			t.Errorf("ParseImageRef(%q) should fail", s)
+		}
+	}
+}
+
+func testRegistryRoundTrip(t *testing.T, r Registry) {
+	dir, err := ioutil.TempDir("", "registry-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := RegistryFiles{
+		Image:    filepath.Join(dir, "app.img"),
+		Manifest: filepath.Join(dir, "app.manifest"),
+		Report:   filepath.Join(dir, "report.json"),
+	}
+	content := map[string]string{files.Image: "image content", files.Manifest: "manifest", files.Report: "{}"}
+	for path, data := range content {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("manifest")))
+
+	ref, _ := ParseImageRef("team/app:1.0")
+	pushed, err := r.Push(ref, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Digest != fmt.Sprintf("%x", sha256.Sum256([]byte("image content"))) || pushed.Size != 13 || pushed.ManifestHash != hash {
+		t.Errorf("unexpected pushed image %+v", pushed)
+	}
+
+	for _, s := range []string{"team/app:1.0", "team/app@" + hash} {
+		ref, _ := ParseImageRef(s)
+		pulledPath := filepath.Join(dir, "pulled", "app.img")
+		pulled, err := r.Pull(ref, pulledPath)
+		if err != nil {
+			t.Fatalf("pulling %s: %v", s, err)
+		}
+		if pulled.Digest != pushed.Digest || pulled.ManifestHash != hash || pulled.ReportDigest != pushed.ReportDigest {
+			t.Errorf("pulled %+v, pushed %+v", pulled, pushed)
+		}
+		pulledFiles := pulledRegistryFiles(pulledPath)
+		for path, want := range map[string]string{pulledFiles.Image: "image content", pulledFiles.Manifest: "manifest", pulledFiles.Report: "{}"} {
+			data, err := ioutil.ReadFile(path)
+			if err != nil || string(data) != want {
+				t.Errorf("pulled %s %q, %v", filepath.Base(path), data, err)
+			}
+		}
+		os.RemoveAll(filepath.Dir(pulledPath))
+	}
+
+	t.Run("should push images without manifest and report", func(t *testing.T) {
+		ref, _ := ParseImageRef("team/app:bare")
+		if _, err := r.Push(ref, RegistryFiles{Image: files.Image}); err != nil {
+			t.Fatal(err)
+		}
+		pulledPath := filepath.Join(dir, "bare", "app.img")
+		pulled, err := r.Pull(ref, pulledPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pulled.ManifestHash != "" || pulled.ReportDigest != "" {
+			t.Errorf("unexpected pulled image %+v", pulled)
+		}
+		if _, err := os.Stat(pulledPath + ".manifest"); !os.IsNotExist(err) {
+			t.Error("manifest written for an image without")
+		}
+	})
+
+	ref, _ = ParseImageRef("team/app:2.0")
+	if _, err := r.Pull(ref, filepath.Join(dir, "missing.img")); err == nil {
+		t.Error("pulling a missing tag should fail")
+	}
+}
+
+func TestDirRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRegistry(dir, NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRegistryRoundTrip(t, r)
+
+	t.Run("should check the digest of pulled images", func(t *testing.T) {
+		blob := filepath.Join(dir, "team", "app", "blobs", fmt.Sprintf("sha256-%x", sha256.Sum256([]byte("image content"))))
+		if err := ioutil.WriteFile(blob, []byte("corrupted"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ref, _ := ParseImageRef("team/app:1.0")
+		pulledPath := filepath.Join(dir, "pulled.img")
+		if _, err := r.Pull(ref, pulledPath); err == nil {
+			t.Error("pulling a corrupted image should fail")
+		}
+		if _, err := os.Stat(pulledPath); !os.IsNotExist(err) {
+			t.Error("corrupted image written")
+		}
+	})
+}
+
+// fakeOCIRegistry implements the parts of the distribution api ops uses,
+// behind a bearer token
+type fakeOCIRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func (f *fakeOCIRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if r.URL.Query().Get("service") != "fake" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake",scope="repository:app:pull,push"`, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(p, "/blobs/uploads/") && r.Method == "POST":
+		f.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%supload-%d?state=x", p, f.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(p, "/blobs/uploads/") && r.Method == "PUT":
+		digest := r.URL.Query().Get("digest")
+		if r.URL.Query().Get("state") != "x" || digest != fmt.Sprintf("sha256:%x", sha256.Sum256(body)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[digest] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		data, ok := f.blobs[p[strings.LastIndex(p, "/")+1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case strings.Contains(p, "/manifests/") && r.Method == "PUT":
+		f.manifests[p] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/manifests/"):
+		data, ok := f.manifests[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOCIRegistry(t *testing.T) {
+	fake := &fakeOCIRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	r, err := NewRegistry("oci://"+u.Host+"/ops", NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.(*ociRegistry).insecure = true
+	testRegistryRoundTrip(t, r)
+
+	if _, ok := fake.manifests["ops/team/app/manifests/1.0"]; !ok {
+		t.Errorf("manifest not pushed under the prefix, got %v", fake.manifests)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`realm="https://auth.example.com/token",service=registry,scope="repository:a/b:pull,push"`)
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for key, value := range want {
+		if params[key] != value {
+			t.Errorf("%s = %q, want %q", key, params[key], value)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package lepton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy configures how transient provider failures are retried. The
+// AWS clients and the Azure uploads retry with it, the GCP, DigitalOcean,
+// Vultr and OpenStack clients with its Transport.
+type RetryPolicy struct {
+	// Retries is how many times a failed call is retried
+	Retries int
+
+	// InitialDelay is the delay before the first retry, it doubles on every
+	// retry up to MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// maxRetryAfter caps the wait throttling responses ask for
+const maxRetryAfter = time.Minute
+
+// DefaultRetryPolicy is used when the config doesn't set retries
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:      5,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// RetryPolicyFromConfig returns the retry policy of a provider config, a
+// negative Retries disables retries
+func RetryPolicyFromConfig(config *ProviderConfig) RetryPolicy {
+	policy := DefaultRetryPolicy
+	if config.Retries > 0 {
+		policy.Retries = config.Retries
+	} else if config.Retries < 0 {
+		policy.Retries = 0
+	}
+	return policy
+}
+
+// Do calls op until it succeeds, fails with an error that isn't transient or
+// runs out of retries. Throttling responses asking to retry later are
+// honored, up to maxRetryAfter.
+func (rp RetryPolicy) Do(op func() error) error {
+	return rp.doContext(context.Background(), op)
+}
+
+// doContext is Do giving up with the error of ctx when it is done
+func (rp RetryPolicy) doContext(ctx context.Context, op func() error) error {
+	delay := rp.InitialDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= rp.Retries || !IsRetryable(err) {
+			return err
+		}
+
+		wait := delay
+		if after := retryAfter(err); after > wait {
+			wait = after
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+		} else if wait > 0 {
+			// jitter so concurrent callers don't retry in lockstep
+			wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > rp.MaxDelay {
+			delay = rp.MaxDelay
+		}
+	}
+}
+
+// Transport returns a RoundTripper sending requests with base and retrying
+// those failing transiently with rp, for the HTTP clients of the provider
+// SDKs that don't retry, like the GCP and DigitalOcean ones. Only idempotent
+// requests are retried, a retried POST could create an instance or a volume
+// twice, and those with a body only when the body can be read again.
+// Retries stop when the context of the request is done.
+func (rp RetryPolicy) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{policy: rp, base: base}
+}
+
+type retryTransport struct {
+	policy RetryPolicy
+	base   http.RoundTripper
+}
+
+// statusError is a response with a status worth retrying
+type statusError struct {
+	resp *http.Response
+}
+
+func (e *statusError) Error() string {
+	return e.resp.Status
+}
+
+func (e *statusError) Response() *http.Response {
+	return e.resp
+}
+
+// isIdempotent tells whether requests of method can be sent again without
+// changing their result, the empty method is GET
+func isIdempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if !isIdempotent(req.Method) || hasBody && req.GetBody == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	attempt := 0
+	err := t.policy.doContext(req.Context(), func() error {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		r := req
+		if attempt > 0 && hasBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			retry := *req
+			retry.Body = body
+			r = &retry
+		}
+		attempt++
+
+		var err error
+		if resp, err = t.base.RoundTrip(r); err != nil {
+			return err
+		}
+		if serr := (&statusError{resp: resp}); IsRetryable(serr) {
+			return serr
+		}
+		return nil
+	})
+	// the last response is returned as is when retries run out
+	if serr, ok := err.(*statusError); ok {
+		return serr.resp, nil
+	}
+	if err != nil {
+		// the response of the last attempt when the context is done
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// IsRetryable reports whether err is a transient failure, like throttling,
+// a server error or a dropped connection
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded", "SlowDown", "RequestTimeout", "RequestTimeoutException":
+			return true
+		}
+	}
+
+	switch httpStatus(err) {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// httpStatus returns the HTTP status code of a provider SDK error, or 0
+func httpStatus(err error) int {
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) {
+		return rerr.StatusCode()
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code
+	}
+
+	var derr autorest.DetailedError
+	if errors.As(err, &derr) {
+		if code, ok := derr.StatusCode.(int); ok {
+			return code
+		}
+	}
+
+	var serr interface{ Response() *http.Response }
+	if errors.As(err, &serr) && serr.Response() != nil {
+		return serr.Response().StatusCode
+	}
+
+	return 0
+}
+
+// retryAfter returns how long a throttling response asked to wait, or 0
+func retryAfter(err error) time.Duration {
+	var derr autorest.DetailedError
+	if errors.As(err, &derr) && derr.Response != nil {
+		return parseRetryAfter(derr.Response.Header.Get("Retry-After"))
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return parseRetryAfter(gerr.Header.Get("Retry-After"))
+	}
+
+	var serr *statusError
+	if errors.As(err, &serr) {
+		return parseRetryAfter(serr.resp.Header.Get("Retry-After"))
+	}
+
+	return 0
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var seconds int
+	if _, err := fmt.Sscanf(value, "%d", &seconds); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// UploadError is returned when an upload fails part way, with how much was
+// uploaded
+type UploadError struct {
+	Uploaded int64
+	Total    int64
+	Err      error
+}
+
+func (e *UploadError) Error() string {
+	percent := 0.0
+	if e.Total > 0 {
+		percent = float64(e.Uploaded) * 100 / float64(e.Total)
+	}
+	return fmt.Sprintf("upload failed at %.0f%% (%d of %d bytes): %v", percent, e.Uploaded, e.Total, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of failed uploads
+func (e *UploadError) ErrorCode() ErrorCode {
+	return ErrImageUploadFailed
+}
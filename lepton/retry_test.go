@@ -0,0 +1,177 @@
+package lepton
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{Retries: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	throttled := &googleapi.Error{Code: http.StatusTooManyRequests}
+
+	t.Run("should retry transient errors until success", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(func() error {
+			calls++
+			if calls < 3 {
+				return throttled
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("got %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("should give up after the configured retries", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(func() error {
+			calls++
+			return throttled
+		})
+		if err != throttled || calls != 4 {
+			t.Errorf("got %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("should not retry permanent errors", func(t *testing.T) {
+		calls := 0
+		policy.Do(func() error {
+			calls++
+			return &googleapi.Error{Code: http.StatusForbidden}
+		})
+		if calls != 1 {
+			t.Errorf("got %d calls", calls)
+		}
+	})
+
+	t.Run("should honor disabled retries", func(t *testing.T) {
+		if got := RetryPolicyFromConfig(&ProviderConfig{Retries: -1}).Retries; got != 0 {
+			t.Errorf("got %d retries", got)
+		}
+		if got := RetryPolicyFromConfig(&ProviderConfig{}).Retries; got != DefaultRetryPolicy.Retries {
+			t.Errorf("got %d retries", got)
+		}
+	})
+}
+
+func TestRetryTransport(t *testing.T) {
+	var calls int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch r.URL.Path {
+		case "/flaky":
+			if calls < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	policy := RetryPolicy{Retries: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	client := &http.Client{Transport: policy.Transport(nil)}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		status int
+		calls  int
+	}{
+		{http.MethodPut, "/flaky", http.StatusOK, 3},
+		{http.MethodPut, "/missing", http.StatusNotFound, 1},
+		{http.MethodPut, "/down", http.StatusBadGateway, 4},
+		// a retried POST could create a resource twice
+		{http.MethodPost, "/down", http.StatusBadGateway, 1},
+	} {
+		calls, bodies = 0, nil
+		req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || calls != tt.calls {
+			t.Errorf("%s: got status %d after %d calls, want %d after %d", tt.path, resp.StatusCode, calls, tt.status, tt.calls)
+		}
+		for _, body := range bodies {
+			if body != "body" {
+				t.Errorf("%s: got body %q on a retry", tt.path, body)
+			}
+		}
+	}
+
+	t.Run("should stop retrying when the request is canceled", func(t *testing.T) {
+		slow := RetryPolicy{Retries: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}
+		client := &http.Client{Transport: slow.Transport(nil)}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/down", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls = 0
+		start := time.Now()
+		if _, err := client.Do(req.WithContext(ctx)); err == nil {
+			t.Error("expected the error of the canceled request")
+		}
+		if calls != 1 || time.Since(start) > time.Minute {
+			t.Errorf("got %d calls in %v", calls, time.Since(start))
+		}
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	retryable := []error{
+		awserr.New("RequestLimitExceeded", "slow down", nil),
+		awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusServiceUnavailable, "id"),
+		&googleapi.Error{Code: http.StatusBadGateway},
+		&UploadError{Err: &googleapi.Error{Code: http.StatusTooManyRequests}},
+	}
+	for _, err := range retryable {
+		if !IsRetryable(err) {
+			t.Errorf("expected %v to be retryable", err)
+		}
+	}
+
+	permanent := []error{
+		errors.New("boom"),
+		awserr.New("InvalidAMIID.NotFound", "", nil),
+		&googleapi.Error{Code: http.StatusNotFound},
+	}
+	for _, err := range permanent {
+		if IsRetryable(err) {
+			t.Errorf("expected %v not to be retryable", err)
+		}
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	err := &UploadError{Uploaded: 95, Total: 100, Err: errors.New("reset")}
+	if !strings.Contains(err.Error(), "95%") {
+		t.Errorf("got %q", err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package lepton
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RunReport describes a local run of an image
+type RunReport struct {
+	// Accelerator is the qemu accelerator the run uses, kvm or hvf for
+	// hardware acceleration and tcg for software emulation
+	Accelerator string
+
+	// StartedAt is the time the hypervisor was started
+	StartedAt time.Time
+
+	// ExitStatus is the exit status of the program, when the kernel
+	// reported it to the hypervisor
+	ExitStatus int
+}
+
+// GuestExitError is returned by local runs of programs that exited with a
+// non zero status, reported by the kernel to the hypervisor
+type GuestExitError struct {
+	Status int
+}
+
+func (e *GuestExitError) Error() string {
+	return fmt.Sprintf("program exited with status %d", e.Status)
+}
+
+// RunLocal runs the image of rconfig with the local hypervisor until it
+// stops. The error is a GuestExitError when the program exited with a non
+// zero status.
+func RunLocal(rconfig *RunConfig) (RunReport, error) {
+	hypervisor := HypervisorInstance()
+	if hypervisor == nil {
+		return RunReport{}, errors.New("no hypervisor found on $PATH, install qemu")
+	}
+	err := hypervisor.Start(rconfig)
+	return hypervisor.Report(), err
+}
@@ -0,0 +1,133 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSerialLogSize is the size in MB a serial log is rotated at
+	DefaultSerialLogSize = 10
+
+	// DefaultSerialLogBackups is how many rotated serial logs are kept
+	DefaultSerialLogBackups = 5
+)
+
+// SerialLog is a writer that timestamps every line of serial output and
+// writes it to a file, rotating the file when it grows past a size. Rotated
+// files are suffixed .1 for the newest to .N for the oldest.
+type SerialLog struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu          sync.Mutex
+	f           *os.File
+	size        int64
+	atLineStart bool
+	now         func() time.Time
+	err         error
+}
+
+// NewSerialLog opens the serial log at path for appending, sizeMB and
+// backups default to DefaultSerialLogSize and DefaultSerialLogBackups when
+// not positive
+func NewSerialLog(path string, sizeMB, backups int) (*SerialLog, error) {
+	if sizeMB <= 0 {
+		sizeMB = DefaultSerialLogSize
+	}
+	if backups <= 0 {
+		backups = DefaultSerialLogBackups
+	}
+
+	l := &SerialLog{
+		path:        path,
+		maxSize:     int64(sizeMB) * 1024 * 1024,
+		backups:     backups,
+		atLineStart: true,
+		now:         time.Now,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *SerialLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a
+// new log file
+func (l *SerialLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.backups))
+	for n := l.backups - 1; n > 0; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, n), fmt.Sprintf("%s.%d", l.path, n+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// Write timestamps and logs p. It never fails so a failing log doesn't
+// interrupt the output it is teed from, the first error is returned by
+// Close instead.
+func (l *SerialLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buf := make([]byte, 0, len(p)+32)
+	for _, b := range p {
+		if l.atLineStart {
+			buf = append(buf, l.now().Format("2006-01-02T15:04:05.000Z07:00 ")...)
+		}
+		buf = append(buf, b)
+		l.atLineStart = b == '\n'
+	}
+
+	if l.err != nil {
+		return len(p), nil
+	}
+
+	if l.size > 0 && l.size+int64(len(buf)) > l.maxSize {
+		if l.err = l.rotate(); l.err != nil {
+			return len(p), nil
+		}
+	}
+
+	n, err := l.f.Write(buf)
+	l.size += int64(n)
+	l.err = err
+	return len(p), nil
+}
+
+// Close closes the log file and returns the first error writing to it
+func (l *SerialLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.f.Close()
+	if l.err != nil {
+		return l.err
+	}
+	return err
+}
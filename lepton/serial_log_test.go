@@ -0,0 +1,59 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSerialLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "serial.log")
+	l, err := NewSerialLog(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.maxSize = 100
+	l.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	t.Run("should timestamp every line", func(t *testing.T) {
+		l.Write([]byte("booting"))
+		l.Write([]byte(" kernel\nen0: up\n"))
+
+		data, _ := ioutil.ReadFile(path)
+		expected := "2020-01-02T03:04:05.000Z booting kernel\n2020-01-02T03:04:05.000Z en0: up\n"
+		if string(data) != expected {
+			t.Errorf("got %q", data)
+		}
+	})
+
+	t.Run("should rotate and drop the oldest log", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			l.Write([]byte("0123456789012345678901234567890123456789\n"))
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"serial.log", "serial.log.1", "serial.log.2"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Error(err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "serial.log.3")); !os.IsNotExist(err) {
+			t.Errorf("expected serial.log.3 to be removed, got %v", err)
+		}
+
+		data, _ := ioutil.ReadFile(path)
+		if len(data) > 100 {
+			t.Errorf("log grew to %d bytes", len(data))
+		}
+	})
+}
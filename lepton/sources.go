@@ -0,0 +1,96 @@
+package lepton
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"time"
+)
+
+// Sources are the clock, randomness and filesystem UUIDs of builds and
+// providers. Tests replace them for deterministic outputs, unset fields are
+// the system ones.
+type Sources struct {
+	// Now returns the current time, for build reports and the names
+	// providers derive from it
+	Now func() time.Time
+
+	// Rand is read for random bytes
+	Rand io.Reader
+
+	// UUID returns the UUID of a new filesystem, a random version 4 UUID
+	// read from Rand when nil
+	UUID func() (string, error)
+}
+
+// opsUUIDNamespace is the namespace of the name based UUIDs of ops
+var opsUUIDNamespace = [16]byte{0x6f, 0x70, 0x73, 0x2d, 0x6e, 0x61, 0x6e, 0x6f, 0x73, 0x2d, 0x75, 0x75, 0x69, 0x64, 0x73, 0x00}
+
+// FixedClock returns a clock always at t
+func FixedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+// SeededRand returns a reader of the pseudo random bytes of seed
+func SeededRand(seed int64) io.Reader {
+	return mrand.New(mrand.NewSource(seed))
+}
+
+// RandomUUID returns a source of random version 4 UUIDs read from r
+func RandomUUID(r io.Reader) func() (string, error) {
+	return func() (string, error) {
+		var u [16]byte
+		if _, err := io.ReadFull(r, u[:]); err != nil {
+			return "", err
+		}
+		u[6] = u[6]&0x0f | 0x40
+		u[8] = u[8]&0x3f | 0x80
+		return formatUUID(u), nil
+	}
+}
+
+// HashUUID returns the version 5 UUID of data, filesystems built from the
+// same manifest get the same UUID with it
+func HashUUID(data []byte) string {
+	u, _ := readerHashUUID(bytes.NewReader(data))
+	return u
+}
+
+// readerHashUUID is HashUUID for the content of r
+func readerHashUUID(r io.Reader) (string, error) {
+	h := sha1.New()
+	h.Write(opsUUIDNamespace[:])
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u), nil
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// sources returns the sources of c with the system ones for unset fields
+func (c *Config) sources() *Sources {
+	s := Sources{}
+	if c.Sources != nil {
+		s = *c.Sources
+	}
+	if s.Now == nil {
+		s.Now = time.Now
+	}
+	if s.Rand == nil {
+		s.Rand = rand.Reader
+	}
+	if s.UUID == nil {
+		s.UUID = RandomUUID(s.Rand)
+	}
+	return &s
+}
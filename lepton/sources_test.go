@@ -0,0 +1,51 @@
+package lepton
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([45])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRandomUUID(t *testing.T) {
+	a, err := RandomUUID(SeededRand(1))()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := RandomUUID(SeededRand(1))()
+	if a != b {
+		t.Errorf("uuids of the same seed differ: %s %s", a, b)
+	}
+	if m := uuidRegexp.FindStringSubmatch(a); m == nil || m[1] != "4" {
+		t.Errorf("%s isn't a version 4 uuid", a)
+	}
+}
+
+func TestHashUUID(t *testing.T) {
+	a := HashUUID([]byte("(program:/main)"))
+	if a != HashUUID([]byte("(program:/main)")) {
+		t.Error("uuids of the same manifest differ")
+	}
+	if a == HashUUID([]byte("(program:/other)")) {
+		t.Error("uuids of different manifests are the same")
+	}
+	if m := uuidRegexp.FindStringSubmatch(a); m == nil || m[1] != "5" {
+		t.Errorf("%s isn't a version 5 uuid", a)
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Config{Sources: &Sources{Now: FixedClock(at)}}
+	if got := newBuildReport(c).StartedAt; !got.Equal(at) {
+		t.Errorf("got %v, want %v", got, at)
+	}
+	s := (&Config{}).sources()
+	if s.Rand == nil {
+		t.Error("no default random source")
+	}
+	if uuid, err := s.UUID(); err != nil || !uuidRegexp.MatchString(uuid) {
+		t.Errorf("default uuid source returned %q, %v", uuid, err)
+	}
+}
@@ -0,0 +1,346 @@
+package lepton
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerRootPrefix marks a TargetRoot that is a docker image reference, like
+// docker://ubuntu:20.04, instead of a directory
+const dockerRootPrefix = "docker://"
+
+// sysrootsDir returns the directory image target roots are extracted to
+func sysrootsDir() string {
+	return filepath.Join(GetOpsHome(), "sysroots")
+}
+
+// whiteoutPrefix marks the files of a target root layer that delete the
+// entry of the rest of their name from the layers below, like in container
+// images
+const whiteoutPrefix = ".wh."
+
+// opaqueWhiteout marks the directories of a target root layer that hide the
+// content of the same directory in the layers below
+const opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+// ResolveTargetRoot replaces a TargetRoot that is a docker image reference
+// by the directory the image filesystem is extracted to. Images missing
+// locally are pulled with the docker cli, and extracted once per image id.
+// TargetRootLayers are laid over the target root in a single directory.
+func ResolveTargetRoot(c *Config) error {
+	root, err := targetRootDir(c.TargetRoot)
+	if err != nil {
+		return err
+	}
+	if len(c.TargetRootLayers) > 0 {
+		dirs := []string{}
+		if root != "" {
+			dirs = append(dirs, root)
+		}
+		for _, layer := range c.TargetRootLayers {
+			dir, err := targetRootDir(layer)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dir)
+		}
+		if root, err = overlaySysroot(dirs); err != nil {
+			return err
+		}
+		c.TargetRootLayers = nil
+	}
+	c.TargetRoot = root
+	return nil
+}
+
+// targetRootDir returns the directory of the target root ref, a directory
+// or a docker image reference
+func targetRootDir(ref string) (string, error) {
+	if !strings.HasPrefix(ref, dockerRootPrefix) {
+		return ref, nil
+	}
+	return dockerSysroot(strings.TrimPrefix(ref, dockerRootPrefix))
+}
+
+func dockerSysroot(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("empty docker image reference")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", fmt.Errorf("docker is needed to use %s%s as target root: %v", dockerRootPrefix, ref, err)
+	}
+
+	id, err := dockerOutput("image", "inspect", "--format", "{{.Id}}", ref)
+	if err != nil {
+		if _, err := dockerOutput("pull", ref); err != nil {
+			return "", err
+		}
+		if id, err = dockerOutput("image", "inspect", "--format", "{{.Id}}", ref); err != nil {
+			return "", err
+		}
+	}
+
+	dir := filepath.Join(sysrootsDir(), strings.TrimPrefix(id, "sha256:"))
+	if _, err := os.Stat(dir); err == nil {
+		MarkCacheUsed(dir)
+		return dir, nil
+	}
+
+	container, err := dockerOutput("create", ref)
+	if err != nil {
+		return "", err
+	}
+	defer exec.Command("docker", "rm", container).Run()
+
+	if err := os.MkdirAll(sysrootsDir(), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(sysrootsDir(), "extract")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	export := exec.Command("docker", "export", container)
+	stdout, err := export.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	export.Stderr = &stderr
+	if err := export.Start(); err != nil {
+		return "", err
+	}
+	if err := extractSysroot(stdout, tmp); err != nil {
+		export.Process.Kill()
+		export.Wait()
+		return "", fmt.Errorf("extracting %s: %v", ref, err)
+	}
+	if err := export.Wait(); err != nil {
+		return "", fmt.Errorf("docker export %s: %v: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+
+	// concurrent builds may have extracted the same image meanwhile
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, serr := os.Stat(dir); serr != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// overlaySysroot returns the directory of the target root layers dirs laid
+// over each other, later layers win. The merged directory is built once per
+// content of the layers, files are hard linked from them when possible.
+func overlaySysroot(dirs []string) (string, error) {
+	key, err := overlayKey(dirs)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(sysrootsDir(), "overlay-"+key)
+	if _, err := os.Stat(dir); err == nil {
+		MarkCacheUsed(dir)
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(sysrootsDir(), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(sysrootsDir(), "extract")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, layer := range dirs {
+		if err := applySysrootLayer(tmp, layer); err != nil {
+			return "", fmt.Errorf("target root layer %s: %v", layer, err)
+		}
+	}
+
+	// concurrent builds may have merged the same layers meanwhile
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, serr := os.Stat(dir); serr != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// overlayKey returns the hash of the entries of the layers dirs, it changes
+// when a file of a layer is added, removed or modified
+func overlayKey(dirs []string) (string, error) {
+	h := sha256.New()
+	for _, layer := range dirs {
+		fmt.Fprintf(h, "layer %s\n", layer)
+		err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			var link string
+			if info.Mode()&os.ModeSymlink != 0 {
+				link, _ = os.Readlink(path)
+			}
+			fmt.Fprintf(h, "%s %v %d %d %s\n", path, info.Mode(), info.Size(), info.ModTime().UnixNano(), link)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// applySysrootLayer lays the directory layer over dir. Entries of the layer
+// replace those of dir, a directory of the layer only replaces what isn't a
+// directory. Whiteout files delete entries of dir, and an opaque whiteout
+// empties the directory it is in. Devices and other special files are
+// skipped.
+func applySysrootLayer(dir string, layer string) error {
+	return filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dir, rel)
+		name := info.Name()
+
+		switch {
+		case name == opaqueWhiteout:
+			// handled with its directory
+			return nil
+		case strings.HasPrefix(name, whiteoutPrefix):
+			return os.RemoveAll(filepath.Join(filepath.Dir(target), strings.TrimPrefix(name, whiteoutPrefix)))
+		case info.IsDir():
+			fi, err := os.Lstat(target)
+			if err == nil && !fi.IsDir() {
+				err = os.Remove(target)
+			} else if err == nil {
+				if _, serr := os.Lstat(filepath.Join(path, opaqueWhiteout)); serr == nil {
+					err = os.RemoveAll(target)
+				}
+			} else if os.IsNotExist(err) {
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+			return copyFile(target, path)
+		}
+		return nil
+	})
+}
+
+func dockerOutput(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// extractSysroot extracts the filesystem tar r to dir. Links are kept as
+// they are, they are resolved within the target root, but no entry may be
+// written outside of dir. Devices and other special files are skipped.
+func extractSysroot(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := sysrootPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeSysrootFile(target, mode, tr)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+		case tar.TypeLink:
+			var source string
+			if source, err = sysrootPath(dir, hdr.Linkname); err == nil {
+				if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+					err = os.Link(source, target)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sysrootPath returns the path of the archive entry name in dir, it fails
+// if the path leaves dir or goes through a symlink extracted before
+func sysrootPath(dir string, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the target root", name)
+	}
+
+	for parent := filepath.Dir(target); len(parent) > len(dir); parent = filepath.Dir(parent) {
+		if fi, err := os.Lstat(parent); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %s is under the symlink %s", name, parent)
+		}
+	}
+	return target, nil
+}
+
+func writeSysrootFile(path string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,140 @@
+package lepton
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func writeTestTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0755, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractSysroot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("should extract files and links", func(t *testing.T) {
+		root := filepath.Join(dir, "ok")
+		err := extractSysroot(writeTestTar(t, []tarEntry{
+			{name: "lib/", typeflag: tar.TypeDir},
+			{name: "lib/libc.so.6", typeflag: tar.TypeReg, body: "libc"},
+			{name: "lib/libc.so", typeflag: tar.TypeSymlink, linkname: "/lib/libc.so.6"},
+			{name: "usr/lib/libc.so.6", typeflag: tar.TypeLink, linkname: "lib/libc.so.6"},
+			{name: "dev/null", typeflag: tar.TypeChar},
+		}), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data, err := ioutil.ReadFile(filepath.Join(root, "usr/lib/libc.so.6")); err != nil || string(data) != "libc" {
+			t.Errorf("got %q, %v", data, err)
+		}
+		if got, err := lookupFile(root, "/lib/libc.so"); err != nil || got != filepath.Join(root, "lib/libc.so.6") {
+			t.Errorf("got %s, %v", got, err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, "dev/null")); !os.IsNotExist(err) {
+			t.Errorf("expected devices to be skipped, got %v", err)
+		}
+	})
+
+	t.Run("should not write outside of the target root", func(t *testing.T) {
+		for i, entries := range [][]tarEntry{
+			{{name: "../escape", typeflag: tar.TypeReg, body: "x"}},
+			{{name: "lib", typeflag: tar.TypeSymlink, linkname: dir}, {name: "lib/escape", typeflag: tar.TypeReg, body: "x"}},
+			{{name: "passwd", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+		} {
+			root := filepath.Join(dir, "bad", string(rune('a'+i)))
+			if err := extractSysroot(writeTestTar(t, entries), root); err == nil {
+				t.Errorf("expected %v to fail", entries)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+			t.Error("file written outside of the target root")
+		}
+	})
+}
+
+func TestResolveTargetRoot(t *testing.T) {
+	c := &Config{TargetRoot: "/srv/sysroot"}
+	if err := ResolveTargetRoot(c); err != nil || c.TargetRoot != "/srv/sysroot" {
+		t.Errorf("got %s, %v", c.TargetRoot, err)
+	}
+
+	c.TargetRoot = dockerRootPrefix
+	if err := ResolveTargetRoot(c); err == nil {
+		t.Error("expected an error for an empty image reference")
+	}
+}
+
+func TestApplySysrootLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path string, body string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := filepath.Join(dir, "base")
+	write(filepath.Join(base, "lib/libc.so.6"), "base")
+	write(filepath.Join(base, "lib/libssl.so"), "base")
+	write(filepath.Join(base, "etc/ssl/cert.pem"), "base")
+	app := filepath.Join(dir, "app")
+	write(filepath.Join(app, "lib/libc.so.6"), "app")
+	write(filepath.Join(app, "lib/.wh.libssl.so"), "")
+	write(filepath.Join(app, "etc/ssl/"+opaqueWhiteout), "")
+	write(filepath.Join(app, "etc/ssl/app.pem"), "app")
+
+	merged := filepath.Join(dir, "merged")
+	for _, layer := range []string{base, app} {
+		if err := applySysrootLayer(merged, layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(merged, "lib/libc.so.6")); err != nil || string(data) != "app" {
+		t.Errorf("expected the later layer to win, got %q, %v", data, err)
+	}
+	for _, path := range []string{"lib/libssl.so", "lib/.wh.libssl.so", "etc/ssl/cert.pem", "etc/ssl/" + opaqueWhiteout} {
+		if _, err := os.Lstat(filepath.Join(merged, path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(merged, "etc/ssl/app.pem")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,197 @@
+package lepton
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// TelemetrySettings are the telemetry choices of the user, telemetry is off
+// unless it was explicitly enabled
+type TelemetrySettings struct {
+	// Enabled records events to the local spool
+	Enabled bool `json:"enabled"`
+
+	// Endpoint is where spooled events are uploaded to, nothing is uploaded
+	// when it's empty
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// TelemetryEvent is an anonymous record of a build or run. It holds no
+// names, paths, addresses or identifiers, only what is needed to measure
+// performance.
+type TelemetryEvent struct {
+	// Event is what happened, like "build", "run" or "image_create"
+	Event string `json:"event"`
+
+	// Time is truncated to the hour
+	Time time.Time `json:"time"`
+
+	Provider   string `json:"provider,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	ImageSize  int64  `json:"image_size,omitempty"`
+
+	// Accelerator is the hypervisor accelerator of local runs
+	Accelerator string `json:"accelerator,omitempty"`
+
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	OpsVersion string `json:"ops_version,omitempty"`
+}
+
+// TelemetrySettingsFile returns the path of the telemetry settings
+func TelemetrySettingsFile() string {
+	return filepath.Join(GetOpsHome(), "telemetry.json")
+}
+
+// TelemetrySpoolFile returns the path of the file events are spooled to, one
+// JSON event per line
+func TelemetrySpoolFile() string {
+	return filepath.Join(GetOpsHome(), "telemetry.spool")
+}
+
+// LoadTelemetrySettings reads the telemetry settings, missing settings leave
+// telemetry disabled
+func LoadTelemetrySettings() (TelemetrySettings, error) {
+	var settings TelemetrySettings
+
+	data, err := ioutil.ReadFile(TelemetrySettingsFile())
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// SaveTelemetrySettings writes the telemetry settings
+func SaveTelemetrySettings(settings TelemetrySettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(TelemetrySettingsFile(), data, 0644)
+}
+
+// RecordTelemetry appends event to the spool when telemetry is enabled.
+// Telemetry must never get in the way, so failures are ignored.
+func RecordTelemetry(event TelemetryEvent) {
+	settings, err := LoadTelemetrySettings()
+	if err != nil || !settings.Enabled {
+		return
+	}
+
+	appendTelemetry(TelemetrySpoolFile(), event)
+}
+
+func appendTelemetry(spool string, event TelemetryEvent) error {
+	event.Time = time.Now().UTC().Truncate(time.Hour)
+	event.OS = runtime.GOOS
+	event.Arch = runtime.GOARCH
+	event.OpsVersion = Version
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(spool, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// SpooledTelemetry returns the events waiting in the spool
+func SpooledTelemetry() ([]TelemetryEvent, error) {
+	return readTelemetry(TelemetrySpoolFile())
+}
+
+func readTelemetry(spool string) ([]TelemetryEvent, error) {
+	events := []TelemetryEvent{}
+
+	f, err := os.Open(spool)
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event TelemetryEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// skip lines from an interrupted write
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// UploadTelemetry posts the spooled events as a JSON array to the configured
+// endpoint and clears the spool once they are accepted
+func UploadTelemetry() (int, error) {
+	settings, err := LoadTelemetrySettings()
+	if err != nil {
+		return 0, err
+	}
+	if !settings.Enabled || settings.Endpoint == "" {
+		return 0, fmt.Errorf("telemetry upload is not enabled")
+	}
+
+	return uploadTelemetry(TelemetrySpoolFile(), settings.Endpoint)
+}
+
+func uploadTelemetry(spool, endpoint string) (int, error) {
+	events, err := readTelemetry(spool)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return 0, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("telemetry upload failed: %s", resp.Status)
+	}
+
+	return len(events), clearTelemetry(spool)
+}
+
+// ClearTelemetry deletes the spooled events
+func ClearTelemetry() error {
+	return clearTelemetry(TelemetrySpoolFile())
+}
+
+func clearTelemetry(spool string) error {
+	err := os.Remove(spool)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package lepton
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTelemetrySpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telemetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "telemetry.spool")
+
+	appendTelemetry(spool, TelemetryEvent{Event: "build", DurationMs: 1200, ImageSize: 1 << 20})
+	appendTelemetry(spool, TelemetryEvent{Event: "run", Accelerator: "kvm"})
+
+	events, err := readTelemetry(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Event != "build" || events[1].Accelerator != "kvm" {
+		t.Fatalf("got %+v", events)
+	}
+	if events[0].Time.Minute() != 0 || events[0].Time.Second() != 0 || events[0].OS == "" {
+		t.Errorf("event not anonymized: %+v", events[0])
+	}
+
+	t.Run("should keep the spool when the upload fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		if _, err := uploadTelemetry(spool, server.URL); err == nil {
+			t.Error("expected an error")
+		}
+		if events, _ := readTelemetry(spool); len(events) != 2 {
+			t.Errorf("got %d spooled events", len(events))
+		}
+	})
+
+	t.Run("should upload and clear the spool", func(t *testing.T) {
+		var uploaded []TelemetryEvent
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&uploaded)
+		}))
+		defer server.Close()
+
+		n, err := uploadTelemetry(spool, server.URL)
+		if err != nil || n != 2 || len(uploaded) != 2 {
+			t.Errorf("got %d, %v, %+v", n, err, uploaded)
+		}
+		if _, err := os.Stat(spool); !os.IsNotExist(err) {
+			t.Errorf("expected spool to be removed, got %v", err)
+		}
+	})
+}
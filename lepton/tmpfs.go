@@ -0,0 +1,83 @@
+package lepton
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AddTmpfs mounts a tmpfs at vmpath, so the program has writable scratch
+// space taking no room in the image. size, like 64M, limits it when set.
+func (m *Manifest) AddTmpfs(vmpath string, size string) error {
+	parts, err := vmPathParts(vmpath)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("tmpfs can't be mounted at the root of the image")
+	}
+
+	var bytes int64
+	if size != "" {
+		if bytes, err = parseBytes(size); err != nil {
+			return fmt.Errorf("invalid size of tmpfs %s: %v", vmpath, err)
+		}
+	}
+
+	vmpath = "/" + strings.Join(parts, "/")
+	if err := m.MkdirAll(vmpath); err != nil {
+		return err
+	}
+	if m.tmpfs == nil {
+		m.tmpfs = make(map[string]int64)
+	}
+	m.tmpfs[vmpath] = bytes
+	return nil
+}
+
+// writeTmpfs writes the tmpfs mounts of the manifest, sorted by path
+func (m *Manifest) writeTmpfs(sb manifestWriter) {
+	if len(m.tmpfs) == 0 {
+		return
+	}
+
+	paths := make([]string, 0, len(m.tmpfs))
+	for vmpath := range m.tmpfs {
+		paths = append(paths, vmpath)
+	}
+	sort.Strings(paths)
+
+	sb.WriteString("tmpfs:(\n")
+	for _, vmpath := range paths {
+		sb.WriteString("    ")
+		sb.WriteString(escapeValue(vmpath))
+		sb.WriteString(":(")
+		if size := m.tmpfs[vmpath]; size > 0 {
+			sb.WriteString("size:")
+			sb.WriteString(strconv.FormatInt(size, 10))
+		}
+		sb.WriteString(")\n")
+	}
+	sb.WriteString(")\n")
+}
+
+// AddTmpfsMounts adds the tmpfs mounts of flags like /tmp:64M, or /run
+// without a size limit, to Tmpfs
+func AddTmpfsMounts(mounts []string, config *Config) error {
+	for _, mnt := range mounts {
+		vmpath, size := mnt, ""
+		if i := strings.LastIndex(mnt, VolumeDelimiter); i >= 0 {
+			vmpath, size = mnt[:i], mnt[i+1:]
+		}
+		if vmpath == "" || vmpath[0] != '/' {
+			return fmt.Errorf("tmpfs config invalid: %s", mnt)
+		}
+
+		if config.Tmpfs == nil {
+			config.Tmpfs = make(map[string]string)
+		}
+		config.Tmpfs[vmpath] = size
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package lepton
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddTmpfs(t *testing.T) {
+	m := NewManifest("")
+	if err := m.AddTmpfs("/tmp", "64M"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTmpfs("run//", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, vmpath := range []string{"/", "/../tmp"} {
+		if err := m.AddTmpfs(vmpath, ""); err == nil {
+			t.Errorf("%q: expected an error", vmpath)
+		}
+	}
+	if err := m.AddTmpfs("/var/tmp", "lots"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+
+	want := map[string]interface{}{"tmp": map[string]interface{}{}, "run": map[string]interface{}{}}
+	if !reflect.DeepEqual(m.root.ToMap(), want) {
+		t.Errorf("got %v, want the mount points %v", m.root.ToMap(), want)
+	}
+	if s := m.String(); !strings.Contains(s, "tmpfs:(\n    /run:()\n    /tmp:(size:64000000)\n)\n") {
+		t.Errorf("missing tmpfs mounts in %s", s)
+	}
+}
+
+func TestAddTmpfsMounts(t *testing.T) {
+	c := &Config{}
+	if err := AddTmpfsMounts([]string{"/tmp:64M", "/run"}, c); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"/tmp": "64M", "/run": ""}; !reflect.DeepEqual(c.Tmpfs, want) {
+		t.Errorf("got %v, want %v", c.Tmpfs, want)
+	}
+	if err := AddTmpfsMounts([]string{"tmp:64M"}, c); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+}
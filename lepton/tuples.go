@@ -0,0 +1,158 @@
+package lepton
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reservedRootTuples are the keys of the root tuple the manifest writes
+// itself, they are set with the typed methods
+var reservedRootTuples = map[string]bool{
+	"boot":        true,
+	"children":    true,
+	"program":     true,
+	"programs":    true,
+	"setup":       true,
+	"arguments":   true,
+	"environment": true,
+	"mounts":      true,
+	"tmpfs":       true,
+	"policy":      true,
+	"klibs":       true,
+	"notrace":     true,
+	"ipaddr":      true,
+	"gateway":     true,
+	"netmask":     true,
+	"ntp_address": true,
+	"ntp_port":    true,
+}
+
+// reservedBootTuples are the keys of the boot tuple the manifest writes
+// itself
+var reservedBootTuples = map[string]bool{
+	"children": true,
+}
+
+// SetRootTuple sets the value at keyPath, like "exec_protection" or
+// "klib/option", in the root tuple of the manifest, for the tunables of the
+// kernel without a typed method. value is a string, a bool, an integer, a
+// []string vector or a map[string]interface{} tuple of those. It replaces the
+// debug flag of the same key, keys the manifest writes itself are rejected.
+func (m *Manifest) SetRootTuple(keyPath string, value interface{}) error {
+	if m.rootTuples == nil {
+		m.rootTuples = make(map[string]interface{})
+	}
+	return setTuple(m.rootTuples, reservedRootTuples, keyPath, value)
+}
+
+// SetBootTuple sets the value at keyPath in the tuple of the boot
+// filesystem, like SetRootTuple. It is written when the manifest has a
+// kernel.
+func (m *Manifest) SetBootTuple(keyPath string, value interface{}) error {
+	if m.bootTuples == nil {
+		m.bootTuples = make(map[string]interface{})
+	}
+	return setTuple(m.bootTuples, reservedBootTuples, keyPath, value)
+}
+
+// setTuple sets value at keyPath in tuples, whose first keys can't be one of
+// reserved
+func setTuple(tuples map[string]interface{}, reserved map[string]bool, keyPath string, value interface{}) error {
+	keys := strings.Split(keyPath, "/")
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "\":()[] \t\n") {
+			return fmt.Errorf("invalid tuple key %q in %s", key, keyPath)
+		}
+	}
+	if reserved[keys[0]] {
+		return fmt.Errorf("tuple %s is set by the manifest, use its typed method", keys[0])
+	}
+	if _, err := encodeTuple(value); err != nil {
+		return fmt.Errorf("%s: %v", keyPath, err)
+	}
+
+	node := tuples
+	for _, key := range keys[:len(keys)-1] {
+		if _, ok := node[key]; !ok {
+			node[key] = make(map[string]interface{})
+		}
+		next, ok := node[key].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %s is not a tuple", keyPath, key)
+		}
+		node = next
+	}
+	node[keys[len(keys)-1]] = value
+	return nil
+}
+
+// encodeTuple returns value in the syntax of manifests
+func encodeTuple(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return escapeValue(v), nil
+	case bool:
+		if v {
+			return "t", nil
+		}
+		return "f", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case []string:
+		values := make([]string, len(v))
+		for i, s := range v {
+			values[i] = escapeValue(s)
+		}
+		return "[" + strings.Join(values, " ") + "]", nil
+	case map[string]interface{}:
+		var sb strings.Builder
+		sb.WriteRune('(')
+		for i, key := range sortedTupleKeys(v) {
+			if key == "" || strings.ContainsAny(key, "\":()[] \t\n") {
+				return "", fmt.Errorf("invalid tuple key %q", key)
+			}
+			s, err := encodeTuple(v[key])
+			if err != nil {
+				return "", err
+			}
+			if i > 0 {
+				sb.WriteRune(' ')
+			}
+			sb.WriteString(key)
+			sb.WriteRune(':')
+			sb.WriteString(s)
+		}
+		sb.WriteRune(')')
+		return sb.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported tuple value %v of type %T", value, value)
+	}
+}
+
+func sortedTupleKeys(tuples map[string]interface{}) []string {
+	keys := make([]string, 0, len(tuples))
+	for key := range tuples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeTuples writes each of tuples between prefix and suffix
+func writeTuples(sb manifestWriter, tuples map[string]interface{}, prefix string, suffix string) {
+	for _, key := range sortedTupleKeys(tuples) {
+		// values are checked when they are set
+		s, _ := encodeTuple(tuples[key])
+		sb.WriteString(prefix)
+		sb.WriteString(key)
+		sb.WriteRune(':')
+		sb.WriteString(s)
+		sb.WriteString(suffix)
+	}
+}
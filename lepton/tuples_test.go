@@ -0,0 +1,78 @@
+package lepton
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeTuple(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"plain", "plain"},
+		{"a b", `"a b"`},
+		{true, "t"},
+		{false, "f"},
+		{1500, "1500"},
+		{[]string{"a", "b c"}, `[a "b c"]`},
+		{map[string]interface{}{"z": "1", "a": map[string]interface{}{"b": true}}, "(a:(b:t) z:1)"},
+	} {
+		got, err := encodeTuple(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("%v: got %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []interface{}{1.5, nil, map[string]interface{}{"a b": "c"}} {
+		if _, err := encodeTuple(value); err == nil {
+			t.Errorf("%v: expected an error", value)
+		}
+	}
+}
+
+func TestSetTuples(t *testing.T) {
+	m := NewManifest("")
+	m.AddKernel("/kernel.img")
+	m.AddDebugFlag("exec_protection", 't')
+
+	for keyPath, value := range map[string]interface{}{
+		"exec_protection": false,
+		"klib/radar/url":  "https://radar",
+		"klib/radar/port": 443,
+	} {
+		if err := m.SetRootTuple(keyPath, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetBootTuple("uefi", true); err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.String()
+	for _, want := range []string{"exec_protection:f\n", `klib:(radar:(port:443 url:"https://radar"))` + "\n", ") uefi:t)\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in %s", want, s)
+		}
+	}
+	if strings.Contains(s, "exec_protection:t") {
+		t.Errorf("the debug flag is not replaced in %s", s)
+	}
+
+	for keyPath, value := range map[string]interface{}{
+		"children":             "x",
+		"environment/PATH":     "/bin",
+		"klib/radar/url/x":     "y",
+		"a//b":                 "c",
+		"a:b":                  "c",
+		"unsupported":          struct{}{},
+		"exec_protection/deep": true,
+	} {
+		if err := m.SetRootTuple(keyPath, value); err == nil {
+			t.Errorf("%s: expected an error", keyPath)
+		}
+	}
+	if err := m.SetBootTuple("children/x", "y"); err == nil {
+		t.Error("expected an error setting the boot children")
+	}
+}
@@ -0,0 +1,142 @@
+package lepton
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadStateInterval is how often the state of an upload in progress is
+// saved, besides when the upload fails
+const uploadStateInterval = 5 * time.Second
+
+// uploadState records the parts of an upload already sent with their
+// checksums, so an upload interrupted by an error resumes where it stopped
+// on the next attempt instead of starting over
+type uploadState struct {
+	// Size and ModTime identify the uploaded file, the state is discarded
+	// when it changed
+	Size    int64
+	ModTime time.Time
+
+	// PartSize is the size of the parts of the upload
+	PartSize int64
+
+	// UploadID is the provider id of the upload, like the id of an s3
+	// multipart upload or of an ebs snapshot
+	UploadID string
+
+	// Parts maps the indexes of the parts sent to their checksum, or to the
+	// etag the provider returned for them
+	Parts map[int64]string
+
+	path  string
+	mu    sync.Mutex
+	saved time.Time
+}
+
+// uploadStatePath returns the file the state of the upload named key is
+// kept in
+func uploadStatePath(key string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(key)
+	return path.Join(GetOpsHome(), "uploads", name+".json")
+}
+
+// loadUploadState returns the state of the upload of source named key, like
+// "s3-<bucket>-<key>", or a new state when there is none or it was saved for
+// another version of source. partSize is checked against the saved one
+// unless it is 0, for uploads whose part size is set by the provider.
+func loadUploadState(key string, source string, partSize int64) (*uploadState, error) {
+	return readUploadState(uploadStatePath(key), source, partSize)
+}
+
+func readUploadState(statePath string, source string, partSize int64) (*uploadState, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := &uploadState{
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+		PartSize: partSize,
+		Parts:    map[int64]string{},
+		path:     statePath,
+		saved:    time.Now(),
+	}
+
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return fresh, nil
+	}
+	s := &uploadState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return fresh, nil
+	}
+	if s.Size != fresh.Size || !s.ModTime.Equal(fresh.ModTime) || (partSize != 0 && s.PartSize != partSize) {
+		return fresh, nil
+	}
+	if s.Parts == nil {
+		s.Parts = map[int64]string{}
+	}
+	s.path = statePath
+	s.saved = fresh.saved
+	return s, nil
+}
+
+// resuming reports whether parts of the upload were already sent
+func (s *uploadState) resuming() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.UploadID != "" || len(s.Parts) > 0
+}
+
+// sent returns the checksum the part was sent with, or false when it wasn't
+// sent yet
+func (s *uploadState) sent(part int64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum, ok := s.Parts[part]
+	return sum, ok
+}
+
+// done records the part as sent, saving the state now and then. Parts
+// may be done concurrently.
+func (s *uploadState) done(part int64, sum string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Parts[part] = sum
+	if time.Since(s.saved) >= uploadStateInterval {
+		s.saveLocked()
+	}
+}
+
+// save writes the state, so a later attempt resumes the upload
+func (s *uploadState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+func (s *uploadState) saveLocked() error {
+	s.saved = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+// remove deletes the state once the upload completed, or when it can't be
+// resumed
+func (s *uploadState) remove() {
+	os.Remove(s.path)
+}
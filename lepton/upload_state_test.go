@@ -0,0 +1,84 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "image")
+	if err := ioutil.WriteFile(source, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(dir, "uploads", "image.json")
+
+	state, err := readUploadState(statePath, source, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.resuming() {
+		t.Fatal("new upload is resuming")
+	}
+	state.UploadID = "upload"
+	state.done(0, "sum0")
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = readUploadState(statePath, source, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.resuming() || state.UploadID != "upload" {
+		t.Fatalf("upload not resumed: %+v", state)
+	}
+	if sum, ok := state.sent(0); !ok || sum != "sum0" {
+		t.Errorf("part 0 = %q, %v", sum, ok)
+	}
+	if _, ok := state.sent(1); ok {
+		t.Error("part 1 sent")
+	}
+
+	// the part size of the upload is set by the provider
+	state, err = readUploadState(statePath, source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.resuming() {
+		t.Error("upload of provider part size not resumed")
+	}
+
+	state, err = readUploadState(statePath, source, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.resuming() {
+		t.Error("upload with another part size resumed")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(source, later, later); err != nil {
+		t.Fatal(err)
+	}
+	state, err = readUploadState(statePath, source, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.resuming() {
+		t.Error("upload of modified file resumed")
+	}
+
+	state.remove()
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("state not removed: %v", err)
+	}
+}
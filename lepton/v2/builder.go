@@ -0,0 +1,95 @@
+package lepton
+
+import (
+	v1 "github.com/nanovms/ops/lepton"
+)
+
+// Builder builds images of a program
+type Builder struct {
+	opts   *options
+	config v1.Config
+}
+
+// NewBuilder returns a builder of images running program
+func NewBuilder(program string, opts ...Option) (*Builder, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Builder{opts: o}
+	if o.config != nil {
+		b.config = *o.config
+	} else {
+		b.config = *v1.NewConfig()
+	}
+	b.config.Program = program
+	if o.targetRoot != "" {
+		b.config.TargetRoot = o.targetRoot
+	}
+	if o.strict {
+		b.config.TargetRootStrict = true
+	}
+	if o.materialize {
+		b.config.MaterializeSymlinks = true
+	}
+	if o.resolve {
+		b.config.ResolveSymlinks = true
+	}
+	if o.symlinks != "" {
+		b.config.SymlinkPolicy = o.symlinks
+	}
+	b.config.Exclude = append(b.config.Exclude, o.excludes...)
+	if o.profileDir != "" {
+		b.config.ProfileDir = o.profileDir
+	}
+	return b, nil
+}
+
+// Config returns the v1 config the builder builds from, changes to it apply
+// to the next builds
+func (b *Builder) Config() *v1.Config {
+	return &b.config
+}
+
+// Manifest resolves the manifest of the image without writing it
+func (b *Builder) Manifest() (*Manifest, error) {
+	m, err := v1.BuildManifest(&b.config)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{m: m}, nil
+}
+
+// Build writes the image to imagePath
+func (b *Builder) Build(imagePath string) error {
+	c := b.config
+	c.RunConfig.Imagename = imagePath
+
+	if b.opts.logger != nil {
+		b.opts.logger.Info("building %s", imagePath)
+	}
+	if err := v1.BuildImage(c); err != nil {
+		return err
+	}
+
+	if b.opts.logger != nil {
+		b.opts.logger.Info("built %s", imagePath)
+	}
+	return nil
+}
+
+// Run boots the image at imagePath with the local hypervisor until it stops
+// and returns the exit status of the program, for tests. The kernel reports
+// it with the PropagateStatus exit policy.
+func (b *Builder) Run(imagePath string) (int, error) {
+	c := b.config
+	c.RunConfig.Imagename = imagePath
+	c.RunConfig.PropagateExitStatus = c.Exit.PropagateStatus
+
+	report, err := v1.RunLocal(&c.RunConfig)
+	if exitErr, ok := err.(*v1.GuestExitError); ok {
+		return exitErr.Status, nil
+	}
+	return report.ExitStatus, err
+}
@@ -0,0 +1,218 @@
+package lepton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clusterGateway is the address of the host seen from local instances, with
+// the user networking of qemu
+const clusterGateway = "10.0.2.2"
+
+var serviceNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Service is an instance of a Cluster
+type Service struct {
+	Name    string
+	Builder *Builder
+
+	// Ports are the guest ports other services and the host reach the
+	// service on
+	Ports []int
+
+	// Ready is what Start waits for before starting the next services, when
+	// set
+	Ready *Expect
+
+	hostPorts map[int]int
+	run       *localRun
+}
+
+// Cluster boots several local instances that reach each other by name, for
+// integration tests of an application with its database. Instances use the
+// user networking of qemu: the ports of each service are forwarded to free
+// host ports, which the other services reach through the host gateway.
+// Every image gets the environment variables
+//
+//	<NAME>_HOST           the host gateway, 10.0.2.2
+//	<NAME>_PORT_<port>    the host port of a port of the service
+//	<NAME>_ADDR           host:port of the first port of the service
+//
+// for each service, NAME being its name in upper case.
+type Cluster struct {
+	services []*Service
+	dir      string
+	mu       sync.Mutex
+}
+
+// NewCluster returns an empty cluster
+func NewCluster() *Cluster {
+	return &Cluster{}
+}
+
+// Add adds a service built by b and listening on ports, services start in
+// the order they are added
+func (c *Cluster) Add(name string, b *Builder, ports ...int) (*Service, error) {
+	if !serviceNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid service name %q, use lowercase letters, digits and _", name)
+	}
+	for _, s := range c.services {
+		if s.Name == name {
+			return nil, fmt.Errorf("service %s already added", name)
+		}
+	}
+	s := &Service{Name: name, Builder: b, Ports: ports}
+	c.services = append(c.services, s)
+	return s, nil
+}
+
+// Start allocates the ports of the services, builds their images and boots
+// them in order, waiting for each to be Ready. The services started are
+// stopped when one fails.
+func (c *Cluster) Start() (err error) {
+	dir, err := ioutil.TempDir("", "ops-cluster")
+	if err != nil {
+		return err
+	}
+	c.dir = dir
+	defer func() {
+		if err != nil {
+			c.Stop()
+		}
+	}()
+
+	env := map[string]string{}
+	for _, s := range c.services {
+		s.hostPorts = map[int]int{}
+		prefix := strings.ToUpper(s.Name)
+		env[prefix+"_HOST"] = clusterGateway
+		for i, port := range s.Ports {
+			hostPort, err := freePort()
+			if err != nil {
+				return err
+			}
+			s.hostPorts[port] = hostPort
+			env[fmt.Sprintf("%s_PORT_%d", prefix, port)] = strconv.Itoa(hostPort)
+			if i == 0 {
+				env[prefix+"_ADDR"] = fmt.Sprintf("%s:%d", clusterGateway, hostPort)
+			}
+		}
+	}
+
+	for _, s := range c.services {
+		config := s.Builder.config
+		if config.Env == nil {
+			config.Env = map[string]string{}
+		} else {
+			config.Env = copyEnv(config.Env)
+		}
+		for k, v := range env {
+			if _, ok := config.Env[k]; !ok {
+				config.Env[k] = v
+			}
+		}
+		builder := &Builder{opts: s.Builder.opts, config: config}
+
+		imagePath := filepath.Join(dir, s.Name+".img")
+		if err := builder.Build(imagePath); err != nil {
+			return fmt.Errorf("service %s: %v", s.Name, err)
+		}
+
+		rconfig := config.RunConfig
+		rconfig.Imagename = imagePath
+		rconfig.Ports = nil
+		for port, hostPort := range s.hostPorts {
+			rconfig.Ports = append(rconfig.Ports, fmt.Sprintf("%d-%d", hostPort, port))
+		}
+		run, err := startLocalRun(rconfig)
+		if err != nil {
+			return fmt.Errorf("service %s: %v", s.Name, err)
+		}
+		c.mu.Lock()
+		s.run = run
+		c.mu.Unlock()
+
+		if s.Ready != nil {
+			ready, reason, err := run.wait(*s.Ready)
+			if err != nil {
+				return fmt.Errorf("service %s: %v", s.Name, err)
+			}
+			if !ready {
+				return fmt.Errorf("service %s not ready: %s, serial output:\n%s", s.Name, reason, run.output.Bytes())
+			}
+		}
+	}
+	return nil
+}
+
+// Addr returns the address the host reaches a port of a service on
+func (c *Cluster) Addr(name string, port int) (string, error) {
+	s := c.service(name)
+	if s == nil {
+		return "", fmt.Errorf("no service %s", name)
+	}
+	hostPort, ok := s.hostPorts[port]
+	if !ok {
+		return "", fmt.Errorf("service %s doesn't listen on port %d", name, port)
+	}
+	return fmt.Sprintf("localhost:%d", hostPort), nil
+}
+
+// Output returns the serial output of a service so far
+func (c *Cluster) Output(name string) string {
+	s := c.service(name)
+	if s == nil || s.run == nil {
+		return ""
+	}
+	return string(s.run.output.Bytes())
+}
+
+// Stop stops the services in the reverse order they started and removes
+// their images
+func (c *Cluster) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.services) - 1; i >= 0; i-- {
+		if run := c.services[i].run; run != nil {
+			run.stop()
+		}
+	}
+	if c.dir != "" {
+		os.RemoveAll(c.dir)
+		c.dir = ""
+	}
+}
+
+func (c *Cluster) service(name string) *Service {
+	for _, s := range c.services {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// freePort returns a tcp port of the host nothing listens on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
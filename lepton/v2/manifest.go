@@ -0,0 +1,183 @@
+package lepton
+
+import (
+	"io"
+	"os"
+
+	v1 "github.com/nanovms/ops/lepton"
+)
+
+// Manifest describes the filesystem and the program of an image
+type Manifest struct {
+	m *v1.Manifest
+}
+
+// NewManifest returns an empty manifest
+func NewManifest(opts ...Option) (*Manifest, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m := v1.NewManifest(o.targetRoot)
+	m.SetStrictTargetRoot(o.strict)
+	m.SetMaterializeSymlinks(o.materialize)
+	m.SetResolveSymlinks(o.resolve)
+	if o.symlinks != "" {
+		if err := m.SetSymlinkPolicy(o.symlinks); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.SetExcludes(o.excludes); err != nil {
+		return nil, err
+	}
+	m.SetWarningOutput(nil)
+	if o.logger != nil {
+		m.SetWarningOutput(warnWriter{o.logger})
+	}
+	return &Manifest{m: m}, nil
+}
+
+// SetProgram adds the program at hostpath and makes it the program the
+// image runs
+func (m *Manifest) SetProgram(hostpath string) error {
+	return m.m.SetProgram(hostpath)
+}
+
+// AddArgument appends an argument of the program
+func (m *Manifest) AddArgument(arg string) {
+	m.m.AddArgument(arg)
+}
+
+// AddEnv sets an environment variable of the program
+func (m *Manifest) AddEnv(name, value string) {
+	m.m.AddEnvironmentVariable(name, value)
+}
+
+// AddEnvPassthrough sets environment variables of the program to their
+// values on the host, it fails when one is not set
+func (m *Manifest) AddEnvPassthrough(names ...string) error {
+	return m.m.AddEnvPassthrough(names...)
+}
+
+// AddFile adds the file at hostpath to the image at vmpath
+func (m *Manifest) AddFile(vmpath, hostpath string) error {
+	return m.m.AddFile(vmpath, hostpath)
+}
+
+// AddFileFromReader adds a file with the content of r to the image at
+// vmpath, it is written to a temporary file until RemoveGeneratedFiles
+func (m *Manifest) AddFileFromReader(vmpath string, r io.Reader) error {
+	return m.m.AddFileFromReader(vmpath, r)
+}
+
+// AddFileBytes adds a file with content data to the image at vmpath, like
+// AddFileFromReader
+func (m *Manifest) AddFileBytes(vmpath string, data []byte) error {
+	return m.m.AddFileBytes(vmpath, data)
+}
+
+// RemoveGeneratedFiles removes the temporary files of AddFileFromReader and
+// AddFileBytes, once the image is written
+func (m *Manifest) RemoveGeneratedFiles() error {
+	return m.m.RemoveGeneratedFiles()
+}
+
+// AddDirectory adds the files under dir to the image at the same paths
+func (m *Manifest) AddDirectory(dir string) error {
+	return m.m.AddDirectory(dir)
+}
+
+// AddDirectoryFunc adds the files under dir filter returns true for to the
+// image at the same paths, directories it returns false for are skipped
+func (m *Manifest) AddDirectoryFunc(dir string, filter func(hostpath string, info os.FileInfo) bool) error {
+	return m.m.AddDirectoryFunc(dir, filter)
+}
+
+// AddDirectoryTo adds the files under hostDir to the image under vmPrefix
+func (m *Manifest) AddDirectoryTo(vmPrefix, hostDir string) error {
+	return m.m.AddDirectoryTo(vmPrefix, hostDir)
+}
+
+// AddDirectoryAs adds the files under hostDir to the image under vmDir
+func (m *Manifest) AddDirectoryAs(hostDir, vmDir string) error {
+	return m.m.AddDirectoryAs(hostDir, vmDir)
+}
+
+// AddBootFile adds the file at hostpath to the boot filesystem of the image
+// at vmpath
+func (m *Manifest) AddBootFile(vmpath, hostpath string) error {
+	return m.m.AddBootFile(vmpath, hostpath)
+}
+
+// AddBootDirectory adds the files under dir to the boot filesystem of the
+// image at the same paths
+func (m *Manifest) AddBootDirectory(dir string) error {
+	return m.m.AddBootDirectory(dir)
+}
+
+// AddBootDirectoryTo adds the files under hostDir to the boot filesystem of
+// the image under vmPrefix
+func (m *Manifest) AddBootDirectoryTo(vmPrefix, hostDir string) error {
+	return m.m.AddBootDirectoryTo(vmPrefix, hostDir)
+}
+
+// MkdirAll creates the directory vmpath and its parents in the image, they
+// stay when empty
+func (m *Manifest) MkdirAll(vmpath string) error {
+	return m.m.MkdirAll(vmpath)
+}
+
+// MapDirs adds the host files matching the glob keys of mappings under the
+// image directories of their values
+func (m *Manifest) MapDirs(mappings map[string]string) error {
+	return m.m.MapDirs(mappings)
+}
+
+// AddKernel sets the kernel the image boots
+func (m *Manifest) AddKernel(path string) {
+	m.m.AddKernel(path)
+}
+
+// RootMap returns the root filesystem of the image as nested maps, see
+// v1.DirNode.ToMap
+func (m *Manifest) RootMap() map[string]interface{} {
+	return m.m.Root().ToMap()
+}
+
+// SetRootMap replaces the root filesystem of the image with tree, in the
+// representation of RootMap
+func (m *Manifest) SetRootMap(tree map[string]interface{}) error {
+	d, err := v1.DirFromMap(tree)
+	if err != nil {
+		return err
+	}
+	m.m.Root().Children = d.Children
+	return nil
+}
+
+// Warnings returns the non-fatal issues found while adding files
+func (m *Manifest) Warnings() []v1.Warning {
+	return m.m.Warnings()
+}
+
+// SetRootTuple sets the value at keyPath, like "exec_protection", in the
+// root tuple of the image, for kernel tunables without a method
+func (m *Manifest) SetRootTuple(keyPath string, value interface{}) error {
+	return m.m.SetRootTuple(keyPath, value)
+}
+
+// SetBootTuple sets the value at keyPath in the tuple of the boot
+// filesystem
+func (m *Manifest) SetBootTuple(keyPath string, value interface{}) error {
+	return m.m.SetBootTuple(keyPath, value)
+}
+
+// V1 returns the v1 manifest, for calls that have no v2 equivalent yet
+func (m *Manifest) V1() *v1.Manifest {
+	return m.m
+}
+
+func (m *Manifest) String() string {
+	return m.m.String()
+}
@@ -0,0 +1,87 @@
+package lepton
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/nanovms/ops/lepton"
+)
+
+func TestNewManifest(t *testing.T) {
+	t.Run("should reject unsupported architectures", func(t *testing.T) {
+		if _, err := NewManifest(WithArch("sparc")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("should build amd64 images on any host", func(t *testing.T) {
+		if _, err := NewManifest(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("should return an error for a missing program", func(t *testing.T) {
+		m, err := NewManifest(WithArch("amd64"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.SetProgram("/nonexistent/program")
+		if code, _ := v1.ErrorCodeOf(err); code != v1.ErrMkfsMissingHostFile {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("should log warnings to the logger", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "v2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"a", "b"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var out bytes.Buffer
+		logger := v1.NewLogger(&out)
+		logger.SetWarn(true)
+		m, err := NewManifest(WithArch("amd64"), WithLogger(logger))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.AddFile("/a", filepath.Join(dir, "a"))
+		m.AddFile("/a", filepath.Join(dir, "b"))
+
+		if len(m.Warnings()) != 1 || !strings.Contains(out.String(), "overwriting existing file /a") {
+			t.Errorf("got warnings %v and log %q", m.Warnings(), out.String())
+		}
+	})
+}
+
+func TestManifestRootMap(t *testing.T) {
+	m, err := NewManifest(WithArch("amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := map[string]interface{}{
+		"etc": map[string]interface{}{
+			"app.conf": "/host/app.conf",
+			"current":  v1.LinkNode{Target: "app.conf"},
+		},
+	}
+	if err := m.SetRootMap(tree); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.RootMap(); !reflect.DeepEqual(got, tree) {
+		t.Errorf("got %v, want %v", got, tree)
+	}
+	if err := m.SetRootMap(map[string]interface{}{"a": 1}); err == nil {
+		t.Error("expected an error for an invalid node")
+	}
+}
@@ -0,0 +1,144 @@
+// Package lepton is the v2 API of ops. Manifests and builders are built with
+// functional options and report every failure as an error instead of
+// printing it or exiting. It is implemented on top of the v1 lepton package,
+// which stays supported so downstreams can migrate one call at a time.
+package lepton
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/nanovms/ops/lepton"
+)
+
+// supportedArchs are the guest architectures images can be built for
+var supportedArchs = map[string]bool{"amd64": true}
+
+type options struct {
+	targetRoot  string
+	strict      bool
+	materialize bool
+	resolve     bool
+	excludes    []string
+	profileDir  string
+	symlinks    v1.SymlinkPolicy
+	logger      *v1.Logger
+	arch        string
+	config      *v1.Config
+}
+
+// Option configures a Manifest or a Builder
+type Option func(*options) error
+
+// WithTargetRoot resolves files and libraries in root instead of the host
+// root, like a cross build sysroot
+func WithTargetRoot(root string) Option {
+	return func(o *options) error {
+		o.targetRoot = root
+		return nil
+	}
+}
+
+// WithStrictTargetRoot confines the lookup of files to the target root,
+// files and symlink targets missing from it are errors instead of being
+// taken from the host
+func WithStrictTargetRoot() Option {
+	return func(o *options) error {
+		o.strict = true
+		return nil
+	}
+}
+
+// WithMaterializedSymlinks adds the files symlinks out of added directories
+// point to instead of the symlinks, which would be dangling in the image
+func WithMaterializedSymlinks() Option {
+	return func(o *options) error {
+		o.materialize = true
+		return nil
+	}
+}
+
+// WithResolvedSymlinks copies the files and directories every symlink points
+// to in place of the symlink, for images without symlinks
+func WithResolvedSymlinks() Option {
+	return func(o *options) error {
+		o.resolve = true
+		return nil
+	}
+}
+
+// WithExcludes leaves the files matching patterns, like *.log or
+// node_modules/**, out of added directories
+func WithExcludes(patterns ...string) Option {
+	return func(o *options) error {
+		o.excludes = append(o.excludes, patterns...)
+		return nil
+	}
+}
+
+// WithProfileDir writes pprof CPU and heap profiles of resolving the
+// manifest and of mkfs to dir on every build
+func WithProfileDir(dir string) Option {
+	return func(o *options) error {
+		o.profileDir = dir
+		return nil
+	}
+}
+
+// WithSymlinkPolicy sets what is added for the symlinks of added files and
+// directories, it takes precedence over WithResolvedSymlinks
+func WithSymlinkPolicy(policy v1.SymlinkPolicy) Option {
+	return func(o *options) error {
+		o.symlinks = policy
+		return nil
+	}
+}
+
+// WithLogger logs manifest warnings and build progress to logger
+func WithLogger(logger *v1.Logger) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithArch sets the guest architecture, it defaults to amd64 whatever the
+// host is
+func WithArch(arch string) Option {
+	return func(o *options) error {
+		if !supportedArchs[arch] {
+			return fmt.Errorf("unsupported architecture %q", arch)
+		}
+		o.arch = arch
+		return nil
+	}
+}
+
+// WithConfig starts from a v1 config, options applied after it override its
+// fields
+func WithConfig(c *v1.Config) Option {
+	return func(o *options) error {
+		o.config = c
+		return nil
+	}
+}
+
+func newOptions(opts []Option) (*options, error) {
+	o := &options{arch: "amd64"}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// warnWriter logs the warnings v1 writes as they are found
+type warnWriter struct {
+	logger *v1.Logger
+}
+
+func (w warnWriter) Write(p []byte) (int, error) {
+	w.logger.Warn("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
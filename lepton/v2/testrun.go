@@ -0,0 +1,223 @@
+package lepton
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	v1 "github.com/nanovms/ops/lepton"
+)
+
+// defaultTestTimeout is how long a test run waits for its expectations when
+// Expect.Timeout is unset
+const defaultTestTimeout = time.Minute
+
+// Expect is what a test run of an image waits for, every expectation set
+// must be met for the run to pass
+type Expect struct {
+	// Output is matched against the serial output of the instance
+	Output *regexp.Regexp
+
+	// HTTP is a url, like http://localhost:8080/health, that must respond
+	// with a 2xx status. Forward its port with the Ports of the RunConfig.
+	HTTP string
+
+	// Timeout is how long the run waits for the expectations, a minute when
+	// unset
+	Timeout time.Duration
+}
+
+// TestResult is the outcome of a test run
+type TestResult struct {
+	Passed bool
+
+	// Reason says why the run failed
+	Reason string
+
+	// Output is the serial output of the instance
+	Output string
+
+	// ExitStatus is the exit status of the program, when it exited before
+	// the run was stopped
+	ExitStatus int
+
+	Duration time.Duration
+}
+
+// TB is the part of testing.TB Assert uses
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// Assert fails t with the reason and the serial output of failed runs
+func (r *TestResult) Assert(t TB) {
+	t.Helper()
+	if !r.Passed {
+		t.Fatalf("%s after %v, serial output:\n%s", r.Reason, r.Duration, r.Output)
+	}
+}
+
+// Test builds an image, boots it with the local hypervisor, waits until the
+// expectations are met or the timeout and stops it. The error is for
+// builds and boots that fail, unmet expectations fail the result.
+func (b *Builder) Test(expect Expect) (*TestResult, error) {
+	if expect.Output == nil && expect.HTTP == "" {
+		return nil, errors.New("a test run expects an output or an http response")
+	}
+	dir, err := ioutil.TempDir("", "ops-test")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	imagePath := filepath.Join(dir, filepath.Base(b.config.Program)+".img")
+	if err := b.Build(imagePath); err != nil {
+		return nil, err
+	}
+
+	rconfig := b.config.RunConfig
+	rconfig.Imagename = imagePath
+	rconfig.PropagateExitStatus = b.config.Exit.PropagateStatus
+	start := time.Now()
+	run, err := startLocalRun(rconfig)
+	if err != nil {
+		return nil, err
+	}
+	defer run.stop()
+
+	result := &TestResult{}
+	result.Passed, result.Reason, err = run.wait(expect)
+	if err != nil {
+		return nil, err
+	}
+	result.ExitStatus = run.exitStatus
+	result.Duration = time.Since(start)
+	result.Output = string(run.output.Bytes())
+	return result, nil
+}
+
+// localRun is an image booted with the local hypervisor
+type localRun struct {
+	hypervisor v1.Hypervisor
+	output     *serialOutput
+	exited     chan error
+	running    bool
+	exitStatus int
+	err        error
+}
+
+// startLocalRun boots the image of rconfig, collecting its serial output
+func startLocalRun(rconfig v1.RunConfig) (*localRun, error) {
+	hypervisor := v1.HypervisorInstance()
+	if hypervisor == nil {
+		return nil, errors.New("no hypervisor found on $PATH, install qemu")
+	}
+	if rconfig.Memory == "" {
+		rconfig.Memory = "2G"
+	}
+
+	run := &localRun{
+		hypervisor: hypervisor,
+		output:     &serialOutput{},
+		exited:     make(chan error, 1),
+		running:    true,
+	}
+	cmd := hypervisor.Command(&rconfig)
+	cmd.Stdout = run.output
+	cmd.Stderr = run.output
+	go func() {
+		run.exited <- hypervisor.Start(&rconfig)
+	}()
+	return run, nil
+}
+
+// exit records the end of the run
+func (r *localRun) exit(err error) {
+	r.running = false
+	if exitErr, ok := err.(*v1.GuestExitError); ok {
+		r.exitStatus = exitErr.Status
+	} else {
+		r.err = err
+	}
+}
+
+// wait waits until the expectations are met, the instance stops or the
+// timeout. It returns whether the expectations were met and why not.
+func (r *localRun) wait(expect Expect) (bool, string, error) {
+	timeout := expect.Timeout
+	if timeout == 0 {
+		timeout = defaultTestTimeout
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.After(timeout)
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+
+	httpOK := expect.HTTP == ""
+	for {
+		timedOut := false
+		if r.running {
+			select {
+			case err := <-r.exited:
+				r.exit(err)
+			case <-deadline:
+				timedOut = true
+			case <-tick.C:
+			}
+		}
+		if r.err != nil {
+			return false, "", r.err
+		}
+
+		if !httpOK && r.running {
+			if resp, err := client.Get(expect.HTTP); err == nil {
+				resp.Body.Close()
+				httpOK = resp.StatusCode >= 200 && resp.StatusCode < 300
+			}
+		}
+		outputOK := expect.Output == nil || expect.Output.Match(r.output.Bytes())
+		switch {
+		case outputOK && httpOK:
+			return true, "", nil
+		case !r.running:
+			return false, fmt.Sprintf("program exited with status %d before the expectations were met", r.exitStatus), nil
+		case timedOut:
+			return false, fmt.Sprintf("expectations not met within %v", timeout), nil
+		}
+	}
+}
+
+// stop stops the instance if it still runs
+func (r *localRun) stop() {
+	if r.running {
+		r.hypervisor.Stop()
+		r.exit(<-r.exited)
+	}
+}
+
+// serialOutput collects the serial output of a test run
+type serialOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *serialOutput) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+// Bytes returns a copy of the output so far
+func (s *serialOutput) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.buf.Bytes()...)
+}
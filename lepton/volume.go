@@ -29,25 +29,38 @@ type NanosVolume struct {
 // also creates a symlink to volume label at <name>
 // TODO investigate symlinked volume interaction with image
 func CreateLocalVolume(config *Config, name, data, size, provider string) (NanosVolume, error) {
-	var vol NanosVolume
 	var mnfPath string
+	if data != "" {
+		config.Dirs = append(config.Dirs, data)
+		mnfPath = path.Join(config.BuildDir, fmt.Sprintf("%s.manifest", name))
+		err := buildVolumeManifest(config, mnfPath)
+		if err != nil {
+			return NanosVolume{}, err
+		}
+	}
+
+	vol, err := createVolumeFromManifest(config, name, mnfPath, "")
+	if err != nil {
+		return vol, err
+	}
+	vol.Data = data
+	return vol, nil
+}
+
+// createVolumeFromManifest creates the volume name in config.BuildDir with
+// the files of the manifest at mnfPath, or empty when it is not set. The
+// files of the manifest are looked up in targetRoot when it is set.
+func createVolumeFromManifest(config *Config, name, mnfPath, targetRoot string) (NanosVolume, error) {
+	var vol NanosVolume
 	mkfsPath := config.Mkfs
 	var mkfsCommand = NewMkfsCommand(mkfsPath)
 	mkfsCommand.SetLabel(name)
 
 	tmp := fmt.Sprintf("%s.raw", name)
-	mnf := fmt.Sprintf("%s.manifest", name)
 	tmpPath := path.Join(config.BuildDir, tmp)
 	mkfsCommand.SetFileSystemPath(tmpPath)
 
-	if data != "" {
-		config.Dirs = append(config.Dirs, data)
-		mnfPath = path.Join(config.BuildDir, mnf)
-		err := buildVolumeManifest(config, mnfPath)
-		if err != nil {
-			return vol, err
-		}
-
+	if mnfPath != "" {
 		src, err := os.Open(mnfPath)
 		if err != nil {
 			return vol, err
@@ -58,10 +71,18 @@ func CreateLocalVolume(config *Config, name, data, size, provider string) (Nanos
 		mkfsCommand.SetEmptyFileSystem()
 	}
 
+	if targetRoot != "" {
+		mkfsCommand.SetTargetRoot(targetRoot)
+	}
+
 	if config.BaseVolumeSz != "" {
 		mkfsCommand.SetFileSystemSize(config.BaseVolumeSz)
 	}
 
+	if err := mkfsCommand.SetUUIDSource(config.sources()); err != nil {
+		return vol, err
+	}
+
 	mkfsCommand.SetupCommand()
 	err := mkfsCommand.Execute()
 	if err != nil {
@@ -86,7 +107,6 @@ func CreateLocalVolume(config *Config, name, data, size, provider string) (Nanos
 		ID:    uuid,
 		Name:  name,
 		Label: name,
-		Data:  data,
 		Path:  rawPath,
 	}
 	return vol, nil
@@ -26,6 +26,11 @@ type Vsphere struct {
 
 // Initialize Vsphere related things
 func (v *Vsphere) Initialize(config *ProviderConfig) error {
+	_, err := ResolveCredentials("vsphere", config.Profile)
+	if err != nil {
+		return err
+	}
+
 	u, err := v.getCredentials()
 	if err != nil {
 		return err
@@ -244,6 +244,7 @@ func (v *Vsphere) convertToCloudInstance(vm *mo.VirtualMachine) *CloudInstance {
 
 	if vm.Summary.Runtime.BootTime != nil {
 		cInstance.Created = vm.Summary.Runtime.BootTime.String()
+		cInstance.CreatedAt = *vm.Summary.Runtime.BootTime
 	}
 
 	if cInstance.Status == "poweredOn" {
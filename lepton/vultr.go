@@ -1,8 +1,13 @@
 package lepton
 
+import (
+	"net/http"
+)
+
 // Vultr provides access to the Vultr API.
 type Vultr struct {
 	Storage *Objects
+	retry   RetryPolicy
 }
 
 type vultrSnap struct {
@@ -26,7 +31,15 @@ type vultrServer struct {
 
 // Initialize GCP related things
 func (v *Vultr) Initialize(config *ProviderConfig) error {
-	return nil
+	_, err := ResolveCredentials("vultr", config.Profile)
+	v.retry = RetryPolicyFromConfig(config)
+	return err
+}
+
+// httpClient returns the client of the calls of the Vultr API, it retries
+// transient failures
+func (v *Vultr) httpClient() *http.Client {
+	return &http.Client{Transport: v.retry.Transport(nil)}
 }
 
 // GetStorage returns storage interface for cloud provider
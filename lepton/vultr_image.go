@@ -48,7 +48,7 @@ func (v *Vultr) createImage(key string, bucket string, region string) {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -71,7 +71,7 @@ func (v *Vultr) destroyImage(snapshotid string) {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -107,7 +107,7 @@ func (v *Vultr) GetImages(ctx *Context) ([]CloudImage, error) {
 // ListImages lists images on Digital Ocean
 func (v *Vultr) ListImages(ctx *Context) error {
 
-	client := http.Client{}
+	client := v.httpClient()
 	req, err := http.NewRequest("GET", "https://api.vultr.com/v1/snapshot/list", nil)
 	if err != nil {
 		fmt.Println(err)
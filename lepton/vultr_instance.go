@@ -38,7 +38,7 @@ func (v *Vultr) CreateInstance(ctx *Context) error {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -65,7 +65,7 @@ func (v *Vultr) GetInstances(ctx *Context) ([]CloudInstance, error) {
 // ListInstances lists instances on v
 func (v *Vultr) ListInstances(ctx *Context) error {
 
-	client := http.Client{}
+	client := v.httpClient()
 	req, err := http.NewRequest("GET", "https://api.vultr.com/v1/server/list", nil)
 	if err != nil {
 		fmt.Println(err)
@@ -133,7 +133,7 @@ func (v *Vultr) DeleteInstance(ctx *Context, instanceID string) error {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -158,7 +158,7 @@ func (v *Vultr) StartInstance(ctx *Context, instanceID string) error {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
@@ -183,7 +183,7 @@ func (v *Vultr) StopInstance(ctx *Context, instanceID string) error {
 	req.Header.Set("API-Key", token)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	client := v.httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		panic(err)
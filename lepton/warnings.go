@@ -0,0 +1,77 @@
+package lepton
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WarningCategory classifies the non-fatal issues found while resolving the
+// files of an image
+type WarningCategory string
+
+const (
+	// WarningOverwrittenFile is reported when a file replaces another one at
+	// the same image path
+	WarningOverwrittenFile WarningCategory = "overwritten-file"
+	// WarningBrokenSymlink is reported for symlinks whose target doesn't
+	// exist, they are left out of the image
+	WarningBrokenSymlink WarningCategory = "broken-symlink"
+	// WarningSpecialFile is reported for devices, sockets and named pipes,
+	// they are left out of the image
+	WarningSpecialFile WarningCategory = "special-file"
+	// WarningExternalSymlink is reported for symlinks of added directories
+	// pointing out of them, which may be dangling in the image
+	WarningExternalSymlink WarningCategory = "external-symlink"
+	// WarningCaseCollision is reported for paths of the image differing only
+	// by case, which are a single file on case-insensitive hosts
+	WarningCaseCollision WarningCategory = "case-collision"
+)
+
+// warningCategories are the known categories, "all" matches every one of them
+var warningCategories = []WarningCategory{WarningOverwrittenFile, WarningBrokenSymlink, WarningSpecialFile, WarningExternalSymlink, WarningCaseCollision}
+
+// Warning is a non-fatal issue found while resolving the files of an image
+type Warning struct {
+	Category WarningCategory
+	// Path is the path in the image the warning is about
+	Path    string
+	Message string
+}
+
+func (w Warning) String() string {
+	return "warning: " + w.Message
+}
+
+// checkWarnings returns an error listing the warnings of the categories in
+// failOn
+func checkWarnings(warnings []Warning, failOn []string) error {
+	fail := map[WarningCategory]bool{}
+	for _, name := range failOn {
+		if name == "all" {
+			for _, category := range warningCategories {
+				fail[category] = true
+			}
+			continue
+		}
+
+		known := false
+		for _, category := range warningCategories {
+			known = known || WarningCategory(name) == category
+		}
+		if !known {
+			return fmt.Errorf("unknown warning category %q", name)
+		}
+		fail[WarningCategory(name)] = true
+	}
+
+	failed := []string{}
+	for _, w := range warnings {
+		if fail[w.Category] {
+			failed = append(failed, fmt.Sprintf("%s: %s", w.Category, w.Message))
+		}
+	}
+	if len(failed) > 0 {
+		return WithCode(ErrMkfsWarnings, fmt.Errorf("build failed on %d warnings:\n%s", len(failed), strings.Join(failed, "\n")))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package lepton
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestWarnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warnings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	special := false
+	if l, err := net.Listen("unix", filepath.Join(dir, "socket")); err == nil {
+		defer l.Close()
+		special = true
+	}
+
+	m := NewManifest("")
+	m.SetWarningOutput(nil)
+	if err := m.AddDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFile("/etc/a", filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFile("/etc/a", filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WarningCategory{WarningBrokenSymlink}
+	if special {
+		want = append(want, WarningSpecialFile)
+	}
+	want = append(want, WarningOverwrittenFile)
+
+	got := []WarningCategory{}
+	for _, w := range m.Warnings() {
+		got = append(got, w.Category)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got warnings %v want %v", m.Warnings(), want)
+	}
+	if m.Warnings()[len(got)-1].Path != "/etc/a" {
+		t.Errorf("got path %q", m.Warnings()[len(got)-1].Path)
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	warnings := []Warning{
+		{Category: WarningBrokenSymlink, Path: "/lib/x", Message: "broken"},
+		{Category: WarningOverwrittenFile, Path: "/etc/a", Message: "overwritten"},
+	}
+
+	if err := checkWarnings(warnings, nil); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := checkWarnings(warnings, []string{"special-file"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, failOn := range [][]string{{"broken-symlink"}, {"all"}} {
+		err := checkWarnings(warnings, failOn)
+		if code, _ := ErrorCodeOf(err); code != ErrMkfsWarnings {
+			t.Errorf("got %v failing on %v", err, failOn)
+		}
+	}
+	if err := checkWarnings(warnings, []string{"bogus"}); err == nil {
+		t.Error("expected unknown category error")
+	}
+}